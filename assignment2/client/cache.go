@@ -0,0 +1,191 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// flightGroup lets concurrent identical reads share one request.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+// do runs fn unless a call for key is already in flight, in which case
+// it waits for that call's result instead. The shared call runs with the
+// context of the caller that started it; if that caller gives up, the
+// others run fn themselves rather than fail with its error.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if f, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if (errors.Is(f.err, context.Canceled) || errors.Is(f.err, context.DeadlineExceeded)) && ctx.Err() == nil {
+			return fn()
+		}
+		return f.val, f.err
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*flight)
+	}
+	f := &flight{done: make(chan struct{})}
+	g.calls[key] = f
+	g.mu.Unlock()
+
+	f.val, f.err = fn()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(f.done)
+	return f.val, f.err
+}
+
+// readCache keeps values returned by Get for a short while. Entries are
+// dropped when they expire, when the cache's watch reports a change of
+// the key and when this client writes the key.
+//
+// Every invalidation advances gen, and a value is only stored if gen is
+// still what it was when the read was sent, so a read racing with a
+// change never caches what the change replaced. All methods are no-ops
+// on a nil cache.
+type readCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	gen     uint64
+	stopped bool
+	entries map[string]cachedValue
+}
+
+type cachedValue struct {
+	value   string
+	expires time.Time
+}
+
+func newReadCache(size int, ttl time.Duration) *readCache {
+	return &readCache{size: size, ttl: ttl, entries: make(map[string]cachedValue)}
+}
+
+func (c *readCache) get(key string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return "", false
+	}
+	return e.value, true
+}
+
+// generation is the value to pass to put for a read sent now.
+func (c *readCache) generation() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// put caches value for key unless something was invalidated since gen.
+// When the cache is full, expired entries go first and then arbitrary
+// ones.
+func (c *readCache) put(key, value string, gen uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopped || c.gen != gen {
+		return
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		now := time.Now()
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < c.size {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedValue{value: value, expires: time.Now().Add(c.ttl)}
+}
+
+func (c *readCache) invalidate(keys ...string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for _, k := range keys {
+		delete(c.entries, k)
+	}
+}
+
+// clear drops every entry; once stopped, nothing is cached any more.
+func (c *readCache) clear(stop bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.stopped = c.stopped || stop
+	c.entries = make(map[string]cachedValue)
+}
+
+// CacheReads keeps up to size values returned by Get for ttl, so that
+// hot keys read over and over are answered locally. A watch on all keys,
+// running until ctx is done, drops a key's entry as soon as it changes,
+// and writes through this client drop theirs right away; once ctx is
+// done nothing is cached any more. A change made while the watch is
+// reconnecting can go unnoticed for up to ttl, and a Reset empties the
+// cache.
+//
+// CacheReads must be called before the client is used.
+func (c *Client) CacheReads(ctx context.Context, size int, ttl time.Duration) {
+	cache := newReadCache(size, ttl)
+	c.cache = cache
+
+	events := c.Watch(ctx, WithEvents(Set, Delete))
+	go func() {
+		for e := range events {
+			if e.Type == Reset {
+				cache.clear(false)
+				continue
+			}
+			cache.invalidate(e.Key)
+		}
+		cache.clear(true)
+	}()
+}
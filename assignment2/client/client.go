@@ -0,0 +1,250 @@
+// Package client is a small Go client for the key-value server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+var ErrNotFound = errors.New("key not found")
+
+type Client struct {
+	// BaseURL is the server address, e.g. "http://localhost:8080".
+	BaseURL string
+
+	HTTPClient *http.Client
+
+	// APIKey is sent with every request when set (-api-keys-file).
+	APIKey string
+
+	// DedupReads lets concurrent Get calls for the same key, and
+	// concurrent List calls, share one request. A read that joins a
+	// request already in flight can miss a write that finished after it
+	// was sent, so leave this off where one goroutine must see another's
+	// writes.
+	DedupReads bool
+
+	// ReadYourWrites sends the revision of the client's last write as
+	// X-Min-Revision with every read, so that a standby serving the read
+	// answers only once it has replicated that write.
+	ReadYourWrites bool
+
+	lastWrite atomic.Uint64
+	cluster   *topology
+	flights   flightGroup
+	cache     *readCache
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// Get returns the value of key, from the local cache with CacheReads.
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	if value, ok := c.cache.get(key); ok {
+		return value, nil
+	}
+	gen := c.cache.generation()
+
+	var value string
+	var err error
+	if c.DedupReads {
+		var v interface{}
+		v, err = c.flights.do(ctx, "get\x00"+key, func() (interface{}, error) {
+			return c.get(ctx, key)
+		})
+		value, _ = v.(string)
+	} else {
+		value, err = c.get(ctx, key)
+	}
+	if err == nil {
+		c.cache.put(key, value, gen)
+	}
+	return value, err
+}
+
+func (c *Client) get(ctx context.Context, key string) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/data/"+url.PathEscape(key), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	return body.Value, nil
+}
+
+func (c *Client) Set(ctx context.Context, key, value string) error {
+	payload, err := json.Marshal(map[string]string{key: value})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/data", payload)
+	c.cache.invalidate(key)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// SetMany stores several keys in one request.
+func (c *Client) SetMany(ctx context.Context, entries map[string]string) error {
+	payload, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/data", payload)
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	c.cache.invalidate(keys...)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *Client) Delete(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/data/"+url.PathEscape(key), nil)
+	c.cache.invalidate(key)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List returns every key and value.
+func (c *Client) List(ctx context.Context) (map[string]string, error) {
+	if !c.DedupReads {
+		return c.list(ctx)
+	}
+	v, err := c.flights.do(ctx, "list", func() (interface{}, error) {
+		return c.list(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Callers that shared the request each get their own map.
+	return maps.Clone(v.(map[string]string)), nil
+}
+
+func (c *Client) list(ctx context.Context) (map[string]string, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/data", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var data map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// do sends a request and turns non-2xx answers into errors. A cluster
+// client picks the node and retries on leader changes.
+func (c *Client) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	if c.cluster != nil {
+		return c.cluster.do(ctx, c, method, path, body)
+	}
+	return c.doAt(ctx, c.BaseURL, method, path, body)
+}
+
+func (c *Client) doAt(ctx context.Context, baseURL, method, path string, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, baseURL+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// The client reads the version 1 format, whatever the server's
+	// -api-version.
+	req.Header.Set("Accept", "application/vnd.kv.v1+json")
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+	if rev := c.lastWrite.Load(); c.ReadYourWrites && method == http.MethodGet && rev > 0 {
+		req.Header.Set("X-Min-Revision", strconv.FormatUint(rev, 10))
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if method != http.MethodGet {
+			c.wrote(resp.Header.Get("X-Revision"))
+		}
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	return nil, statusError(resp)
+}
+
+// wrote records the revision of a successful write.
+func (c *Client) wrote(revision string) {
+	rev, err := strconv.ParseUint(revision, 10, 64)
+	if err != nil {
+		return
+	}
+	for {
+		last := c.lastWrite.Load()
+		if rev <= last || c.lastWrite.CompareAndSwap(last, rev) {
+			return
+		}
+	}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// StatusError is returned for unexpected HTTP responses.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.Code, e.Message)
+}
+
+func statusError(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &StatusError{Code: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+}
@@ -0,0 +1,195 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// topologyTTL is how long a fetched topology is trusted before it is
+	// refreshed on the next request.
+	topologyTTL = 10 * time.Second
+
+	maxAttempts = 3
+)
+
+var ErrNoLeader = errors.New("cluster has no leader")
+
+// NewCluster returns a client for a cluster reachable through any of the
+// given base URLs. It learns the topology from GET /cluster/status, sends
+// writes to the lease holder and spreads reads over the standbys when
+// they replicate (reads may then be slightly stale; pass max_stale to the
+// server to bound that, or set ReadYourWrites). Requests that fail because the leader moved are
+// retried against the new one.
+func NewCluster(seeds ...string) *Client {
+	t := &topology{}
+	for _, seed := range seeds {
+		t.seeds = append(t.seeds, strings.TrimRight(seed, "/"))
+	}
+
+	c := New("")
+	if len(t.seeds) > 0 {
+		c.BaseURL = t.seeds[0]
+	}
+	c.cluster = t
+	return c
+}
+
+type topology struct {
+	seeds []string
+
+	mu        sync.Mutex
+	leader    string
+	readers   []string
+	fetchedAt time.Time
+
+	next atomic.Uint32
+}
+
+type clusterStatus struct {
+	Self    string `json:"self"`
+	Role    string `json:"role"`
+	Leader  string `json:"leader"`
+	Members []struct {
+		Addr string `json:"addr"`
+		Role string `json:"role"`
+	} `json:"members"`
+	ReplicaReads bool `json:"replica_reads"`
+}
+
+func (t *topology) do(ctx context.Context, c *Client, method, path string, body []byte) (*http.Response, error) {
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(100<<attempt) * time.Millisecond):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		// A read that failed on a standby is retried on the leader.
+		target, err := t.pick(ctx, c, method == http.MethodGet && attempt == 0)
+		if err != nil {
+			lastErr = err
+			t.invalidate()
+			continue
+		}
+
+		resp, err := c.doAt(ctx, target, method, path, body)
+		if err == nil || !retryable(ctx, err) {
+			return resp, err
+		}
+		lastErr = err
+		t.invalidate()
+	}
+	return nil, lastErr
+}
+
+// retryable reports whether err may be caused by a change of leader: the
+// node is unreachable or answered as a standby.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code == http.StatusServiceUnavailable || se.Code == http.StatusBadGateway
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func (t *topology) pick(ctx context.Context, c *Client, read bool) (string, error) {
+	t.mu.Lock()
+	stale := t.leader == "" || time.Since(t.fetchedAt) > topologyTTL
+	t.mu.Unlock()
+
+	if stale {
+		if err := t.refresh(ctx, c); err != nil {
+			return "", err
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// The leader takes its share of reads too.
+	if read && len(t.readers) > 0 {
+		n := int(t.next.Add(1)) % (len(t.readers) + 1)
+		if n < len(t.readers) {
+			return t.readers[n], nil
+		}
+	}
+	return t.leader, nil
+}
+
+func (t *topology) invalidate() {
+	t.mu.Lock()
+	t.fetchedAt = time.Time{}
+	t.mu.Unlock()
+}
+
+// refresh asks the seeds, in order, for the cluster status.
+func (t *topology) refresh(ctx context.Context, c *Client) error {
+	lastErr := errors.New("no seed URLs")
+	for _, seed := range t.seeds {
+		resp, err := c.doAt(ctx, seed, http.MethodGet, "/cluster/status", nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var st clusterStatus
+		err = json.NewDecoder(resp.Body).Decode(&st)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if st.Leader == "" {
+			lastErr = ErrNoLeader
+			continue
+		}
+
+		leader, readers := resolveStatus(seed, st)
+		t.mu.Lock()
+		t.leader, t.readers, t.fetchedAt = leader, readers, time.Now()
+		t.mu.Unlock()
+		return nil
+	}
+	return lastErr
+}
+
+// resolveStatus turns the addresses in a status answered by seed into
+// base URLs. The answering node is reached through seed itself, since its
+// advertised address may not be routable from the client.
+func resolveStatus(seed string, st clusterStatus) (leader string, readers []string) {
+	scheme := "http"
+	if u, err := url.Parse(seed); err == nil && u.Scheme != "" {
+		scheme = u.Scheme
+	}
+	toURL := func(addr string) string {
+		if addr == st.Self {
+			return seed
+		}
+		return scheme + "://" + addr
+	}
+
+	leader = toURL(st.Leader)
+	if st.ReplicaReads {
+		for _, m := range st.Members {
+			if m.Role == "standby" {
+				readers = append(readers, toURL(m.Addr))
+			}
+		}
+	}
+	return leader, readers
+}
@@ -0,0 +1,245 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type EventType string
+
+const (
+	Set    EventType = "set"
+	Delete EventType = "delete"
+	Tags   EventType = "tags"
+
+	// Reset is delivered when the server could not replay the events
+	// missed while disconnected. Anything cached from earlier events
+	// should be reloaded.
+	Reset EventType = "reset"
+
+	// Initial events carry the entries that existed when a watch with
+	// WithInitialState started, and Synced follows the last of them.
+	Initial EventType = "initial"
+	Synced  EventType = "synced"
+)
+
+type Event struct {
+	Seq   uint64    `json:"seq"`
+	Type  EventType `json:"type"`
+	Key   string    `json:"key"`
+	Value string    `json:"value,omitempty"`
+	Tags  []string  `json:"tags,omitempty"`
+	Time  time.Time `json:"time"`
+
+	// Coalesced is how many earlier changes of the key this event
+	// replaces, with WithCoalesce.
+	Coalesced int `json:"coalesced,omitempty"`
+}
+
+type WatchOption func(*watchOptions)
+
+type watchOptions struct {
+	prefix   string
+	events   []EventType
+	since    uint64
+	buffer   int
+	initial  bool
+	coalesce time.Duration
+
+	subscription string
+}
+
+// WithPrefix only delivers events for keys starting with prefix.
+func WithPrefix(prefix string) WatchOption {
+	return func(o *watchOptions) { o.prefix = prefix }
+}
+
+// WithEvents only delivers events of the given types.
+func WithEvents(types ...EventType) WatchOption {
+	return func(o *watchOptions) { o.events = append(o.events, types...) }
+}
+
+// WithStartSeq replays the events after seq before streaming new ones.
+func WithStartSeq(seq uint64) WatchOption {
+	return func(o *watchOptions) { o.since = seq }
+}
+
+// WithInitialState starts the watch with the current entries, as Initial
+// events, and a Synced event after them; the live events that follow
+// pick up exactly where the listing left off. Whenever the watch has to
+// start over (the server could not replay a gap, or the connection broke
+// during the listing), a Reset is delivered and the entries are listed
+// again.
+func WithInitialState() WatchOption {
+	return func(o *watchOptions) { o.initial = true }
+}
+
+// WithSubscription watches through the named server-side subscription,
+// which remembers the last event delivered: a watch started later, even
+// from another process, resumes after it. WithStartSeq and
+// WithInitialState do not apply. If the server no longer has the events
+// after the subscription's position, the subscription is deleted and
+// recreated at the current position, after a Reset.
+func WithSubscription(name string) WatchOption {
+	return func(o *watchOptions) { o.subscription = name }
+}
+
+// WithCoalesce asks the server to hold events for window after the first
+// one and then deliver only the latest change of each key and type, for
+// keys that change faster than the watcher cares about.
+func WithCoalesce(window time.Duration) WatchOption {
+	return func(o *watchOptions) { o.coalesce = window }
+}
+
+// WithBuffer sets the capacity of the returned channel (default 64).
+func WithBuffer(n int) WatchOption {
+	return func(o *watchOptions) { o.buffer = n }
+}
+
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 5 * time.Second
+
+	// Servers send a heartbeat every 15s; a stream silent for longer
+	// than this is treated as dead.
+	idleTimeout = 45 * time.Second
+)
+
+// Watch streams mutation events until ctx is done, then closes the
+// returned channel. Lost connections are re-established with backoff and
+// resume after the last delivered sequence number, so no event is missed
+// or repeated unless the server no longer has it, in which case a Reset
+// event is delivered first.
+func (c *Client) Watch(ctx context.Context, opts ...WatchOption) <-chan Event {
+	o := watchOptions{buffer: 64}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	out := make(chan Event, o.buffer)
+	go func() {
+		defer close(out)
+
+		since := o.since
+		backoff := minBackoff
+		for {
+			delivered, err := c.watchOnce(ctx, &o, &since, out)
+			if ctx.Err() != nil {
+				return
+			}
+			if se, ok := err.(*StatusError); ok && se.Code == http.StatusGone {
+				// Start over from the current position.
+				since = 0
+				if o.subscription != "" {
+					c.DeleteSubscription(ctx, o.subscription)
+				}
+				select {
+				case out <- Event{Type: Reset, Time: time.Now()}:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			if delivered {
+				backoff = minBackoff
+			}
+			if o.initial && o.subscription == "" && since == 0 && delivered {
+				// The listing was cut short and starts over.
+				select {
+				case out <- Event{Type: Reset, Time: time.Now()}:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			backoff = min(backoff*2, maxBackoff)
+		}
+	}()
+	return out
+}
+
+// watchOnce runs a single streaming request. since is advanced as events
+// are delivered; delivered reports whether the connection got that far.
+func (c *Client) watchOnce(ctx context.Context, o *watchOptions, since *uint64, out chan<- Event) (delivered bool, err error) {
+	q := url.Values{}
+	if o.prefix != "" {
+		q.Set("prefix", o.prefix)
+	}
+	if len(o.events) > 0 {
+		types := make([]string, len(o.events))
+		for i, t := range o.events {
+			types[i] = string(t)
+		}
+		q.Set("events", strings.Join(types, ","))
+	}
+	if o.coalesce > 0 {
+		q.Set("coalesce", o.coalesce.String())
+	}
+	if o.subscription != "" {
+		q.Set("subscription", o.subscription)
+	} else if *since > 0 {
+		q.Set("since", strconv.FormatUint(*since, 10))
+	} else if o.initial {
+		q.Set("send_initial", "true")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resp, err := c.do(ctx, http.MethodGet, "/watch?"+q.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	idle := time.AfterFunc(idleTimeout, cancel)
+	defer idle.Stop()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		idle.Reset(idleTimeout)
+
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return delivered, err
+		}
+		delivered = true
+		if e.Type == "heartbeat" || e.Type == "overflow" {
+			continue
+		}
+
+		select {
+		case out <- e:
+			// Initial events carry each entry's own revision; the
+			// stream position is the one Synced reports.
+			if e.Type != Initial {
+				*since = e.Seq
+			}
+		case <-ctx.Done():
+			return delivered, ctx.Err()
+		}
+	}
+	return delivered, scanner.Err()
+}
+
+// DeleteSubscription removes a named subscription and its position.
+func (c *Client) DeleteSubscription(ctx context.Context, name string) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/subscriptions/"+url.PathEscape(name), nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
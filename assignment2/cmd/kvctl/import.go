@@ -0,0 +1,93 @@
+package main
+
+import (
+	"assignment2/client"
+	"assignment2/internal/redisdump"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	addr := fs.String("addr", "http://localhost:8080", "server address")
+	apiKey := fs.String("api-key", "", "API key, if the server requires one")
+	format := fs.String("format", "", "rdb or aof (default: from the file extension)")
+	db := fs.Int("db", 0, "Redis database to import (-1 = all, merged)")
+	prefix := fs.String("prefix", "", "prepended to every imported key")
+	batch := fs.Int("batch", 1000, "keys per request")
+	dryRun := fs.Bool("dry-run", false, "only read the file and report what would be imported")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: kvctl import [flags] dump.rdb|appendonly.aof")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("import needs exactly one file")
+	}
+	if *batch < 1 {
+		return errors.New("-batch must be at least 1")
+	}
+
+	path := fs.Arg(0)
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(path), ".")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var data map[string]string
+	var stats redisdump.Stats
+	switch *format {
+	case "rdb":
+		data, stats, err = redisdump.ReadRDB(f, *db)
+	case "aof":
+		data, stats, err = redisdump.ReadAOF(f, *db)
+	default:
+		return fmt.Errorf("unknown format %q: use -format rdb or aof", *format)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("read %d string keys (skipped %d of other types, %d expired, %d in other databases)\n",
+		stats.Strings, stats.Skipped, stats.Expired, stats.OtherDBs)
+	if *dryRun {
+		return nil
+	}
+
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	c := client.New(*addr)
+	c.APIKey = *apiKey
+	ctx := context.Background()
+
+	for start := 0; start < len(keys); start += *batch {
+		end := min(start+*batch, len(keys))
+		entries := make(map[string]string, end-start)
+		for _, k := range keys[start:end] {
+			entries[*prefix+k] = data[k]
+		}
+		if err := c.SetMany(ctx, entries); err != nil {
+			return fmt.Errorf("after %d keys: %w", start, err)
+		}
+	}
+	fmt.Printf("imported %d keys into %s\n", len(keys), *addr)
+	return nil
+}
@@ -0,0 +1,36 @@
+// Command kvctl is a command-line tool for the key-value server.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `usage: kvctl <command> [flags] [args]
+
+commands:
+  import   load the string keys of a Redis RDB or AOF file
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "import":
+		err = runImport(os.Args[2:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "kvctl: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "kvctl:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "context"
+
+// cycleLogLevel does nothing on platforms without SIGUSR1; PUT
+// /admin/loglevel still changes the level.
+func cycleLogLevel(ctx context.Context) {}
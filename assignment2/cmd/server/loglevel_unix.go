@@ -0,0 +1,30 @@
+//go:build unix
+
+package main
+
+import (
+	"assignment2/internal/logging"
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// cycleLogLevel moves the log level one step more verbose on every
+// SIGUSR1, from error back around to debug after debug, until ctx ends.
+func cycleLogLevel(ctx context.Context) {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	defer signal.Stop(usr1)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-usr1:
+			level := logging.Cycle()
+			log.Printf("[LOG] level changed to %s by SIGUSR1\n", level)
+		}
+	}
+}
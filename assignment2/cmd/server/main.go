@@ -1,133 +1,44 @@
 package main
 
 import (
+	"assignment2/internal/config"
+	"assignment2/internal/logging"
+	"assignment2/internal/server"
+	"assignment2/internal/version"
 	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"time"
 )
 
-// Server holds shared state
-type Server struct {
-	mu       sync.Mutex
-	data     map[string]string
-	requests int
-}
-
-// Constructor
-func NewServer() *Server {
-	return &Server{
-		data: make(map[string]string),
-	}
-}
-
-// Middleware to count requests
-func (s *Server) countRequests(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		s.mu.Lock()
-		s.requests++
-		s.mu.Unlock()
-		next(w, r)
-	}
-}
-
-// POST /data
-func (s *Server) postDataHandler(w http.ResponseWriter, r *http.Request) {
-	var body map[string]string
-
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
-		return
-	}
-
-	s.mu.Lock()
-	for k, v := range body {
-		s.data[k] = v
-	}
-	s.mu.Unlock()
-
-	w.WriteHeader(http.StatusCreated)
-}
-
-// GET /data
-func (s *Server) getDataHandler(w http.ResponseWriter, r *http.Request) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(s.data)
-}
-
-// DELETE /data/{key}
-func (s *Server) deleteDataHandler(w http.ResponseWriter, r *http.Request) {
-	key := r.PathValue("key")
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if _, exists := s.data[key]; !exists {
-		http.Error(w, "Key not found", http.StatusNotFound)
-		return
-	}
-
-	delete(s.data, key)
-	w.WriteHeader(http.StatusNoContent)
-}
-
-// GET /stats
-func (s *Server) statsHandler(w http.ResponseWriter, r *http.Request) {
-	s.mu.Lock()
-	stats := map[string]int{
-		"total_requests": s.requests,
-		"database_size":  len(s.data),
-	}
-	s.mu.Unlock()
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(stats)
-}
-
-// Background worker
-func (s *Server) startBackgroundWorker(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			s.mu.Lock()
-			fmt.Printf(
-				"Status: Requests=%d, DB size=%d\n",
-				s.requests,
-				len(s.data),
-			)
-			s.mu.Unlock()
-		case <-ctx.Done():
-			fmt.Println("Background worker stopped")
-			return
+func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
 		}
+		log.Fatal(err)
 	}
-}
-
-func main() {
-	server := NewServer()
-	mux := http.NewServeMux()
+	log.SetOutput(logging.Filter(os.Stderr))
+	logging.SetLevel(cfg.LogLevel)
 
-	mux.HandleFunc("POST /data", server.countRequests(server.postDataHandler))
-	mux.HandleFunc("GET /data", server.countRequests(server.getDataHandler))
-	mux.HandleFunc("DELETE /data/{key}", server.countRequests(server.deleteDataHandler))
-	mux.HandleFunc("GET /stats", server.countRequests(server.statsHandler))
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	httpServer := &http.Server{
-		Addr:    ":8080",
-		Handler: mux,
+		Addr:    cfg.Addr,
+		Handler: srv.Routes(),
 	}
+	srv.ConfigureHTTP(httpServer)
+	httpServer.RegisterOnShutdown(srv.CloseWatchers)
 
 	ctx, stop := signal.NotifyContext(
 		context.Background(),
@@ -135,12 +46,34 @@ func main() {
 		syscall.SIGTERM,
 	)
 	defer stop()
+	go cycleLogLevel(ctx)
 
-	go server.startBackgroundWorker(ctx)
+	if err := srv.Seed(ctx); err != nil {
+		log.Fatal(err)
+	}
+
+	go srv.StartWorker(ctx)
+	go srv.StartDiscovery(ctx)
+	go srv.StartLease(ctx)
+	go srv.StartReplication(ctx)
+
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv.LogStartup(ln.Addr().String())
 
 	go func() {
-		fmt.Println("Server running on :8080")
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if tlsConfig := srv.TLSConfig(); tlsConfig != nil {
+			httpServer.TLSConfig = tlsConfig
+			fmt.Printf("Server %s running on %s (TLS)\n", version.String(), ln.Addr())
+			err = httpServer.ServeTLS(ln, "", "")
+		} else {
+			fmt.Printf("Server %s running on %s\n", version.String(), ln.Addr())
+			err = httpServer.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatal(err)
 		}
 	}()
@@ -151,6 +84,8 @@ func main() {
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	httpServer.Shutdown(shutdownCtx)
+	start := time.Now()
+	drainErr := httpServer.Shutdown(shutdownCtx)
+	srv.CloseAndLog(time.Since(start), drainErr)
 	fmt.Println("Server stopped gracefully")
 }
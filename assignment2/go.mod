@@ -1,3 +1,7 @@
 module assignment2
 
-go 1.22
\ No newline at end of file
+go 1.22
+
+require go.starlark.net v0.0.0-20250417143717-f57e51f710eb
+
+require golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
@@ -0,0 +1,122 @@
+// Package archive keeps entries moved out of the store in gzip-compressed
+// NDJSON objects, in a directory or an S3 bucket, and reads single
+// entries back.
+package archive
+
+import (
+	"assignment2/internal/sigv4"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("archive object not found")
+
+// Target is where archive objects are kept.
+type Target interface {
+	Put(ctx context.Context, name string, data []byte) error
+	Get(ctx context.Context, name string) ([]byte, error)
+
+	// String names the target, e.g. s3://bucket/prefix.
+	String() string
+}
+
+// S3Options configure s3:// targets.
+type S3Options struct {
+	// Endpoint replaces https://s3.<Region>.amazonaws.com, e.g. for
+	// MinIO. Buckets are addressed by path.
+	Endpoint string
+	Region   string
+
+	// Credentials are read from the environment if not set.
+	Credentials sigv4.Credentials
+
+	// HTTPClient defaults to one with a 30 second timeout.
+	HTTPClient *http.Client
+}
+
+// Open returns the target for url: s3://bucket/prefix, or else the path
+// of a directory, which is created if needed.
+func Open(url string, opts S3Options) (Target, error) {
+	if rest, ok := strings.CutPrefix(url, "s3://"); ok {
+		bucket, prefix, _ := strings.Cut(rest, "/")
+		return NewS3(bucket, prefix, opts)
+	}
+	return NewDir(strings.TrimPrefix(url, "file://"))
+}
+
+// Entry is one archived key.
+type Entry struct {
+	Key      string    `json:"key"`
+	Value    string    `json:"value"`
+	Revision uint64    `json:"revision"`
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+	Tags     []string  `json:"tags,omitempty"`
+}
+
+// Encode returns entries as an archive object: one JSON line each,
+// gzip-compressed.
+func Encode(entries []Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode returns every entry of an archive object.
+func Decode(data []byte) ([]Entry, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var entries []Entry
+	sc := bufio.NewScanner(zr)
+	sc.Buffer(make([]byte, 64*1024), 1<<30)
+	for sc.Scan() {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// Find returns the entry for key in an archive object.
+func Find(data []byte, key string) (Entry, bool, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return Entry{}, false, err
+	}
+	defer zr.Close()
+
+	sc := bufio.NewScanner(zr)
+	sc.Buffer(make([]byte, 64*1024), 1<<30)
+	for sc.Scan() {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return Entry{}, false, err
+		}
+		if e.Key == key {
+			return e, true, nil
+		}
+	}
+	return Entry{}, false, sc.Err()
+}
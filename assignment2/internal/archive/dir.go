@@ -0,0 +1,52 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Dir keeps archive objects as files in a directory, e.g. one on a
+// mounted network volume.
+type Dir struct {
+	path string
+}
+
+func NewDir(path string) (*Dir, error) {
+	if path == "" {
+		return nil, errors.New("archive: directory required")
+	}
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, err
+	}
+	return &Dir{path: path}, nil
+}
+
+// Put writes the object to a temporary file first, so a crash never
+// leaves a partial one under its name.
+func (d *Dir) Put(ctx context.Context, name string, data []byte) error {
+	path := filepath.Join(d.path, filepath.FromSlash(name))
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (d *Dir) Get(ctx context.Context, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(d.path, filepath.FromSlash(name)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (d *Dir) String() string {
+	return d.path
+}
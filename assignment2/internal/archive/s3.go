@@ -0,0 +1,112 @@
+package archive
+
+import (
+	"assignment2/internal/sigv4"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3 keeps archive objects in a bucket, under an optional key prefix.
+type S3 struct {
+	bucket   string
+	prefix   string
+	region   string
+	endpoint string
+	creds    sigv4.Credentials
+	client   *http.Client
+}
+
+func NewS3(bucket, prefix string, opts S3Options) (*S3, error) {
+	if bucket == "" {
+		return nil, errors.New("archive: s3 bucket required")
+	}
+	s := &S3{
+		bucket:   bucket,
+		prefix:   strings.Trim(prefix, "/"),
+		region:   opts.Region,
+		endpoint: strings.TrimSuffix(opts.Endpoint, "/"),
+		creds:    opts.Credentials,
+		client:   opts.HTTPClient,
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.endpoint == "" {
+		s.endpoint = "https://s3." + s.region + ".amazonaws.com"
+	}
+	if s.creds.AccessKeyID == "" {
+		var err error
+		if s.creds, err = sigv4.CredentialsFromEnv(); err != nil {
+			return nil, fmt.Errorf("archive: %w", err)
+		}
+	}
+	if s.client == nil {
+		s.client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return s, nil
+}
+
+func (s *S3) Put(ctx context.Context, name string, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, name, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Get(ctx context.Context, name string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (s *S3) String() string {
+	if s.prefix == "" {
+		return "s3://" + s.bucket
+	}
+	return "s3://" + s.bucket + "/" + s.prefix
+}
+
+// do sends one signed request for the object name and fails on anything
+// but a 2xx answer.
+func (s *S3) do(ctx context.Context, method, name string, payload []byte) (*http.Response, error) {
+	key := name
+	if s.prefix != "" {
+		key = s.prefix + "/" + name
+	}
+	u := s.endpoint + "/" + s.bucket + "/" + (&url.URL{Path: key}).EscapedPath()
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/gzip")
+	}
+	req.Header.Set("X-Amz-Content-Sha256", sigv4.HexSHA256(payload))
+	sigv4.Sign(req, payload, s.creds, s.region, "s3", time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("archive: s3 %s %s: %s: %s", method, key, resp.Status, bytes.TrimSpace(body))
+}
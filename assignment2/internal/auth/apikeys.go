@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// HeaderAPIKey carries a tenant API key; "Authorization: Bearer <key>"
+// is accepted as well.
+const HeaderAPIKey = "X-API-Key"
+
+// TenantKey is what an API key grants: the namespace of its tenant and,
+// with Scopes, only the keys in it that they cover. Priority is the
+// class of its requests under -max-in-flight, if not the default.
+type TenantKey struct {
+	Tenant   string
+	Scopes   Scopes
+	Priority string
+}
+
+// LoadTenantKeys reads "key:tenant" lines, each optionally followed by
+// scopes and a priority separated by spaces ("key:tenant read:cfg:*
+// write:jobs:* priority:low"); blank lines and lines starting with # are
+// ignored. Tenant names may not contain "/", which separates them from
+// the keys in their namespace.
+func LoadTenantKeys(path string) (map[string]TenantKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]TenantKey)
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		key, tenant, ok := strings.Cut(fields[0], ":")
+		if !ok || key == "" || tenant == "" || strings.Contains(tenant, "/") {
+			return nil, fmt.Errorf("%s:%d: expected key:tenant", path, n+1)
+		}
+		tk := TenantKey{Tenant: tenant}
+		for _, f := range fields[1:] {
+			if class, ok := strings.CutPrefix(f, "priority:"); ok {
+				if class != "high" && class != "normal" && class != "low" {
+					return nil, fmt.Errorf("%s:%d: invalid priority %q, expected high, normal or low", path, n+1, class)
+				}
+				tk.Priority = class
+				continue
+			}
+			sc, err := ParseScope(f)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, n+1, err)
+			}
+			tk.Scopes = append(tk.Scopes, sc)
+		}
+		keys[key] = tk
+	}
+	return keys, nil
+}
+
+// APIKey returns the API key sent with r, if any.
+func APIKey(r *http.Request) string {
+	if key := r.Header.Get(HeaderAPIKey); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
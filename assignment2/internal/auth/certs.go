@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// RoleMap assigns roles to client certificates by subject name. Patterns
+// are matched against the certificate's CN and its DNS, email and URI
+// SANs; a leading "*." matches any subdomain.
+type RoleMap struct {
+	entries []roleEntry
+}
+
+type roleEntry struct {
+	pattern string
+	roles   []string
+}
+
+// ParseRoleMap parses "name=role|role,other=role".
+func ParseRoleMap(s string) (*RoleMap, error) {
+	m := &RoleMap{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		pattern, list, ok := strings.Cut(item, "=")
+		if !ok || pattern == "" || list == "" {
+			return nil, fmt.Errorf("invalid role mapping %q", item)
+		}
+
+		var roles []string
+		for _, role := range strings.Split(list, "|") {
+			if _, known := roleRank[role]; !known {
+				return nil, fmt.Errorf("unknown role %q", role)
+			}
+			roles = append(roles, role)
+		}
+		m.entries = append(m.entries, roleEntry{pattern: pattern, roles: roles})
+	}
+	return m, nil
+}
+
+func (m *RoleMap) Empty() bool {
+	return m == nil || len(m.entries) == 0
+}
+
+// PrincipalFromCert builds the principal for a verified client
+// certificate. Without any mappings every verified certificate gets full
+// access; otherwise it gets the union of all matching entries.
+func (m *RoleMap) PrincipalFromCert(cert *x509.Certificate) *Principal {
+	names := certNames(cert)
+
+	p := &Principal{Method: "mtls"}
+	if len(names) > 0 {
+		p.Name = names[0]
+	}
+
+	if m.Empty() {
+		p.Roles = []string{RoleAdmin}
+		return p
+	}
+
+	seen := make(map[string]bool)
+	for _, e := range m.entries {
+		for _, name := range names {
+			if matchName(e.pattern, name) {
+				for _, role := range e.roles {
+					if !seen[role] {
+						seen[role] = true
+						p.Roles = append(p.Roles, role)
+					}
+				}
+				break
+			}
+		}
+	}
+	return p
+}
+
+func certNames(cert *x509.Certificate) []string {
+	var names []string
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	return names
+}
+
+func matchName(pattern, name string) bool {
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(name, pattern[1:])
+	}
+	return pattern == name
+}
+
+// LoadCertPool reads PEM bundles of CA certificates into one pool.
+func LoadCertPool(paths ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, path := range paths {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + path)
+		}
+	}
+	return pool, nil
+}
+
+// VerifyClientChain reports whether the certificate chain a client
+// presented verifies as a client certificate against roots. Handshakes
+// verify against every CA the listener accepts; this tells which one a
+// certificate belongs to.
+func VerifyClientChain(chain []*x509.Certificate, roots *x509.CertPool) bool {
+	if len(chain) == 0 || roots == nil {
+		return false
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err == nil
+}
+
+// PeerPrincipal is the principal of a cluster peer, authenticated by a
+// certificate of the peer CA. Peers replicate and forward writes, so they
+// may read and write.
+func PeerPrincipal(cert *x509.Certificate) *Principal {
+	p := &Principal{Method: "peer", Roles: []string{RoleWrite}}
+	if names := certNames(cert); len(names) > 0 {
+		p.Name = names[0]
+	}
+	return p
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrNonceStoreFull = errors.New("nonce store full")
+
+// NonceStore remembers used nonces until they expire. It holds at most
+// Max entries; expired ones are dropped by Cleanup.
+type NonceStore struct {
+	mu     sync.Mutex
+	max    int
+	nonces map[string]time.Time
+}
+
+func NewNonceStore(max int) *NonceStore {
+	return &NonceStore{
+		max:    max,
+		nonces: make(map[string]time.Time),
+	}
+}
+
+// Use records nonce until expires. It fails with ErrReplay if the nonce
+// has been seen before and is still remembered.
+func (n *NonceStore) Use(nonce string, expires time.Time) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if until, ok := n.nonces[nonce]; ok && time.Now().Before(until) {
+		return ErrReplay
+	}
+
+	if len(n.nonces) >= n.max {
+		n.removeExpired(time.Now())
+		if len(n.nonces) >= n.max {
+			return ErrNonceStoreFull
+		}
+	}
+
+	n.nonces[nonce] = expires
+	return nil
+}
+
+// Cleanup drops expired nonces and returns how many are still held.
+func (n *NonceStore) Cleanup(now time.Time) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.removeExpired(now)
+	return len(n.nonces)
+}
+
+func (n *NonceStore) removeExpired(now time.Time) {
+	for nonce, until := range n.nonces {
+		if !now.Before(until) {
+			delete(n.nonces, nonce)
+		}
+	}
+}
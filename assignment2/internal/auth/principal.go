@@ -0,0 +1,31 @@
+package auth
+
+// Roles, from least to most privileged. A principal holding a role also
+// has every weaker one.
+const (
+	RoleRead  = "read"
+	RoleWrite = "write"
+	RoleAdmin = "admin"
+)
+
+var roleRank = map[string]int{RoleRead: 1, RoleWrite: 2, RoleAdmin: 3}
+
+// Principal is an authenticated caller.
+type Principal struct {
+	Name   string
+	Roles  []string
+	Method string
+}
+
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+	want := roleRank[role]
+	for _, r := range p.Roles {
+		if roleRank[r] >= want {
+			return true
+		}
+	}
+	return false
+}
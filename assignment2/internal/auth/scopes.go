@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Scope accesses. Unlike roles, neither implies the other, so that a key
+// can be write-only.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// Scope lets an API key read or write the keys matching Pattern: one key,
+// or with a trailing "*" every key starting with what comes before it.
+// Keys are named as the tenant sees them.
+type Scope struct {
+	Access  string
+	Pattern string
+}
+
+// ParseScope parses "access:pattern", e.g. "read:cfg:*".
+func ParseScope(s string) (Scope, error) {
+	access, pattern, ok := strings.Cut(s, ":")
+	if !ok || pattern == "" || (access != ScopeRead && access != ScopeWrite) {
+		return Scope{}, fmt.Errorf("invalid scope %q, expected read:pattern or write:pattern", s)
+	}
+	if i := strings.IndexByte(pattern, '*'); i >= 0 && i != len(pattern)-1 {
+		return Scope{}, fmt.Errorf("invalid scope %q, * may only end the pattern", s)
+	}
+	return Scope{Access: access, Pattern: pattern}, nil
+}
+
+func (sc Scope) String() string {
+	return sc.Access + ":" + sc.Pattern
+}
+
+// Matches reports whether key is covered by the pattern.
+func (sc Scope) Matches(key string) bool {
+	if prefix, ok := strings.CutSuffix(sc.Pattern, "*"); ok {
+		return strings.HasPrefix(key, prefix)
+	}
+	return key == sc.Pattern
+}
+
+// Scopes limit an API key to the keys they cover. No scopes at all means
+// no limit.
+type Scopes []Scope
+
+// Allow reports whether the scopes permit access to key.
+func (ss Scopes) Allow(access, key string) bool {
+	if len(ss) == 0 {
+		return true
+	}
+	for _, sc := range ss {
+		if sc.Access == access && sc.Matches(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ss Scopes) String() string {
+	parts := make([]string, len(ss))
+	for i, sc := range ss {
+		parts[i] = sc.String()
+	}
+	return strings.Join(parts, " ")
+}
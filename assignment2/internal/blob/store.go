@@ -0,0 +1,342 @@
+// Package blob stores large values on disk. They are uploaded in parts
+// through an upload session and read back as files, so neither side has
+// to hold a whole value in memory.
+package blob
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxPart is the highest part number of an upload.
+const MaxPart = 10000
+
+var (
+	ErrNotFound     = errors.New("blob not found")
+	ErrNoUpload     = errors.New("upload not found")
+	ErrPartTooLarge = errors.New("part too large")
+	ErrInvalidPart  = errors.New("invalid part number")
+	ErrMissingPart  = errors.New("part not uploaded")
+	ErrEmptyUpload  = errors.New("upload has no parts")
+)
+
+// Info describes a stored blob.
+type Info struct {
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	Parts    int       `json:"parts"`
+	Modified time.Time `json:"modified"`
+}
+
+// Part describes an uploaded part.
+type Part struct {
+	Number int    `json:"part"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type uploadMeta struct {
+	Key     string    `json:"key"`
+	Created time.Time `json:"created"`
+}
+
+// Store keeps blobs in dir/objects and upload sessions in dir/uploads.
+// Blob files are replaced by rename, so a reader that opened one keeps
+// seeing a complete version.
+type Store struct {
+	dir string
+}
+
+func Open(dir string) (*Store, error) {
+	for _, sub := range []string{"objects", "uploads"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &Store{dir: dir}, nil
+}
+
+// CreateUpload starts an upload session for key and returns its id.
+func (s *Store) CreateUpload(key string) (string, error) {
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(raw[:])
+
+	dir := s.uploadDir(id)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return "", err
+	}
+	meta := uploadMeta{Key: key, Created: time.Now()}
+	if err := writeJSONFile(filepath.Join(dir, "upload.json"), meta); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return id, nil
+}
+
+// PutPart stores part n of an upload, replacing an earlier attempt. At
+// most max bytes are accepted.
+func (s *Store) PutPart(id string, n int, r io.Reader, max int64) (Part, error) {
+	if n < 1 || n > MaxPart {
+		return Part{}, ErrInvalidPart
+	}
+	if _, err := s.upload(id); err != nil {
+		return Part{}, err
+	}
+
+	dir := s.uploadDir(id)
+	tmp, err := os.CreateTemp(dir, "part-*.tmp")
+	if err != nil {
+		return Part{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(r, max+1))
+	if err != nil {
+		return Part{}, err
+	}
+	if size > max {
+		return Part{}, ErrPartTooLarge
+	}
+	if err := tmp.Close(); err != nil {
+		return Part{}, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, partName(n))); err != nil {
+		return Part{}, err
+	}
+	return Part{Number: n, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// Commit joins the given parts, or all uploaded parts in order when parts
+// is empty, into the blob for the upload's key and ends the session.
+func (s *Store) Commit(id string, parts []int) (Info, error) {
+	meta, err := s.upload(id)
+	if err != nil {
+		return Info{}, err
+	}
+	dir := s.uploadDir(id)
+
+	if len(parts) == 0 {
+		if parts, err = s.uploadedParts(id); err != nil {
+			return Info{}, err
+		}
+	}
+	if len(parts) == 0 {
+		return Info{}, ErrEmptyUpload
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "objects"), "blob-*.tmp")
+	if err != nil {
+		return Info{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	var size int64
+	for _, n := range parts {
+		if n < 1 || n > MaxPart {
+			return Info{}, ErrInvalidPart
+		}
+		f, err := os.Open(filepath.Join(dir, partName(n)))
+		if os.IsNotExist(err) {
+			return Info{}, fmt.Errorf("%w: %d", ErrMissingPart, n)
+		}
+		if err != nil {
+			return Info{}, err
+		}
+		written, err := io.Copy(io.MultiWriter(tmp, h), f)
+		f.Close()
+		if err != nil {
+			return Info{}, err
+		}
+		size += written
+	}
+	if err := tmp.Sync(); err != nil {
+		return Info{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return Info{}, err
+	}
+
+	info := Info{
+		Key:      meta.Key,
+		Size:     size,
+		SHA256:   hex.EncodeToString(h.Sum(nil)),
+		Parts:    len(parts),
+		Modified: time.Now(),
+	}
+	name := s.objectPath(meta.Key)
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return Info{}, err
+	}
+	if err := writeJSONFile(name+".json", info); err != nil {
+		return Info{}, err
+	}
+
+	os.RemoveAll(dir)
+	return info, nil
+}
+
+// Abort ends an upload session and discards its parts.
+func (s *Store) Abort(id string) error {
+	if _, err := s.upload(id); err != nil {
+		return err
+	}
+	return os.RemoveAll(s.uploadDir(id))
+}
+
+// Open returns the blob stored for key. The caller closes the file.
+func (s *Store) Open(key string) (*os.File, Info, error) {
+	name := s.objectPath(key)
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, Info{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Info{}, err
+	}
+
+	// The description is written after the data is renamed into place;
+	// if it is behind the file, only trust what the file says.
+	var info Info
+	if err := readJSONFile(name+".json", &info); err != nil || info.Size != st.Size() {
+		info = Info{Key: key, Size: st.Size(), Modified: st.ModTime()}
+	}
+	return f, info, nil
+}
+
+// Delete removes the blob stored for key.
+func (s *Store) Delete(key string) (bool, error) {
+	name := s.objectPath(key)
+	err := os.Remove(name)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	os.Remove(name + ".json")
+	return true, nil
+}
+
+// CleanupUploads removes upload sessions older than maxAge and returns
+// how many there were.
+func (s *Store) CleanupUploads(maxAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, "uploads"))
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	cutoff := time.Now().Add(-maxAge)
+	for _, e := range entries {
+		var meta uploadMeta
+		err := readJSONFile(filepath.Join(s.dir, "uploads", e.Name(), "upload.json"), &meta)
+		if err == nil && meta.Created.After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.dir, "uploads", e.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func (s *Store) upload(id string) (uploadMeta, error) {
+	var meta uploadMeta
+	if !validID(id) {
+		return meta, ErrNoUpload
+	}
+	err := readJSONFile(filepath.Join(s.uploadDir(id), "upload.json"), &meta)
+	if os.IsNotExist(err) {
+		return meta, ErrNoUpload
+	}
+	return meta, err
+}
+
+func (s *Store) uploadedParts(id string) ([]int, error) {
+	entries, err := os.ReadDir(s.uploadDir(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []int
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, "part-") || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimPrefix(name, "part-")); err == nil {
+			parts = append(parts, n)
+		}
+	}
+	sort.Ints(parts)
+	return parts, nil
+}
+
+func (s *Store) uploadDir(id string) string {
+	return filepath.Join(s.dir, "uploads", id)
+}
+
+// objectPath names blob files by a hash of the key, so any key is a safe
+// file name.
+func (s *Store) objectPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, "objects", hex.EncodeToString(sum[:]))
+}
+
+func partName(n int) string {
+	return fmt.Sprintf("part-%05d", n)
+}
+
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func writeJSONFile(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func readJSONFile(name string, v interface{}) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
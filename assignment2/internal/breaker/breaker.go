@@ -0,0 +1,119 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	Closed   State = "closed"
+	Open     State = "open"
+	HalfOpen State = "half-open"
+)
+
+// Breaker stops calls to an upstream after Threshold consecutive
+// failures. Once Cooldown has passed a single probe is let through; its
+// outcome closes the breaker again or re-opens it for another Cooldown.
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	successes int64
+	errors    int64
+	rejected  int64
+	trips     int64
+}
+
+type Snapshot struct {
+	State               State     `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Successes           int64     `json:"successes"`
+	Failures            int64     `json:"failures"`
+	Rejected            int64     `json:"rejected"`
+	Trips               int64     `json:"trips"`
+	OpenedAt            time.Time `json:"opened_at,omitempty"`
+}
+
+func New(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{Threshold: threshold, Cooldown: cooldown, state: Closed}
+}
+
+// Allow reports whether a call may proceed. Every allowed call must be
+// followed by Success or Failure.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		if time.Since(b.openedAt) < b.Cooldown {
+			b.rejected++
+			return false
+		}
+		b.state = HalfOpen
+		b.probing = true
+		return true
+
+	case HalfOpen:
+		if b.probing {
+			b.rejected++
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.successes++
+	b.failures = 0
+	b.probing = false
+	b.state = Closed
+}
+
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.errors++
+	b.failures++
+	b.probing = false
+
+	if b.state == HalfOpen || b.failures >= b.Threshold {
+		if b.state != Open {
+			b.trips++
+		}
+		b.state = Open
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *Breaker) Snapshot() Snapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snap := Snapshot{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Successes:           b.successes,
+		Failures:            b.errors,
+		Rejected:            b.rejected,
+		Trips:               b.trips,
+	}
+	if b.state != Closed {
+		snap.OpenedAt = b.openedAt
+	}
+	return snap
+}
@@ -0,0 +1,150 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Discovery finds peers from a static seed list and/or a DNS SRV record,
+// joins them on startup and keeps the membership fresh.
+type Discovery struct {
+	Seeds    []string
+	SRV      string
+	Interval time.Duration
+	Members  *Membership
+	Scheme   string
+	Client   *http.Client
+	Resolver *net.Resolver
+}
+
+type JoinRequest struct {
+	Addr string `json:"addr"`
+}
+
+type JoinResponse struct {
+	Members []string `json:"members"`
+}
+
+func (d *Discovery) Run(ctx context.Context) {
+	d.refresh(ctx)
+
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.refresh(ctx)
+
+		case <-ctx.Done():
+			log.Println("[CLUSTER] discovery stopped")
+			return
+		}
+	}
+}
+
+func (d *Discovery) refresh(ctx context.Context) {
+	started := time.Now()
+
+	candidates, err := d.resolve(ctx)
+	if err != nil {
+		log.Printf("[CLUSTER] resolve failed: %v\n", err)
+	}
+
+	// Peers learned through other members are joined as well, so that
+	// the membership converges even if DNS only returns part of the set.
+	seen := make(map[string]bool)
+	for _, addr := range append(candidates, d.Members.Addrs()...) {
+		if seen[addr] || addr == d.Members.Self() {
+			continue
+		}
+		seen[addr] = true
+
+		if err := d.join(ctx, addr); err != nil {
+			log.Printf("[CLUSTER] join %s failed: %v\n", addr, err)
+		}
+	}
+
+	// A member that missed three refresh rounds is considered gone.
+	for _, addr := range d.Members.Prune(started.Add(-3 * d.Interval)) {
+		log.Printf("[CLUSTER] member %s removed\n", addr)
+	}
+}
+
+func (d *Discovery) resolve(ctx context.Context) ([]string, error) {
+	addrs := append([]string(nil), d.Seeds...)
+	if d.SRV == "" {
+		return addrs, nil
+	}
+
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	_, records, err := resolver.LookupSRV(ctx, "", "", d.SRV)
+	if err != nil {
+		return addrs, err
+	}
+	for _, srv := range records {
+		host := strings.TrimSuffix(srv.Target, ".")
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+	}
+	return addrs, nil
+}
+
+func (d *Discovery) join(ctx context.Context, addr string) error {
+	body, _ := json.Marshal(JoinRequest{Addr: d.Members.Self()})
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.scheme()+"://"+addr+"/cluster/join", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var joined JoinResponse
+	if err := json.NewDecoder(resp.Body).Decode(&joined); err != nil {
+		return err
+	}
+
+	d.Members.Touch(addr, "discovery")
+	for _, peer := range joined.Members {
+		d.Members.Add(peer, "gossip")
+	}
+	return nil
+}
+
+func (d *Discovery) client() *http.Client {
+	if d.Client != nil {
+		return d.Client
+	}
+	return http.DefaultClient
+}
+
+func (d *Discovery) scheme() string {
+	if d.Scheme != "" {
+		return d.Scheme
+	}
+	return "http"
+}
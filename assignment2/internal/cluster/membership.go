@@ -0,0 +1,99 @@
+package cluster
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type Member struct {
+	Addr     string    `json:"addr"`
+	Source   string    `json:"source"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Membership is the set of peers this node currently knows about.
+type Membership struct {
+	mu      sync.Mutex
+	self    string
+	members map[string]Member
+}
+
+func NewMembership(self string) *Membership {
+	return &Membership{
+		self:    self,
+		members: make(map[string]Member),
+	}
+}
+
+func (m *Membership) Self() string {
+	return m.self
+}
+
+// Touch records that addr was seen alive. The node's own address is ignored.
+func (m *Membership) Touch(addr, source string) {
+	if addr == "" || addr == m.self {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	member, ok := m.members[addr]
+	if !ok {
+		member = Member{Addr: addr, Source: source}
+	}
+	member.LastSeen = time.Now()
+	m.members[addr] = member
+}
+
+// Add records addr only if it is not already known, so a peer that is
+// merely gossiped about cannot keep a dead member alive.
+func (m *Membership) Add(addr, source string) {
+	if addr == "" || addr == m.self {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.members[addr]; !ok {
+		m.members[addr] = Member{Addr: addr, Source: source, LastSeen: time.Now()}
+	}
+}
+
+// Prune drops members that have not been seen since the given time.
+func (m *Membership) Prune(before time.Time) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var removed []string
+	for addr, member := range m.members {
+		if member.LastSeen.Before(before) {
+			delete(m.members, addr)
+			removed = append(removed, addr)
+		}
+	}
+	return removed
+}
+
+func (m *Membership) List() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]Member, 0, len(m.members))
+	for _, member := range m.members {
+		list = append(list, member)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Addr < list[j].Addr })
+	return list
+}
+
+func (m *Membership) Addrs() []string {
+	members := m.List()
+	addrs := make([]string, len(members))
+	for i, member := range members {
+		addrs[i] = member.Addr
+	}
+	return addrs
+}
@@ -0,0 +1,82 @@
+package cluster
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+)
+
+// ringReplicas is how many points each node has on the ring; more points
+// spread keys more evenly.
+const ringReplicas = 128
+
+// Ring places keys on nodes by consistent hashing: each node owns the
+// arcs before its points, so adding or removing a node only moves the
+// keys on the arcs it gains or loses. Every node that builds a ring from
+// the same addresses places every key the same way.
+type Ring struct {
+	points []uint64
+	owners map[uint64]string
+	nodes  int
+}
+
+// NewRing builds the ring of nodes; duplicates count once.
+func NewRing(nodes []string) *Ring {
+	r := &Ring{owners: make(map[uint64]string)}
+	seen := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		if node == "" || seen[node] {
+			continue
+		}
+		seen[node] = true
+		r.nodes++
+		for i := 0; i < ringReplicas; i++ {
+			p := hash64(node, byte(i), byte(i>>8))
+			// On a collision the smaller address wins, whatever the order
+			// the nodes came in.
+			if owner, ok := r.owners[p]; ok && owner < node {
+				continue
+			} else if !ok {
+				r.points = append(r.points, p)
+			}
+			r.owners[p] = node
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Hash is where key falls on the ring.
+func Hash(key string) uint64 {
+	return hash64(key)
+}
+
+// Lookup returns up to n distinct nodes for key, its owner first and
+// then the nodes met walking the ring on from it, the natural places for
+// copies.
+func (r *Ring) Lookup(key string, n int) []string {
+	if n > r.nodes {
+		n = r.nodes
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	h := Hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	out := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for j := 0; len(out) < n; j++ {
+		node := r.owners[r.points[(i+j)%len(r.points)]]
+		if !seen[node] {
+			seen[node] = true
+			out = append(out, node)
+		}
+	}
+	return out
+}
+
+func hash64(s string, suffix ...byte) uint64 {
+	sum := sha256.Sum256(append([]byte(s), suffix...))
+	return binary.BigEndian.Uint64(sum[:8])
+}
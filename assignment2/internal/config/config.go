@@ -0,0 +1,919 @@
+package config
+
+import (
+	"assignment2/internal/logging"
+	"flag"
+	"fmt"
+	"math"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy deletes keys with Prefix that have not been changed
+// for MaxAge.
+type RetentionPolicy struct {
+	Prefix string
+	MaxAge time.Duration
+}
+
+// CachePolicy is how long shared caches may keep reads of keys with
+// Prefix; 0 makes them check back every time.
+type CachePolicy struct {
+	Prefix string
+	MaxAge time.Duration
+}
+
+// FaultSpec describes the faults injected into a route. Each rate is the
+// probability, from 0 to 1, that a request gets that fault.
+type FaultSpec struct {
+	Latency     time.Duration
+	LatencyRate float64
+	ErrorStatus int
+	ErrorRate   float64
+	DropRate    float64
+}
+
+// SLO is a latency objective: the share of requests, from 0 to 1, that
+// should be answered within Threshold.
+type SLO struct {
+	Objective float64
+	Threshold time.Duration
+}
+
+type Config struct {
+	Addr string
+
+	// Client connections
+	ReadHeaderTimeout time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	KeepAlive         bool
+
+	// Persistence
+	DataDir       string
+	WALSyncWindow time.Duration
+	WALNoSync     bool
+	Dedup         bool
+
+	// What writes get while the write-ahead log can't be written: "fail"
+	// or "buffer" (up to WALFailureBuffer MB).
+	WALFailurePolicy string
+	WALFailureBuffer int64
+
+	// Initial dataset
+	Seed     string
+	SeedMode string
+
+	// Staged imports, per tenant: keys and megabytes a staging area
+	// may hold
+	ImportMaxKeys  int
+	ImportMaxBytes int64
+
+	// Asynchronous bulk imports (POST /imports): how many run at once
+	// and the largest file in megabytes
+	BulkImportWorkers int
+	BulkImportMaxSize int64
+
+	// Largest value POST /data/{key}/append may grow a key to, in bytes
+	AppendMaxBytes int
+
+	// Longest advisory edit lock (POST /data/{key}/lock)
+	LockMaxTTL time.Duration
+
+	// Sessions (/sessions): idle time after which a session expires, 0
+	// to disable them, and the key prefix they are stored under
+	SessionTTL    time.Duration
+	SessionPrefix string
+
+	// Blob uploads
+	BlobDir       string
+	BlobMaxPart   int64
+	BlobUploadTTL time.Duration
+
+	// Retention
+	Retention         []RetentionPolicy
+	RetentionDryRun   bool
+	RetentionInterval time.Duration
+
+	// Archiving of keys unchanged for longer than a policy's MaxAge to
+	// ArchiveURL (s3://bucket/prefix or a directory), leaving tombstones
+	// under ArchiveTombstonePrefix; reads of archived keys get a hint
+	// or rehydrate them, as ArchiveReads says
+	Archive                []RetentionPolicy
+	ArchiveURL             string
+	ArchiveS3Endpoint      string
+	ArchiveS3Region        string
+	ArchiveTombstonePrefix string
+	ArchiveReads           string
+	ArchiveInterval        time.Duration
+
+	// Integrity checks of memory against the write-ahead log
+	IntegrityInterval time.Duration
+
+	// Write-ahead log compaction
+	CompactInterval    time.Duration
+	CompactMinSize     int64
+	CompactBytesPerSec int64
+
+	// Deletion of keys whose time to live has run out
+	TTLSweepInterval time.Duration
+
+	// Background job watchdog
+	JobStallTimeout time.Duration
+	JobRestart      bool
+
+	// Maintenance jobs wait while the server handles at least
+	// JobPauseRPS requests a second (0 = never), for at most JobMaxDefer
+	// in a row
+	JobPauseRPS float64
+	JobMaxDefer time.Duration
+
+	// Memory pressure
+	MemoryLimit    int64
+	MemoryPolicy   string
+	MemoryInterval time.Duration
+
+	// Values kept in memory, in MB (0 = all), and how often colder
+	// ones are moved to disk
+	TierBudget   int64
+	TierInterval time.Duration
+
+	// Cache of encoded GET /data responses
+	ListCacheSize int
+
+	// Keys a ?filter= expression may be evaluated on per listing
+	FilterMaxKeys int
+
+	// Cache-Control max-age of reads under /data, by key prefix
+	CacheMaxAge []CachePolicy
+
+	// Response format of requests that don't ask for one
+	APIVersion int
+
+	// Watch
+	WatchHistory  int
+	WatchBuffer   int
+	WatchOverflow string
+
+	// Cluster membership
+	AdvertiseAddr  string
+	ClusterSeeds   []string
+	ClusterSRV     string
+	ClusterRefresh time.Duration
+
+	// Kubernetes Lease based active/standby
+	LeaseName        string
+	LeaseNamespace   string
+	LeaseDuration    time.Duration
+	StandbyMode      string
+	StandbyReplicate bool
+	MinRevisionWait  time.Duration
+
+	// Log shipping: the primary seals its changes into segments under
+	// <data-dir>/shipping, and a standby with ShipFrom pulls and applies
+	// them
+	LogShipping     bool
+	ShipSegmentSize int64
+	ShipSegmentAge  time.Duration
+	ShipRetain      int
+	ShipFrom        string
+	ShipPoll        time.Duration
+
+	// Mirroring of write traffic to a secondary server
+	MirrorURL     string
+	MirrorPercent float64
+	MirrorTimeout time.Duration
+	MirrorQueue   int
+
+	// Secondary stores every change is copied to in the background
+	// (dynamodb://table, or s3://bucket/prefix or a directory for
+	// snapshots), and how often they are compared with this one
+	Secondaries               []string
+	SecondaryQueue            int
+	SecondarySnapshotInterval time.Duration
+	ReconcileInterval         time.Duration
+
+	// Circuit breaker for proxied upstreams
+	BreakerFailures int
+	BreakerCooldown time.Duration
+
+	// Concurrency limits per route pattern, e.g. "GET /data" -> 4
+	ConcurrencyLimits map[string]int
+	ConcurrencyWait   time.Duration
+
+	// Requests running at once across all routes before the rest queue
+	// in priority lanes (high, normal, low) let in by weight; 0 is
+	// unlimited
+	MaxInFlight     int
+	PriorityWait    time.Duration
+	PriorityWeights map[string]int
+
+	// Request body bytes per second each API key (or client IP) may
+	// write, with bursts of WriteBurstBytes; 0 is unlimited
+	WriteBytesPerSec  int64
+	WriteBurstBytes   int64
+	WriteThrottleWait time.Duration
+
+	// Latency objectives per route pattern ("*" for every client route
+	// without one), tracked over SLOWindow
+	SLOs      map[string]SLO
+	SLOWindow time.Duration
+
+	// Fault injection per route pattern ("*" for all), for testing
+	// clients; never enable in production
+	Faults map[string]FaultSpec
+
+	// Mock mode: JSON file of canned responses served instead of the
+	// real handlers, for contract tests; never enable in production
+	Mock string
+
+	// Test mode: POST /admin/checkpoint and /admin/reset, for
+	// integration test suites; never enable in production
+	Checkpoints bool
+
+	// Network access lists
+	IPAllow []string
+	IPDeny  []string
+
+	// Key prefixes readable by anyone under /public/data, and how long
+	// browsers and proxies may cache them
+	PublicPrefixes []string
+	PublicMaxAge   time.Duration
+
+	// Key prefix holding feature flags for GET /flags/evaluate; values
+	// under it must be flag definitions
+	FlagPrefix string
+
+	// TLS and client certificate authentication
+	TLSCert       string
+	TLSKey        string
+	TLSClientCA   string
+	TLSClientAuth string
+	TLSRoleMap    string
+
+	// TLS between cluster peers
+	PeerTLSCA   string
+	PeerTLSCert string
+	PeerTLSKey  string
+	PeerMTLS    bool
+
+	// Tenant API keys
+	APIKeysFile string
+
+	// Megabytes each tenant may store, the fraction of it past which
+	// writes carry a warning, how often usage is measured, and where
+	// crossings are reported
+	TenantQuota     int64
+	TenantQuotaSoft float64
+	QuotaInterval   time.Duration
+	QuotaWebhook    string
+
+	// v3 JSON API, in the shapes of etcd's JSON gateway
+	V3API bool
+
+	// HMAC request signing
+	HMACKeysFile  string
+	HMACMaxSkew   time.Duration
+	HMACMaxBody   int64
+	NonceCapacity int
+
+	// Server log
+	LogLevel logging.Level
+
+	// Access log
+	AccessLog           string
+	AccessLogMaxSize    int64
+	AccessLogMaxAge     time.Duration
+	AccessLogMaxBackups int
+	AccessLogCompress   bool
+
+	// Event log
+	EventLog           string
+	EventLogPrefixes   []string
+	EventLogMaxSize    int64
+	EventLogMaxAge     time.Duration
+	EventLogMaxBackups int
+	EventLogCompress   bool
+
+	// Audit log of destructive and administrative actions
+	AuditLog string
+
+	// Requests slower than this are logged with a timing breakdown
+	SlowRequest time.Duration
+
+	// Stats history
+	StatsSampleInterval time.Duration
+	StatsHistorySize    int
+
+	// Key segments of the prefixes in GET /stats/contention; 0 disables
+	// contention tracking
+	ContentionDepth int
+
+	// Keys whose reads and writes are tracked by name for GET
+	// /stats/hotkeys (0 disables tracking), and how often the counts
+	// are halved
+	HotKeys         int
+	HotKeysHalfLife time.Duration
+
+	// Maintenance mode defaults
+	MaintenanceMessage    string
+	MaintenanceRetryAfter time.Duration
+
+	// Every flag's value, whether given or defaulted, and the names of
+	// those given, for the startup summary
+	Flags    map[string]string
+	FlagsSet []string
+}
+
+func Load(args []string) (Config, error) {
+	var cfg Config
+	var seeds, secondaries, ipAllow, ipDeny, publicPrefixes, retention, archive, cacheMaxAge, limits, weights, slos, faults, eventPrefixes, logLevel string
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.StringVar(&cfg.Addr, "addr", ":8080", "listen address")
+	fs.DurationVar(&cfg.ReadHeaderTimeout, "read-header-timeout", 10*time.Second, "how long a client may take to send request headers")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", 2*time.Minute, "close keep-alive connections idle for this long")
+	fs.IntVar(&cfg.MaxHeaderBytes, "max-header-bytes", 1<<20, "maximum size of request headers")
+	fs.BoolVar(&cfg.KeepAlive, "keep-alive", true, "reuse client connections for several requests")
+	fs.StringVar(&cfg.DataDir, "data-dir", "", "directory for the write-ahead log (in-memory only when empty)")
+	fs.DurationVar(&cfg.WALSyncWindow, "wal-sync-window", 2*time.Millisecond,
+		"group-commit window: concurrent writes arriving within it share one fsync; larger values raise throughput but add up to this much latency per write")
+	fs.BoolVar(&cfg.WALNoSync, "wal-no-sync", false, "skip fsync on commit (faster, but acknowledged writes can be lost on a crash)")
+	fs.StringVar(&cfg.WALFailurePolicy, "wal-failure-policy", "fail",
+		"while the write-ahead log can't be written (disk full, volume gone): fail (refuse writes with 503) or buffer (keep acknowledging them from memory, up to -wal-failure-buffer); either way they are written once it recovers")
+	fs.Int64Var(&cfg.WALFailureBuffer, "wal-failure-buffer", 256, "megabytes of writes buffered with -wal-failure-policy=buffer before writes are refused")
+	fs.BoolVar(&cfg.Dedup, "dedup", false, "store identical values once (costs a SHA-256 per write)")
+	fs.StringVar(&cfg.Seed, "seed", "", "JSON or CSV file, or http(s) URL, of an initial dataset")
+	fs.StringVar(&cfg.SeedMode, "seed-mode", "first-boot", "when -seed is applied: first-boot (only to a store never written to), merge or replace")
+	fs.IntVar(&cfg.ImportMaxKeys, "import-max-keys", 1000000, "keys a staged import (POST /import) may hold")
+	fs.Int64Var(&cfg.ImportMaxBytes, "import-max-bytes", 256, "megabytes of keys and values a staged import may hold")
+	fs.IntVar(&cfg.BulkImportWorkers, "bulk-import-workers", 2, "bulk imports (POST /imports) processed at the same time; others wait their turn")
+	fs.Int64Var(&cfg.BulkImportMaxSize, "bulk-import-max-size", 1024, "largest file a bulk import (POST /imports) may upload, in megabytes")
+	fs.IntVar(&cfg.AppendMaxBytes, "append-max-bytes", 1<<20, "largest value, in bytes, POST /data/{key}/append may grow a key to")
+	fs.DurationVar(&cfg.LockMaxTTL, "lock-max-ttl", time.Hour, "longest time an edit lock (POST /data/{key}/lock) is held without renewal")
+	fs.DurationVar(&cfg.SessionTTL, "session-ttl", 0, "enables /sessions: a session expires after this long without being read or written (0 = disabled)")
+	fs.StringVar(&cfg.SessionPrefix, "session-prefix", "sessions/", "key prefix sessions are stored under")
+	fs.StringVar(&cfg.BlobDir, "blob-dir", "", "directory for uploaded blobs (default <data-dir>/blobs; uploads are disabled without either)")
+	fs.Int64Var(&cfg.BlobMaxPart, "blob-max-part", 64, "maximum size of one upload part in megabytes")
+	fs.DurationVar(&cfg.BlobUploadTTL, "blob-upload-ttl", 24*time.Hour, "discard upload sessions that are not committed within this time")
+	fs.StringVar(&retention, "retention", "", "comma-separated prefix=max-age retention policies, e.g. events:=168h")
+	fs.BoolVar(&cfg.RetentionDryRun, "retention-dry-run", false, "only report what the -retention policies would delete")
+	fs.DurationVar(&cfg.RetentionInterval, "retention-interval", time.Minute, "how often retention policies are enforced")
+	fs.StringVar(&archive, "archive", "", "comma-separated prefix=age policies: move keys unchanged for longer than age to -archive-url, e.g. logs:=720h")
+	fs.StringVar(&cfg.ArchiveURL, "archive-url", "", "where archived keys are written: s3://bucket/prefix or a directory")
+	fs.StringVar(&cfg.ArchiveS3Endpoint, "archive-s3-endpoint", "", "S3 endpoint for -archive-url, e.g. of MinIO (default https://s3.<region>.amazonaws.com)")
+	fs.StringVar(&cfg.ArchiveS3Region, "archive-s3-region", "us-east-1", "S3 region for -archive-url")
+	fs.StringVar(&cfg.ArchiveTombstonePrefix, "archive-tombstone-prefix", "archived/", "key prefix of the tombstones that point archived keys to their archive")
+	fs.StringVar(&cfg.ArchiveReads, "archive-reads", "hint", "reads of archived keys: hint (404 naming the archive) or rehydrate (restore the key and serve it)")
+	fs.DurationVar(&cfg.ArchiveInterval, "archive-interval", time.Hour, "how often -archive policies are enforced")
+	fs.DurationVar(&cfg.CompactInterval, "compact-interval", time.Minute, "how often the write-ahead log size is checked for compaction (with -data-dir)")
+	fs.Int64Var(&cfg.CompactMinSize, "compact-min-size", 64, "compact the write-ahead log once it exceeds this many megabytes and twice its last snapshot (0 = only on request)")
+	fs.Int64Var(&cfg.CompactBytesPerSec, "compact-bytes-per-sec", 0, "how fast compactions may write the snapshot, in bytes per second (0 = no limit)")
+	fs.DurationVar(&cfg.JobStallTimeout, "job-stall-timeout", 5*time.Minute, "how long a background job may run without a heartbeat before it counts as stalled (0 = no watchdog)")
+	fs.BoolVar(&cfg.JobRestart, "job-restart-stalled", false, "cancel stalled background jobs and restart them, as well as jobs whose goroutine stopped")
+	fs.Float64Var(&cfg.JobPauseRPS, "job-pause-rps", 0, "hold back maintenance jobs (archive, compaction, integrity, retention, ttl, upload cleanup) while requests per second are at least this (0 = never)")
+	fs.DurationVar(&cfg.JobMaxDefer, "job-max-defer", 10*time.Minute, "longest a maintenance job is held back by -job-pause-rps before it runs anyway (0 = no limit)")
+	fs.Int64Var(&cfg.MemoryLimit, "memory-limit", 0, "heap size in megabytes above which -memory-policy applies (0 = no limit)")
+	fs.StringVar(&cfg.MemoryPolicy, "memory-policy", "reject", "what to do above -memory-limit: reject (writes get 503), evict (delete the least recently changed keys) or gc (force garbage collection)")
+	fs.DurationVar(&cfg.MemoryInterval, "memory-check-interval", time.Second, "how often heap usage is checked against -memory-limit")
+	fs.DurationVar(&cfg.TTLSweepInterval, "ttl-sweep-interval", time.Second, "how often expired keys are deleted (they are hidden from reads as soon as they expire)")
+	fs.DurationVar(&cfg.IntegrityInterval, "integrity-interval", 10*time.Minute, "how often the store is compared with its write-ahead log (with -data-dir)")
+	fs.Int64Var(&cfg.TierBudget, "tier-budget", 0, "megabytes of keys and values kept in memory; the least recently accessed values beyond it are kept on disk (needs -data-dir; 0 = all in memory)")
+	fs.DurationVar(&cfg.TierInterval, "tier-interval", time.Second, "how often values over -tier-budget are moved to disk")
+	fs.IntVar(&cfg.ListCacheSize, "list-cache-size", 32, "megabytes of encoded GET /data responses kept until the next write (0 = no cache)")
+	fs.IntVar(&cfg.FilterMaxKeys, "filter-max-keys", 100000, "keys a GET /data?filter= expression may be evaluated on; listings that would need more are refused")
+	fs.IntVar(&cfg.APIVersion, "api-version", 1, "response format of requests that name none with a /v1/ or /v2/ path prefix or an Accept: application/vnd.kv.vN+json header: 1 (bare bodies) or 2 (enveloped)")
+	fs.StringVar(&cacheMaxAge, "cache-max-age", "", "comma-separated prefix=duration list of how long proxies and CDNs may cache GET /data responses for keys with prefix; the longest matching prefix wins (no caching when none match)")
+	fs.IntVar(&cfg.WatchHistory, "watch-history", 10000, "number of recent events kept so watchers can resume with since=")
+	fs.IntVar(&cfg.WatchBuffer, "watch-buffer", 256, "events queued per watcher before -watch-overflow applies")
+	fs.StringVar(&cfg.WatchOverflow, "watch-overflow", "disconnect", "what happens when a watcher's queue is full: disconnect, drop-oldest or coalesce (drop a queued event for the same key)")
+	fs.StringVar(&cfg.AdvertiseAddr, "advertise-addr", "", "address peers use to reach this node (default hostname + listen port)")
+	fs.StringVar(&seeds, "cluster-seeds", "", "comma-separated list of static peer addresses")
+	fs.StringVar(&cfg.ClusterSRV, "cluster-srv", "", "DNS SRV name used to discover peers (e.g. _kv._tcp.kv.default.svc.cluster.local)")
+	fs.DurationVar(&cfg.ClusterRefresh, "cluster-refresh", 30*time.Second, "how often peer membership is refreshed")
+	fs.StringVar(&cfg.LeaseName, "lease-name", "", "Kubernetes Lease to hold for active/standby mode (disabled when empty)")
+	fs.StringVar(&cfg.LeaseNamespace, "lease-namespace", "", "namespace of the Lease (default: the pod's namespace)")
+	fs.DurationVar(&cfg.LeaseDuration, "lease-duration", 15*time.Second, "how long a Lease is valid without renewal")
+	fs.BoolVar(&cfg.StandbyReplicate, "standby-replicate", false, "standbys follow the lease holder's changes so they can serve reads")
+	fs.DurationVar(&cfg.MinRevisionWait, "min-revision-wait", 2*time.Second, "how long a standby holds a read with X-Min-Revision for replication to catch up before proxying or refusing it")
+	fs.BoolVar(&cfg.LogShipping, "log-shipping", false, "seal changes into log segments under <data-dir>/shipping for standbys started with -ship-from")
+	fs.Int64Var(&cfg.ShipSegmentSize, "ship-segment-size", 16, "seal a log segment once its changes take this many megabytes")
+	fs.DurationVar(&cfg.ShipSegmentAge, "ship-segment-age", time.Minute, "seal a log segment at the latest this long after its first change, which bounds how far a standby falls behind")
+	fs.IntVar(&cfg.ShipRetain, "ship-retain", 1000, "sealed log segments kept for standbys; one that falls further behind starts over from a snapshot")
+	fs.StringVar(&cfg.ShipFrom, "ship-from", "", "run as a log shipping standby of the primary at this address, read-only until POST /admin/promote")
+	fs.DurationVar(&cfg.ShipPoll, "ship-poll", 5*time.Second, "how often a -ship-from standby asks for new log segments")
+	fs.StringVar(&cfg.StandbyMode, "standby-mode", "reject", "what a standby does with writes: reject (503) or proxy (to the lease holder)")
+	fs.StringVar(&cfg.MirrorURL, "mirror-url", "", "base URL of a secondary server that receives a copy of write requests in the background")
+	fs.Float64Var(&cfg.MirrorPercent, "mirror-percent", 100, "percentage of write requests mirrored to -mirror-url")
+	fs.DurationVar(&cfg.MirrorTimeout, "mirror-timeout", 5*time.Second, "timeout of a mirrored request")
+	fs.IntVar(&cfg.MirrorQueue, "mirror-queue", 1000, "mirrored requests queued before further ones are dropped")
+	fs.StringVar(&secondaries, "secondary", "", "comma-separated secondary stores changes are copied to in the background: dynamodb://table?region=R[&endpoint=URL], or s3://bucket/prefix[?region=R&endpoint=URL] or a directory for periodic snapshots")
+	fs.IntVar(&cfg.SecondaryQueue, "secondary-queue", 10000, "changes queued per -secondary before it falls back to a full resync")
+	fs.DurationVar(&cfg.SecondarySnapshotInterval, "secondary-snapshot-interval", 5*time.Minute, "how often snapshot secondaries are rewritten if anything changed")
+	fs.DurationVar(&cfg.ReconcileInterval, "reconcile-interval", 10*time.Minute, "how often each -secondary is compared with the store and divergent keys are reported")
+	fs.IntVar(&cfg.BreakerFailures, "breaker-failures", 5, "consecutive upstream failures that open the circuit breaker")
+	fs.DurationVar(&cfg.BreakerCooldown, "breaker-cooldown", 10*time.Second, "how long an open breaker waits before probing the upstream again")
+	fs.StringVar(&slos, "slo", "", "comma-separated route=objective<threshold latency SLOs, e.g. \"GET /data=99%<50ms,*=99.9%<200ms\" (\"*\" covers every client route without its own); compliance and burn rates are in GET /stats/slo")
+	fs.DurationVar(&cfg.SLOWindow, "slo-window", 30*24*time.Hour, "window over which SLO compliance and the error budget are computed")
+	fs.StringVar(&faults, "fault-injection", "", "comma-separated route=fault|fault... to inject for testing clients, with faults latency:DURATION@RATE, error[:STATUS]@RATE and drop@RATE, e.g. \"GET /data=latency:500ms@0.2|error:503@0.05,*=drop@0.01\"")
+	fs.StringVar(&cfg.Mock, "mock", "", "JSON file of fixtures (method, path, status, latency, headers, body) answered instead of the real handlers, for contract testing clients")
+	fs.BoolVar(&cfg.Checkpoints, "checkpoints", false, "enables POST /admin/checkpoint and POST /admin/reset?to=<name>, which save and restore all keys in memory for integration tests")
+	fs.StringVar(&limits, "concurrency-limits", "", "comma-separated route=max limits on concurrent requests, e.g. \"GET /data=4,POST /data/{key}/eval=2\"")
+	fs.IntVar(&cfg.MaxInFlight, "max-in-flight", 0, "requests handled at once before the rest queue by priority (0 = unlimited)")
+	fs.DurationVar(&cfg.PriorityWait, "priority-wait", 5*time.Second, "how long a request queued by -max-in-flight waits before a 503")
+	fs.StringVar(&weights, "priority-weights", "high=8,normal=4,low=1", "comma-separated class=weight shares of the priority lanes")
+	fs.DurationVar(&cfg.ConcurrencyWait, "concurrency-wait", time.Second, "how long a request over its route's limit waits for a slot before a 503 (0 = reject at once)")
+	fs.Int64Var(&cfg.WriteBytesPerSec, "write-bytes-per-sec", 0, "request body bytes per second each API key (or client IP without one) may write; bodies are read no faster (0 = unlimited)")
+	fs.Int64Var(&cfg.WriteBurstBytes, "write-burst-bytes", 0, "bytes a client may write at full speed before -write-bytes-per-sec applies (default: one second's worth)")
+	fs.DurationVar(&cfg.WriteThrottleWait, "write-throttle-wait", 10*time.Second, "writes from a client that is further behind -write-bytes-per-sec than this are rejected with 429")
+	fs.StringVar(&ipAllow, "ip-allow", "", "comma-separated CIDRs allowed to connect (all when empty)")
+	fs.StringVar(&ipDeny, "ip-deny", "", "comma-separated CIDRs that are always rejected")
+	fs.StringVar(&publicPrefixes, "public-prefixes", "", "comma-separated key prefixes served read-only, without authentication and with CORS open, under /public/data (none when empty)")
+	fs.DurationVar(&cfg.PublicMaxAge, "public-max-age", time.Minute, "Cache-Control max-age of /public/data responses")
+	fs.StringVar(&cfg.FlagPrefix, "flag-prefix", "", "key prefix holding feature flags, e.g. \"flags/\"; values under it must be flag definitions and are evaluated by GET /flags/evaluate (disabled when empty)")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", "", "PEM certificate for serving HTTPS")
+	fs.StringVar(&cfg.TLSKey, "tls-key", "", "PEM private key for -tls-cert")
+	fs.StringVar(&cfg.TLSClientCA, "tls-client-ca", "", "PEM CA bundle; when set, clients authenticate with certificates signed by it")
+	fs.StringVar(&cfg.TLSClientAuth, "tls-client-auth", "require", "require or optional client certificates when -tls-client-ca is set")
+	fs.StringVar(&cfg.PeerTLSCA, "peer-tls-ca", "", "PEM CA bundle that cluster peers' certificates are signed by; when set, peer traffic uses TLS verified against it, apart from -tls-client-ca")
+	fs.StringVar(&cfg.PeerTLSCert, "peer-tls-cert", "", "PEM certificate presented to other peers (default -tls-cert)")
+	fs.StringVar(&cfg.PeerTLSKey, "peer-tls-key", "", "PEM private key for -peer-tls-cert")
+	fs.BoolVar(&cfg.PeerMTLS, "peer-mtls", false, "require peers to present a certificate signed by -peer-tls-ca on peer endpoints")
+	fs.StringVar(&cfg.TLSRoleMap, "tls-role-map", "", "certificate name to roles, e.g. admin.example.com=admin,*.svc.local=read|write")
+	fs.StringVar(&cfg.APIKeysFile, "api-keys-file", "", "file of key:tenant lines; when set, data requests need an API key and are confined to the tenant's keys")
+	fs.Int64Var(&cfg.TenantQuota, "tenant-quota", 0, "megabytes of keys and values each tenant may store; writes beyond it are rejected with 507 (needs -api-keys-file; 0 = unlimited)")
+	fs.Float64Var(&cfg.TenantQuotaSoft, "tenant-quota-soft", 0.8, "fraction of -tenant-quota past which writes still succeed but carry an X-Quota-Warning header")
+	fs.DurationVar(&cfg.QuotaInterval, "quota-interval", 10*time.Second, "how often tenant usage is measured against -tenant-quota")
+	fs.StringVar(&cfg.QuotaWebhook, "quota-webhook", "", "URL POSTed a JSON notice whenever a tenant crosses the soft or hard quota, either way (none when empty)")
+	fs.BoolVar(&cfg.V3API, "v3-api", false, "serve range, put, deleterange and watch under /v3/ in the JSON shapes of etcd's v3 gateway (not gRPC, so not for etcd clients)")
+	fs.StringVar(&cfg.HMACKeysFile, "hmac-keys-file", "", "file of id:secret lines; when set, data requests must be HMAC-signed")
+	fs.DurationVar(&cfg.HMACMaxSkew, "hmac-max-skew", 5*time.Minute, "accepted clock difference for signed request timestamps")
+	fs.Int64Var(&cfg.HMACMaxBody, "hmac-max-body", 10, "maximum body of a signed request in megabytes; signed bodies are held in memory until the signature is checked")
+	fs.IntVar(&cfg.NonceCapacity, "nonce-capacity", 100000, "maximum number of remembered nonces")
+	fs.StringVar(&logLevel, "log-level", "info", "lowest level of server log lines written: debug, info, warn or error")
+	fs.StringVar(&cfg.AccessLog, "access-log", "", "path of the access log file (disabled when empty)")
+	fs.Int64Var(&cfg.AccessLogMaxSize, "access-log-max-size", 100, "rotate the access log after this many megabytes (0 = never)")
+	fs.DurationVar(&cfg.AccessLogMaxAge, "access-log-max-age", 24*time.Hour, "rotate the access log after this long (0 = never)")
+	fs.IntVar(&cfg.AccessLogMaxBackups, "access-log-max-backups", 7, "number of rotated access logs to keep (0 = all)")
+	fs.BoolVar(&cfg.AccessLogCompress, "access-log-compress", true, "gzip rotated access logs")
+	fs.StringVar(&cfg.EventLog, "event-log", "", "path of a file every mutation is appended to as an NDJSON event (disabled when empty)")
+	fs.StringVar(&eventPrefixes, "event-log-prefix", "", "comma-separated key prefixes written to the event log (all when empty)")
+	fs.Int64Var(&cfg.EventLogMaxSize, "event-log-max-size", 100, "rotate the event log after this many megabytes (0 = never)")
+	fs.DurationVar(&cfg.EventLogMaxAge, "event-log-max-age", 24*time.Hour, "rotate the event log after this long (0 = never)")
+	fs.IntVar(&cfg.EventLogMaxBackups, "event-log-max-backups", 7, "number of rotated event logs to keep (0 = all)")
+	fs.BoolVar(&cfg.EventLogCompress, "event-log-compress", true, "gzip rotated event logs")
+	fs.StringVar(&cfg.AuditLog, "audit-log", "", "path of a file bulk clears and other destructive actions are appended to as JSON lines (application log only when empty)")
+	fs.DurationVar(&cfg.SlowRequest, "slow-request", time.Second, "log requests that take longer than this with a timing breakdown (0 = off)")
+	fs.DurationVar(&cfg.StatsSampleInterval, "stats-sample-interval", 10*time.Second, "how often a stats sample is added to the history")
+	fs.IntVar(&cfg.StatsHistorySize, "stats-history-size", 360, "number of stats samples kept for GET /stats/history")
+	fs.IntVar(&cfg.HotKeys, "hotkeys", 0, "track reads and writes per key, remembering the hottest this many keys for GET /stats/hotkeys (0 = disabled)")
+	fs.DurationVar(&cfg.HotKeysHalfLife, "hotkeys-half-life", 10*time.Minute, "how often -hotkeys counts are halved, so that the list follows current traffic")
+	fs.IntVar(&cfg.ContentionDepth, "contention-depth", 1, "key segments (ending in ':' or '/') that lock waits and CAS conflicts are grouped by in GET /stats/contention; 0 disables tracking")
+	fs.StringVar(&cfg.MaintenanceMessage, "maintenance-message", "Server is under maintenance", "default message returned by data endpoints in maintenance mode")
+	fs.DurationVar(&cfg.MaintenanceRetryAfter, "maintenance-retry-after", time.Minute, "default Retry-After sent in maintenance mode")
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	cfg.Flags = make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) { cfg.Flags[f.Name] = f.Value.String() })
+	fs.Visit(func(f *flag.Flag) { cfg.FlagsSet = append(cfg.FlagsSet, f.Name) })
+
+	cfg.ClusterSeeds = splitList(seeds)
+	cfg.IPAllow = splitList(ipAllow)
+	cfg.IPDeny = splitList(ipDeny)
+	cfg.PublicPrefixes = splitList(publicPrefixes)
+	cfg.Secondaries = splitList(secondaries)
+	cfg.EventLogPrefixes = splitList(eventPrefixes)
+
+	for _, item := range splitList(retention) {
+		policy, err := ParseRetentionPolicy(item)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Retention = append(cfg.Retention, policy)
+	}
+	for _, item := range splitList(cacheMaxAge) {
+		policy, err := ParseCachePolicy(item)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.CacheMaxAge = append(cfg.CacheMaxAge, policy)
+	}
+	for _, item := range splitList(archive) {
+		policy, err := ParseRetentionPolicy(item)
+		if err != nil {
+			return cfg, err
+		}
+		if strings.HasPrefix(cfg.ArchiveTombstonePrefix, policy.Prefix) {
+			return cfg, fmt.Errorf("-archive prefix %q covers -archive-tombstone-prefix", policy.Prefix)
+		}
+		cfg.Archive = append(cfg.Archive, policy)
+	}
+	if len(cfg.Archive) > 0 && cfg.ArchiveURL == "" {
+		return cfg, fmt.Errorf("-archive requires -archive-url")
+	}
+	if cfg.ArchiveTombstonePrefix == "" {
+		return cfg, fmt.Errorf("-archive-tombstone-prefix must not be empty")
+	}
+	if cfg.ArchiveReads != "hint" && cfg.ArchiveReads != "rehydrate" {
+		return cfg, fmt.Errorf("-archive-reads must be hint or rehydrate")
+	}
+	if cfg.ArchiveInterval <= 0 {
+		return cfg, fmt.Errorf("-archive-interval must be positive")
+	}
+
+	if limits != "" {
+		cfg.ConcurrencyLimits = make(map[string]int)
+	}
+	for _, item := range splitList(limits) {
+		route, max, err := parseConcurrencyLimit(item)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.ConcurrencyLimits[route] = max
+	}
+
+	cfg.PriorityWeights = map[string]int{"high": 8, "normal": 4, "low": 1}
+	for _, item := range splitList(weights) {
+		class, weight, ok := strings.Cut(item, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(weight))
+		if _, known := cfg.PriorityWeights[strings.TrimSpace(class)]; !ok || !known || err != nil || n < 1 {
+			return cfg, fmt.Errorf("invalid -priority-weights entry %q: want high, normal or low = a positive weight", item)
+		}
+		cfg.PriorityWeights[strings.TrimSpace(class)] = n
+	}
+
+	if slos != "" {
+		cfg.SLOs = make(map[string]SLO)
+	}
+	for _, item := range splitList(slos) {
+		route, slo, err := parseSLO(item)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.SLOs[route] = slo
+	}
+	if cfg.SLOWindow < time.Hour {
+		return cfg, fmt.Errorf("-slo-window must be at least 1h")
+	}
+
+	if faults != "" {
+		cfg.Faults = make(map[string]FaultSpec)
+	}
+	for _, item := range splitList(faults) {
+		route, spec, err := parseFaults(item)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Faults[route] = spec
+	}
+
+	if cfg.BlobDir == "" && cfg.DataDir != "" {
+		cfg.BlobDir = filepath.Join(cfg.DataDir, "blobs")
+	}
+	if cfg.ReadHeaderTimeout <= 0 || cfg.IdleTimeout <= 0 {
+		return cfg, fmt.Errorf("-read-header-timeout and -idle-timeout must be positive")
+	}
+	if cfg.MaxHeaderBytes < 4096 {
+		return cfg, fmt.Errorf("-max-header-bytes must be at least 4096")
+	}
+	if cfg.RetentionInterval <= 0 {
+		return cfg, fmt.Errorf("-retention-interval must be positive")
+	}
+	if cfg.CompactInterval <= 0 {
+		return cfg, fmt.Errorf("-compact-interval must be positive")
+	}
+	if cfg.CompactBytesPerSec < 0 {
+		return cfg, fmt.Errorf("-compact-bytes-per-sec must not be negative")
+	}
+	if cfg.CompactMinSize < 0 {
+		return cfg, fmt.Errorf("-compact-min-size must not be negative")
+	}
+	if cfg.TTLSweepInterval <= 0 {
+		return cfg, fmt.Errorf("-ttl-sweep-interval must be positive")
+	}
+	level, err := logging.ParseLevel(logLevel)
+	if err != nil {
+		return cfg, fmt.Errorf("-log-level: %v", err)
+	}
+	cfg.LogLevel = level
+	if cfg.JobStallTimeout < 0 {
+		return cfg, fmt.Errorf("-job-stall-timeout must not be negative")
+	}
+	if cfg.JobPauseRPS < 0 || cfg.JobMaxDefer < 0 {
+		return cfg, fmt.Errorf("-job-pause-rps and -job-max-defer must not be negative")
+	}
+	if cfg.JobRestart && cfg.JobStallTimeout == 0 {
+		return cfg, fmt.Errorf("-job-restart-stalled requires -job-stall-timeout")
+	}
+	if cfg.MemoryLimit < 0 {
+		return cfg, fmt.Errorf("-memory-limit must not be negative")
+	}
+	if p := cfg.MemoryPolicy; p != "reject" && p != "evict" && p != "gc" {
+		return cfg, fmt.Errorf("invalid -memory-policy %q", p)
+	}
+	if cfg.MemoryInterval <= 0 {
+		return cfg, fmt.Errorf("-memory-check-interval must be positive")
+	}
+	if cfg.IntegrityInterval <= 0 {
+		return cfg, fmt.Errorf("-integrity-interval must be positive")
+	}
+	if cfg.TierBudget < 0 {
+		return cfg, fmt.Errorf("-tier-budget must not be negative")
+	}
+	if cfg.TierBudget > 0 && cfg.DataDir == "" {
+		return cfg, fmt.Errorf("-tier-budget requires -data-dir")
+	}
+	if cfg.TierInterval <= 0 {
+		return cfg, fmt.Errorf("-tier-interval must be positive")
+	}
+	if cfg.PublicMaxAge < 0 {
+		return cfg, fmt.Errorf("-public-max-age must not be negative")
+	}
+	if cfg.TenantQuota < 0 {
+		return cfg, fmt.Errorf("-tenant-quota must not be negative")
+	}
+	if cfg.TenantQuota > 0 && cfg.APIKeysFile == "" {
+		return cfg, fmt.Errorf("-tenant-quota requires -api-keys-file")
+	}
+	if cfg.TenantQuotaSoft <= 0 || cfg.TenantQuotaSoft > 1 {
+		return cfg, fmt.Errorf("-tenant-quota-soft must be above 0 and at most 1")
+	}
+	if cfg.QuotaInterval <= 0 {
+		return cfg, fmt.Errorf("-quota-interval must be positive")
+	}
+	if cfg.ListCacheSize < 0 {
+		return cfg, fmt.Errorf("-list-cache-size must not be negative")
+	}
+	if cfg.FilterMaxKeys < 1 {
+		return cfg, fmt.Errorf("-filter-max-keys must be at least 1")
+	}
+	if cfg.APIVersion < 1 || cfg.APIVersion > 2 {
+		return cfg, fmt.Errorf("-api-version must be 1 or 2")
+	}
+	if cfg.ImportMaxKeys < 1 || cfg.ImportMaxBytes < 1 {
+		return cfg, fmt.Errorf("-import-max-keys and -import-max-bytes must be at least 1")
+	}
+	if cfg.BulkImportWorkers < 1 || cfg.BulkImportMaxSize < 1 {
+		return cfg, fmt.Errorf("-bulk-import-workers and -bulk-import-max-size must be at least 1")
+	}
+	if cfg.AppendMaxBytes < 1 {
+		return cfg, fmt.Errorf("-append-max-bytes must be at least 1")
+	}
+	if cfg.LockMaxTTL <= 0 {
+		return cfg, fmt.Errorf("-lock-max-ttl must be positive")
+	}
+	if cfg.SessionTTL < 0 {
+		return cfg, fmt.Errorf("-session-ttl must not be negative")
+	}
+	if cfg.SessionTTL > 0 && cfg.SessionPrefix == "" {
+		return cfg, fmt.Errorf("-session-prefix must not be empty")
+	}
+	if cfg.BlobMaxPart < 1 {
+		return cfg, fmt.Errorf("-blob-max-part must be at least 1")
+	}
+	if cfg.HMACMaxBody < 1 {
+		return cfg, fmt.Errorf("-hmac-max-body must be at least 1")
+	}
+	if cfg.WatchBuffer < 1 {
+		return cfg, fmt.Errorf("-watch-buffer must be at least 1")
+	}
+	if p := cfg.WALFailurePolicy; p != "fail" && p != "buffer" {
+		return cfg, fmt.Errorf("invalid -wal-failure-policy %q", p)
+	}
+	if cfg.WALFailureBuffer < 0 {
+		return cfg, fmt.Errorf("-wal-failure-buffer must not be negative")
+	}
+	if o := cfg.WatchOverflow; o != "disconnect" && o != "drop-oldest" && o != "coalesce" {
+		return cfg, fmt.Errorf("invalid -watch-overflow %q", o)
+	}
+	if cfg.StatsHistorySize < 1 {
+		return cfg, fmt.Errorf("-stats-history-size must be at least 1")
+	}
+	if cfg.WriteBytesPerSec < 0 || cfg.WriteBurstBytes < 0 {
+		return cfg, fmt.Errorf("-write-bytes-per-sec and -write-burst-bytes must not be negative")
+	}
+	if cfg.ContentionDepth < 0 {
+		return cfg, fmt.Errorf("-contention-depth must not be negative")
+	}
+	if cfg.HotKeys < 0 {
+		return cfg, fmt.Errorf("-hotkeys must not be negative")
+	}
+	if cfg.HotKeysHalfLife <= 0 {
+		return cfg, fmt.Errorf("-hotkeys-half-life must be positive")
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return cfg, fmt.Errorf("-tls-cert and -tls-key must be set together")
+	}
+	if cfg.TLSClientCA != "" && cfg.TLSCert == "" {
+		return cfg, fmt.Errorf("-tls-client-ca requires -tls-cert")
+	}
+	if (cfg.PeerTLSCert == "") != (cfg.PeerTLSKey == "") {
+		return cfg, fmt.Errorf("-peer-tls-cert and -peer-tls-key must be set together")
+	}
+	if cfg.PeerTLSCA != "" && cfg.TLSCert == "" {
+		return cfg, fmt.Errorf("-peer-tls-ca requires -tls-cert")
+	}
+	if (cfg.PeerMTLS || cfg.PeerTLSCert != "") && cfg.PeerTLSCA == "" {
+		return cfg, fmt.Errorf("-peer-mtls and -peer-tls-cert require -peer-tls-ca")
+	}
+	if cfg.TLSClientAuth != "require" && cfg.TLSClientAuth != "optional" {
+		return cfg, fmt.Errorf("invalid -tls-client-auth %q", cfg.TLSClientAuth)
+	}
+	if cfg.MirrorPercent < 0 || cfg.MirrorPercent > 100 {
+		return cfg, fmt.Errorf("-mirror-percent must be between 0 and 100")
+	}
+	if cfg.MirrorURL != "" {
+		if u, err := url.Parse(cfg.MirrorURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return cfg, fmt.Errorf("invalid -mirror-url %q", cfg.MirrorURL)
+		}
+	}
+	if cfg.MirrorTimeout <= 0 {
+		return cfg, fmt.Errorf("-mirror-timeout must be positive")
+	}
+	if cfg.MirrorQueue < 1 {
+		return cfg, fmt.Errorf("-mirror-queue must be at least 1")
+	}
+	for _, sec := range cfg.Secondaries {
+		if err := checkSecondary(sec); err != nil {
+			return cfg, err
+		}
+	}
+	if cfg.SecondaryQueue < 1 {
+		return cfg, fmt.Errorf("-secondary-queue must be at least 1")
+	}
+	if cfg.SecondarySnapshotInterval <= 0 || cfg.ReconcileInterval <= 0 {
+		return cfg, fmt.Errorf("-secondary-snapshot-interval and -reconcile-interval must be positive")
+	}
+	if cfg.BreakerFailures < 1 {
+		return cfg, fmt.Errorf("-breaker-failures must be at least 1")
+	}
+	if cfg.SeedMode != "first-boot" && cfg.SeedMode != "merge" && cfg.SeedMode != "replace" {
+		return cfg, fmt.Errorf("invalid -seed-mode %q", cfg.SeedMode)
+	}
+	if cfg.StandbyMode != "reject" && cfg.StandbyMode != "proxy" {
+		return cfg, fmt.Errorf("invalid -standby-mode %q", cfg.StandbyMode)
+	}
+	if cfg.MaxInFlight < 0 {
+		return cfg, fmt.Errorf("-max-in-flight must not be negative")
+	}
+	if cfg.MinRevisionWait < 0 {
+		return cfg, fmt.Errorf("-min-revision-wait must not be negative")
+	}
+	if cfg.LogShipping && cfg.DataDir == "" {
+		return cfg, fmt.Errorf("-log-shipping requires -data-dir")
+	}
+	if cfg.LeaseName != "" && cfg.LeaseDuration < time.Second {
+		return cfg, fmt.Errorf("-lease-duration must be at least 1s")
+	}
+	if cfg.ShipFrom != "" && cfg.LeaseName != "" {
+		return cfg, fmt.Errorf("-ship-from and -lease-name cannot be combined")
+	}
+	if cfg.ShipSegmentSize < 1 || cfg.ShipSegmentAge <= 0 || cfg.ShipRetain < 1 || cfg.ShipPoll <= 0 {
+		return cfg, fmt.Errorf("-ship-segment-size, -ship-segment-age, -ship-retain and -ship-poll must be positive")
+	}
+	return cfg, nil
+}
+
+// ClusterEnabled reports whether any peer discovery source is configured.
+func (c Config) ClusterEnabled() bool {
+	return len(c.ClusterSeeds) > 0 || c.ClusterSRV != ""
+}
+
+// ParseRetentionPolicy parses "prefix=max-age". The prefix may be empty
+// to match every key.
+func ParseRetentionPolicy(s string) (RetentionPolicy, error) {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return RetentionPolicy{}, fmt.Errorf("invalid retention policy %q: want prefix=max-age", s)
+	}
+	maxAge, err := time.ParseDuration(s[i+1:])
+	if err != nil || maxAge <= 0 {
+		return RetentionPolicy{}, fmt.Errorf("invalid retention max age in %q", s)
+	}
+	return RetentionPolicy{Prefix: s[:i], MaxAge: maxAge}, nil
+}
+
+// ParseCachePolicy parses "prefix=max-age". The prefix may be empty to
+// match every key, and the max age 0.
+func ParseCachePolicy(s string) (CachePolicy, error) {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return CachePolicy{}, fmt.Errorf("invalid cache policy %q: want prefix=max-age", s)
+	}
+	maxAge, err := time.ParseDuration(s[i+1:])
+	if err != nil || maxAge < 0 {
+		return CachePolicy{}, fmt.Errorf("invalid cache max age in %q", s)
+	}
+	return CachePolicy{Prefix: s[:i], MaxAge: maxAge}, nil
+}
+
+// checkSecondary checks a -secondary URL: dynamodb://table?region=R,
+// s3://bucket/prefix, or a directory.
+func checkSecondary(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid -secondary %q: %v", s, err)
+	}
+	switch u.Scheme {
+	case "dynamodb":
+		if u.Host == "" || u.Query().Get("region") == "" {
+			return fmt.Errorf("invalid -secondary %q: want dynamodb://table?region=R", s)
+		}
+	case "s3":
+		if u.Host == "" {
+			return fmt.Errorf("invalid -secondary %q: want s3://bucket/prefix", s)
+		}
+	case "", "file":
+	default:
+		return fmt.Errorf("invalid -secondary %q: want dynamodb://, s3:// or a directory", s)
+	}
+	return nil
+}
+
+// parseConcurrencyLimit parses "METHOD /pattern=max".
+func parseConcurrencyLimit(s string) (string, int, error) {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return "", 0, fmt.Errorf("invalid concurrency limit %q: want route=max", s)
+	}
+	max, err := strconv.Atoi(s[i+1:])
+	if err != nil || max < 1 {
+		return "", 0, fmt.Errorf("invalid concurrency limit in %q", s)
+	}
+	return strings.Join(strings.Fields(s[:i]), " "), max, nil
+}
+
+// parseSLO parses "METHOD /pattern=objective%<threshold".
+func parseSLO(s string) (string, SLO, error) {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return "", SLO{}, fmt.Errorf("invalid SLO %q: want route=objective%%<threshold", s)
+	}
+	objective, threshold, ok := strings.Cut(s[i+1:], "<")
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(objective), "%"), 64)
+	if !ok || err != nil || pct <= 0 || pct >= 100 {
+		return "", SLO{}, fmt.Errorf("invalid SLO objective in %q: want a percentage between 0 and 100", s)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(threshold))
+	if err != nil || d <= 0 {
+		return "", SLO{}, fmt.Errorf("invalid SLO threshold in %q", s)
+	}
+	return strings.Join(strings.Fields(s[:i]), " "), SLO{Objective: math.Round(pct*1e7) / 1e9, Threshold: d}, nil
+}
+
+// parseFaults parses "METHOD /pattern=fault|fault...".
+func parseFaults(s string) (string, FaultSpec, error) {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return "", FaultSpec{}, fmt.Errorf("invalid fault injection %q: want route=faults", s)
+	}
+
+	var spec FaultSpec
+	for _, fault := range strings.Split(s[i+1:], "|") {
+		kind, rateText, ok := strings.Cut(strings.TrimSpace(fault), "@")
+		rate, err := strconv.ParseFloat(rateText, 64)
+		if !ok || err != nil || rate < 0 || rate > 1 {
+			return "", FaultSpec{}, fmt.Errorf("invalid fault %q in %q: want kind@rate with a rate from 0 to 1", fault, s)
+		}
+		kind, arg, _ := strings.Cut(kind, ":")
+
+		switch kind {
+		case "latency":
+			d, err := time.ParseDuration(arg)
+			if err != nil || d <= 0 {
+				return "", FaultSpec{}, fmt.Errorf("invalid latency in %q", s)
+			}
+			spec.Latency, spec.LatencyRate = d, rate
+		case "error":
+			spec.ErrorStatus = 500
+			if arg != "" {
+				code, err := strconv.Atoi(arg)
+				if err != nil || code < 400 || code > 599 {
+					return "", FaultSpec{}, fmt.Errorf("invalid error status in %q", s)
+				}
+				spec.ErrorStatus = code
+			}
+			spec.ErrorRate = rate
+		case "drop":
+			spec.DropRate = rate
+		default:
+			return "", FaultSpec{}, fmt.Errorf("unknown fault %q in %q (want latency, error or drop)", kind, s)
+		}
+	}
+	return strings.Join(strings.Fields(s[:i]), " "), spec, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
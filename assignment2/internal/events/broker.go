@@ -0,0 +1,390 @@
+package events
+
+import (
+	"assignment2/internal/storage"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrTooOld means the requested starting point is no longer in the
+// replay history.
+var ErrTooOld = errors.New("events since requested sequence are no longer available")
+
+var ErrClosed = errors.New("event broker closed")
+
+// TypeReset announces that the state was replaced wholesale, e.g. from a
+// snapshot; watchers should reload instead of applying further events to
+// what they have.
+const TypeReset = "reset"
+
+// Overflow is what happens when a subscriber's queue is full and another
+// event arrives for it.
+type Overflow string
+
+const (
+	// OverflowDisconnect ends the subscription (after the queued events
+	// have been received), so the subscriber resumes with since=.
+	OverflowDisconnect Overflow = "disconnect"
+
+	// OverflowDropOldest discards the oldest queued event.
+	OverflowDropOldest Overflow = "drop-oldest"
+
+	// OverflowCoalesce discards a queued event for the same key, so the
+	// subscriber still sees the latest change of every key. Without one
+	// it disconnects.
+	OverflowCoalesce Overflow = "coalesce"
+)
+
+// ParseOverflow checks an overflow policy name; "" is OverflowDisconnect.
+func ParseOverflow(s string) (Overflow, error) {
+	switch o := Overflow(s); o {
+	case "":
+		return OverflowDisconnect, nil
+	case OverflowDisconnect, OverflowDropOldest, OverflowCoalesce:
+		return o, nil
+	}
+	return "", fmt.Errorf("unknown overflow policy %q (want disconnect, drop-oldest or coalesce)", s)
+}
+
+type Event struct {
+	Seq   uint64    `json:"seq"`
+	Type  string    `json:"type"`
+	Key   string    `json:"key"`
+	Value string    `json:"value,omitempty"`
+	Tags  []string  `json:"tags,omitempty"`
+	Time  time.Time `json:"time"`
+
+	// Expires and TTL are a set's or an expire's time to live, as in
+	// storage.Record: the deadline in Unix nanoseconds (0 for none) and
+	// the duration it was granted for.
+	Expires int64 `json:"expires,omitempty"`
+	TTL     int64 `json:"ttl,omitempty"`
+
+	// Enc is, for a set, how the client encrypted the value.
+	Enc *storage.Encryption `json:"enc,omitempty"`
+}
+
+// Broker fans mutation events out to subscribers and keeps the most
+// recent ones so that a reconnecting subscriber can resume.
+type Broker struct {
+	mu      sync.Mutex
+	seq     uint64
+	history []Event
+	next    int
+	full    bool
+	subs    map[*Subscription]struct{}
+	closed  bool
+
+	bufferSize int
+
+	dropped      atomic.Int64
+	coalesced    atomic.Int64
+	disconnected atomic.Int64
+}
+
+// Stats are the broker's subscriber counts and overflow totals.
+type Stats struct {
+	Subscribers  int   `json:"subscribers"`
+	Queued       int   `json:"queued"`
+	Dropped      int64 `json:"dropped"`
+	Coalesced    int64 `json:"coalesced"`
+	Disconnected int64 `json:"disconnected"`
+}
+
+// Subscription receives events on C. Each one has a queue of at most the
+// broker's buffer size between Publish and C, so a slow subscriber costs
+// bounded memory and never holds up publishers; its overflow policy
+// decides what gives when the queue is full.
+type Subscription struct {
+	C <-chan Event
+
+	out      chan Event
+	filter   func(Event) bool
+	overflow Overflow
+	broker   *Broker
+	once     sync.Once
+
+	mu      sync.Mutex
+	queue   []Event
+	ended   bool // no more events will be queued
+	wake    chan struct{}
+	stop    chan struct{}
+	dropped atomic.Int64
+
+	// Disconnected is set when the subscriber fell so far behind that its
+	// queue overflowed; C is then closed after the queued events.
+	Disconnected atomic.Bool
+}
+
+// NewBroker returns a broker whose latest sequence number is seq, so a
+// subscriber may start from there even though nothing is in the history.
+func NewBroker(seq uint64, historySize, bufferSize int) *Broker {
+	return &Broker{
+		seq:        seq,
+		history:    make([]Event, historySize),
+		subs:       make(map[*Subscription]struct{}),
+		bufferSize: bufferSize,
+	}
+}
+
+// Publish delivers e. Events without a sequence number get the next one;
+// otherwise e.Seq must be greater than that of the previous event. It
+// never blocks, so it is safe to call while holding the store lock.
+func (b *Broker) Publish(e Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if e.Seq == 0 {
+		e.Seq = b.seq + 1
+	}
+	b.seq = e.Seq
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if e.Type == TypeReset {
+		// Nothing before a reset can be replayed meaningfully.
+		b.next, b.full = 0, false
+	} else if len(b.history) > 0 {
+		b.history[b.next] = e
+		b.next = (b.next + 1) % len(b.history)
+		if b.next == 0 {
+			b.full = true
+		}
+	}
+
+	for sub := range b.subs {
+		if sub.filter != nil && !sub.filter(e) {
+			continue
+		}
+		if !sub.push(e) {
+			b.disconnected.Add(1)
+			delete(b.subs, sub)
+		}
+	}
+	return e
+}
+
+// push queues e and reports whether the subscription goes on.
+func (s *Subscription) push(e Event) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queue) >= s.broker.bufferSize && !s.makeRoomLocked(e) {
+		s.Disconnected.Store(true)
+		s.endLocked()
+		return false
+	}
+	s.queue = append(s.queue, e)
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (s *Subscription) makeRoomLocked(e Event) bool {
+	switch s.overflow {
+	case OverflowDropOldest:
+		s.queue = s.queue[1:]
+		s.dropped.Add(1)
+		s.broker.dropped.Add(1)
+		return true
+	case OverflowCoalesce:
+		for i, q := range s.queue {
+			if q.Key == e.Key && q.Type != TypeReset {
+				s.queue = append(s.queue[:i], s.queue[i+1:]...)
+				s.dropped.Add(1)
+				s.broker.coalesced.Add(1)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// endLocked lets the pump close C once the queue is drained.
+func (s *Subscription) endLocked() {
+	if !s.ended {
+		s.ended = true
+		close(s.wake)
+	}
+}
+
+// pump moves queued events to C.
+func (s *Subscription) pump() {
+	defer close(s.out)
+
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			ended := s.ended
+			s.mu.Unlock()
+			if ended {
+				return
+			}
+			select {
+			case <-s.wake:
+			case <-s.stop:
+				return
+			}
+			continue
+		}
+		e := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		select {
+		case s.out <- e:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Dropped returns the number of events the subscription lost to its
+// overflow policy.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Stats returns the current subscriber counts and the overflow totals
+// since the broker was created.
+func (b *Broker) Stats() Stats {
+	b.mu.Lock()
+	st := Stats{Subscribers: len(b.subs)}
+	for sub := range b.subs {
+		sub.mu.Lock()
+		st.Queued += len(sub.queue)
+		sub.mu.Unlock()
+	}
+	b.mu.Unlock()
+
+	st.Dropped = b.dropped.Load()
+	st.Coalesced = b.coalesced.Load()
+	st.Disconnected = b.disconnected.Load()
+	return st
+}
+
+// Seq returns the sequence number of the latest event.
+func (b *Broker) Seq() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.seq
+}
+
+// Subscribe registers a subscriber for events matching filter (nil for
+// all) that handles a full queue as overflow says. With since > 0 the events after since that are still in the
+// history are returned as backlog, atomically with the registration, so
+// nothing is missed or duplicated between the two.
+func (b *Broker) Subscribe(since uint64, filter func(Event) bool, overflow Overflow) (*Subscription, []Event, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, nil, ErrClosed
+	}
+
+	var backlog []Event
+	if since > 0 {
+		var err error
+		if backlog, err = b.sinceLocked(since, filter); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if overflow == "" {
+		overflow = OverflowDisconnect
+	}
+	out := make(chan Event)
+	sub := &Subscription{
+		C:        out,
+		out:      out,
+		filter:   filter,
+		overflow: overflow,
+		broker:   b,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+	b.subs[sub] = struct{}{}
+	go sub.pump()
+	return sub, backlog, nil
+}
+
+// Since returns the events after since that match filter (nil for all)
+// from the history, or ErrTooOld if it no longer reaches back that far.
+// Since 0 asks for every event, which the history only has until it
+// first fills up.
+func (b *Broker) Since(since uint64, filter func(Event) bool) ([]Event, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sinceLocked(since, filter)
+}
+
+func (b *Broker) sinceLocked(since uint64, filter func(Event) bool) ([]Event, error) {
+	// A sequence from the future means the history was reset (e.g. by a
+	// restart), so the caller cannot resume either.
+	if since > b.seq {
+		return nil, ErrTooOld
+	}
+	if since == b.seq {
+		return nil, nil
+	}
+	oldest := b.oldestLocked()
+	if oldest == 0 || since+1 < oldest {
+		return nil, ErrTooOld
+	}
+	var out []Event
+	for _, e := range b.historyLocked() {
+		if e.Seq > since && (filter == nil || filter(e)) {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// Close ends every subscription and refuses new ones. Publishing still
+// works so that writers are not affected.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		sub.mu.Lock()
+		sub.endLocked()
+		sub.mu.Unlock()
+	}
+}
+
+// Close unregisters the subscription and discards what is queued.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		b := s.broker
+		b.mu.Lock()
+		delete(b.subs, s)
+		b.mu.Unlock()
+		close(s.stop)
+	})
+}
+
+func (b *Broker) oldestLocked() uint64 {
+	if b.full {
+		return b.history[b.next].Seq
+	}
+	if b.next == 0 {
+		return 0
+	}
+	return b.history[0].Seq
+}
+
+func (b *Broker) historyLocked() []Event {
+	if !b.full {
+		return b.history[:b.next]
+	}
+	return append(append([]Event(nil), b.history[b.next:]...), b.history[:b.next]...)
+}
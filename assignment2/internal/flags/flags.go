@@ -0,0 +1,156 @@
+// Package flags defines the feature flags stored under -flag-prefix and
+// evaluates them for a user.
+//
+// A flag's value is one of
+//
+//	true or false                 on or off for everyone
+//	25                            on for 25% of users
+//	{"enabled": true, "rollout": 25, "rules": [...]}
+//
+// In the object form "enabled" is the kill switch, "rollout" the percent
+// of users the flag is on for (100 when left out) and "rules" target
+// users by attribute before the rollout is applied:
+//
+//	{"attribute": "country", "in": ["KZ", "DE"], "value": true}
+//
+// The first rule whose attribute has one of the listed values decides.
+// The user's ID is the attribute "user".
+package flags
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+)
+
+// buckets is how finely users are spread over a rollout: percentages
+// have two decimals.
+const buckets = 10000
+
+// Flag is a parsed flag definition.
+type Flag struct {
+	Enabled bool     `json:"enabled"`
+	Rollout *float64 `json:"rollout,omitempty"`
+	Rules   []Rule   `json:"rules,omitempty"`
+}
+
+// Rule serves Value to the users whose Attribute is one of In.
+type Rule struct {
+	Attribute string   `json:"attribute"`
+	In        []string `json:"in"`
+	Value     *bool    `json:"value"`
+}
+
+// Reasons for an evaluation's result.
+const (
+	ReasonDisabled = "disabled"
+	ReasonEnabled  = "enabled"
+	ReasonRule     = "rule"
+	ReasonRollout  = "rollout"
+	ReasonNoUser   = "no_user"
+	ReasonInvalid  = "invalid"
+	ReasonMissing  = "missing"
+)
+
+// Result is a flag evaluated for one user.
+type Result struct {
+	Value  bool   `json:"value"`
+	Reason string `json:"reason"`
+
+	// Rule is the index of the rule that decided, with ReasonRule.
+	Rule *int `json:"rule,omitempty"`
+
+	// Bucket is where the user falls in 0-9999 when a rollout decided;
+	// the flag is on if it is below the rollout times 100.
+	Bucket *int `json:"bucket,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// Parse reads a flag definition in any of its forms.
+func Parse(value string) (Flag, error) {
+	data := bytes.TrimSpace([]byte(value))
+	switch {
+	case len(data) == 0:
+		return Flag{}, errors.New("empty flag")
+	case data[0] == '{':
+		var f Flag
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&f); err != nil {
+			return Flag{}, fmt.Errorf("invalid flag: %v", err)
+		}
+		return f, f.validate()
+	case string(data) == "true" || string(data) == "false":
+		return Flag{Enabled: string(data) == "true"}, nil
+	}
+
+	pct, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return Flag{}, errors.New("flag must be true, false, a percentage or an object")
+	}
+	f := Flag{Enabled: true, Rollout: &pct}
+	return f, f.validate()
+}
+
+func (f Flag) validate() error {
+	if f.Rollout != nil && !(*f.Rollout >= 0 && *f.Rollout <= 100) {
+		return errors.New("rollout must be between 0 and 100")
+	}
+	for i, r := range f.Rules {
+		switch {
+		case r.Attribute == "":
+			return fmt.Errorf("rule %d: attribute is required", i)
+		case len(r.In) == 0:
+			return fmt.Errorf("rule %d: in must list at least one value", i)
+		case r.Value == nil:
+			return fmt.Errorf("rule %d: value is required", i)
+		}
+	}
+	return nil
+}
+
+// Evaluate decides the flag called name for the user with attrs, whose
+// "user" attribute is the user's ID. The rollout hashes the flag name
+// with the ID, so a user keeps the same answer from every server and
+// across restarts, raising the rollout only adds users, and each flag
+// picks its own users.
+func (f Flag) Evaluate(name string, attrs map[string]string) Result {
+	if !f.Enabled {
+		return Result{Reason: ReasonDisabled}
+	}
+	for i, r := range f.Rules {
+		v, ok := attrs[r.Attribute]
+		if !ok {
+			continue
+		}
+		for _, want := range r.In {
+			if v == want {
+				i := i
+				return Result{Value: *r.Value, Reason: ReasonRule, Rule: &i}
+			}
+		}
+	}
+	if f.Rollout == nil || *f.Rollout >= 100 {
+		return Result{Value: true, Reason: ReasonEnabled}
+	}
+
+	user := attrs["user"]
+	if user == "" {
+		return Result{Reason: ReasonNoUser}
+	}
+	b := Bucket(name, user)
+	return Result{Value: float64(b) < *f.Rollout*buckets/100, Reason: ReasonRollout, Bucket: &b}
+}
+
+// Bucket places user in 0-9999 for the flag called name.
+func Bucket(name, user string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(user))
+	return int(h.Sum32() % buckets)
+}
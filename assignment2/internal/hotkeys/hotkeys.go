@@ -0,0 +1,233 @@
+// Package hotkeys finds the most accessed keys in bounded memory. Access
+// counts live in count-min sketches, which may overestimate a key but
+// never underestimate it, and only the keys with the highest estimates
+// are remembered by name.
+package hotkeys
+
+import (
+	"container/heap"
+	"hash/maphash"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	sketchDepth = 4
+	sketchWidth = 4096
+)
+
+// KeyStats is what the tracker knows about one key. LastRead and
+// LastWrite are zero if it was not read or written while remembered.
+type KeyStats struct {
+	Key       string
+	Reads     uint64
+	Writes    uint64
+	LastRead  time.Time
+	LastWrite time.Time
+}
+
+// Tracker counts reads and writes per key and keeps the Capacity keys
+// with the most of them. Its memory does not grow with the number of
+// keys.
+type Tracker struct {
+	Capacity int
+
+	mu     sync.Mutex
+	seed   maphash.Seed
+	reads  sketch
+	writes sketch
+	top    candidates
+	byKey  map[string]*candidate
+}
+
+type candidate struct {
+	KeyStats
+	index int
+}
+
+func (c *candidate) score() uint64 {
+	return c.Reads + c.Writes
+}
+
+func New(capacity int) *Tracker {
+	return &Tracker{
+		Capacity: capacity,
+		seed:     maphash.MakeSeed(),
+		reads:    newSketch(),
+		writes:   newSketch(),
+		byKey:    make(map[string]*candidate, capacity),
+	}
+}
+
+// Read counts a read of key.
+func (t *Tracker) Read(key string, now time.Time) {
+	t.record(key, now, false)
+}
+
+// Write counts a write of key.
+func (t *Tracker) Write(key string, now time.Time) {
+	t.record(key, now, true)
+}
+
+func (t *Tracker) record(key string, now time.Time, write bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h := maphash.String(t.seed, key)
+	var reads, writes uint64
+	if write {
+		writes = t.writes.add(h)
+		reads = t.reads.estimate(h)
+	} else {
+		reads = t.reads.add(h)
+		writes = t.writes.estimate(h)
+	}
+
+	c, ok := t.byKey[key]
+	if !ok {
+		if len(t.top) >= t.Capacity {
+			// Replace the coldest remembered key, if this one is hotter.
+			if t.Capacity == 0 || t.top[0].score() >= reads+writes {
+				return
+			}
+			delete(t.byKey, heap.Pop(&t.top).(*candidate).Key)
+		}
+		c = &candidate{KeyStats: KeyStats{Key: key}}
+		t.byKey[key] = c
+		heap.Push(&t.top, c)
+	}
+	c.Reads, c.Writes = reads, writes
+	if write {
+		c.LastWrite = now
+	} else {
+		c.LastRead = now
+	}
+	heap.Fix(&t.top, c.index)
+}
+
+// Top returns the n hottest keys by reads plus writes, or by reads or
+// writes alone, hottest first.
+func (t *Tracker) Top(n int, by string) []KeyStats {
+	t.mu.Lock()
+	out := make([]KeyStats, len(t.top))
+	for i, c := range t.top {
+		out[i] = c.KeyStats
+	}
+	t.mu.Unlock()
+
+	score := func(s KeyStats) uint64 {
+		switch by {
+		case "reads":
+			return s.Reads
+		case "writes":
+			return s.Writes
+		}
+		return s.Reads + s.Writes
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := score(out[i]), score(out[j])
+		return a > b || a == b && out[i].Key < out[j].Key
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// Tracked returns how many keys are remembered by name.
+func (t *Tracker) Tracked() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.top)
+}
+
+// Decay halves every count, so that keys that were hot long ago make way
+// for those that are hot now.
+func (t *Tracker) Decay() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.reads.halve()
+	t.writes.halve()
+	for _, c := range t.top {
+		c.Reads /= 2
+		c.Writes /= 2
+	}
+	heap.Init(&t.top)
+}
+
+// sketch is a count-min sketch: every key has a counter in each row, and
+// its count is the smallest of them.
+type sketch [sketchDepth][]uint64
+
+func newSketch() sketch {
+	var s sketch
+	for i := range s {
+		s[i] = make([]uint64, sketchWidth)
+	}
+	return s
+}
+
+// slot derives the column of row i from h, using a different part of it
+// per row.
+func slot(h uint64, i int) int {
+	h ^= uint64(i) * 0x9e3779b97f4a7c15
+	h ^= h >> 29
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 32
+	return int(h % sketchWidth)
+}
+
+// add counts one more for h and returns its new estimate. Only the
+// smallest counters grow (conservative update), which keeps the
+// overestimates down.
+func (s *sketch) add(h uint64) uint64 {
+	est := s.estimate(h) + 1
+	for i := range s {
+		if c := &s[i][slot(h, i)]; *c < est {
+			*c = est
+		}
+	}
+	return est
+}
+
+func (s *sketch) estimate(h uint64) uint64 {
+	est := ^uint64(0)
+	for i := range s {
+		est = min(est, s[i][slot(h, i)])
+	}
+	return est
+}
+
+func (s *sketch) halve() {
+	for i := range s {
+		for j := range s[i] {
+			s[i][j] /= 2
+		}
+	}
+}
+
+// candidates is a min-heap of the remembered keys by score.
+type candidates []*candidate
+
+func (h candidates) Len() int           { return len(h) }
+func (h candidates) Less(i, j int) bool { return h[i].score() < h[j].score() }
+func (h candidates) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *candidates) Push(x interface{}) {
+	c := x.(*candidate)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *candidates) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,114 @@
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Filter decides whether a client address may reach the server. The
+// deny list wins over the allow list; an empty allow list allows every
+// address that is not denied.
+type Filter struct {
+	mu    sync.RWMutex
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func New(allow, deny []string) (*Filter, error) {
+	f := &Filter{}
+	return f, f.Set(allow, deny)
+}
+
+// Set replaces both lists atomically.
+func (f *Filter) Set(allow, deny []string) error {
+	allowNets, err := parseAll(allow)
+	if err != nil {
+		return err
+	}
+	denyNets, err := parseAll(deny)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.allow, f.deny = allowNets, denyNets
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *Filter) Lists() (allow, deny []string) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return format(f.allow), format(f.deny)
+}
+
+// Check returns "" if ip may pass, otherwise the list that rejected it.
+func (f *Filter) Check(ip net.IP) string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if contains(f.deny, ip) {
+		return "deny"
+	}
+	if len(f.allow) > 0 && !contains(f.allow, ip) {
+		return "allow"
+	}
+	return ""
+}
+
+// Empty reports whether the filter lets everything through.
+func (f *Filter) Empty() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.allow) == 0 && len(f.deny) == 0
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAll accepts CIDRs and bare addresses, which match only themselves.
+func parseAll(items []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", item)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func format(nets []*net.IPNet) []string {
+	out := make([]string, len(nets))
+	for i, n := range nets {
+		out[i] = n.String()
+	}
+	return out
+}
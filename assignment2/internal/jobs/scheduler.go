@@ -0,0 +1,449 @@
+package jobs
+
+import (
+	"assignment2/internal/logging"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrUnknownJob = errors.New("unknown job")
+
+type Func func(ctx context.Context) error
+
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      Func
+
+	// Deferrable jobs are maintenance that can wait: their scheduled
+	// runs are skipped while the scheduler is paused.
+	Deferrable bool
+}
+
+// watchInterval is how often the watchdog looks at the jobs.
+const watchInterval = time.Second
+
+// Stats describes the run history of a job.
+type Stats struct {
+	Name          string
+	Interval      time.Duration
+	Runs          int64
+	Failures      int64
+	Running       bool
+	LastRun       time.Time
+	LastDuration  time.Duration
+	TotalDuration time.Duration
+	LastError     string
+	LastErrorAt   time.Time
+
+	// Watchdog state. RunningSince and LastHeartbeat are set while the
+	// job runs. Stalled is set when the run has gone without a heartbeat
+	// for longer than the stall timeout, Stopped when the job's goroutine
+	// exited on its own.
+	RunningSince  time.Time
+	LastHeartbeat time.Time
+	Stalled       bool
+	Stopped       bool
+	Restarts      int64
+
+	// Deferred counts scheduled runs skipped while the scheduler was
+	// paused; DeferredSince is when the current streak of them began.
+	Deferred      int64
+	DeferredSince time.Time
+}
+
+// Healthy reports whether the watchdog finds nothing wrong with the job.
+func (st Stats) Healthy() bool {
+	return !st.Stalled && !st.Stopped
+}
+
+type entry struct {
+	job     Job
+	trigger chan struct{}
+
+	mu    sync.Mutex
+	stats Stats
+
+	// gen counts the goroutines started for the job; one whose gen is
+	// behind has been replaced and leaves the stats alone.
+	gen     int
+	cancel  context.CancelFunc // cancels the current run
+	release func()             // stops Run from waiting for the goroutine
+}
+
+// Scheduler runs named jobs periodically, each in its own goroutine, and
+// keeps per-job counters. A job never overlaps with itself, unless the
+// watchdog restarts it while a stalled run ignores its cancellation.
+type Scheduler struct {
+	mu   sync.Mutex
+	jobs map[string]*entry
+
+	stallAfter time.Duration
+	restart    bool
+	wg         sync.WaitGroup
+
+	// While paused, deferrable jobs skip their scheduled runs, for at
+	// most maxDefer in a row if it is set.
+	pauseMu     sync.Mutex
+	paused      bool
+	pauseReason string
+	pausedSince time.Time
+	pauses      int64
+	maxDefer    time.Duration
+}
+
+// PauseState describes whether deferrable jobs are held back.
+type PauseState struct {
+	Paused bool
+	Reason string
+	Since  time.Time
+
+	// Pauses counts how often the scheduler was paused.
+	Pauses int64
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{jobs: make(map[string]*entry)}
+}
+
+// Register adds a job. It must be called before Run.
+func (s *Scheduler) Register(job Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs[job.Name] = &entry{
+		job:     job,
+		trigger: make(chan struct{}, 1),
+		stats:   Stats{Name: job.Name, Interval: job.Interval},
+	}
+}
+
+// Watch turns on the watchdog: a run that goes stallAfter without a
+// heartbeat is reported as stalled and, with restart, cancelled and
+// replaced by a fresh goroutine, as is a job whose goroutine stopped. A
+// start counts as a heartbeat; long jobs can send more with Heartbeat. It
+// must be called before Run.
+func (s *Scheduler) Watch(stallAfter time.Duration, restart bool) {
+	s.stallAfter = stallAfter
+	s.restart = restart
+}
+
+// DeferFor bounds how long a deferrable job's runs are skipped in a row
+// while paused; after that it runs on schedule again until it has run
+// once. 0 means no bound. It must be called before Run.
+func (s *Scheduler) DeferFor(max time.Duration) {
+	s.maxDefer = max
+}
+
+// Pause holds back the scheduled runs of deferrable jobs, for reason,
+// until Resume. Runs in progress and triggered runs are not affected.
+func (s *Scheduler) Pause(reason string) {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+
+	if !s.paused {
+		s.paused = true
+		s.pausedSince = time.Now()
+		s.pauses++
+	}
+	s.pauseReason = reason
+}
+
+// Resume lets deferrable jobs run on schedule again.
+func (s *Scheduler) Resume() {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+
+	s.paused = false
+	s.pauseReason = ""
+	s.pausedSince = time.Time{}
+}
+
+// Paused returns the pause state.
+func (s *Scheduler) Paused() PauseState {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	return PauseState{Paused: s.paused, Reason: s.pauseReason, Since: s.pausedSince, Pauses: s.pauses}
+}
+
+// deferRun reports whether a scheduled run of e is skipped, and counts
+// it if so.
+func (s *Scheduler) deferRun(e *entry) bool {
+	if !e.job.Deferrable || !s.Paused().Paused {
+		return false
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	if e.stats.DeferredSince.IsZero() {
+		e.stats.DeferredSince = now
+	}
+	if s.maxDefer > 0 && now.Sub(e.stats.DeferredSince) >= s.maxDefer {
+		return false
+	}
+	e.stats.Deferred++
+	return true
+}
+
+// Run starts every registered job and blocks until ctx is cancelled and
+// all jobs have returned. Runs abandoned by the watchdog are not waited
+// for.
+func (s *Scheduler) Run(ctx context.Context) {
+	for _, e := range s.entries() {
+		s.start(ctx, e)
+	}
+	if s.stallAfter > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.watch(ctx)
+		}()
+	}
+	s.wg.Wait()
+}
+
+func (s *Scheduler) entries() []*entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]*entry, 0, len(s.jobs))
+	for _, e := range s.jobs {
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// start runs the job in a new goroutine, which replaces any earlier one.
+func (s *Scheduler) start(ctx context.Context, e *entry) {
+	s.wg.Add(1)
+	var once sync.Once
+	release := func() { once.Do(s.wg.Done) }
+
+	e.mu.Lock()
+	e.gen++
+	gen := e.gen
+	e.release = release
+	e.stats.Stopped = false
+	e.mu.Unlock()
+
+	go func() {
+		defer release()
+		defer e.exited(ctx, gen)
+		s.loop(ctx, e, gen)
+	}()
+}
+
+// watch is the watchdog loop.
+func (s *Scheduler) watch(ctx context.Context) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, e := range s.entries() {
+				if e.check(s.stallAfter) && s.restart && ctx.Err() == nil {
+					s.restartJob(ctx, e)
+				}
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check updates the job's Stalled flag and reports whether the job needs
+// a restart.
+func (e *entry) check(stallAfter time.Duration) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	stalled := e.stats.Running && time.Since(e.stats.LastHeartbeat) > stallAfter
+	if stalled && !e.stats.Stalled {
+		log.Printf("[JOBS] %s stalled: running for %s, last heartbeat %s ago\n", e.job.Name,
+			time.Since(e.stats.RunningSince).Round(time.Second), time.Since(e.stats.LastHeartbeat).Round(time.Second))
+	}
+	e.stats.Stalled = stalled
+	return stalled || e.stats.Stopped
+}
+
+// restartJob cancels the job's current run, if any, and starts a new
+// goroutine for it without waiting for the old one.
+func (s *Scheduler) restartJob(ctx context.Context, e *entry) {
+	e.mu.Lock()
+	if e.cancel != nil {
+		e.cancel()
+	}
+	release := e.release
+	e.stats.Running = false
+	e.stats.RunningSince = time.Time{}
+	e.stats.LastHeartbeat = time.Time{}
+	e.stats.Stalled = false
+	e.stats.Restarts++
+	e.mu.Unlock()
+
+	release()
+	log.Printf("[JOBS] %s restarted by the watchdog\n", e.job.Name)
+	s.start(ctx, e)
+}
+
+// exited marks the job stopped if its current goroutine returns while
+// the scheduler is still running.
+func (e *entry) exited(ctx context.Context, gen int) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if gen == e.gen {
+		e.stats.Running = false
+		e.stats.Stopped = true
+		log.Printf("[JOBS] %s stopped unexpectedly\n", e.job.Name)
+	}
+}
+
+// Trigger asks a job to run now. If a run is already pending the request
+// is merged with it.
+func (s *Scheduler) Trigger(name string) error {
+	s.mu.Lock()
+	e, ok := s.jobs[name]
+	s.mu.Unlock()
+
+	if !ok {
+		return ErrUnknownJob
+	}
+
+	select {
+	case e.trigger <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *Scheduler) Stats() []Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]Stats, 0, len(s.jobs))
+	for _, e := range s.jobs {
+		e.mu.Lock()
+		out = append(out, e.stats)
+		e.mu.Unlock()
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (s *Scheduler) loop(ctx context.Context, e *entry, gen int) {
+	ticker := time.NewTicker(e.job.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if s.deferRun(e) {
+				continue
+			}
+		case <-e.trigger:
+		case <-ctx.Done():
+			log.Printf("[JOBS] %s stopped\n", e.job.Name)
+			return
+		}
+		if !e.runOnce(ctx, gen) {
+			return
+		}
+	}
+}
+
+type beatKey struct{}
+
+type beat struct {
+	e   *entry
+	gen int
+}
+
+// Heartbeat tells the watchdog that the job running with ctx is making
+// progress. Outside a job it does nothing.
+func Heartbeat(ctx context.Context) {
+	b, ok := ctx.Value(beatKey{}).(beat)
+	if !ok {
+		return
+	}
+
+	b.e.mu.Lock()
+	defer b.e.mu.Unlock()
+
+	if b.gen == b.e.gen {
+		b.e.stats.LastHeartbeat = time.Now()
+	}
+}
+
+// runOnce runs the job and reports whether the goroutine running it is
+// still the job's current one.
+func (e *entry) runOnce(ctx context.Context, gen int) bool {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx = context.WithValue(ctx, beatKey{}, beat{e: e, gen: gen})
+
+	start := time.Now()
+	e.mu.Lock()
+	if gen != e.gen {
+		e.mu.Unlock()
+		return false
+	}
+	e.cancel = cancel
+	e.stats.Running = true
+	e.stats.RunningSince = start
+	e.stats.LastHeartbeat = start
+	e.mu.Unlock()
+
+	err := safeRun(ctx, e.job.Run)
+	elapsed := time.Since(start)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if gen != e.gen {
+		log.Printf("[JOBS] %s: abandoned run returned after %s\n", e.job.Name, elapsed.Round(time.Millisecond))
+		return false
+	}
+	e.cancel = nil
+	e.stats.Running = false
+	e.stats.RunningSince = time.Time{}
+	e.stats.LastHeartbeat = time.Time{}
+	e.stats.Stalled = false
+	e.stats.Runs++
+	e.stats.DeferredSince = time.Time{}
+	e.stats.LastRun = start
+	e.stats.LastDuration = elapsed
+	e.stats.TotalDuration += elapsed
+	if err != nil {
+		e.stats.Failures++
+		e.stats.LastError = err.Error()
+		e.stats.LastErrorAt = start
+		log.Printf("[JOBS] %s failed: %v\n", e.job.Name, err)
+	}
+	logging.Debugf("[JOBS] %s ran in %s\n", e.job.Name, elapsed.Round(time.Microsecond))
+	return true
+}
+
+// safeRun turns a panicking job into a failed run instead of taking the
+// whole process down.
+func safeRun(ctx context.Context, fn Func) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
@@ -0,0 +1,364 @@
+package lease
+
+import (
+	"assignment2/internal/logging"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// microTime is the wire format of metav1.MicroTime.
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+var errConflict = errors.New("lease was modified concurrently")
+
+// Elector competes for a coordination.k8s.io/v1 Lease. Only the current
+// holder is expected to accept writes.
+type Elector struct {
+	Name      string
+	Namespace string
+	Identity  string
+	Duration  time.Duration
+
+	host      string
+	tokenFile string
+	client    *http.Client
+
+	mu      sync.Mutex
+	holder  string
+	leading bool
+
+	// deadline is when leading lapses unless a renewal succeeds first.
+	deadline time.Time
+}
+
+type leaseObject struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       *string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds *int32  `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string  `json:"acquireTime,omitempty"`
+	RenewTime            string  `json:"renewTime,omitempty"`
+	LeaseTransitions     *int32  `json:"leaseTransitions,omitempty"`
+}
+
+// NewInClusterElector builds an Elector that talks to the API server
+// using the pod's service account. An empty namespace means the pod's own.
+func NewInClusterElector(name, namespace, identity string, duration time.Duration) (*Elector, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running inside a Kubernetes cluster")
+	}
+
+	// The token is read again for every request, as the kubelet
+	// rotates a projected one; this only checks that it is there.
+	if _, err := os.ReadFile(serviceAccountDir + "/token"); err != nil {
+		return nil, err
+	}
+
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid service account CA bundle")
+	}
+
+	if namespace == "" {
+		ns, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, err
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	return NewElector("https://"+net.JoinHostPort(host, port), serviceAccountDir+"/token", client, name, namespace, identity, duration), nil
+}
+
+// NewElector builds an Elector that talks to the API server at host
+// (https://10.0.0.1:443) with client, authenticating with the bearer
+// token in tokenFile.
+func NewElector(host, tokenFile string, client *http.Client, name, namespace, identity string, duration time.Duration) *Elector {
+	return &Elector{
+		Name:      name,
+		Namespace: namespace,
+		Identity:  identity,
+		Duration:  duration,
+		host:      host,
+		tokenFile: tokenFile,
+		client:    client,
+	}
+}
+
+// IsLeader reports whether this elector holds the lease. Leading lapses
+// two thirds of the lease duration after the last successful renewal
+// started, even while another one is still in flight, so the node stops
+// writing before the standbys may take the lease over.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leading && time.Now().Before(e.deadline)
+}
+
+// Holder returns the identity of the current lease holder, if any.
+func (e *Elector) Holder() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.holder
+}
+
+// Run tries to acquire or renew the lease every third of its duration
+// and releases it when ctx is cancelled.
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.Duration / 3)
+	defer ticker.Stop()
+
+	e.tick(ctx)
+	for {
+		select {
+		case <-ticker.C:
+			e.tick(ctx)
+
+		case <-ctx.Done():
+			e.release()
+			log.Println("[LEASE] stopped")
+			return
+		}
+	}
+}
+
+// tick tries to acquire or renew the lease. The attempt gets a third of
+// the lease duration, the time until the next one.
+func (e *Elector) tick(ctx context.Context) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, e.Duration/3)
+	defer cancel()
+
+	holder, err := e.tryAcquireOrRenew(ctx, start)
+	if err != nil {
+		log.Printf("[LEASE] %v\n", err)
+
+		// Without a fresh renewal we can no longer be sure we still hold
+		// the lease, so stop accepting writes.
+		e.set(e.Holder(), false, time.Time{})
+		return
+	}
+
+	leading := holder == e.Identity
+	if leading != e.IsLeader() {
+		log.Printf("[LEASE] leading=%t holder=%s\n", leading, holder)
+	} else {
+		logging.Debugf("[LEASE] renewed: holder=%s\n", holder)
+	}
+	e.set(holder, leading, start.Add(e.Duration*2/3))
+}
+
+func (e *Elector) set(holder string, leading bool, deadline time.Time) {
+	e.mu.Lock()
+	e.holder = holder
+	e.leading = leading
+	e.deadline = deadline
+	e.mu.Unlock()
+}
+
+func (e *Elector) tryAcquireOrRenew(ctx context.Context, now time.Time) (string, error) {
+	current, err := e.get(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if current == nil {
+		obj := e.newLease(now)
+		if err := e.write(ctx, http.MethodPost, e.collectionURL(), obj); err != nil {
+			return "", err
+		}
+		return e.Identity, nil
+	}
+
+	holder := ""
+	if current.Spec.HolderIdentity != nil {
+		holder = *current.Spec.HolderIdentity
+	}
+
+	if holder != "" && holder != e.Identity && !expired(current.Spec, now) {
+		return holder, nil
+	}
+
+	transitions := int32(0)
+	if current.Spec.LeaseTransitions != nil {
+		transitions = *current.Spec.LeaseTransitions
+	}
+
+	obj := e.newLease(now)
+	obj.Metadata.ResourceVersion = current.Metadata.ResourceVersion
+	if holder == e.Identity {
+		obj.Spec.AcquireTime = current.Spec.AcquireTime
+	} else {
+		transitions++
+	}
+	obj.Spec.LeaseTransitions = &transitions
+
+	if err := e.write(ctx, http.MethodPut, e.objectURL(), obj); err != nil {
+		if errors.Is(err, errConflict) {
+			return holder, nil
+		}
+		return "", err
+	}
+	return e.Identity, nil
+}
+
+// release hands the lease back so a standby can take over immediately
+// instead of waiting for it to expire.
+func (e *Elector) release() {
+	if !e.IsLeader() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	current, err := e.get(ctx)
+	if err != nil || current == nil {
+		return
+	}
+
+	empty := ""
+	seconds := int32(1)
+	current.Spec.HolderIdentity = &empty
+	current.Spec.LeaseDurationSeconds = &seconds
+	current.Spec.RenewTime = time.Now().UTC().Format(microTime)
+
+	if err := e.write(ctx, http.MethodPut, e.objectURL(), current); err != nil {
+		log.Printf("[LEASE] release failed: %v\n", err)
+	}
+	e.set("", false, time.Time{})
+}
+
+func (e *Elector) newLease(now time.Time) *leaseObject {
+	identity := e.Identity
+	// Rounded up, so that others never see the lease expire before this
+	// elector stops leading.
+	seconds := int32((e.Duration + time.Second - 1) / time.Second)
+	stamp := now.UTC().Format(microTime)
+
+	return &leaseObject{
+		APIVersion: "coordination.k8s.io/v1",
+		Kind:       "Lease",
+		Metadata:   leaseMetadata{Name: e.Name, Namespace: e.Namespace},
+		Spec: leaseSpec{
+			HolderIdentity:       &identity,
+			LeaseDurationSeconds: &seconds,
+			AcquireTime:          stamp,
+			RenewTime:            stamp,
+		},
+	}
+}
+
+func expired(spec leaseSpec, now time.Time) bool {
+	if spec.RenewTime == "" || spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	renewed, err := time.Parse(microTime, spec.RenewTime)
+	if err != nil {
+		return true
+	}
+	return now.After(renewed.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second))
+}
+
+func (e *Elector) collectionURL() string {
+	return fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", e.host, e.Namespace)
+}
+
+func (e *Elector) objectURL() string {
+	return e.collectionURL() + "/" + e.Name
+}
+
+func (e *Elector) get(ctx context.Context) (*leaseObject, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.objectURL(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := e.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var obj leaseObject
+		if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+			return nil, err
+		}
+		return &obj, nil
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("get lease: unexpected status %s", resp.Status)
+	}
+}
+
+func (e *Elector) write(ctx context.Context, method, url string, obj *leaseObject) error {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return nil
+	case http.StatusConflict:
+		return errConflict
+	default:
+		return fmt.Errorf("%s lease: unexpected status %s", strings.ToLower(method), resp.Status)
+	}
+}
+
+func (e *Elector) do(req *http.Request) (*http.Response, error) {
+	token, err := os.ReadFile(e.tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	return e.client.Do(req)
+}
@@ -0,0 +1,136 @@
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAPI serves one Lease object the way the API server does, as far as
+// the elector uses it.
+type fakeAPI struct {
+	mu    sync.Mutex
+	lease *leaseObject
+	token string // the bearer token requests must carry
+	stall chan struct{}
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	stall := f.stall
+	token := f.token
+	f.mu.Unlock()
+	if stall != nil && r.Method == http.MethodPut {
+		select {
+		case <-stall:
+		case <-r.Context().Done():
+		}
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+token {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		if f.lease == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(f.lease)
+	case http.MethodPost, http.MethodPut:
+		var obj leaseObject
+		if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.lease = &obj
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func startElector(t *testing.T, api *fakeAPI, duration time.Duration) (*Elector, string) {
+	t.Helper()
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte(api.token+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	e := NewElector(srv.URL, tokenFile, srv.Client(), "kv", "default", "node-a", duration)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return e, tokenFile
+}
+
+func waitFor(t *testing.T, what string, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(timeout); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestElectorStopsLeadingWhenRenewalStalls(t *testing.T) {
+	api := &fakeAPI{token: "one"}
+	duration := 1500 * time.Millisecond
+	e, _ := startElector(t, api, duration)
+	waitFor(t, "the lease", time.Second, e.IsLeader)
+
+	stall := make(chan struct{})
+	defer close(stall)
+	api.mu.Lock()
+	api.stall = stall
+	renewed := api.lease.Spec.RenewTime
+	api.mu.Unlock()
+
+	last, err := time.Parse(microTime, renewed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "leading to lapse", 2*duration, func() bool { return !e.IsLeader() })
+	if expires := last.Add(duration); !time.Now().Before(expires) {
+		t.Fatalf("still leading at %v, after the lease renewed at %v expired", time.Now(), last)
+	}
+}
+
+func TestElectorRereadsToken(t *testing.T) {
+	api := &fakeAPI{token: "one"}
+	e, tokenFile := startElector(t, api, 600*time.Millisecond)
+	waitFor(t, "the lease", time.Second, e.IsLeader)
+
+	api.mu.Lock()
+	api.token = "two"
+	api.mu.Unlock()
+	if err := os.WriteFile(tokenFile, []byte("two\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A few renewals later the elector must still lead, with the new
+	// token.
+	time.Sleep(time.Second)
+	if !e.IsLeader() {
+		t.Fatal("stopped leading after the token was rotated")
+	}
+}
@@ -0,0 +1,179 @@
+package limit
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Lanes bounds how many calls run at once, like Limiter, but queues the
+// calls beyond Max by class and lets them in in proportion to the weights
+// of their classes: while all classes have calls waiting, a class of
+// weight 8 gets eight slots for every one a class of weight 1 gets, so a
+// busy class slows the others down without starving them.
+type Lanes struct {
+	Max int
+
+	mu       sync.Mutex
+	inFlight int
+	lanes    []*lane
+	byClass  map[string]*lane
+
+	// vtime is the pass of the last lane served; a lane that starts
+	// queueing starts from it, so time spent idle is no credit.
+	vtime float64
+}
+
+type lane struct {
+	class  string
+	weight int
+
+	// pass grows by 1/weight with every call let in, and the lane with
+	// the smallest pass goes next (stride scheduling).
+	pass  float64
+	queue []chan struct{}
+
+	inFlight int
+	admitted int64
+	rejected int64
+}
+
+// LaneSnapshot is a Snapshot of one class.
+type LaneSnapshot struct {
+	Snapshot
+	Weight int `json:"weight"`
+}
+
+// NewLanes returns lanes for the given classes and weights; weights must
+// be positive.
+func NewLanes(max int, weights map[string]int) *Lanes {
+	l := &Lanes{Max: max, byClass: make(map[string]*lane, len(weights))}
+	for class, weight := range weights {
+		ln := &lane{class: class, weight: weight}
+		l.lanes = append(l.lanes, ln)
+		l.byClass[class] = ln
+	}
+	// Ties go to the heavier class.
+	sort.Slice(l.lanes, func(i, j int) bool {
+		a, b := l.lanes[i], l.lanes[j]
+		return a.weight > b.weight || a.weight == b.weight && a.class < b.class
+	})
+	return l
+}
+
+// Acquire reports whether a call of class may proceed, waiting up to wait
+// behind the calls queued before it. Every successful Acquire must be
+// followed by Release with the same class. Waiting stops early if ctx is
+// done.
+func (l *Lanes) Acquire(ctx context.Context, class string, wait time.Duration) bool {
+	l.mu.Lock()
+	ln := l.byClass[class]
+	if l.inFlight < l.Max && !l.queuedLocked() {
+		l.admitLocked(ln)
+		l.mu.Unlock()
+		return true
+	}
+	if wait <= 0 {
+		ln.rejected++
+		l.mu.Unlock()
+		return false
+	}
+	ready := make(chan struct{})
+	if len(ln.queue) == 0 {
+		ln.pass = max(ln.pass, l.vtime)
+	}
+	ln.queue = append(ln.queue, ready)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ln.rejected++
+	for i, c := range ln.queue {
+		if c == ready {
+			ln.queue = append(ln.queue[:i], ln.queue[i+1:]...)
+			return false
+		}
+	}
+	// Let in just as it gave up: pass the slot on.
+	ln.admitted--
+	ln.inFlight--
+	l.inFlight--
+	l.dispatchLocked()
+	return false
+}
+
+// Release frees the slot of a call of class.
+func (l *Lanes) Release(class string) {
+	l.mu.Lock()
+	l.byClass[class].inFlight--
+	l.inFlight--
+	l.dispatchLocked()
+	l.mu.Unlock()
+}
+
+func (l *Lanes) queuedLocked() bool {
+	for _, ln := range l.lanes {
+		if len(ln.queue) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Lanes) admitLocked(ln *lane) {
+	ln.inFlight++
+	ln.admitted++
+	l.inFlight++
+}
+
+// dispatchLocked lets queued calls in while there are free slots.
+func (l *Lanes) dispatchLocked() {
+	for l.inFlight < l.Max {
+		var next *lane
+		for _, ln := range l.lanes {
+			if len(ln.queue) > 0 && (next == nil || ln.pass < next.pass) {
+				next = ln
+			}
+		}
+		if next == nil {
+			return
+		}
+		ready := next.queue[0]
+		next.queue = next.queue[1:]
+		l.vtime = next.pass
+		next.pass += 1 / float64(next.weight)
+		l.admitLocked(next)
+		close(ready)
+	}
+}
+
+func (l *Lanes) Snapshot() map[string]LaneSnapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := make(map[string]LaneSnapshot, len(l.lanes))
+	for _, ln := range l.lanes {
+		out[ln.class] = LaneSnapshot{
+			Snapshot: Snapshot{
+				Max:      l.Max,
+				InFlight: ln.inFlight,
+				Waiting:  int64(len(ln.queue)),
+				Admitted: ln.admitted,
+				Rejected: ln.rejected,
+			},
+			Weight: ln.weight,
+		}
+	}
+	return out
+}
@@ -0,0 +1,78 @@
+package limit
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Limiter bounds how many calls run at once. A call beyond Max waits up
+// to Wait for a slot to free up and is rejected after that, or right
+// away when Wait is 0.
+type Limiter struct {
+	Max  int
+	Wait time.Duration
+
+	slots chan struct{}
+
+	waiting  atomic.Int64
+	admitted atomic.Int64
+	rejected atomic.Int64
+}
+
+type Snapshot struct {
+	Max      int   `json:"max"`
+	InFlight int   `json:"in_flight"`
+	Waiting  int64 `json:"waiting"`
+	Admitted int64 `json:"admitted"`
+	Rejected int64 `json:"rejected"`
+}
+
+func New(max int, wait time.Duration) *Limiter {
+	return &Limiter{Max: max, Wait: wait, slots: make(chan struct{}, max)}
+}
+
+// Acquire reports whether the call may proceed. Every successful Acquire
+// must be followed by Release. Waiting stops early if ctx is done.
+func (l *Limiter) Acquire(ctx context.Context) bool {
+	select {
+	case l.slots <- struct{}{}:
+		l.admitted.Add(1)
+		return true
+	default:
+	}
+	if l.Wait <= 0 {
+		l.rejected.Add(1)
+		return false
+	}
+
+	l.waiting.Add(1)
+	defer l.waiting.Add(-1)
+
+	timer := time.NewTimer(l.Wait)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		l.admitted.Add(1)
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	l.rejected.Add(1)
+	return false
+}
+
+func (l *Limiter) Release() {
+	<-l.slots
+}
+
+func (l *Limiter) Snapshot() Snapshot {
+	return Snapshot{
+		Max:      l.Max,
+		InFlight: len(l.slots),
+		Waiting:  l.waiting.Load(),
+		Admitted: l.admitted.Load(),
+		Rejected: l.rejected.Load(),
+	}
+}
@@ -0,0 +1,78 @@
+package limit
+
+import (
+	"sync"
+	"time"
+)
+
+// Rate is a token bucket: it refills at PerSecond tokens a second up to
+// Burst. Callers take what they use and may go into debt, which later
+// callers wait out.
+type Rate struct {
+	PerSecond float64
+	Burst     float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRate returns a full bucket.
+func NewRate(perSecond, burst float64) *Rate {
+	return &Rate{PerSecond: perSecond, Burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (r *Rate) refillLocked(now time.Time) {
+	r.tokens = min(r.Burst, r.tokens+now.Sub(r.last).Seconds()*r.PerSecond)
+	r.last = now
+}
+
+// Delay returns how long a caller taking n tokens now would have to wait,
+// without taking them.
+func (r *Rate) Delay(n float64) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.refillLocked(time.Now())
+	return r.delayLocked(n)
+}
+
+func (r *Rate) delayLocked(n float64) time.Duration {
+	if short := n - r.tokens; short > 0 {
+		return time.Duration(short / r.PerSecond * float64(time.Second))
+	}
+	return 0
+}
+
+// Take takes n tokens and returns how long the caller has to wait before
+// using them.
+func (r *Rate) Take(n float64) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.refillLocked(time.Now())
+	d := r.delayLocked(n)
+	r.tokens -= n
+	return d
+}
+
+// Available returns the tokens in the bucket now, negative while callers
+// are in debt, and how long it takes to refill completely.
+func (r *Rate) Available() (float64, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.refillLocked(time.Now())
+	refill := time.Duration((r.Burst - r.tokens) / r.PerSecond * float64(time.Second))
+	return r.tokens, refill
+}
+
+// Full reports whether the bucket has refilled completely, i.e. nobody
+// has used it for a while.
+func (r *Rate) Full() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.refillLocked(time.Now())
+	return r.tokens >= r.Burst
+}
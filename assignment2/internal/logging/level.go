@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is the severity of a log line. Lines are tagged like "[WAL] ...";
+// [DEBUG] lines are debug, [WARN] lines warn, [ERROR] lines error and
+// every other tag info, except that [LOG] lines, which report level
+// changes, always pass.
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", s)
+}
+
+var current atomic.Int32
+
+func init() { current.Store(int32(LevelInfo)) }
+
+// CurrentLevel returns the lowest level logged.
+func CurrentLevel() Level { return Level(current.Load()) }
+
+// SetLevel changes the lowest level logged and returns the previous one.
+func SetLevel(l Level) Level { return Level(current.Swap(int32(l))) }
+
+// Cycle moves to the next more verbose level, wrapping from debug back
+// to error, and returns the new level.
+func Cycle() Level {
+	for {
+		old := current.Load()
+		next := old - 1
+		if next < int32(LevelDebug) {
+			next = int32(LevelError)
+		}
+		if current.CompareAndSwap(old, next) {
+			return Level(next)
+		}
+	}
+}
+
+// Enabled reports whether lines of level l are logged.
+func Enabled(l Level) bool { return l >= CurrentLevel() }
+
+// Debugf logs a [DEBUG] line if debug logging is on. The arguments are
+// not formatted otherwise.
+func Debugf(format string, args ...interface{}) {
+	if !Enabled(LevelDebug) {
+		return
+	}
+	log.Output(2, fmt.Sprintf("[DEBUG] "+format, args...))
+}
+
+// Filter returns a writer for log.SetOutput that drops the lines below
+// the current level. Lines without a tag, such as those of log.Fatal,
+// always pass.
+func Filter(w io.Writer) io.Writer { return filter{w} }
+
+type filter struct{ w io.Writer }
+
+func (f filter) Write(p []byte) (int, error) {
+	if !Enabled(lineLevel(p)) {
+		return len(p), nil
+	}
+	return f.w.Write(p)
+}
+
+// lineLevel finds the tag of a log line past the date and time prefix.
+// Untagged lines count as errors, so no level drops them.
+func lineLevel(p []byte) Level {
+	i := bytes.IndexByte(p, '[')
+	if i < 0 {
+		return LevelError
+	}
+	j := bytes.IndexByte(p[i:], ']')
+	if j < 0 {
+		return LevelError
+	}
+	tag := string(p[i+1 : i+j])
+	if tag == "" || strings.Trim(tag, "ABCDEFGHIJKLMNOPQRSTUVWXYZ-") != "" {
+		return LevelError
+	}
+	switch tag {
+	case "DEBUG":
+		return LevelDebug
+	case "WARN":
+		return LevelWarn
+	case "ERROR", "LOG":
+		return LevelError
+	}
+	return LevelInfo
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are upper bounds in seconds for request latencies.
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Exemplar is one observation singled out as an example of its bucket,
+// usually with the trace_id of the request it came from.
+type Exemplar struct {
+	Labels []Label
+	Value  float64
+	Time   time.Time
+}
+
+// HistogramVec is a histogram partitioned by label values. Each bucket
+// keeps the exemplar of its latest observation that had one.
+type HistogramVec struct {
+	name    string
+	help    string
+	buckets []float64
+	labels  []string
+
+	mu     sync.Mutex
+	values map[string]*histValue
+}
+
+type histValue struct {
+	labels []string
+
+	// counts and exemplars have one entry per bucket plus one for +Inf;
+	// counts are per bucket, not cumulative.
+	counts    []uint64
+	exemplars []*Exemplar
+	sum       float64
+	count     uint64
+}
+
+// NewHistogramVec returns a histogram with the given bucket upper
+// bounds, which must be sorted.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{name: name, help: help, buckets: buckets, labels: labels, values: make(map[string]*histValue)}
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.ObserveWithExemplar(v, nil, labelValues...)
+}
+
+// ObserveWithExemplar records v and, with exemplar labels, makes it the
+// example for its bucket.
+func (h *HistogramVec) ObserveWithExemplar(v float64, exemplar []Label, labelValues ...string) {
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	val, ok := h.values[key]
+	if !ok {
+		val = &histValue{
+			labels:    append([]string(nil), labelValues...),
+			counts:    make([]uint64, len(h.buckets)+1),
+			exemplars: make([]*Exemplar, len(h.buckets)+1),
+		}
+		h.values[key] = val
+	}
+	val.counts[i]++
+	val.sum += v
+	val.count++
+	if len(exemplar) > 0 {
+		val.exemplars[i] = &Exemplar{Labels: exemplar, Value: v, Time: time.Now()}
+	}
+}
+
+func (h *HistogramVec) Collect() []Family {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f := Family{Name: h.name, Help: h.help, Type: TypeHistogram}
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		val := h.values[k]
+		labels := Pairs(h.labels, val.labels)
+
+		var cumulative uint64
+		for i, n := range val.counts {
+			cumulative += n
+			le := math.Inf(1)
+			if i < len(h.buckets) {
+				le = h.buckets[i]
+			}
+			bucket := append(append([]Label(nil), labels...), Label{Name: "le", Value: formatValue(le)})
+			f.Samples = append(f.Samples, Sample{Suffix: "_bucket", Labels: bucket, Value: float64(cumulative), Exemplar: val.exemplars[i]})
+		}
+		f.Samples = append(f.Samples,
+			Sample{Suffix: "_sum", Labels: labels, Value: val.sum},
+			Sample{Suffix: "_count", Labels: labels, Value: float64(val.count)},
+		)
+	}
+	return []Family{f}
+}
@@ -0,0 +1,250 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	// Suffix is appended to the family name, e.g. "_bucket".
+	Suffix string
+	Labels []Label
+	Value  float64
+
+	// Exemplar is only written in the OpenMetrics format.
+	Exemplar *Exemplar
+}
+
+// Family is one metric name with all of its labelled samples.
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// Collector produces metric families at scrape time.
+type Collector interface {
+	Collect() []Family
+}
+
+type CollectorFunc func() []Family
+
+func (f CollectorFunc) Collect() []Family { return f() }
+
+// Registry gathers collectors and renders them in the Prometheus text
+// exposition format or in OpenMetrics.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mu.Unlock()
+}
+
+func (r *Registry) Gather() []Family {
+	r.mu.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	var families []Family
+	for _, c := range collectors {
+		families = append(families, c.Collect()...)
+	}
+	sort.SliceStable(families, func(i, j int) bool { return families[i].Name < families[j].Name })
+	return families
+}
+
+func (r *Registry) WriteText(w io.Writer) error {
+	for _, f := range r.Gather() {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.Name, f.Help, f.Name, f.Type); err != nil {
+			return err
+		}
+		for _, s := range f.Samples {
+			if _, err := fmt.Fprintf(w, "%s%s%s %s\n", f.Name, s.Suffix, formatLabels(s.Labels), formatValue(s.Value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteOpenMetrics renders the metrics in the OpenMetrics text format,
+// which unlike the Prometheus one carries exemplars. Counter families are
+// named without their _total suffix, as OpenMetrics requires; a counter
+// that lacks one is declared unknown instead.
+func (r *Registry) WriteOpenMetrics(w io.Writer) error {
+	for _, f := range r.Gather() {
+		name, typ := f.Name, f.Type
+		if typ == TypeCounter {
+			var ok bool
+			if name, ok = strings.CutSuffix(name, "_total"); !ok {
+				name, typ = f.Name, "unknown"
+			}
+		}
+		if _, err := fmt.Fprintf(w, "# TYPE %s %s\n# HELP %s %s\n", name, typ, name, f.Help); err != nil {
+			return err
+		}
+		for _, s := range f.Samples {
+			line := f.Name + s.Suffix + formatLabels(s.Labels) + " " + formatValue(s.Value)
+			if e := s.Exemplar; e != nil {
+				line += " # " + formatLabels(e.Labels) + " " + formatValue(e.Value) + " " + formatTimestamp(e.Time)
+			}
+			if _, err := io.WriteString(w, line+"\n"); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := io.WriteString(w, "# EOF\n")
+	return err
+}
+
+func formatTimestamp(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', 3, 64)
+}
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = l.Name + "=" + strconv.Quote(l.Value)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Vec is a counter or gauge partitioned by label values.
+type Vec struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*vecValue
+}
+
+type vecValue struct {
+	labels []string
+	value  float64
+}
+
+func NewCounterVec(name, help string, labels ...string) *Vec {
+	return newVec(name, help, TypeCounter, labels)
+}
+
+func NewGaugeVec(name, help string, labels ...string) *Vec {
+	return newVec(name, help, TypeGauge, labels)
+}
+
+func newVec(name, help, typ string, labels []string) *Vec {
+	return &Vec{name: name, help: help, typ: typ, labels: labels, values: make(map[string]*vecValue)}
+}
+
+func (v *Vec) Inc(labelValues ...string) {
+	v.Add(1, labelValues...)
+}
+
+func (v *Vec) Add(delta float64, labelValues ...string) {
+	v.mu.Lock()
+	v.get(labelValues).value += delta
+	v.mu.Unlock()
+}
+
+func (v *Vec) Set(value float64, labelValues ...string) {
+	v.mu.Lock()
+	v.get(labelValues).value = value
+	v.mu.Unlock()
+}
+
+func (v *Vec) Value(labelValues ...string) float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if val, ok := v.values[strings.Join(labelValues, "\xff")]; ok {
+		return val.value
+	}
+	return 0
+}
+
+func (v *Vec) get(labelValues []string) *vecValue {
+	key := strings.Join(labelValues, "\xff")
+	val, ok := v.values[key]
+	if !ok {
+		val = &vecValue{labels: append([]string(nil), labelValues...)}
+		v.values[key] = val
+	}
+	return val
+}
+
+func (v *Vec) Collect() []Family {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	f := Family{Name: v.name, Help: v.help, Type: v.typ}
+	keys := make([]string, 0, len(v.values))
+	for k := range v.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		val := v.values[k]
+		f.Samples = append(f.Samples, Sample{Labels: Pairs(v.labels, val.labels), Value: val.value})
+	}
+	return []Family{f}
+}
+
+// Pairs zips label names with values.
+func Pairs(names, values []string) []Label {
+	labels := make([]Label, 0, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		labels = append(labels, Label{Name: name, Value: value})
+	}
+	return labels
+}
+
+// Single builds a family with one unlabelled sample.
+func Single(name, help, typ string, value float64) Family {
+	return Family{Name: name, Help: help, Type: typ, Samples: []Sample{{Value: value}}}
+}
@@ -0,0 +1,270 @@
+package redisdump
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ReadAOF replays an append-only file and returns the string keys of
+// database db (or of all databases with AllDBs) it ends up with. A file
+// with an RDB preamble, as written by aof-use-rdb-preamble, is read as
+// that snapshot followed by its commands. Commands on other types mark
+// their keys as skipped; expiry commands are ignored, since Redis logs
+// expired keys as deletes.
+func ReadAOF(r io.Reader, db int) (map[string]string, Stats, error) {
+	br := bufio.NewReader(r)
+	d := newDump(db)
+
+	if magic, _ := br.Peek(5); string(magic) == "REDIS" {
+		if err := d.readRDB(br); err != nil {
+			return d.data, d.result(), err
+		}
+	}
+
+	for {
+		cmd, err := readCommand(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return d.data, d.result(), err
+		}
+		d.stats.Commands++
+		if err := d.apply(cmd); err != nil {
+			return d.data, d.result(), fmt.Errorf("command %d (%s): %w", d.stats.Commands, cmd[0], err)
+		}
+	}
+
+	return d.data, d.result(), nil
+}
+
+// readCommand reads one RESP array of bulk strings. A command cut off by
+// a crash at the end of the file counts as the end.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("expected a RESP array, got %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid RESP array length %q", line)
+	}
+
+	// n comes from the file; args grow as they are read.
+	args := make([]string, 0, min(n, 16))
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, truncated(err)
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected a RESP bulk string, got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxStringLen {
+			return nil, fmt.Errorf("invalid RESP bulk length %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, truncated(err)
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		return "", io.EOF
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func truncated(err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return io.EOF
+	}
+	return err
+}
+
+// apply replays cmd. With AllDBs, FLUSHDB clears every database.
+func (d *dump) apply(cmd []string) error {
+	name := strings.ToUpper(cmd[0])
+	args := cmd[1:]
+
+	switch name {
+	case "SELECT":
+		if len(args) != 1 {
+			return errArgs
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+		d.current = n
+		return nil
+	case "MULTI", "EXEC", "PEXPIREAT", "EXPIREAT", "EXPIRE", "PEXPIRE", "PERSIST", "GETEX":
+		return nil
+	case "FLUSHALL":
+		d.flush()
+		return nil
+	}
+	if !d.selected() {
+		return nil
+	}
+	others := d.others
+
+	set := func(key, value string) {
+		d.data[key] = value
+		delete(others, key)
+	}
+	del := func(key string) {
+		delete(d.data, key)
+		delete(others, key)
+	}
+
+	switch name {
+	case "SET", "GETSET":
+		// SET k v [NX|XX] [GET] [EX|PX|EXAT|PXAT n] [KEEPTTL]
+		if len(args) < 2 {
+			return errArgs
+		}
+		_, exists := d.data[args[0]]
+		for _, opt := range args[2:] {
+			switch strings.ToUpper(opt) {
+			case "NX":
+				if exists || others[args[0]] {
+					return nil
+				}
+			case "XX":
+				if !exists && !others[args[0]] {
+					return nil
+				}
+			}
+		}
+		set(args[0], args[1])
+	case "SETNX":
+		if len(args) != 2 {
+			return errArgs
+		}
+		if _, ok := d.data[args[0]]; !ok && !others[args[0]] {
+			set(args[0], args[1])
+		}
+	case "SETEX", "PSETEX":
+		if len(args) != 3 {
+			return errArgs
+		}
+		set(args[0], args[2])
+	case "MSET":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return errArgs
+		}
+		for i := 0; i < len(args); i += 2 {
+			set(args[i], args[i+1])
+		}
+	case "APPEND":
+		if len(args) != 2 {
+			return errArgs
+		}
+		set(args[0], d.data[args[0]]+args[1])
+	case "SETRANGE":
+		if len(args) != 3 {
+			return errArgs
+		}
+		offset, err := strconv.Atoi(args[1])
+		if err != nil || offset < 0 {
+			return errArgs
+		}
+		v := []byte(d.data[args[0]])
+		if end := offset + len(args[2]); end > len(v) {
+			v = append(v, make([]byte, end-len(v))...)
+		}
+		copy(v[offset:], args[2])
+		set(args[0], string(v))
+	case "INCR", "DECR", "INCRBY", "DECRBY":
+		delta := int64(1)
+		if name == "INCRBY" || name == "DECRBY" {
+			if len(args) != 2 {
+				return errArgs
+			}
+			var err error
+			if delta, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+				return err
+			}
+		}
+		if name == "DECR" || name == "DECRBY" {
+			delta = -delta
+		}
+		var n int64
+		if v, ok := d.data[args[0]]; ok {
+			var err error
+			if n, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return err
+			}
+		}
+		set(args[0], strconv.FormatInt(n+delta, 10))
+	case "INCRBYFLOAT":
+		if len(args) != 2 {
+			return errArgs
+		}
+		delta, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return err
+		}
+		var f float64
+		if v, ok := d.data[args[0]]; ok {
+			if f, err = strconv.ParseFloat(v, 64); err != nil {
+				return err
+			}
+		}
+		set(args[0], strconv.FormatFloat(f+delta, 'f', -1, 64))
+	case "DEL", "UNLINK", "GETDEL":
+		for _, key := range args {
+			del(key)
+		}
+	case "RENAME", "RENAMENX":
+		if len(args) != 2 {
+			return errArgs
+		}
+		if name == "RENAMENX" {
+			if _, ok := d.data[args[1]]; ok || others[args[1]] {
+				return nil
+			}
+		}
+		v, isString := d.data[args[0]]
+		other := others[args[0]]
+		del(args[0])
+		switch {
+		case isString:
+			set(args[1], v)
+		case other:
+			del(args[1])
+			others[args[1]] = true
+		}
+	case "FLUSHDB":
+		d.flush()
+	default:
+		// Any other write names its key first and makes it a
+		// non-string (LPUSH, HSET, SADD, ZADD, XADD, ...).
+		if len(args) > 0 {
+			delete(d.data, args[0])
+			others[args[0]] = true
+		}
+	}
+	return nil
+}
+
+func (d *dump) flush() {
+	d.data = make(map[string]string)
+	d.others = make(map[string]bool)
+}
+
+var errArgs = errors.New("wrong number of arguments")
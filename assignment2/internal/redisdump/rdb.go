@@ -0,0 +1,430 @@
+// Package redisdump reads the string keys out of Redis persistence files:
+// RDB snapshots and append-only files.
+package redisdump
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// AllDBs selects keys from every database; they then share one namespace.
+const AllDBs = -1
+
+// Stats counts what a dump held besides the imported strings.
+type Stats struct {
+	Strings  int `json:"strings"`
+	Skipped  int `json:"skipped"` // keys of other types
+	Expired  int `json:"expired"`
+	OtherDBs int `json:"other_dbs"`
+	Commands int `json:"commands,omitempty"`
+}
+
+// RDB opcodes and value types, as in Redis' rdb.h.
+const (
+	opFunction2    = 0xf5
+	opFunction     = 0xf6
+	opModuleAux    = 0xf7
+	opIdle         = 0xf8
+	opFreq         = 0xf9
+	opAux          = 0xfa
+	opResizeDB     = 0xfb
+	opExpireTimeMs = 0xfc
+	opExpireTime   = 0xfd
+	opSelectDB     = 0xfe
+	opEOF          = 0xff
+
+	typeString         = 0
+	typeList           = 1
+	typeSet            = 2
+	typeZSet           = 3
+	typeHash           = 4
+	typeZSet2          = 5
+	typeHashZipmap     = 9
+	typeListZiplist    = 10
+	typeSetIntset      = 11
+	typeZSetZiplist    = 12
+	typeHashZiplist    = 13
+	typeListQuicklist  = 14
+	typeHashListpack   = 16
+	typeZSetListpack   = 17
+	typeListQuicklist2 = 18
+	typeSetListpack    = 20
+)
+
+// Special string encodings, flagged by a length byte of 0b11xxxxxx.
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// maxVersion is the newest RDB format known to be readable.
+const maxVersion = 12
+
+// maxStringLen is Redis' own limit on a string (proto-max-bulk-len), so
+// that a corrupt length is an error instead of a huge allocation.
+const maxStringLen = 512 << 20
+
+func checkStringLen(n uint64) error {
+	if n > maxStringLen {
+		return fmt.Errorf("string length %d exceeds %d bytes", n, maxStringLen)
+	}
+	return nil
+}
+
+var errUnsupportedType = errors.New("unsupported RDB value type")
+
+// ReadRDB returns the string keys of database db (or of all databases
+// with AllDBs) from an RDB snapshot. Keys that had already expired are
+// left out; expiry times are otherwise dropped.
+func ReadRDB(r io.Reader, db int) (map[string]string, Stats, error) {
+	d := newDump(db)
+	err := d.readRDB(bufio.NewReader(r))
+	return d.data, d.result(), err
+}
+
+// dump accumulates the keys read from a file. others holds the keys of
+// the selected databases that hold something other than a string.
+type dump struct {
+	db      int
+	current int
+	data    map[string]string
+	others  map[string]bool
+	stats   Stats
+	now     time.Time
+}
+
+func newDump(db int) *dump {
+	return &dump{db: db, data: make(map[string]string), others: make(map[string]bool), now: time.Now()}
+}
+
+func (d *dump) result() Stats {
+	st := d.stats
+	st.Strings = len(d.data)
+	st.Skipped = len(d.others)
+	return st
+}
+
+func (d *dump) selected() bool {
+	return d.db == AllDBs || d.db == d.current
+}
+
+func (d *dump) readRDB(r *bufio.Reader) error {
+	magic := make([]byte, 9)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return fmt.Errorf("reading RDB header: %w", err)
+	}
+	if string(magic[:5]) != "REDIS" {
+		return errors.New("not an RDB file")
+	}
+	version, err := strconv.Atoi(string(magic[5:]))
+	if err != nil || version < 1 || version > maxVersion {
+		return fmt.Errorf("unsupported RDB version %q", magic[5:])
+	}
+
+	var expireAt time.Time
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("reading RDB: %w", err)
+		}
+
+		switch op {
+		case opEOF:
+			if version >= 5 {
+				// CRC64 of the file; a truncated checksum is tolerated.
+				io.CopyN(io.Discard, r, 8)
+			}
+			return nil
+		case opSelectDB:
+			n, _, err := readLength(r)
+			if err != nil {
+				return err
+			}
+			d.current = int(n)
+		case opResizeDB:
+			if _, _, err := readLength(r); err != nil {
+				return err
+			}
+			if _, _, err := readLength(r); err != nil {
+				return err
+			}
+		case opAux:
+			if _, err := readString(r); err != nil {
+				return err
+			}
+			if _, err := readString(r); err != nil {
+				return err
+			}
+		case opExpireTime:
+			var sec uint32
+			if err := binary.Read(r, binary.LittleEndian, &sec); err != nil {
+				return err
+			}
+			expireAt = time.Unix(int64(sec), 0)
+		case opExpireTimeMs:
+			var ms uint64
+			if err := binary.Read(r, binary.LittleEndian, &ms); err != nil {
+				return err
+			}
+			expireAt = time.UnixMilli(int64(ms))
+		case opFreq:
+			if _, err := r.ReadByte(); err != nil {
+				return err
+			}
+		case opIdle:
+			if _, _, err := readLength(r); err != nil {
+				return err
+			}
+		case opFunction2:
+			if _, err := readString(r); err != nil {
+				return err
+			}
+		case opFunction, opModuleAux:
+			return fmt.Errorf("RDB opcode %#x is not supported", op)
+
+		default:
+			key, err := readString(r)
+			if err != nil {
+				return err
+			}
+			value, isString, err := readValue(r, op)
+			if err != nil {
+				return fmt.Errorf("key %q: %w", key, err)
+			}
+			d.add(key, value, isString, expireAt)
+			expireAt = time.Time{}
+		}
+	}
+}
+
+// add records a key read from the snapshot.
+func (d *dump) add(key, value string, isString bool, expireAt time.Time) {
+	switch {
+	case !d.selected():
+		d.stats.OtherDBs++
+	case !expireAt.IsZero() && !expireAt.After(d.now):
+		d.stats.Expired++
+	case !isString:
+		delete(d.data, key)
+		d.others[key] = true
+	default:
+		delete(d.others, key)
+		d.data[key] = value
+	}
+}
+
+// readValue reads a value of type typ. Only strings are returned; other
+// types are read past.
+func readValue(r *bufio.Reader, typ byte) (string, bool, error) {
+	switch typ {
+	case typeString:
+		s, err := readString(r)
+		return s, true, err
+	case typeList, typeSet:
+		return "", false, skipStrings(r, 1)
+	case typeHash:
+		return "", false, skipStrings(r, 2)
+	case typeZSet:
+		n, _, err := readLength(r)
+		if err != nil {
+			return "", false, err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err := readString(r); err != nil {
+				return "", false, err
+			}
+			// Scores are strings prefixed by a one-byte length; 253-255
+			// stand for NaN and the infinities.
+			l, err := r.ReadByte()
+			if err != nil {
+				return "", false, err
+			}
+			if l < 253 {
+				if _, err := io.CopyN(io.Discard, r, int64(l)); err != nil {
+					return "", false, err
+				}
+			}
+		}
+		return "", false, nil
+	case typeZSet2:
+		n, _, err := readLength(r)
+		if err != nil {
+			return "", false, err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err := readString(r); err != nil {
+				return "", false, err
+			}
+			if _, err := io.CopyN(io.Discard, r, 8); err != nil {
+				return "", false, err
+			}
+		}
+		return "", false, nil
+	case typeHashZipmap, typeListZiplist, typeSetIntset, typeZSetZiplist,
+		typeHashZiplist, typeHashListpack, typeZSetListpack, typeSetListpack:
+		_, err := readString(r)
+		return "", false, err
+	case typeListQuicklist:
+		return "", false, skipStrings(r, 1)
+	case typeListQuicklist2:
+		n, _, err := readLength(r)
+		if err != nil {
+			return "", false, err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, _, err := readLength(r); err != nil {
+				return "", false, err
+			}
+			if _, err := readString(r); err != nil {
+				return "", false, err
+			}
+		}
+		return "", false, nil
+	}
+	return "", false, fmt.Errorf("%w %d", errUnsupportedType, typ)
+}
+
+// skipStrings reads past a length followed by per strings per element.
+func skipStrings(r *bufio.Reader, per int) error {
+	n, _, err := readLength(r)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n*uint64(per); i++ {
+		if _, err := readString(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLength decodes an RDB length. encoded is set when the value is a
+// special string encoding instead, which n then identifies.
+func readLength(r *bufio.Reader) (n uint64, encoded bool, err error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		next, err := r.ReadByte()
+		return uint64(b&0x3f)<<8 | uint64(next), false, err
+	case 2:
+		switch b {
+		case 0x80:
+			var v uint32
+			err := binary.Read(r, binary.BigEndian, &v)
+			return uint64(v), false, err
+		case 0x81:
+			var v uint64
+			err := binary.Read(r, binary.BigEndian, &v)
+			return v, false, err
+		}
+		return 0, false, fmt.Errorf("invalid RDB length byte %#x", b)
+	}
+	return uint64(b & 0x3f), true, nil
+}
+
+func readString(r *bufio.Reader) (string, error) {
+	n, encoded, err := readLength(r)
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		if err := checkStringLen(n); err != nil {
+			return "", err
+		}
+		buf := make([]byte, n)
+		_, err := io.ReadFull(r, buf)
+		return string(buf), err
+	}
+
+	switch n {
+	case encInt8:
+		b, err := r.ReadByte()
+		return strconv.Itoa(int(int8(b))), err
+	case encInt16:
+		var v int16
+		err := binary.Read(r, binary.LittleEndian, &v)
+		return strconv.Itoa(int(v)), err
+	case encInt32:
+		var v int32
+		err := binary.Read(r, binary.LittleEndian, &v)
+		return strconv.Itoa(int(v)), err
+	case encLZF:
+		clen, _, err := readLength(r)
+		if err != nil {
+			return "", err
+		}
+		ulen, _, err := readLength(r)
+		if err != nil {
+			return "", err
+		}
+		if err := checkStringLen(clen); err != nil {
+			return "", err
+		}
+		if err := checkStringLen(ulen); err != nil {
+			return "", err
+		}
+		compressed := make([]byte, clen)
+		if _, err := io.ReadFull(r, compressed); err != nil {
+			return "", err
+		}
+		out, err := lzfDecompress(compressed, int(ulen))
+		return string(out), err
+	}
+	return "", fmt.Errorf("unknown RDB string encoding %d", n)
+}
+
+var errCorruptLZF = errors.New("corrupt LZF data")
+
+func lzfDecompress(in []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		if ctrl < 1<<5 {
+			n := ctrl + 1
+			if i+n > len(in) {
+				return nil, errCorruptLZF
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, errCorruptLZF
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errCorruptLZF
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errCorruptLZF
+		}
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != size {
+		return nil, errCorruptLZF
+	}
+	return out, nil
+}
@@ -0,0 +1,200 @@
+package rotate
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "20060102-150405.000"
+
+// Writer is an append-only file that is rotated once it grows past
+// MaxSize bytes or has been open longer than MaxAge. Rotated files are
+// renamed with a timestamp suffix and optionally gzipped; only the newest
+// MaxBackups are kept. Zero values disable the respective limit.
+type Writer struct {
+	Path       string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+	Compress   bool
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	wg       sync.WaitGroup
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if w.due(int64(len(p))) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate forces a rotation, e.g. on SIGHUP from an external tool.
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return w.open()
+	}
+	return w.rotate()
+}
+
+// Close closes the current file and waits for pending compressions.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.mu.Unlock()
+
+	w.wg.Wait()
+	return err
+}
+
+func (w *Writer) due(next int64) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.MaxSize > 0 && w.size+next > w.MaxSize {
+		return true
+	}
+	return w.MaxAge > 0 && time.Since(w.openedAt) > w.MaxAge
+}
+
+func (w *Writer) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.Path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(w.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	w.file = f
+	w.size = info.Size()
+	w.openedAt = time.Now()
+	return nil
+}
+
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+
+	backup := w.Path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(w.Path, backup); err != nil {
+		return err
+	}
+
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	// Compression and pruning happen off the write path.
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		if w.Compress {
+			if err := compress(backup); err != nil {
+				log.Printf("[ROTATE] compress %s: %v\n", backup, err)
+			}
+		}
+		w.prune()
+	}()
+	return nil
+}
+
+func (w *Writer) prune() {
+	if w.MaxBackups <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(w.Path + ".*")
+	if err != nil {
+		return
+	}
+
+	var backups []string
+	for _, m := range matches {
+		if !strings.HasSuffix(m, ".tmp") {
+			backups = append(backups, m)
+		}
+	}
+
+	// The suffix sorts chronologically, with or without .gz.
+	sort.Strings(backups)
+	for len(backups) > w.MaxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
+
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
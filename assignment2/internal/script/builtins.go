@@ -0,0 +1,224 @@
+package script
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var builtins = map[string]func(args []interface{}) (interface{}, error){
+	"len":        builtinLen,
+	"str":        unaryFn(func(v interface{}) (interface{}, error) { return toString(v), nil }),
+	"num":        unaryFn(toNumber),
+	"int":        numberFn(math.Trunc),
+	"round":      numberFn(math.Round),
+	"abs":        numberFn(math.Abs),
+	"upper":      stringFn(strings.ToUpper),
+	"lower":      stringFn(strings.ToLower),
+	"trim":       stringFn(strings.TrimSpace),
+	"contains":   builtinContains,
+	"startswith": builtinStartsWith,
+	"has":        builtinHas,
+	"keys":       builtinKeys,
+	"append":     builtinAppend,
+	"min":        builtinMin,
+	"max":        builtinMax,
+	"json":       unaryFn(func(v interface{}) (interface{}, error) { return encodeJSON(v) }),
+	"parse":      builtinParse,
+}
+
+func unaryFn(fn func(v interface{}) (interface{}, error)) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, errors.New("expects 1 argument")
+		}
+		return fn(args[0])
+	}
+}
+
+func numberFn(fn func(float64) float64) func([]interface{}) (interface{}, error) {
+	return unaryFn(func(v interface{}) (interface{}, error) {
+		f, ok := v.(float64)
+		if !ok {
+			return nil, errors.New("expects a number")
+		}
+		return fn(f), nil
+	})
+}
+
+func stringFn(fn func(string) string) func([]interface{}) (interface{}, error) {
+	return unaryFn(func(v interface{}) (interface{}, error) {
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.New("expects a string")
+		}
+		return fn(s), nil
+	})
+}
+
+func builtinLen(args []interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errors.New("expects 1 argument")
+	}
+	switch t := args[0].(type) {
+	case string:
+		return float64(len(t)), nil
+	case []interface{}:
+		return float64(len(t)), nil
+	case map[string]interface{}:
+		return float64(len(t)), nil
+	case nil:
+		return float64(0), nil
+	}
+	return nil, errors.New("expects a string, list or object")
+}
+
+func toNumber(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case float64:
+		return t, nil
+	case bool:
+		if t {
+			return float64(1), nil
+		}
+		return float64(0), nil
+	case nil:
+		return float64(0), nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		if err != nil {
+			return nil, errors.New("not a number")
+		}
+		return f, nil
+	}
+	return nil, errors.New("not a number")
+}
+
+func builtinContains(args []interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errors.New("expects 2 arguments")
+	}
+	switch t := args[0].(type) {
+	case string:
+		sub, ok := args[1].(string)
+		return ok && strings.Contains(t, sub), nil
+	case []interface{}:
+		for _, item := range t {
+			if reflect.DeepEqual(item, args[1]) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, nil
+}
+
+func builtinStartsWith(args []interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errors.New("expects 2 arguments")
+	}
+	s, ok1 := args[0].(string)
+	prefix, ok2 := args[1].(string)
+	return ok1 && ok2 && strings.HasPrefix(s, prefix), nil
+}
+
+func builtinHas(args []interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errors.New("expects 2 arguments")
+	}
+	obj, ok := args[0].(map[string]interface{})
+	key, _ := args[1].(string)
+	if !ok {
+		return false, nil
+	}
+	_, found := obj[key]
+	return found, nil
+}
+
+func builtinKeys(args []interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errors.New("expects 1 argument")
+	}
+	obj, ok := args[0].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("expects an object")
+	}
+	names := make([]string, 0, len(obj))
+	for k := range obj {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	out := make([]interface{}, len(names))
+	for i, k := range names {
+		out[i] = k
+	}
+	return out, nil
+}
+
+func builtinAppend(args []interface{}) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, errors.New("expects a list")
+	}
+	var list []interface{}
+	switch t := args[0].(type) {
+	case []interface{}:
+		list = append(list, t...)
+	case nil:
+	default:
+		return nil, errors.New("expects a list")
+	}
+	return append(list, args[1:]...), nil
+}
+
+func builtinMin(args []interface{}) (interface{}, error) {
+	return extreme(args, -1)
+}
+
+func builtinMax(args []interface{}) (interface{}, error) {
+	return extreme(args, 1)
+}
+
+func extreme(args []interface{}, sign int) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, errors.New("expects at least 1 argument")
+	}
+	best := args[0]
+	for _, v := range args[1:] {
+		c, err := compare(v, best)
+		if err != nil {
+			return nil, err
+		}
+		if c*sign > 0 {
+			best = v
+		}
+	}
+	return best, nil
+}
+
+func builtinParse(args []interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errors.New("expects 1 argument")
+	}
+	s, ok := args[0].(string)
+	if !ok {
+		return nil, errors.New("expects a string")
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return nil, errors.New("invalid JSON")
+	}
+	return v, nil
+}
+
+func encodeJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
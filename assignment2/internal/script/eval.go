@@ -0,0 +1,292 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+func eval(n node, vars map[string]interface{}) (interface{}, error) {
+	switch e := n.(type) {
+	case *literal:
+		return e.value, nil
+
+	case *variable:
+		v, ok := vars[e.name]
+		if !ok {
+			return nil, fmt.Errorf("undefined variable %q", e.name)
+		}
+		return v, nil
+
+	case *listLit:
+		items := make([]interface{}, len(e.items))
+		for i, item := range e.items {
+			v, err := eval(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = v
+		}
+		return items, nil
+
+	case *objectLit:
+		obj := make(map[string]interface{}, len(e.keys))
+		for i, key := range e.keys {
+			v, err := eval(e.values[i], vars)
+			if err != nil {
+				return nil, err
+			}
+			obj[key] = v
+		}
+		return obj, nil
+
+	case *member:
+		target, err := eval(e.target, vars)
+		if err != nil {
+			return nil, err
+		}
+		// Missing fields and fields of non-objects read as null, which
+		// keeps scripts short when values have optional fields.
+		if obj, ok := target.(map[string]interface{}); ok {
+			return obj[e.name], nil
+		}
+		return nil, nil
+
+	case *index:
+		target, err := eval(e.target, vars)
+		if err != nil {
+			return nil, err
+		}
+		idx, err := eval(e.index, vars)
+		if err != nil {
+			return nil, err
+		}
+		switch t := target.(type) {
+		case map[string]interface{}:
+			key, _ := idx.(string)
+			return t[key], nil
+		case []interface{}:
+			if i, ok := listIndex(idx, len(t)); ok {
+				return t[i], nil
+			}
+		case string:
+			if i, ok := listIndex(idx, len(t)); ok {
+				return t[i : i+1], nil
+			}
+		}
+		return nil, nil
+
+	case *call:
+		fn, ok := builtins[e.name]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %q", e.name)
+		}
+		args := make([]interface{}, len(e.args))
+		for i, arg := range e.args {
+			v, err := eval(arg, vars)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = v
+		}
+		v, err := fn(args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.name, err)
+		}
+		return v, nil
+
+	case *unary:
+		v, err := eval(e.operand, vars)
+		if err != nil {
+			return nil, err
+		}
+		if e.op == "!" {
+			return !truthy(v), nil
+		}
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("cannot negate %s", typeName(v))
+		}
+		return -f, nil
+
+	case *binary:
+		return evalBinary(e, vars)
+	}
+
+	return nil, errors.New("invalid expression")
+}
+
+func evalBinary(e *binary, vars map[string]interface{}) (interface{}, error) {
+	left, err := eval(e.left, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	// && and || short-circuit and yield booleans.
+	switch e.op {
+	case "&&":
+		if !truthy(left) {
+			return false, nil
+		}
+		right, err := eval(e.right, vars)
+		return truthy(right), err
+	case "||":
+		if truthy(left) {
+			return true, nil
+		}
+		right, err := eval(e.right, vars)
+		return truthy(right), err
+	}
+
+	right, err := eval(e.right, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "==":
+		return reflect.DeepEqual(left, right), nil
+	case "!=":
+		return !reflect.DeepEqual(left, right), nil
+
+	case "<", "<=", ">", ">=":
+		c, err := compare(left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch e.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+
+	case "+":
+		if l, ok := left.([]interface{}); ok {
+			if r, ok := right.([]interface{}); ok {
+				return append(append([]interface{}{}, l...), r...), nil
+			}
+		}
+		_, ls := left.(string)
+		_, rs := right.(string)
+		if ls || rs {
+			return toString(left) + toString(right), nil
+		}
+	}
+
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("invalid operands for %s: %s and %s", e.op, typeName(left), typeName(right))
+	}
+
+	switch e.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return math.Mod(l, r), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", e.op)
+}
+
+func compare(left, right interface{}) (int, error) {
+	switch l := left.(type) {
+	case float64:
+		if r, ok := right.(float64); ok {
+			switch {
+			case l < r:
+				return -1, nil
+			case l > r:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if r, ok := right.(string); ok {
+			return strings.Compare(l, r), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %s and %s", typeName(left), typeName(right))
+}
+
+func listIndex(idx interface{}, n int) (int, bool) {
+	f, ok := idx.(float64)
+	if !ok || f != math.Trunc(f) {
+		return 0, false
+	}
+	i := int(f)
+	if i < 0 {
+		i += n
+	}
+	return i, i >= 0 && i < n
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case float64:
+		return t != 0
+	case string:
+		return t != ""
+	case []interface{}:
+		return len(t) > 0
+	case map[string]interface{}:
+		return len(t) > 0
+	}
+	return true
+}
+
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	}
+	s, _ := encodeJSON(v)
+	return s
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
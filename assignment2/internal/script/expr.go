@@ -0,0 +1,100 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxExprLen bounds the size of an expression.
+const MaxExprLen = 1 << 10
+
+// Expr is a compiled expression, such as a listing filter.
+type Expr struct {
+	root node
+	uses map[string]bool
+}
+
+// CompileExpr compiles a single expression over the variables names;
+// any other variable or an unknown function is an error.
+func CompileExpr(src string, names ...string) (*Expr, error) {
+	if len(src) > MaxExprLen {
+		return nil, errors.New("expression too long")
+	}
+
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	p.skipNewlines()
+	root, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	p.skipNewlines()
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.peek().text)
+	}
+
+	known := make(map[string]bool, len(names))
+	for _, name := range names {
+		known[name] = true
+	}
+	x := &Expr{root: root, uses: make(map[string]bool)}
+	if err := x.check(root, known); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *Expr) check(n node, known map[string]bool) error {
+	switch e := n.(type) {
+	case *variable:
+		if !known[e.name] {
+			return fmt.Errorf("undefined variable %q", e.name)
+		}
+		x.uses[e.name] = true
+	case *listLit:
+		return x.checkAll(e.items, known)
+	case *objectLit:
+		return x.checkAll(e.values, known)
+	case *member:
+		return x.check(e.target, known)
+	case *index:
+		return x.checkAll([]node{e.target, e.index}, known)
+	case *call:
+		if _, ok := builtins[e.name]; !ok {
+			return fmt.Errorf("unknown function %q", e.name)
+		}
+		return x.checkAll(e.args, known)
+	case *unary:
+		return x.check(e.operand, known)
+	case *binary:
+		return x.checkAll([]node{e.left, e.right}, known)
+	}
+	return nil
+}
+
+func (x *Expr) checkAll(nodes []node, known map[string]bool) error {
+	for _, n := range nodes {
+		if err := x.check(n, known); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Uses reports whether the expression reads the variable name, so that
+// callers only compute the variables it needs.
+func (x *Expr) Uses(name string) bool {
+	return x.uses[name]
+}
+
+// Match evaluates the expression with vars and reports whether the result
+// is truthy. Like a missing field, an expression that fails (comparing a
+// number with a string, say) does not match.
+func (x *Expr) Match(vars map[string]interface{}) bool {
+	v, err := eval(x.root, vars)
+	return err == nil && truthy(v)
+}
@@ -0,0 +1,266 @@
+package script
+
+import "fmt"
+
+type node interface{}
+
+type (
+	literal   struct{ value interface{} }
+	variable  struct{ name string }
+	listLit   struct{ items []node }
+	objectLit struct {
+		keys   []string
+		values []node
+	}
+	member struct {
+		target node
+		name   string
+	}
+	index struct {
+		target node
+		index  node
+	}
+	call struct {
+		name string
+		args []node
+	}
+	unary struct {
+		op      string
+		operand node
+	}
+	binary struct {
+		op          string
+		left, right node
+	}
+)
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// precedence lists binary operators from loosest to tightest binding.
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return t.kind == tokPunct && t.text == text || t.kind == tokIdent && keywords[text] && t.text == text
+}
+
+func (p *parser) expect(text string) error {
+	if !p.is(text) {
+		return p.errorf("expected %q", text)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("syntax error at %d: %s", p.peek().pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) skipNewlines() {
+	for p.peek().kind == tokNewline {
+		p.next()
+	}
+}
+
+func (p *parser) expression() (node, error) {
+	return p.binaryLevel(0)
+}
+
+func (p *parser) binaryLevel(level int) (node, error) {
+	if level == len(precedence) {
+		return p.unaryExpr()
+	}
+
+	left, err := p.binaryLevel(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := ""
+		for _, candidate := range precedence[level] {
+			if p.is(candidate) {
+				op = candidate
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.binaryLevel(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) unaryExpr() (node, error) {
+	if p.is("!") || p.is("-") {
+		op := p.next().text
+		operand, err := p.unaryExpr()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: op, operand: operand}, nil
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (node, error) {
+	expr, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.is("."):
+			p.next()
+			name := p.next()
+			if name.kind != tokIdent {
+				return nil, p.errorf("expected field name")
+			}
+			expr = &member{target: expr, name: name.text}
+
+		case p.is("["):
+			p.next()
+			idx, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			expr = &index{target: expr, index: idx}
+
+		default:
+			return expr, nil
+		}
+	}
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokNumber:
+		return &literal{value: t.num}, nil
+	case tokString:
+		return &literal{value: t.text}, nil
+
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &literal{value: true}, nil
+		case "false":
+			return &literal{value: false}, nil
+		case "null":
+			return &literal{value: nil}, nil
+		}
+		if keywords[t.text] {
+			return nil, fmt.Errorf("syntax error at %d: unexpected %q", t.pos, t.text)
+		}
+
+		if !p.is("(") {
+			return &variable{name: t.text}, nil
+		}
+		p.next()
+		args, err := p.list(")")
+		if err != nil {
+			return nil, err
+		}
+		return &call{name: t.text, args: args}, nil
+
+	case tokPunct:
+		switch t.text {
+		case "(":
+			expr, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			return expr, p.expect(")")
+
+		case "[":
+			items, err := p.list("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listLit{items: items}, nil
+
+		case "{":
+			return p.object()
+		}
+	}
+
+	return nil, fmt.Errorf("syntax error at %d: unexpected token", t.pos)
+}
+
+func (p *parser) list(closing string) ([]node, error) {
+	var items []node
+	for !p.is(closing) {
+		item, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		if !p.is(",") {
+			break
+		}
+		p.next()
+	}
+	return items, p.expect(closing)
+}
+
+func (p *parser) object() (node, error) {
+	obj := &objectLit{}
+
+	p.skipNewlines()
+	for !p.is("}") {
+		key := p.next()
+		if key.kind != tokString && key.kind != tokIdent {
+			return nil, p.errorf("expected object key")
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		p.skipNewlines()
+
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		obj.keys = append(obj.keys, key.text)
+		obj.values = append(obj.values, value)
+
+		p.skipNewlines()
+		if !p.is(",") {
+			break
+		}
+		p.next()
+		p.skipNewlines()
+	}
+	return obj, p.expect("}")
+}
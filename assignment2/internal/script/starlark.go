@@ -0,0 +1,206 @@
+package script
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	starjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// MaxSourceLen bounds the size of a script.
+const MaxSourceLen = 16 << 10
+
+// MaxSteps bounds the work of one run, so that a script that loops for
+// too long fails instead of holding its key.
+const MaxSteps = 1 << 20
+
+// maxDepth bounds how deeply values handed back by a script may nest,
+// which also stops a list that contains itself.
+const maxDepth = 100
+
+// predeclared is what scripts see besides the Starlark built-ins.
+var predeclared = starlark.StringDict{"json": starjson.Module}
+
+// Program is a compiled Starlark script that defines
+// main(key, value, args). Values going in and out are the types produced
+// by encoding/json: nil, bool, float64 or json.Number, string,
+// []interface{} and map[string]interface{}.
+type Program struct {
+	prog *starlark.Program
+}
+
+func Compile(src string) (*Program, error) {
+	if len(src) > MaxSourceLen {
+		return nil, errors.New("script too long")
+	}
+
+	f, prog, err := starlark.SourceProgramOptions(&syntax.FileOptions{}, "script", src, predeclared.Has)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range f.Stmts {
+		if def, ok := stmt.(*syntax.DefStmt); ok && def.Name.Name == "main" {
+			return &Program{prog: prog}, nil
+		}
+	}
+	return nil, errors.New("script does not define main(key, value, args)")
+}
+
+// Run calls main(key, value, args) and returns the value it returned
+// and, if it returned a (value, result) pair, the result. changed reports
+// whether the returned value differs from value as it came in; main may
+// also have modified value in place and returned it.
+func (p *Program) Run(key string, value, args interface{}) (out, result interface{}, changed bool, err error) {
+	in, err := toStarlark(value)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	before, err := fromStarlark(in, 0)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	sargs, err := toStarlark(args)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	thread := &starlark.Thread{Name: "eval", Print: func(*starlark.Thread, string) {}}
+	thread.SetMaxExecutionSteps(MaxSteps)
+	globals, err := p.prog.Init(thread, predeclared)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	ret, err := starlark.Call(thread, globals["main"], starlark.Tuple{starlark.String(key), in, sargs}, nil)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	var res starlark.Value = starlark.None
+	if pair, ok := ret.(starlark.Tuple); ok {
+		if len(pair) != 2 {
+			return nil, nil, false, fmt.Errorf("main returned a tuple of %d, expected (value, result)", len(pair))
+		}
+		ret, res = pair[0], pair[1]
+	}
+	if out, err = fromStarlark(ret, 0); err != nil {
+		return nil, nil, false, fmt.Errorf("value: %w", err)
+	}
+	if result, err = fromStarlark(res, 0); err != nil {
+		return nil, nil, false, fmt.Errorf("result: %w", err)
+	}
+	return out, result, !reflect.DeepEqual(before, out), nil
+}
+
+// toStarlark converts a value decoded by encoding/json. Whole numbers
+// become ints, so that scripts can index and count with them; numbers
+// decoded as json.Number keep every digit.
+func toStarlark(v interface{}) (starlark.Value, error) {
+	switch t := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(t), nil
+	case string:
+		return starlark.String(t), nil
+	case float64:
+		if t == math.Trunc(t) && math.Abs(t) <= 1<<53 {
+			return starlark.MakeInt64(int64(t)), nil
+		}
+		return starlark.Float(t), nil
+	case json.Number:
+		if !strings.ContainsAny(string(t), ".eE") {
+			if i, ok := new(big.Int).SetString(string(t), 10); ok {
+				return starlark.MakeBigInt(i), nil
+			}
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return starlark.Float(f), nil
+	case []interface{}:
+		elems := make([]starlark.Value, len(t))
+		for i, item := range t {
+			e, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			elems[i] = e
+		}
+		return starlark.NewList(elems), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		d := starlark.NewDict(len(t))
+		for _, k := range keys {
+			e, err := toStarlark(t[k])
+			if err != nil {
+				return nil, err
+			}
+			d.SetKey(starlark.String(k), e)
+		}
+		return d, nil
+	}
+	return nil, fmt.Errorf("cannot pass %T to a script", v)
+}
+
+// fromStarlark converts a value back to what encoding/json would have
+// decoded it as, except that ints are int64 or, beyond that, json.Number.
+func fromStarlark(v starlark.Value, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("nested too deeply")
+	}
+
+	switch t := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(t), nil
+	case starlark.String:
+		return string(t), nil
+	case starlark.Int:
+		if i, ok := t.Int64(); ok {
+			return i, nil
+		}
+		return json.Number(t.String()), nil
+	case starlark.Float:
+		return float64(t), nil
+	case *starlark.List, starlark.Tuple:
+		seq := t.(starlark.Indexable)
+		out := make([]interface{}, seq.Len())
+		for i := range out {
+			e, err := fromStarlark(seq.Index(i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = e
+		}
+		return out, nil
+	case *starlark.Dict:
+		out := make(map[string]interface{}, t.Len())
+		for _, item := range t.Items() {
+			k, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("dict keys must be strings, not %s", item[0].Type())
+			}
+			e, err := fromStarlark(item[1], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			out[string(k)] = e
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("cannot store a %s", v.Type())
+}
@@ -0,0 +1,107 @@
+package seed
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// fetchTimeout bounds downloading a dataset from a URL.
+const fetchTimeout = 30 * time.Second
+
+// Load reads a dataset from a file or an http(s) URL. JSON datasets are
+// an object of keys to values; values that are not strings are stored as
+// their JSON text. CSV datasets have key,value rows, optionally under a
+// key,value header. CSV is recognised by a .csv extension or a text/csv
+// content type, anything else is read as JSON.
+func Load(ctx context.Context, src string) (map[string]string, error) {
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		return fetch(ctx, src)
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parse(f, isCSV(src, ""))
+}
+
+func fetch(ctx context.Context, url string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("seed %s: %s", url, resp.Status)
+	}
+	return parse(resp.Body, isCSV(req.URL.Path, resp.Header.Get("Content-Type")))
+}
+
+func isCSV(name, contentType string) bool {
+	return strings.EqualFold(path.Ext(name), ".csv") || strings.HasPrefix(contentType, "text/csv")
+}
+
+func parse(r io.Reader, csvFormat bool) (map[string]string, error) {
+	if csvFormat {
+		return parseCSV(r)
+	}
+	return parseJSON(r)
+}
+
+func parseJSON(r io.Reader) (map[string]string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON dataset: %w", err)
+	}
+
+	data := make(map[string]string, len(raw))
+	for k, v := range raw {
+		var s string
+		if err := json.Unmarshal(v, &s); err == nil {
+			data[k] = s
+		} else {
+			data[k] = string(v)
+		}
+	}
+	return data, nil
+}
+
+func parseCSV(r io.Reader) (map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+
+	data := make(map[string]string)
+	for line := 1; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV dataset: %w", err)
+		}
+		if line == 1 && row[0] == "key" && row[1] == "value" {
+			continue
+		}
+		if row[0] == "" {
+			return nil, fmt.Errorf("invalid CSV dataset: empty key on line %d", line)
+		}
+		data[row[0]] = row[1]
+	}
+}
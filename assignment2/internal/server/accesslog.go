@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type accessEntry struct {
+	Time       string  `json:"time"`
+	RequestID  string  `json:"request_id"`
+	RemoteAddr string  `json:"remote_addr"`
+	Method     string  `json:"method"`
+	URI        string  `json:"uri"`
+	Proto      string  `json:"proto"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMs float64 `json:"duration_ms"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+	KeyID      string  `json:"key_id,omitempty"`
+	Principal  string  `json:"principal,omitempty"`
+	Tenant     string  `json:"tenant,omitempty"`
+	TraceID    string  `json:"trace_id,omitempty"`
+	APIVersion int     `json:"api_version,omitempty"`
+}
+
+// statusRecorder captures the status code and body size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach Flush and deadlines.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// accessLog writes one JSON line per request to the access log, which is
+// kept apart from the application log so it can be shipped on its own.
+func (s *Server) accessLog(next http.Handler) http.Handler {
+	if s.accessLogOut == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		info := RequestInfoFrom(r.Context())
+		entry := accessEntry{
+			Time:       start.UTC().Format(time.RFC3339Nano),
+			RequestID:  info.ID,
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			URI:        r.URL.RequestURI(),
+			Proto:      r.Proto,
+			Status:     rec.status,
+			Bytes:      rec.bytes,
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			UserAgent:  r.UserAgent(),
+			KeyID:      info.KeyID,
+			Tenant:     info.Tenant,
+			TraceID:    info.TraceID,
+			APIVersion: info.APIVersion,
+		}
+		if info.Principal != nil {
+			entry.Principal = info.Principal.Name
+		}
+		line, _ := json.Marshal(entry)
+		s.accessLogOut.Write(append(line, '\n'))
+	})
+}
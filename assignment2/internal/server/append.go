@@ -0,0 +1,143 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// appendRequest appends either Data to a string value or Elements to a
+// JSON array value.
+type appendRequest struct {
+	Data     *string           `json:"data"`
+	Elements []json.RawMessage `json:"elements"`
+}
+
+type appendResponse struct {
+	Key      string `json:"key"`
+	Revision uint64 `json:"revision"`
+	Size     int    `json:"size"`
+
+	// Length is the number of elements, for an array.
+	Length *int `json:"length,omitempty"`
+}
+
+var (
+	errNotArray      = errors.New("value is not a JSON array")
+	errAppendTooLong = errors.New("value would exceed -append-max-bytes")
+)
+
+// appendTo returns old with the request's data or elements added, and
+// for an array its new length.
+func (req appendRequest) appendTo(old string, exists bool) (string, *int, error) {
+	if req.Data != nil {
+		return old + *req.Data, nil, nil
+	}
+
+	var elems []json.RawMessage
+	if exists {
+		if err := json.Unmarshal([]byte(old), &elems); err != nil || elems == nil {
+			return "", nil, errNotArray
+		}
+	}
+	elems = append(elems, req.Elements...)
+	out, err := json.Marshal(elems)
+	if err != nil {
+		return "", nil, err
+	}
+	n := len(elems)
+	return string(out), &n, nil
+}
+
+// POST /data/{key}/append
+//
+// Appends {"data": "..."} to the key's value, or {"elements": [...]} to
+// the JSON array it holds, atomically: concurrent appends all land, in
+// some order, without read-modify-write races. A missing key is created
+// (as "" or []) unless ?create=false, which answers 404 instead; an
+// existing key keeps its time to live. An array append to a value that
+// is not an array gets 409, and one that would take the value over
+// -append-max-bytes gets 413. The answer carries the new size, and
+// length for arrays, rather than the value.
+func (s *Server) AppendData(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	create := true
+	if v := r.URL.Query().Get("create"); v != "" {
+		var err error
+		if create, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Invalid create", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var req appendRequest
+	if err := readJSON(r.Body, &req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if (req.Data == nil) == (req.Elements == nil) {
+		http.Error(w, "Exactly one of data and elements is required", http.StatusBadRequest)
+		return
+	}
+
+	key := r.PathValue("key")
+	var (
+		size   int
+		length *int
+	)
+	apply := func(old string, exists bool) (string, bool, error) {
+		if !exists && !create {
+			return "", false, errNotFound
+		}
+		value, n, err := req.appendTo(old, exists)
+		if err != nil {
+			return "", false, err
+		}
+		if len(value) > s.cfg.AppendMaxBytes {
+			return "", false, errAppendTooLong
+		}
+		if len(s.validators) > 0 {
+			if err := s.validateWrite(r.Context(), key, value); err != nil {
+				return "", false, err
+			}
+		}
+		size, length = len(value), n
+		return value, true, nil
+	}
+
+	var (
+		rev uint64
+		err error
+	)
+	if len(s.validators) == 0 {
+		rev, err = s.store.Update(r.Context(), scopedKey(r, key), apply)
+	} else {
+		rev, err = s.updateValidated(r.Context(), scopedKey(r, key), apply)
+	}
+	if writeRejected(w, err) {
+		return
+	}
+	switch err {
+	case nil:
+	case errNotFound:
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	case errNotArray:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errAppendTooLong:
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case errEvalConflict:
+		s.conflictOutcome(w, r, http.StatusConflict, key, scopedKey(r, key))
+		return
+	default:
+		storeFailed(w, "Failed to persist: ", err)
+		return
+	}
+
+	setRevision(w, rev)
+	writeJSON(w, http.StatusOK, appendResponse{Key: key, Revision: rev, Size: size, Length: length})
+}
@@ -0,0 +1,396 @@
+package server
+
+import (
+	"assignment2/internal/archive"
+	"assignment2/internal/events"
+	"assignment2/internal/metrics"
+	"assignment2/internal/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// archiveBatch caps how many keys one policy archives per run; each run
+// of a policy writes at most one archive object.
+const archiveBatch = 1000
+
+var (
+	errNotArchived  = errors.New("key is not in its archive")
+	errArchiveMoved = errors.New("tombstone changed during rehydration")
+)
+
+// tombstone is what an archived key leaves behind, under
+// -archive-tombstone-prefix followed by the key.
+type tombstone struct {
+	Archive    string    `json:"archive"`
+	Object     string    `json:"object"`
+	Revision   uint64    `json:"revision"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// archiveHint is the 404 answer for an archived key with
+// -archive-reads=hint.
+type archiveHint struct {
+	Error string `json:"error"`
+	Key   string `json:"key"`
+	tombstone
+	Rehydrate string `json:"rehydrate"`
+}
+
+type archiveState struct {
+	target   archive.Target
+	policies []retentionPolicy
+
+	// written holds the keys under a policy set since the last
+	// tombstones job, whose tombstones, if any, are stale.
+	mu      sync.Mutex
+	written map[string]bool
+
+	// cached is the archive object read last, since rehydrating keys
+	// archived together fetches the same one.
+	cacheMu    sync.Mutex
+	cachedName string
+	cached     []byte
+
+	archived   atomic.Int64
+	rehydrated atomic.Int64
+	hinted     atomic.Int64
+}
+
+// newArchive opens -archive-url, or returns nil without -archive.
+func newArchive(s *Server) (*archiveState, error) {
+	if len(s.cfg.Archive) == 0 {
+		return nil, nil
+	}
+	target, err := archive.Open(s.cfg.ArchiveURL, archive.S3Options{
+		Endpoint: s.cfg.ArchiveS3Endpoint,
+		Region:   s.cfg.ArchiveS3Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	a := &archiveState{target: target, written: make(map[string]bool)}
+	for _, p := range s.cfg.Archive {
+		a.policies = append(a.policies, retentionPolicy{Prefix: p.Prefix, MaxAge: p.MaxAge.String(), maxAge: p.MaxAge})
+	}
+	return a, nil
+}
+
+// covers reports whether key falls under an archive policy.
+func (a *archiveState) covers(key string) bool {
+	for _, p := range a.policies {
+		if strings.HasPrefix(key, p.Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// noteWrite is called by the observer for every event, under the store
+// lock, so it only takes note of the key.
+func (a *archiveState) noteWrite(e events.Event) {
+	if e.Type != storage.OpSet || !a.covers(e.Key) {
+		return
+	}
+	a.mu.Lock()
+	a.written[e.Key] = true
+	a.mu.Unlock()
+}
+
+func (a *archiveState) wasWritten(key string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.written[key]
+}
+
+func (a *archiveState) fetch(ctx context.Context, name string) ([]byte, error) {
+	a.cacheMu.Lock()
+	defer a.cacheMu.Unlock()
+	if a.cachedName == name {
+		return a.cached, nil
+	}
+	data, err := a.target.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	a.cachedName, a.cached = name, data
+	return data, nil
+}
+
+func (s *Server) tombstoneKey(key string) string {
+	return s.cfg.ArchiveTombstonePrefix + key
+}
+
+// archiveJob moves the keys each policy has found unchanged for too long
+// to the archive. Only the lease holder archives; a standby gets the
+// tombstones through replication.
+func (s *Server) archiveJob(ctx context.Context) error {
+	if s.isStandby() {
+		return nil
+	}
+	for _, p := range s.archive.policies {
+		n, object, err := s.archivePolicy(ctx, p)
+		switch {
+		case err != nil:
+			log.Printf("[ARCHIVE] %q: %s\n", p.Prefix, err)
+		case n > 0:
+			log.Printf("[ARCHIVE] %q: archived %d keys to %s\n", p.Prefix, n, object)
+		}
+	}
+	return nil
+}
+
+// archivePolicy writes up to archiveBatch of p's keys to one archive
+// object and then, in one transaction, replaces those that are still
+// unchanged by tombstones. Keys with a time to live are left to expire,
+// and values the client encrypted stay where their metadata is.
+func (s *Server) archivePolicy(ctx context.Context, p retentionPolicy) (int, string, error) {
+	now := time.Now()
+	candidates, err := s.store.ModifiedBefore(ctx, p.Prefix, now.Add(-p.maxAge), archiveBatch)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var entries []archive.Entry
+	for _, k := range candidates {
+		if !governs(p, k, s.archive.policies) {
+			continue
+		}
+		info, ok, err := s.store.GetMeta(ctx, k)
+		if err != nil {
+			return 0, "", err
+		}
+		if !ok || !info.ExpiresAt.IsZero() || info.Encryption != nil {
+			continue
+		}
+		entries = append(entries, archive.Entry{
+			Key:      k,
+			Value:    info.Value,
+			Revision: info.Revision,
+			Created:  info.Created,
+			Modified: info.Modified,
+			Tags:     info.Tags,
+		})
+	}
+	if len(entries) == 0 {
+		return 0, "", nil
+	}
+
+	data, err := archive.Encode(entries)
+	if err != nil {
+		return 0, "", err
+	}
+	object := fmt.Sprintf("%s-%d.ndjson.gz", now.UTC().Format("20060102T150405.000000000Z"), s.store.Revision())
+	if err := s.archive.target.Put(ctx, object, data); err != nil {
+		return 0, "", err
+	}
+
+	archived := 0
+	_, err = s.store.Txn(ctx, func(tx *storage.Txn) error {
+		archived = 0
+		for _, e := range entries {
+			if v, ok := tx.Get(e.Key); !ok || v != e.Value {
+				continue
+			}
+			stone, err := json.Marshal(tombstone{
+				Archive:    s.archive.target.String(),
+				Object:     object,
+				Revision:   e.Revision,
+				ArchivedAt: now,
+			})
+			if err != nil {
+				return err
+			}
+			tx.Delete(e.Key)
+			tx.Set(s.tombstoneKey(e.Key), string(stone))
+			archived++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, object, err
+	}
+	s.archive.archived.Add(int64(archived))
+	return archived, object, nil
+}
+
+// dropStaleTombstones deletes the tombstones of archived keys that have
+// been written again since, so that deleting such a key later does not
+// bring back its archived value.
+func (s *Server) dropStaleTombstones(ctx context.Context) error {
+	s.archive.mu.Lock()
+	written := s.archive.written
+	s.archive.written = make(map[string]bool)
+	s.archive.mu.Unlock()
+
+	if len(written) == 0 || s.isStandby() {
+		return nil
+	}
+	_, err := s.store.Txn(ctx, func(tx *storage.Txn) error {
+		for k := range written {
+			tx.Delete(s.tombstoneKey(k))
+		}
+		return nil
+	})
+	if err != nil {
+		// Try again next time.
+		s.archive.mu.Lock()
+		for k := range written {
+			s.archive.written[k] = true
+		}
+		s.archive.mu.Unlock()
+	}
+	return err
+}
+
+// serveArchived answers a read of stored, which does not exist, if it
+// was archived: with a hint, or after rehydrating it from the archive if
+// -archive-reads=rehydrate and this server can write. It reports whether
+// it answered; after a rehydration it has not, and the key can be read
+// again.
+func (s *Server) serveArchived(w http.ResponseWriter, r *http.Request, key, stored string) bool {
+	if !s.archive.covers(stored) || s.archive.wasWritten(stored) {
+		return false
+	}
+	raw, ok, err := s.store.Get(r.Context(), s.tombstoneKey(stored))
+	if err != nil || !ok {
+		return false
+	}
+	var stone tombstone
+	if err := json.Unmarshal([]byte(raw), &stone); err != nil {
+		return false
+	}
+
+	if s.cfg.ArchiveReads == "hint" || s.isStandby() {
+		s.archive.hinted.Add(1)
+		writeJSON(w, http.StatusNotFound, archiveHint{
+			Error:     "Key archived",
+			Key:       key,
+			tombstone: stone,
+			Rehydrate: "POST /data/" + key + "/rehydrate",
+		})
+		return true
+	}
+
+	switch err := s.rehydrate(r.Context(), stored, raw, stone); {
+	case err == nil, errors.Is(err, errArchiveMoved):
+		return false
+	default:
+		http.Error(w, "Failed to rehydrate from archive: "+err.Error(), http.StatusBadGateway)
+		return true
+	}
+}
+
+// rehydrate restores stored from the archive object its tombstone raw
+// points to, and deletes the tombstone, unless either changed meanwhile.
+func (s *Server) rehydrate(ctx context.Context, stored, raw string, stone tombstone) error {
+	data, err := s.archive.fetch(ctx, stone.Object)
+	if err != nil {
+		return err
+	}
+	entry, found, err := archive.Find(data, stored)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errNotArchived
+	}
+
+	_, err = s.store.Txn(ctx, func(tx *storage.Txn) error {
+		if cur, ok := tx.Get(s.tombstoneKey(stored)); !ok || cur != raw {
+			return errArchiveMoved
+		}
+		if _, exists := tx.Get(stored); exists {
+			return errArchiveMoved
+		}
+		tx.Set(stored, entry.Value)
+		tx.Delete(s.tombstoneKey(stored))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(entry.Tags) > 0 {
+		if _, err := s.store.SetTags(ctx, stored, entry.Tags); err != nil {
+			return err
+		}
+	}
+	s.archive.rehydrated.Add(1)
+	return nil
+}
+
+func (s *Server) archiveEnabled(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.archive == nil {
+			s.IncrementRequests()
+			http.Error(w, "Archiving is not configured (-archive)", http.StatusNotImplemented)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// POST /data/{key}/rehydrate
+//
+// Restores an archived key from its archive, whatever -archive-reads
+// says, and answers like GET /data/{key}. A key that exists is left
+// alone; one that neither exists nor was archived gets 404.
+func (s *Server) RehydrateKey(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	key := r.PathValue("key")
+	stored := scopedKey(r, key)
+	raw, ok, err := s.store.Get(r.Context(), s.tombstoneKey(stored))
+	if err != nil {
+		storeFailed(w, "Failed to read: ", err)
+		return
+	}
+	if ok {
+		var stone tombstone
+		if err := json.Unmarshal([]byte(raw), &stone); err != nil {
+			http.Error(w, "Invalid tombstone", http.StatusInternalServerError)
+			return
+		}
+		err := s.rehydrate(r.Context(), stored, raw, stone)
+		if err != nil && !errors.Is(err, errArchiveMoved) {
+			http.Error(w, "Failed to rehydrate from archive: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+
+	entry, ok, err := s.store.GetEntry(r.Context(), stored)
+	if err != nil {
+		storeFailed(w, "Failed to read: ", err)
+		return
+	}
+	if !ok {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	writeEntry(w, key, entry)
+}
+
+func (s *Server) archiveStats() map[string]interface{} {
+	return map[string]interface{}{
+		"target":     s.archive.target.String(),
+		"reads":      s.cfg.ArchiveReads,
+		"archived":   s.archive.archived.Load(),
+		"rehydrated": s.archive.rehydrated.Load(),
+		"hinted":     s.archive.hinted.Load(),
+	}
+}
+
+func (s *Server) collectArchiveMetrics() []metrics.Family {
+	return []metrics.Family{
+		metrics.Single("kv_archived_keys_total", "Keys moved to the archive by -archive policies.", metrics.TypeCounter, float64(s.archive.archived.Load())),
+		metrics.Single("kv_archive_rehydrated_total", "Archived keys restored from the archive.", metrics.TypeCounter, float64(s.archive.rehydrated.Load())),
+		metrics.Single("kv_archive_hints_total", "Reads of archived keys answered with a hint.", metrics.TypeCounter, float64(s.archive.hinted.Load())),
+	}
+}
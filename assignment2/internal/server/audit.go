@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+type auditEntry struct {
+	Time       string                 `json:"time"`
+	RequestID  string                 `json:"request_id"`
+	Action     string                 `json:"action"`
+	RemoteAddr string                 `json:"remote_addr"`
+	KeyID      string                 `json:"key_id,omitempty"`
+	Principal  string                 `json:"principal,omitempty"`
+	Tenant     string                 `json:"tenant,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+}
+
+// audit records a destructive or administrative action: always in the
+// application log, and as a JSON line in the -audit-log file if there is
+// one.
+func (s *Server) audit(r *http.Request, action string, details map[string]interface{}) {
+	info := RequestInfoFrom(r.Context())
+	entry := auditEntry{
+		Time:       time.Now().UTC().Format(time.RFC3339Nano),
+		RequestID:  info.ID,
+		Action:     action,
+		RemoteAddr: r.RemoteAddr,
+		KeyID:      info.KeyID,
+		Tenant:     info.Tenant,
+		Details:    details,
+	}
+	if info.Principal != nil {
+		entry.Principal = info.Principal.Name
+	}
+	line, _ := json.Marshal(entry)
+	log.Printf("[AUDIT] %s\n", line)
+
+	if s.auditLog == nil {
+		return
+	}
+	if _, err := s.auditLog.Write(append(line, '\n')); err != nil {
+		log.Printf("[AUDIT] write: %v\n", err)
+	}
+}
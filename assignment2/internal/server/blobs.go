@@ -0,0 +1,167 @@
+package server
+
+import (
+	"assignment2/internal/blob"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// blobsEnabled answers 501 when no blob directory is configured.
+func (s *Server) blobsEnabled(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.blobs == nil {
+			s.IncrementRequests()
+			http.Error(w, "Blob storage is not configured (-blob-dir)", http.StatusNotImplemented)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// cleanupUploads is the upload-cleanup job.
+func (s *Server) cleanupUploads(ctx context.Context) error {
+	n, err := s.blobs.CleanupUploads(s.cfg.BlobUploadTTL)
+	if n > 0 {
+		log.Printf("[BLOB] discarded %d expired uploads\n", n)
+	}
+	return err
+}
+
+// POST /data/{key}/upload
+//
+// Starts an upload session. Parts are then sent with
+// PUT /uploads/{id}/{part} and joined by POST /uploads/{id}/commit.
+func (s *Server) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	key := r.PathValue("key")
+	id, err := s.blobs.CreateUpload(scopedKey(r, key))
+	if err != nil {
+		http.Error(w, "Failed to create upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"upload_id":      id,
+		"key":            key,
+		"max_part_bytes": s.cfg.BlobMaxPart << 20,
+		"max_parts":      blob.MaxPart,
+	})
+}
+
+// PUT /uploads/{id}/{part}
+func (s *Server) PutUploadPart(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	n, err := strconv.Atoi(r.PathValue("part"))
+	if err != nil {
+		http.Error(w, "Invalid part number", http.StatusBadRequest)
+		return
+	}
+
+	part, err := s.blobs.PutPart(r.PathValue("id"), n, r.Body, s.cfg.BlobMaxPart<<20)
+	if err != nil {
+		blobFailed(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(part)
+}
+
+// POST /uploads/{id}/commit
+//
+// An optional body {"parts": [1, 2, 3]} selects and orders the parts;
+// otherwise all uploaded parts are joined in order.
+func (s *Server) CommitUpload(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	var req struct {
+		Parts []int `json:"parts"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+
+	info, err := s.blobs.Commit(r.PathValue("id"), req.Parts)
+	if err != nil {
+		blobFailed(w, err)
+		return
+	}
+	info.Key = strings.TrimPrefix(info.Key, tenantScope(r))
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(info)
+}
+
+// DELETE /uploads/{id}
+func (s *Server) AbortUpload(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	id := r.PathValue("id")
+	if err := s.blobs.Abort(id); err != nil {
+		blobFailed(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"aborted": id})
+}
+
+// GET /data/{key}/blob
+//
+// Streams the blob from disk. Range requests and If-None-Match (on the
+// content hash) are supported.
+func (s *Server) GetBlob(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	f, info, err := s.blobs.Open(scopedKey(r, r.PathValue("key")))
+	if err != nil {
+		blobFailed(w, err)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if info.SHA256 != "" {
+		w.Header().Set("ETag", `"`+info.SHA256+`"`)
+	}
+	http.ServeContent(w, r, "", info.Modified, f)
+}
+
+// DELETE /data/{key}/blob
+func (s *Server) DeleteBlob(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	key := r.PathValue("key")
+	found, err := s.blobs.Delete(scopedKey(r, key))
+	if err != nil {
+		blobFailed(w, err)
+		return
+	}
+	if !found {
+		http.Error(w, "Blob not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"deleted": key})
+}
+
+func blobFailed(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, blob.ErrNotFound):
+		http.Error(w, "Blob not found", http.StatusNotFound)
+	case errors.Is(err, blob.ErrNoUpload):
+		http.Error(w, "Upload not found", http.StatusNotFound)
+	case errors.Is(err, blob.ErrPartTooLarge):
+		http.Error(w, "Part too large", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, blob.ErrInvalidPart), errors.Is(err, blob.ErrMissingPart), errors.Is(err, blob.ErrEmptyUpload):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
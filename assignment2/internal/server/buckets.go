@@ -0,0 +1,83 @@
+package server
+
+import (
+	"assignment2/internal/storage"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// A bucket is the keys named <bucket>/..., within the caller's tenant.
+const bucketSeparator = "/"
+
+type cloneRequest struct {
+	To       string `json:"to"`
+	Revision uint64 `json:"revision"`
+}
+
+func validBucket(name string) bool {
+	return name != "" && !strings.Contains(name, bucketSeparator)
+}
+
+// POST /buckets/{bucket}/clone
+//
+// Copies every key of the bucket, with its tags and time to live, into
+// the empty bucket "to" in one atomic batch. With "revision" the bucket is
+// copied as it was at that revision, which the write-ahead log must still
+// reach back to.
+func (s *Server) CloneBucket(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	bucket := r.PathValue("bucket")
+	var req cloneRequest
+	if err := readJSON(r.Body, &req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !validBucket(bucket) || !validBucket(req.To) {
+		http.Error(w, "Bucket names must be non-empty and must not contain "+bucketSeparator, http.StatusBadRequest)
+		return
+	}
+	if req.To == bucket {
+		http.Error(w, "Cannot clone a bucket into itself", http.StatusBadRequest)
+		return
+	}
+
+	if s.checkPrefixLocks(w, r, req.To+bucketSeparator) {
+		return
+	}
+
+	src := scopedKey(r, bucket+bucketSeparator)
+	dst := scopedKey(r, req.To+bucketSeparator)
+	n, rev, err := s.store.ClonePrefix(r.Context(), src, dst, req.Revision)
+	switch {
+	case err == nil:
+	case errors.Is(err, storage.ErrPrefixNotEmpty):
+		http.Error(w, "Destination bucket is not empty", http.StatusConflict)
+		return
+	case errors.Is(err, storage.ErrFutureRevision):
+		http.Error(w, "Revision is in the future", http.StatusBadRequest)
+		return
+	case errors.Is(err, storage.ErrCompacted):
+		http.Error(w, "Revision is no longer available", http.StatusGone)
+		return
+	default:
+		storeFailed(w, "Clone failed: ", err)
+		return
+	}
+	if n == 0 {
+		http.Error(w, "Bucket not found", http.StatusNotFound)
+		return
+	}
+
+	setRevision(w, rev)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"bucket":   bucket,
+		"to":       req.To,
+		"keys":     n,
+		"revision": rev,
+	})
+}
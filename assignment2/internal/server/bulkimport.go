@@ -0,0 +1,636 @@
+package server
+
+import (
+	"assignment2/internal/jobs"
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// bulkBatchSize is how many records a bulk import writes at once.
+	bulkBatchSize = 500
+
+	// bulkRetries is how often a batch the store refused is tried again,
+	// waiting bulkBackoff, then twice as long each time up to
+	// bulkMaxBackoff, before the import fails.
+	bulkRetries    = 10
+	bulkBackoff    = 100 * time.Millisecond
+	bulkMaxBackoff = 10 * time.Second
+
+	// bulkMaxErrors is how many record errors an import reports in full;
+	// the rest are only counted.
+	bulkMaxErrors = 100
+
+	// bulkMaxLine is the longest NDJSON line.
+	bulkMaxLine = 16 << 20
+
+	// bulkKeep is how long a finished import can still be looked up.
+	bulkKeep = time.Hour
+)
+
+// Bulk import states.
+const (
+	bulkQueued   = "queued"
+	bulkRunning  = "running"
+	bulkDone     = "done"
+	bulkFailed   = "failed"
+	bulkCanceled = "canceled"
+)
+
+var errBulkCanceled = errors.New("import canceled")
+
+// bulkImports are the imports of POST /imports. The uploaded file is
+// spooled to a temporary file and imported by the bulk-import job; like
+// staged imports, imports live in memory on the node that took them and
+// are lost on restart.
+type bulkImports struct {
+	mu      sync.Mutex
+	imports map[string]*bulkImport
+	queue   []*bulkImport
+}
+
+type bulkImport struct {
+	id     string
+	scope  string
+	info   RequestInfo
+	path   string
+	format string
+	ttl    time.Duration
+
+	mu       sync.Mutex
+	status   bulkImportStatus
+	cancel   context.CancelFunc
+	canceled bool
+}
+
+type bulkImportStatus struct {
+	ID       string  `json:"id"`
+	State    string  `json:"state"`
+	Format   string  `json:"format"`
+	Bytes    int64   `json:"bytes"`
+	Read     int64   `json:"bytes_read"`
+	Progress float64 `json:"progress"`
+
+	Records  int64 `json:"records"`
+	Imported int64 `json:"imported"`
+	Failed   int64 `json:"failed"`
+	Retries  int64 `json:"retries"`
+
+	RecordsPerSec float64 `json:"records_per_sec"`
+	BytesPerSec   float64 `json:"bytes_per_sec"`
+
+	// Revision is that of the last batch written.
+	Revision uint64 `json:"revision,omitempty"`
+
+	Errors []bulkRecordError `json:"errors,omitempty"`
+	Error  string            `json:"error,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// bulkRecordError is a record that was skipped.
+type bulkRecordError struct {
+	Line  int    `json:"line"`
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error"`
+}
+
+// snapshot returns the status with the throughput as of now.
+func (b *bulkImport) snapshot() bulkImportStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st := b.status
+	st.Errors = append([]bulkRecordError(nil), st.Errors...)
+	if st.Bytes > 0 {
+		st.Progress = float64(st.Read) / float64(st.Bytes)
+	}
+	if st.StartedAt != nil {
+		end := time.Now()
+		if st.FinishedAt != nil {
+			end = *st.FinishedAt
+		}
+		if secs := end.Sub(*st.StartedAt).Seconds(); secs > 0 {
+			st.RecordsPerSec = float64(st.Imported) / secs
+			st.BytesPerSec = float64(st.Read) / secs
+		}
+	}
+	return st
+}
+
+func (b *bulkImport) update(fn func(st *bulkImportStatus)) {
+	b.mu.Lock()
+	fn(&b.status)
+	b.mu.Unlock()
+}
+
+func (b *bulkImport) recordFailed(line int, key string, err error) {
+	b.update(func(st *bulkImportStatus) {
+		st.Failed++
+		if len(st.Errors) < bulkMaxErrors {
+			st.Errors = append(st.Errors, bulkRecordError{Line: line, Key: key, Error: err.Error()})
+		}
+	})
+}
+
+// finish ends the import in state with err, and removes its file.
+func (b *bulkImport) finish(state string, err error) {
+	os.Remove(b.path)
+	now := time.Now().Round(0)
+	b.update(func(st *bulkImportStatus) {
+		st.State = state
+		st.FinishedAt = &now
+		if err != nil {
+			st.Error = err.Error()
+		}
+	})
+}
+
+// prune forgets imports that finished more than bulkKeep ago. The caller
+// holds bi.mu.
+func (bi *bulkImports) prune(now time.Time) {
+	for id, b := range bi.imports {
+		b.mu.Lock()
+		old := b.status.FinishedAt != nil && now.Sub(*b.status.FinishedAt) > bulkKeep
+		b.mu.Unlock()
+		if old {
+			delete(bi.imports, id)
+		}
+	}
+}
+
+// next takes the next queued import.
+func (bi *bulkImports) next() *bulkImport {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+
+	for len(bi.queue) > 0 {
+		b := bi.queue[0]
+		bi.queue = bi.queue[1:]
+		b.mu.Lock()
+		canceled := b.canceled
+		b.mu.Unlock()
+		if !canceled {
+			return b
+		}
+	}
+	return nil
+}
+
+// discard removes the files of the imports still queued, on shutdown.
+func (bi *bulkImports) discard() {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+
+	for _, b := range bi.queue {
+		os.Remove(b.path)
+	}
+	bi.queue = nil
+}
+
+// lookupBulkImport returns the caller's import {id}.
+func (s *Server) lookupBulkImport(r *http.Request) (*bulkImport, bool) {
+	bi := &s.bulkImports
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+
+	b, ok := bi.imports[r.PathValue("id")]
+	if !ok || b.scope != tenantScope(r) {
+		return nil, false
+	}
+	return b, true
+}
+
+// POST /imports
+//
+// Takes a file of records to import in the background and answers 202
+// with the import's status, whose id GET /imports/{id} follows. The
+// file is NDJSON, one {"key": ..., "value": ...} per line (values that
+// are not strings are stored as their JSON text), or with ?format=csv or
+// Content-Type text/csv, key,value rows with an optional header. ?ttl=
+// applies to every key. Bad records are skipped and reported; the rest
+// are written in batches, retried with backoff while the store refuses
+// writes.
+func (s *Server) CreateBulkImport(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	ttl, err := parseTTL(r)
+	if err != nil {
+		http.Error(w, "Invalid ttl", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+			format = "csv"
+		}
+	}
+	if format != "ndjson" && format != "csv" {
+		http.Error(w, "format must be ndjson or csv", http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.CreateTemp("", "kv-import-*")
+	if err != nil {
+		http.Error(w, "Failed to store the file", http.StatusInternalServerError)
+		return
+	}
+	n, err := io.Copy(f, http.MaxBytesReader(w, r.Body, s.cfg.BulkImportMaxSize<<20))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "File exceeds -bulk-import-max-size", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Failed to read the file", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().Round(0)
+	b := &bulkImport{
+		id:     newRequestID(),
+		scope:  tenantScope(r),
+		info:   *RequestInfoFrom(r.Context()),
+		path:   f.Name(),
+		format: format,
+		ttl:    ttl,
+	}
+	b.status = bulkImportStatus{ID: b.id, State: bulkQueued, Format: format, Bytes: n, CreatedAt: now}
+
+	bi := &s.bulkImports
+	bi.mu.Lock()
+	bi.prune(now)
+	if bi.imports == nil {
+		bi.imports = make(map[string]*bulkImport)
+	}
+	bi.imports[b.id] = b
+	bi.queue = append(bi.queue, b)
+	bi.mu.Unlock()
+	s.jobs.Trigger("bulk-import")
+
+	w.Header().Set("Location", "/imports/"+b.id)
+	writeJSON(w, http.StatusAccepted, b.snapshot())
+}
+
+// GET /imports
+func (s *Server) ListBulkImports(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	bi := &s.bulkImports
+	bi.mu.Lock()
+	bi.prune(time.Now())
+	var mine []*bulkImport
+	for _, b := range bi.imports {
+		if b.scope == tenantScope(r) {
+			mine = append(mine, b)
+		}
+	}
+	bi.mu.Unlock()
+
+	out := make([]bulkImportStatus, len(mine))
+	for i, b := range mine {
+		out[i] = b.snapshot()
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"imports": out})
+}
+
+// GET /imports/{id}
+//
+// The import's progress: bytes read out of the file's, records imported
+// and skipped (the first 100 with their line and error), batches retried,
+// and throughput.
+func (s *Server) GetBulkImport(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	b, ok := s.lookupBulkImport(r)
+	if !ok {
+		http.Error(w, "Import not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, b.snapshot())
+}
+
+// DELETE /imports/{id}
+//
+// Cancels a queued or running import. Batches already written stay.
+func (s *Server) CancelBulkImport(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	b, ok := s.lookupBulkImport(r)
+	if !ok {
+		http.Error(w, "Import not found", http.StatusNotFound)
+		return
+	}
+
+	b.mu.Lock()
+	state := b.status.State
+	if state == bulkQueued || state == bulkRunning {
+		b.canceled = true
+		if b.cancel != nil {
+			b.cancel()
+		}
+	}
+	b.mu.Unlock()
+
+	switch state {
+	case bulkQueued:
+		b.finish(bulkCanceled, errBulkCanceled)
+	case bulkRunning:
+		// The worker finishes it once the current batch is done.
+	default:
+		http.Error(w, "Import already "+state, http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusOK, b.snapshot())
+}
+
+// bulkImportJob is the bulk-import job: it runs queued imports, up to
+// -bulk-import-workers at a time, until the queue is empty.
+func (s *Server) bulkImportJob(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for i := 0; i < s.cfg.BulkImportWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := s.bulkImports.next(); b != nil && ctx.Err() == nil; b = s.bulkImports.next() {
+				s.runBulkImport(ctx, b)
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (s *Server) runBulkImport(ctx context.Context, b *bulkImport) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// Validators see the tenant and caller of the upload.
+	ctx = context.WithValue(ctx, requestInfoKey{}, &b.info)
+
+	now := time.Now().Round(0)
+	b.mu.Lock()
+	if b.canceled {
+		b.mu.Unlock()
+		return
+	}
+	b.cancel = cancel
+	b.status.State = bulkRunning
+	b.status.StartedAt = &now
+	b.mu.Unlock()
+
+	err := s.importFile(ctx, b)
+	switch {
+	case b.isCanceled():
+		b.finish(bulkCanceled, errBulkCanceled)
+	case err != nil:
+		b.finish(bulkFailed, err)
+	default:
+		b.finish(bulkDone, nil)
+	}
+	st := b.snapshot()
+	log.Printf("[IMPORT] %s %s: %d imported, %d failed, %d retries\n", b.id, st.State, st.Imported, st.Failed, st.Retries)
+}
+
+func (b *bulkImport) isCanceled() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.canceled
+}
+
+// countingReader counts the bytes read through it into the import's
+// status.
+type countingReader struct {
+	r io.Reader
+	b *bulkImport
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.b.update(func(st *bulkImportStatus) { st.Read += int64(n) })
+	return n, err
+}
+
+// bulkRecord is a record of the file, or why it could not be read.
+type bulkRecord struct {
+	line  int
+	key   string
+	value string
+	err   error
+}
+
+// importFile reads b's file and writes its records in batches.
+func (s *Server) importFile(ctx context.Context, b *bulkImport) error {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	next := ndjsonRecords(countingReader{f, b})
+	if b.format == "csv" {
+		next = csvRecords(countingReader{f, b})
+	}
+
+	batch := make(map[string]string, bulkBatchSize)
+	for {
+		rec, ok := next()
+		if !ok {
+			if rec.err != nil {
+				return rec.err
+			}
+			break
+		}
+		b.update(func(st *bulkImportStatus) { st.Records++ })
+		if rec.err == nil {
+			rec.err = s.checkBulkRecord(ctx, b, rec)
+		}
+		if rec.err != nil {
+			b.recordFailed(rec.line, rec.key, rec.err)
+			continue
+		}
+		batch[b.scope+rec.key] = rec.value
+		if len(batch) == bulkBatchSize {
+			if err := s.writeBulkBatch(ctx, b, batch); err != nil {
+				return err
+			}
+			batch = make(map[string]string, bulkBatchSize)
+		}
+	}
+	if len(batch) > 0 {
+		return s.writeBulkBatch(ctx, b, batch)
+	}
+	return nil
+}
+
+// checkBulkRecord applies the checks of POST /data to one record.
+func (s *Server) checkBulkRecord(ctx context.Context, b *bulkImport, rec bulkRecord) error {
+	if rec.key == "" {
+		return errors.New("key is required")
+	}
+	if err := s.validateWrite(ctx, rec.key, rec.value); err != nil {
+		var verr *validationError
+		if errors.As(err, &verr) {
+			return verr.err
+		}
+		return err
+	}
+	if _, _, locked := s.locks.blocking([]string{b.scope + rec.key}, ""); locked {
+		return errors.New("key is locked")
+	}
+	return nil
+}
+
+// writeBulkBatch writes batch, waiting out memory pressure and retrying
+// refused writes with backoff.
+func (s *Server) writeBulkBatch(ctx context.Context, b *bulkImport, batch map[string]string) error {
+	backoff := bulkBackoff
+	for attempt := 0; ; attempt++ {
+		if b.isCanceled() {
+			return errBulkCanceled
+		}
+		if s.isStandby() {
+			return errors.New("this node is no longer the leader")
+		}
+
+		var err error
+		if s.cfg.MemoryLimit > 0 && s.cfg.MemoryPolicy == "reject" && s.memory.pressure.Load() {
+			err = errors.New("memory limit exceeded")
+		} else {
+			if err := s.awaitLowLane(ctx, b); err != nil {
+				return err
+			}
+			var rev uint64
+			rev, err = s.store.SetManyTTL(ctx, batch, b.ttl)
+			if s.lanes != nil {
+				s.lanes.Release("low")
+			}
+			if err == nil {
+				b.update(func(st *bulkImportStatus) {
+					st.Imported += int64(len(batch))
+					st.Revision = rev
+				})
+				jobs.Heartbeat(ctx)
+				return nil
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if attempt == bulkRetries {
+			return fmt.Errorf("batch failed after %d retries: %v", bulkRetries, err)
+		}
+
+		b.update(func(st *bulkImportStatus) { st.Retries++ })
+		jobs.Heartbeat(ctx)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(2*backoff, bulkMaxBackoff)
+	}
+}
+
+// awaitLowLane takes a slot in the low priority lane for a batch under
+// -max-in-flight, so that requests go first while the server is busy.
+func (s *Server) awaitLowLane(ctx context.Context, b *bulkImport) error {
+	for s.lanes != nil && !s.lanes.Acquire(ctx, "low", bulkMaxBackoff) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if b.isCanceled() {
+			return errBulkCanceled
+		}
+		jobs.Heartbeat(ctx)
+	}
+	return nil
+}
+
+// ndjsonRecords returns an iterator over the records of an NDJSON file.
+// When it reports no more records, the record carries the read error if
+// reading stopped on one.
+func ndjsonRecords(r io.Reader) func() (bulkRecord, bool) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), bulkMaxLine)
+	line := 0
+	return func() (bulkRecord, bool) {
+		for sc.Scan() {
+			line++
+			text := strings.TrimSpace(sc.Text())
+			if text == "" {
+				continue
+			}
+			var raw struct {
+				Key   string          `json:"key"`
+				Value json.RawMessage `json:"value"`
+			}
+			if err := json.Unmarshal([]byte(text), &raw); err != nil {
+				return bulkRecord{line: line, err: errors.New("invalid JSON")}, true
+			}
+			rec := bulkRecord{line: line, key: raw.Key}
+			switch {
+			case len(raw.Value) == 0:
+				rec.err = errors.New("value is required")
+			case raw.Value[0] == '"':
+				json.Unmarshal(raw.Value, &rec.value)
+			default:
+				rec.value = string(raw.Value)
+			}
+			return rec, true
+		}
+		return bulkRecord{err: sc.Err()}, false
+	}
+}
+
+// csvRecords is ndjsonRecords for key,value CSV; a first row of
+// key,value is a header.
+func csvRecords(r io.Reader) func() (bulkRecord, bool) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	first := true
+	return func() (bulkRecord, bool) {
+		for {
+			row, err := cr.Read()
+			var perr *csv.ParseError
+			switch {
+			case err == io.EOF:
+				return bulkRecord{}, false
+			case errors.As(err, &perr):
+				return bulkRecord{line: perr.Line, err: perr.Err}, true
+			case err != nil:
+				return bulkRecord{err: err}, false
+			}
+
+			line, _ := cr.FieldPos(0)
+			header := first && len(row) == 2 && row[0] == "key" && row[1] == "value"
+			first = false
+			switch {
+			case header:
+				continue
+			case len(row) != 2:
+				return bulkRecord{line: line, err: fmt.Errorf("expected 2 fields, got %d", len(row))}, true
+			}
+			return bulkRecord{line: line, key: row[0], value: row[1]}, true
+		}
+	}
+}
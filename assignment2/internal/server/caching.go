@@ -0,0 +1,85 @@
+package server
+
+import (
+	"assignment2/internal/auth"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheMaxAge returns the -cache-max-age policy with the longest prefix
+// of key, and false if none has one.
+func (s *Server) cacheMaxAge(key string) (time.Duration, bool) {
+	best, found := -1, false
+	var maxAge time.Duration
+	for _, p := range s.cfg.CacheMaxAge {
+		if len(p.Prefix) > best && strings.HasPrefix(key, p.Prefix) {
+			best, maxAge, found = len(p.Prefix), p.MaxAge, true
+		}
+	}
+	return maxAge, found
+}
+
+// authenticated reports whether r was made with credentials, so that its
+// answer is only for the caller and must not be kept by shared caches.
+func authenticated(r *http.Request) bool {
+	info := RequestInfoFrom(r.Context())
+	return info.Principal != nil || info.KeyID != "" || info.Tenant != "" ||
+		r.Header.Get(auth.HeaderAPIKey) != "" || r.Header.Get("Authorization") != ""
+}
+
+// setCacheControl sets Cache-Control for a read of key, or of the keys
+// under it for a listing, from -cache-max-age. Without a policy, or with
+// max age 0, caches have to check back with every request.
+func (s *Server) setCacheControl(w http.ResponseWriter, r *http.Request, key string) {
+	maxAge, ok := s.cacheMaxAge(key)
+	if !ok || maxAge == 0 {
+		w.Header().Set("Cache-Control", "no-cache")
+		return
+	}
+	visibility := "public"
+	if authenticated(r) {
+		visibility = "private"
+	}
+	w.Header().Set("Cache-Control", visibility+", max-age="+strconv.Itoa(int(maxAge.Seconds())))
+}
+
+// setCacheHeaders sets the headers caches validate a read of a key with:
+// ETag, Last-Modified and Cache-Control. It reports true after answering
+// 304 Not Modified if the request's If-None-Match or, without one,
+// If-Modified-Since shows the caller has the current value.
+func (s *Server) setCacheHeaders(w http.ResponseWriter, r *http.Request, key, tag string, rev uint64, modified time.Time) bool {
+	h := w.Header()
+	h.Set("ETag", tag)
+	if !modified.IsZero() {
+		h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	s.setCacheControl(w, r, key)
+	setRevision(w, rev)
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !noneMatch(inm, tag) {
+			return false
+		}
+	} else if !notModifiedSince(r.Header.Get("If-Modified-Since"), modified) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// notModifiedSince reports whether modified is no later than the
+// If-Modified-Since header ims, to the second.
+func notModifiedSince(ims string, modified time.Time) bool {
+	if ims == "" || modified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	return err == nil && !modified.Truncate(time.Second).After(since)
+}
+
+// setAge sets Age on a response served from a cache filled at cached.
+func setAge(w http.ResponseWriter, cached time.Time) {
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(cached).Seconds())))
+}
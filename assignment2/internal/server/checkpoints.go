@@ -0,0 +1,123 @@
+package server
+
+import (
+	"assignment2/internal/storage"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// checkpoints are named copies of the whole store kept in memory by
+// -checkpoints, so that a test suite can put the server back into a
+// known state between test cases without restarting it.
+type checkpoints struct {
+	mu    sync.Mutex
+	saved map[string]checkpoint
+}
+
+type checkpoint struct {
+	records  []storage.Record
+	revision uint64
+	keys     int
+	created  time.Time
+}
+
+type checkpointInfo struct {
+	Name      string    `json:"name"`
+	Revision  uint64    `json:"revision"`
+	Keys      int       `json:"keys"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (c checkpoint) info(name string) checkpointInfo {
+	return checkpointInfo{Name: name, Revision: c.revision, Keys: c.keys, CreatedAt: c.created}
+}
+
+// POST /admin/checkpoint
+//
+// Saves every key, with its tags and time to live, as the checkpoint
+// ?name= ("default" if not given), replacing one of the same name.
+func (s *Server) SaveCheckpoint(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "default"
+	}
+	recs, rev, err := s.store.Snapshot(r.Context())
+	if err != nil {
+		storeFailed(w, "Checkpoint failed: ", err)
+		return
+	}
+	cp := checkpoint{records: recs, revision: rev, created: time.Now().Round(0)}
+	for _, rec := range recs {
+		if rec.Op == storage.OpSet {
+			cp.keys++
+		}
+	}
+
+	c := &s.checkpoints
+	c.mu.Lock()
+	if c.saved == nil {
+		c.saved = make(map[string]checkpoint)
+	}
+	c.saved[name] = cp
+	c.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, cp.info(name))
+}
+
+// GET /admin/checkpoints
+func (s *Server) ListCheckpoints(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	c := &s.checkpoints
+	c.mu.Lock()
+	out := make([]checkpointInfo, 0, len(c.saved))
+	for name, cp := range c.saved {
+		out = append(out, cp.info(name))
+	}
+	c.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"checkpoints": out})
+}
+
+// POST /admin/reset
+//
+// Replaces the contents of the store with the checkpoint ?to=, or with
+// nothing if to is not given, and drops all edit locks. The checkpoint
+// is kept, so it can be restored again. Revisions keep counting up from
+// the current one: watchers see a reset event and the restored keys keep
+// the revisions they had when the checkpoint was saved.
+func (s *Server) ResetToCheckpoint(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	name := r.URL.Query().Get("to")
+	var cp checkpoint
+	if name != "" {
+		c := &s.checkpoints
+		c.mu.Lock()
+		var ok bool
+		cp, ok = c.saved[name]
+		c.mu.Unlock()
+		if !ok {
+			http.Error(w, "Checkpoint not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	rev, err := s.store.Reset(r.Context(), cp.records)
+	if err != nil {
+		storeFailed(w, "Reset failed: ", err)
+		return
+	}
+	s.locks.mu.Lock()
+	s.locks.locks = nil
+	s.locks.mu.Unlock()
+	s.audit(r, "reset", map[string]interface{}{"checkpoint": name, "keys": cp.keys, "revision": rev})
+
+	setRevision(w, rev)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"checkpoint": name, "keys": cp.keys, "revision": rev})
+}
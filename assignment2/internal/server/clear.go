@@ -0,0 +1,127 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clearTokenTTL is how long a confirmation token for DELETE /data stays
+// valid.
+const clearTokenTTL = time.Minute
+
+// clearTokens are the outstanding confirmation tokens of bulk clears,
+// each good for one clear of the prefix it was issued for.
+type clearTokens struct {
+	mu     sync.Mutex
+	tokens map[string]clearToken
+}
+
+type clearToken struct {
+	prefix  string
+	expires time.Time
+}
+
+func (t *clearTokens) issue(prefix string) string {
+	var raw [16]byte
+	rand.Read(raw[:])
+	token := hex.EncodeToString(raw[:])
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if t.tokens == nil {
+		t.tokens = make(map[string]clearToken)
+	}
+	for k, tok := range t.tokens {
+		if now.After(tok.expires) {
+			delete(t.tokens, k)
+		}
+	}
+	t.tokens[token] = clearToken{prefix: prefix, expires: now.Add(clearTokenTTL)}
+	return token
+}
+
+// redeem uses up token if it was issued for prefix and has not expired.
+func (t *clearTokens) redeem(token, prefix string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tok, ok := t.tokens[token]
+	if !ok || tok.prefix != prefix || time.Now().After(tok.expires) {
+		return false
+	}
+	delete(t.tokens, token)
+	return true
+}
+
+// DELETE /data?prefix=tmp:
+//
+// Deletes every key under prefix in one batch; an empty prefix clears the
+// caller's whole namespace. Only admins may clear, and only with an
+// X-Confirm: yes header or with ?confirm= and the single-use token that
+// an unconfirmed request is answered with (428). Every clear is written to
+// the audit log.
+func (s *Server) ClearData(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	q := r.URL.Query()
+	if !q.Has("prefix") {
+		http.Error(w, "prefix is required (use ?prefix= to clear everything)", http.StatusBadRequest)
+		return
+	}
+	dry, err := dryRun(r)
+	if err != nil {
+		http.Error(w, "Invalid dry_run", http.StatusBadRequest)
+		return
+	}
+	prefix := q.Get("prefix")
+	scoped := scopedKey(r, prefix)
+
+	confirmed := strings.EqualFold(r.Header.Get("X-Confirm"), "yes")
+	if !confirmed && !dry {
+		if token := q.Get("confirm"); token != "" {
+			if !s.clearTokens.redeem(token, scoped) {
+				http.Error(w, "Invalid or expired confirmation token", http.StatusForbidden)
+				return
+			}
+			confirmed = true
+		}
+	}
+
+	if dry || !confirmed {
+		n, err := s.store.CountPrefix(r.Context(), scoped)
+		if err != nil {
+			storeFailed(w, "Count failed: ", err)
+			return
+		}
+		out := map[string]interface{}{"prefix": prefix, "keys": n}
+		if dry {
+			out["dry_run"] = true
+			writeJSON(w, http.StatusOK, out)
+			return
+		}
+		out["confirm_token"] = s.clearTokens.issue(scoped)
+		out["expires_in"] = clearTokenTTL.String()
+		writeJSON(w, http.StatusPreconditionRequired, out)
+		return
+	}
+
+	n, rev, err := s.store.DeletePrefix(r.Context(), scoped)
+	if err != nil {
+		storeFailed(w, "Clear failed: ", err)
+		return
+	}
+	s.audit(r, "clear", map[string]interface{}{"prefix": scoped, "deleted": n, "revision": rev})
+
+	setRevision(w, rev)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"prefix":   prefix,
+		"deleted":  n,
+		"revision": rev,
+	})
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"assignment2/internal/cluster"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// StartDiscovery joins the configured peers and keeps membership fresh
+// until ctx is cancelled. It returns immediately when clustering is off.
+func (s *Server) StartDiscovery(ctx context.Context) {
+	if s.discovery == nil {
+		return
+	}
+	s.discovery.Run(ctx)
+}
+
+// POST /cluster/join
+func (s *Server) ClusterJoin(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	if s.members == nil {
+		http.Error(w, "Cluster mode disabled", http.StatusNotFound)
+		return
+	}
+
+	var req cluster.JoinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Addr == "" {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	s.members.Touch(req.Addr, "join")
+
+	// Reply with everyone we know (including ourselves) so the joiner
+	// learns the rest of the cluster in one round trip.
+	members := append(s.members.Addrs(), s.members.Self())
+	json.NewEncoder(w).Encode(cluster.JoinResponse{Members: members})
+}
+
+// GET /cluster/members
+func (s *Server) ClusterMembers(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	if s.members == nil {
+		http.Error(w, "Cluster mode disabled", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"self":    s.members.Self(),
+		"members": s.members.List(),
+	})
+}
+
+// GET /cluster/status
+//
+// The topology as this node sees it, for clients that route writes to
+// the lease holder and reads to replicating standbys.
+func (s *Server) ClusterStatus(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	self := advertiseAddr(s.cfg)
+	if s.elector != nil {
+		self = s.elector.Identity
+	}
+
+	role, leader := "standalone", self
+	if s.elector != nil {
+		leader = s.elector.Holder()
+		if s.elector.IsLeader() {
+			role = "leader"
+		} else {
+			role = "standby"
+		}
+	}
+
+	addrs := []string{self}
+	if s.members != nil {
+		addrs = append(addrs, s.members.Addrs()...)
+	}
+	members := make([]map[string]string, 0, len(addrs))
+	for _, addr := range addrs {
+		memberRole := "standby"
+		switch {
+		case s.elector == nil:
+			memberRole = "standalone"
+		case addr == leader:
+			memberRole = "leader"
+		}
+		members = append(members, map[string]string{"addr": addr, "role": memberRole})
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"self":          self,
+		"role":          role,
+		"leader":        leader,
+		"members":       members,
+		"replica_reads": s.elector != nil && s.cfg.StandbyReplicate,
+		"revision":      s.store.Revision(),
+	})
+}
+
+// defaultKeymapNodes is how many nodes GET /cluster/keymap lists per key
+// unless ?nodes= says otherwise.
+const defaultKeymapNodes = 3
+
+// GET /cluster/keymap
+//
+// Where ?key= is placed on the consistent-hash ring of this node and the
+// members it knows: its owner and, with ?nodes= (default 3), the next
+// distinct nodes on the ring. Every node holds every key, so the
+// placement is for clients that want each key read from the same nodes,
+// e.g. for cache locality; writes still go to the leader, which is
+// reported too.
+func (s *Server) ClusterKeymap(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "key is required", http.StatusBadRequest)
+		return
+	}
+	n := defaultKeymapNodes
+	if v := r.URL.Query().Get("nodes"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 {
+			http.Error(w, "Invalid nodes", http.StatusBadRequest)
+			return
+		}
+	}
+
+	self := advertiseAddr(s.cfg)
+	leader := self
+	if s.elector != nil {
+		self = s.elector.Identity
+		leader = s.elector.Holder()
+	}
+	addrs := []string{self}
+	if s.members != nil {
+		addrs = append(addrs, s.members.Addrs()...)
+	}
+	nodes := cluster.NewRing(addrs).Lookup(key, n)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":     key,
+		"hash":    strconv.FormatUint(cluster.Hash(key), 16),
+		"owner":   nodes[0],
+		"nodes":   nodes,
+		"leader":  leader,
+		"members": len(addrs),
+	})
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"assignment2/internal/events"
+	"sort"
+	"time"
+)
+
+// maxWatchCoalesce caps a watcher's ?coalesce= window.
+const maxWatchCoalesce = time.Minute
+
+// coalescedEvent is an event as sent to a coalescing watcher: Coalesced
+// is how many earlier changes of the key it replaces.
+type coalescedEvent struct {
+	events.Event
+	Coalesced int `json:"coalesced,omitempty"`
+}
+
+// watchCoalescer holds a watcher's events for a window and then hands
+// over only the latest one per key and type. Keeping the types apart
+// means a tags change does not hide the set before it, and in seq order
+// the last set or delete still decides whether the key exists.
+type watchCoalescer struct {
+	window  time.Duration
+	pending map[coalesceKey]*coalescedEvent
+}
+
+type coalesceKey struct {
+	key, typ string
+}
+
+func newWatchCoalescer(window time.Duration) *watchCoalescer {
+	return &watchCoalescer{window: window, pending: make(map[coalesceKey]*coalescedEvent)}
+}
+
+// add holds e and reports whether it started a new window.
+func (c *watchCoalescer) add(e events.Event) bool {
+	started := len(c.pending) == 0
+	k := coalesceKey{e.Key, e.Type}
+	if p, ok := c.pending[k]; ok {
+		p.Event = e
+		p.Coalesced++
+		return false
+	}
+	c.pending[k] = &coalescedEvent{Event: e}
+	return started
+}
+
+// flush returns the held events in sequence order, so that a watcher
+// resuming with since= after any of them misses none of the others.
+func (c *watchCoalescer) flush() []coalescedEvent {
+	out := make([]coalescedEvent, 0, len(c.pending))
+	for key, e := range c.pending {
+		out = append(out, *e)
+		delete(c.pending, key)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Seq < out[j].Seq })
+	return out
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"assignment2/internal/jobs"
+	"assignment2/internal/metrics"
+	"assignment2/internal/storage"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// walStats is storage.CompactionStats for /stats and POST /admin/compact.
+type walStats struct {
+	Size              int64      `json:"size"`
+	Written           int64      `json:"written"`
+	Compactions       int64      `json:"compactions"`
+	SnapshotSize      int64      `json:"snapshot_size"`
+	LastCompactionMs  float64    `json:"last_compaction_ms"`
+	TotalCompactionMs float64    `json:"total_compaction_ms"`
+	LastCompaction    *time.Time `json:"last_compaction,omitempty"`
+	ThrottledMs       float64    `json:"throttled_ms"`
+}
+
+func newWALStats(st storage.CompactionStats) walStats {
+	out := walStats{
+		Size:              st.Size,
+		Written:           st.Written,
+		Compactions:       st.Compactions,
+		SnapshotSize:      st.SnapshotSize,
+		LastCompactionMs:  float64(st.LastDuration.Microseconds()) / 1000,
+		TotalCompactionMs: float64(st.TotalDuration.Microseconds()) / 1000,
+		ThrottledMs:       float64(st.Throttled.Microseconds()) / 1000,
+	}
+	if !st.LastTime.IsZero() {
+		out.LastCompaction = &st.LastTime
+	}
+	return out
+}
+
+// compact compacts the write-ahead log and logs the outcome. A compaction
+// slowed down by -compact-bytes-per-sec keeps the watchdog informed.
+func (s *Server) compact(ctx context.Context) (storage.CompactionStats, error) {
+	before, _ := s.store.CompactionStats()
+	st, err := s.store.Compact(storage.WithProgress(ctx, func() { jobs.Heartbeat(ctx) }))
+	if err != nil {
+		log.Printf("[COMPACT] failed: %v\n", err)
+		return st, err
+	}
+	log.Printf("[COMPACT] log %d -> %d bytes (snapshot %d) in %s\n", before.Size, st.Size, st.SnapshotSize, st.LastDuration.Round(time.Millisecond))
+	return st, nil
+}
+
+// compactionJob compacts the log once it is larger than -compact-min-size
+// and has at least doubled since the last snapshot, so compaction work
+// stays proportional to what was written.
+func (s *Server) compactionJob(ctx context.Context) error {
+	if s.cfg.CompactMinSize == 0 {
+		return nil
+	}
+	st, _ := s.store.CompactionStats()
+	if st.Size < s.cfg.CompactMinSize<<20 || st.Size < 2*st.SnapshotSize {
+		return nil
+	}
+	_, err := s.compact(ctx)
+	return err
+}
+
+// POST /admin/compact
+//
+// Compacts the write-ahead log now and returns its stats.
+func (s *Server) CompactLog(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	st, err := s.compact(r.Context())
+	if err != nil {
+		storeFailed(w, "Compaction failed: ", err)
+		return
+	}
+	json.NewEncoder(w).Encode(newWALStats(st))
+}
+
+func (s *Server) collectWALMetrics() []metrics.Family {
+	st, _ := s.store.CompactionStats()
+	return []metrics.Family{
+		metrics.Single("kv_wal_size_bytes", "Size of the write-ahead log.", metrics.TypeGauge, float64(st.Size)),
+		metrics.Single("kv_wal_written_bytes_total", "Bytes appended to the write-ahead log.", metrics.TypeCounter, float64(st.Written)),
+		metrics.Single("kv_wal_snapshot_size_bytes", "Size of the snapshot written by the last compaction.", metrics.TypeGauge, float64(st.SnapshotSize)),
+		metrics.Single("kv_wal_compactions_total", "Write-ahead log compactions.", metrics.TypeCounter, float64(st.Compactions)),
+		metrics.Single("kv_wal_compaction_duration_seconds_total", "Time spent compacting the write-ahead log.", metrics.TypeCounter, st.TotalDuration.Seconds()),
+		metrics.Single("kv_wal_last_compaction_duration_seconds", "Duration of the last compaction.", metrics.TypeGauge, st.LastDuration.Seconds()),
+		metrics.Single("kv_wal_compaction_throttled_seconds_total", "Time compactions waited for -compact-bytes-per-sec.", metrics.TypeCounter, st.Throttled.Seconds()),
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"assignment2/internal/limit"
+	"assignment2/internal/metrics"
+	"log"
+	"net/http"
+	"sort"
+)
+
+func newLimiters(s *Server) map[string]*limit.Limiter {
+	limiters := make(map[string]*limit.Limiter, len(s.cfg.ConcurrencyLimits))
+	for route, max := range s.cfg.ConcurrencyLimits {
+		limiters[route] = limit.New(max, s.cfg.ConcurrencyWait)
+	}
+	return limiters
+}
+
+// limitConcurrency wraps the handler of route in its limiter, if one is
+// configured. Requests over the limit wait up to -concurrency-wait and
+// are then turned away with 503.
+func (s *Server) limitConcurrency(route string, next http.HandlerFunc) http.HandlerFunc {
+	l, ok := s.limiters[route]
+	if !ok {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !l.Acquire(r.Context()) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many concurrent requests", http.StatusServiceUnavailable)
+			return
+		}
+		defer l.Release()
+		next(w, r)
+	}
+}
+
+// checkLimitedRoutes warns about -concurrency-limits entries that name no
+// route, which would otherwise be silently ignored.
+func (s *Server) checkLimitedRoutes(routes map[string]bool) {
+	for route := range s.limiters {
+		if !routes[route] {
+			log.Printf("[LIMIT] -concurrency-limits: no route %q\n", route)
+		}
+	}
+}
+
+func (s *Server) concurrencyStats() map[string]limit.Snapshot {
+	out := make(map[string]limit.Snapshot, len(s.limiters))
+	for route, l := range s.limiters {
+		out[route] = l.Snapshot()
+	}
+	return out
+}
+
+func (s *Server) collectConcurrencyMetrics() []metrics.Family {
+	inFlight := metrics.Family{Name: "kv_concurrency_in_flight", Help: "Requests running per limited route.", Type: metrics.TypeGauge}
+	waiting := metrics.Family{Name: "kv_concurrency_waiting", Help: "Requests waiting for a slot per limited route.", Type: metrics.TypeGauge}
+	rejected := metrics.Family{Name: "kv_concurrency_rejected_total", Help: "Requests rejected by a route's concurrency limit.", Type: metrics.TypeCounter}
+
+	routes := make([]string, 0, len(s.limiters))
+	for route := range s.limiters {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	for _, route := range routes {
+		snap := s.limiters[route].Snapshot()
+		labels := []metrics.Label{{Name: "route", Value: route}}
+		inFlight.Samples = append(inFlight.Samples, metrics.Sample{Labels: labels, Value: float64(snap.InFlight)})
+		waiting.Samples = append(waiting.Samples, metrics.Sample{Labels: labels, Value: float64(snap.Waiting)})
+		rejected.Samples = append(rejected.Samples, metrics.Sample{Labels: labels, Value: float64(snap.Rejected)})
+	}
+	return []metrics.Family{inFlight, waiting, rejected}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	errNotFound           = errors.New("key not found")
+	errPreconditionFailed = errors.New("precondition failed")
+)
+
+// etag is a strong validator derived from the value's content.
+func etag(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+type deleteCondition struct {
+	ifMatch  []string
+	expected *string
+}
+
+func parseDeleteCondition(r *http.Request) (deleteCondition, error) {
+	var cond deleteCondition
+
+	if header := r.Header.Get("If-Match"); header != "" {
+		for _, tag := range strings.Split(header, ",") {
+			cond.ifMatch = append(cond.ifMatch, strings.TrimSpace(tag))
+		}
+	}
+
+	var body struct {
+		Expected *string `json:"expected"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		return cond, err
+	}
+	cond.expected = body.Expected
+
+	return cond, nil
+}
+
+// present reports whether the delete is conditional at all.
+func (c deleteCondition) present() bool {
+	return c.expected != nil || len(c.ifMatch) > 0
+}
+
+func (c deleteCondition) matches(current string) bool {
+	if c.expected != nil && *c.expected != current {
+		return false
+	}
+	if len(c.ifMatch) == 0 {
+		return true
+	}
+
+	tag := etag(current)
+	for _, candidate := range c.ifMatch {
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Outcomes of a conditional operation.
+const (
+	outcomeApplied  = "applied"
+	outcomeConflict = "conflict"
+	outcomeNotFound = "not_found"
+)
+
+// casOutcome is the answer to a conditional operation that was not
+// applied. A conflict carries the key's current state, so the client can
+// resolve it without reading the key again.
+type casOutcome struct {
+	Outcome string      `json:"outcome"`
+	Key     string      `json:"key"`
+	Current *casCurrent `json:"current,omitempty"`
+}
+
+type casCurrent struct {
+	Value    string `json:"value"`
+	Revision uint64 `json:"revision"`
+	ETag     string `json:"etag"`
+}
+
+// notFoundOutcome answers 404 for key.
+func notFoundOutcome(w http.ResponseWriter, key string) {
+	writeJSON(w, http.StatusNotFound, casOutcome{Outcome: outcomeNotFound, Key: key})
+}
+
+// conflictOutcome answers status with the current state of stored, the
+// scoped name of key; "current" is left out if the key has gone since.
+func (s *Server) conflictOutcome(w http.ResponseWriter, r *http.Request, status int, key, stored string) {
+	value, rev, ok, err := s.store.GetRevision(r.Context(), stored)
+	if err != nil {
+		storeFailed(w, "", err)
+		return
+	}
+	out := casOutcome{Outcome: outcomeConflict, Key: key}
+	if ok {
+		out.Current = &casCurrent{Value: value, Revision: rev, ETag: etag(value)}
+		w.Header().Set("ETag", out.Current.ETag)
+		setRevision(w, rev)
+	}
+	writeJSON(w, status, out)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"assignment2/internal/metrics"
+	"bytes"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+)
+
+// connStats counts connections as http.Server reports their states.
+type connStats struct {
+	open      atomic.Int64
+	accepted  atomic.Int64
+	closed    atomic.Int64
+	hijacked  atomic.Int64
+	tlsErrors atomic.Int64
+}
+
+// ConfigureHTTP applies the connection settings to hs and hooks up the
+// connection metrics.
+func (s *Server) ConfigureHTTP(hs *http.Server) {
+	hs.ReadHeaderTimeout = s.cfg.ReadHeaderTimeout
+	hs.IdleTimeout = s.cfg.IdleTimeout
+	hs.MaxHeaderBytes = s.cfg.MaxHeaderBytes
+	hs.SetKeepAlivesEnabled(s.cfg.KeepAlive)
+	hs.ConnState = s.trackConn
+	hs.ErrorLog = log.New(&httpErrorLog{conns: &s.conns}, "", log.LstdFlags)
+}
+
+func (s *Server) trackConn(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		s.conns.open.Add(1)
+		s.conns.accepted.Add(1)
+	case http.StateClosed:
+		s.conns.open.Add(-1)
+		s.conns.closed.Add(1)
+	case http.StateHijacked:
+		s.conns.open.Add(-1)
+		s.conns.hijacked.Add(1)
+	}
+}
+
+// httpErrorLog passes http.Server's error log through to stderr, counting
+// failed TLS handshakes on the way; the server reports them nowhere else.
+type httpErrorLog struct {
+	conns *connStats
+}
+
+var tlsHandshakeError = []byte("TLS handshake error")
+
+func (l *httpErrorLog) Write(p []byte) (int, error) {
+	if bytes.Contains(p, tlsHandshakeError) {
+		l.conns.tlsErrors.Add(1)
+	}
+	return os.Stderr.Write(p)
+}
+
+func (s *Server) collectConnMetrics() []metrics.Family {
+	return []metrics.Family{
+		metrics.Single("kv_connections_open", "Client connections currently open.", metrics.TypeGauge, float64(s.conns.open.Load())),
+		metrics.Single("kv_connections_accepted_total", "Client connections accepted.", metrics.TypeCounter, float64(s.conns.accepted.Load())),
+		metrics.Single("kv_connections_closed_total", "Client connections closed.", metrics.TypeCounter, float64(s.conns.closed.Load())),
+		metrics.Single("kv_connections_hijacked_total", "Client connections taken over by a handler.", metrics.TypeCounter, float64(s.conns.hijacked.Load())),
+		metrics.Single("kv_tls_handshake_errors_total", "Failed TLS handshakes.", metrics.TypeCounter, float64(s.conns.tlsErrors.Load())),
+	}
+}
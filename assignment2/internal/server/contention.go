@@ -0,0 +1,78 @@
+package server
+
+import (
+	"assignment2/internal/metrics"
+	"assignment2/internal/storage"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+const defaultContentionLimit = 20
+
+type prefixContention struct {
+	Prefix        string  `json:"prefix,omitempty"`
+	Writes        int64   `json:"writes"`
+	LockWaitMS    float64 `json:"lock_wait_ms"`
+	MaxLockWaitMS float64 `json:"max_lock_wait_ms"`
+	CASAttempts   int64   `json:"cas_attempts"`
+	CASConflicts  int64   `json:"cas_conflicts"`
+	ConflictRate  float64 `json:"conflict_rate"`
+}
+
+func newPrefixContention(p storage.PrefixContention) prefixContention {
+	return prefixContention{
+		Prefix:        p.Prefix,
+		Writes:        p.Writes,
+		LockWaitMS:    float64(p.LockWait.Microseconds()) / 1000,
+		MaxLockWaitMS: float64(p.MaxLockWait.Microseconds()) / 1000,
+		CASAttempts:   p.CASAttempts,
+		CASConflicts:  p.CASConflicts,
+		ConflictRate:  p.ConflictRate(),
+	}
+}
+
+// GET /stats/contention
+//
+// Lists the key prefixes with the most conditional write conflicts, then
+// the most time spent waiting for the store lock; ?limit= caps how many
+// (default 20). Prefixes are the first -contention-depth segments of the
+// keys.
+func (s *Server) ContentionStats(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	limit := defaultContentionLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	top, total, ok := s.store.Contention(limit)
+	if !ok {
+		http.Error(w, "Contention tracking is disabled", http.StatusNotFound)
+		return
+	}
+
+	prefixes := make([]prefixContention, len(top))
+	for i, p := range top {
+		prefixes[i] = newPrefixContention(p)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"depth":    s.cfg.ContentionDepth,
+		"prefixes": prefixes,
+		"total":    newPrefixContention(total),
+	})
+}
+
+func (s *Server) collectContentionMetrics() []metrics.Family {
+	_, total, _ := s.store.Contention(0)
+	return []metrics.Family{
+		metrics.Single("kv_store_lock_wait_seconds_total", "Time writes spent waiting for the store lock.", metrics.TypeCounter, total.LockWait.Seconds()),
+		metrics.Single("kv_cas_attempts_total", "Conditional writes.", metrics.TypeCounter, float64(total.CASAttempts)),
+		metrics.Single("kv_cas_conflicts_total", "Conditional writes that failed because the key had changed.", metrics.TypeCounter, float64(total.CASConflicts)),
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultContentionSeconds = 10
+	maxContentionSeconds     = 60
+
+	// While capturing, one in mutexProfileFraction contended locks is
+	// sampled, and blocking of blockProfileRate nanoseconds or more.
+	mutexProfileFraction = 10
+	blockProfileRate     = 10000
+)
+
+// contentionCapture allows one mutex and block profile capture at a time,
+// since they share the runtime's sampling settings.
+var contentionCapture sync.Mutex
+
+// GET /admin/debug/goroutines
+//
+// The stack of every goroutine as text, with its state and how long it
+// has been blocked, like an unrecovered panic prints them. With
+// ?group=true goroutines with identical stacks are counted together,
+// most common first, which makes leaks and pile-ups stand out.
+func (s *Server) DebugGoroutines(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	debug := 2
+	if v := r.URL.Query().Get("group"); v != "" {
+		group, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "Invalid group", http.StatusBadRequest)
+			return
+		}
+		if group {
+			debug = 1
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	pprof.Lookup("goroutine").WriteTo(w, debug)
+}
+
+// GET /admin/debug/mutex
+//
+// Samples lock contention and blocking (channels, selects, sync.Cond,
+// locks) for ?seconds= (default 10, at most 60), then answers with the
+// mutex and block profiles as text: the stacks that waited, with how
+// many times and for how long in total. Sampling is off otherwise, so it
+// costs nothing between captures; the counts include earlier captures.
+// A second capture while one runs gets 409.
+func (s *Server) DebugMutex(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	seconds := defaultContentionSeconds
+	if v := r.URL.Query().Get("seconds"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxContentionSeconds {
+			http.Error(w, fmt.Sprintf("Invalid seconds, expected 1 to %d", maxContentionSeconds), http.StatusBadRequest)
+			return
+		}
+		seconds = n
+	}
+	if !contentionCapture.TryLock() {
+		http.Error(w, "A capture is already running", http.StatusConflict)
+		return
+	}
+	defer contentionCapture.Unlock()
+
+	prev := runtime.SetMutexProfileFraction(mutexProfileFraction)
+	runtime.SetBlockProfileRate(blockProfileRate)
+	defer func() {
+		runtime.SetMutexProfileFraction(prev)
+		runtime.SetBlockProfileRate(0)
+	}()
+	select {
+	case <-time.After(time.Duration(seconds) * time.Second):
+	case <-r.Context().Done():
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	pprof.Lookup("mutex").WriteTo(w, 1)
+	fmt.Fprintln(w)
+	pprof.Lookup("block").WriteTo(w, 1)
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// errDryRun aborts a store update once a dry run has evaluated it, so
+// nothing is changed.
+var errDryRun = errors.New("dry run")
+
+// dryRun reports whether the request asks for ?dry_run=true: validate and
+// evaluate it, and report what would change instead of changing it.
+func dryRun(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("dry_run")
+	if v == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+// dryRunChange is one key a dry run would change.
+type dryRunChange struct {
+	Key string `json:"key"`
+	Op  string `json:"op"` // create, update or delete
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+type dryRunResponse struct {
+	DryRun    bool           `json:"dry_run"`
+	Changes   []dryRunChange `json:"changes"`
+	Unchanged int            `json:"unchanged"`
+}
+
+// planSet works out what setting entries, named as the client knows them,
+// would change, in key order.
+func (s *Server) planSet(ctx context.Context, scope string, entries map[string]string) (dryRunResponse, error) {
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	plan := dryRunResponse{DryRun: true, Changes: []dryRunChange{}}
+	for _, k := range keys {
+		old, exists, err := s.store.Get(ctx, scope+k)
+		if err != nil {
+			return plan, err
+		}
+		switch {
+		case !exists:
+			plan.Changes = append(plan.Changes, dryRunChange{Key: k, Op: "create", New: entries[k]})
+		case old != entries[k]:
+			plan.Changes = append(plan.Changes, dryRunChange{Key: k, Op: "update", Old: old, New: entries[k]})
+		default:
+			plan.Unchanged++
+		}
+	}
+	return plan, nil
+}
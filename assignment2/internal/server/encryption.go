@@ -0,0 +1,70 @@
+package server
+
+import (
+	"assignment2/internal/storage"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Headers describing a client-side encrypted write.
+const (
+	headerEncryptionKeyID     = "X-Encryption-Key-Id"
+	headerEncryptionAlgorithm = "X-Encryption-Algorithm"
+)
+
+// maxEncryptionField caps the length of each encryption header.
+const maxEncryptionField = 256
+
+// parseEncryption reads the encryption metadata of a write from its
+// headers; it is nil if the client did not encrypt the values. Both
+// headers are needed.
+func parseEncryption(r *http.Request) (*storage.Encryption, error) {
+	enc := storage.Encryption{
+		KeyID:     strings.TrimSpace(r.Header.Get(headerEncryptionKeyID)),
+		Algorithm: strings.TrimSpace(r.Header.Get(headerEncryptionAlgorithm)),
+	}
+	switch {
+	case enc.KeyID == "" && enc.Algorithm == "":
+		return nil, nil
+	case enc.KeyID == "" || enc.Algorithm == "":
+		return nil, errors.New("both " + headerEncryptionKeyID + " and " + headerEncryptionAlgorithm + " are required")
+	case len(enc.KeyID) > maxEncryptionField || len(enc.Algorithm) > maxEncryptionField:
+		return nil, errors.New("encryption headers are limited to 256 bytes")
+	}
+	return &enc, nil
+}
+
+// loadEncrypted finds the keys under scope whose values the client
+// encrypted, for listings that project fields out of values.
+func (s *Server) loadEncrypted(ctx context.Context, scope string, opts *listOptions) error {
+	if len(opts.fields) == 0 || opts.excludeValues {
+		return nil
+	}
+	encrypted, err := s.store.Encrypted(ctx)
+	if err != nil {
+		return err
+	}
+	opts.encrypted = make(map[string]bool, len(encrypted))
+	for k := range encrypted {
+		if name, ok := strings.CutPrefix(k, scope); ok {
+			opts.encrypted[name] = true
+		}
+	}
+	return nil
+}
+
+// plaintextOnly refuses, with 409, to let the server rewrite a value the
+// client encrypted (patches, appends, scripts), since it cannot read it.
+func (s *Server) plaintextOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry, ok, err := s.store.GetEntry(r.Context(), scopedKey(r, r.PathValue("key")))
+		if err == nil && ok && entry.Encryption != nil {
+			s.IncrementRequests()
+			http.Error(w, "Value is encrypted by the client; only a new value can replace it", http.StatusConflict)
+			return
+		}
+		next(w, r)
+	}
+}
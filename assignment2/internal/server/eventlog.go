@@ -0,0 +1,79 @@
+package server
+
+import (
+	"assignment2/internal/events"
+	"assignment2/internal/rotate"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+)
+
+// eventLogBuffer is how many events can be queued for the event log
+// before writers to the store wait for it to catch up.
+const eventLogBuffer = 4096
+
+// eventLog appends mutation events as NDJSON, in the format of GET /watch,
+// to a rotated file that batch systems can tail. Events are written by
+// their own goroutine so file I/O stays out of the store lock; none are
+// dropped.
+type eventLog struct {
+	out      *rotate.Writer
+	prefixes []string
+	queue    chan events.Event
+	done     chan struct{}
+}
+
+func newEventLog(out *rotate.Writer, prefixes []string) *eventLog {
+	l := &eventLog{
+		out:      out,
+		prefixes: prefixes,
+		queue:    make(chan events.Event, eventLogBuffer),
+		done:     make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// add queues e if it passes the prefix filter. Resets always pass, so a
+// reader knows to start over.
+func (l *eventLog) add(e events.Event) {
+	if e.Type != events.TypeReset && !l.matches(e.Key) {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	l.queue <- e
+}
+
+func (l *eventLog) matches(key string) bool {
+	if len(l.prefixes) == 0 {
+		return true
+	}
+	for _, p := range l.prefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *eventLog) run() {
+	defer close(l.done)
+
+	for e := range l.queue {
+		line, _ := json.Marshal(e)
+		if _, err := l.out.Write(append(line, '\n')); err != nil {
+			log.Printf("[EVENTLOG] write: %v\n", err)
+		}
+	}
+}
+
+// Close writes the queued events and closes the file. The store must no
+// longer be written to.
+func (l *eventLog) Close() error {
+	close(l.queue)
+	<-l.done
+	return l.out.Close()
+}
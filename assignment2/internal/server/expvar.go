@@ -0,0 +1,77 @@
+package server
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+)
+
+// expvars are the server's own variables on GET /debug/vars. They are
+// not published in the process-wide expvar registry, which takes each
+// name only once, so that every Server serves its own.
+func (s *Server) expvars() []expvar.KeyValue {
+	vars := []expvar.KeyValue{
+		{Key: "kv", Value: expvar.Func(func() interface{} {
+			req, size, uptime := s.Stats()
+			return map[string]interface{}{
+				"requests":       req,
+				"keys":           size,
+				"revision":       s.store.Revision(),
+				"expiring_keys":  s.store.Expiring(),
+				"expired":        s.expired.Load(),
+				"uptime_seconds": uptime,
+			}
+		})},
+		{Key: "kv_jobs", Value: expvar.Func(func() interface{} {
+			list := make(map[string]interface{})
+			for _, st := range s.jobs.Stats() {
+				list[st.Name] = jobInfo(st)
+			}
+			return map[string]interface{}{"jobs": list, "throttle": s.throttleState()}
+		})},
+		{Key: "kv_watch", Value: expvar.Func(func() interface{} {
+			return s.events.Stats()
+		})},
+	}
+	if s.cfg.DataDir != "" {
+		vars = append(vars, expvar.KeyValue{Key: "kv_wal", Value: expvar.Func(func() interface{} {
+			st, _ := s.store.CompactionStats()
+			return map[string]interface{}{
+				"size_bytes":              st.Size,
+				"written_bytes":           st.Written,
+				"snapshot_size_bytes":     st.SnapshotSize,
+				"compactions":             st.Compactions,
+				"last_compaction_ms":      float64(st.LastDuration.Microseconds()) / 1000,
+				"total_compaction_ms":     float64(st.TotalDuration.Microseconds()) / 1000,
+				"compaction_throttled_ms": float64(st.Throttled.Microseconds()) / 1000,
+			}
+		})})
+	}
+	return vars
+}
+
+// GET /debug/vars
+//
+// Serves what expvar's own handler would, so that expvar collectors and
+// tools such as expvarmon can read the server: the process-wide variables
+// (cmdline and memstats) followed by kv, kv_jobs, kv_watch and, with a
+// data directory, kv_wal.
+func (s *Server) DebugVars(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n")
+	first := true
+	write := func(name string, v expvar.Var) {
+		if !first {
+			fmt.Fprintf(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", name, v)
+	}
+	expvar.Do(func(kv expvar.KeyValue) {
+		write(kv.Key, kv.Value)
+	})
+	for _, kv := range s.expvars() {
+		write(kv.Key, kv.Value)
+	}
+	fmt.Fprintf(w, "\n}\n")
+}
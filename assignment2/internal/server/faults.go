@@ -0,0 +1,129 @@
+package server
+
+import (
+	"assignment2/internal/config"
+	"encoding/json"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// allRoutes is the -fault-injection route that applies to every client
+// route without an entry of its own. Health checks, metrics, admin and
+// cluster routes are only affected when named.
+const allRoutes = "*"
+
+func clientRoute(route string) bool {
+	_, path, _ := strings.Cut(route, " ")
+	for _, prefix := range []string{"/admin/", "/cluster/", "/metrics", "/healthz", "/readyz"} {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// injectFaults wraps the handler of route with the faults configured for
+// it, so that client teams can exercise their timeouts and retries in
+// staging: added latency, error responses and connections closed
+// without an answer. Each is rolled independently per request; a
+// request that is delayed can still fail afterwards.
+func (s *Server) injectFaults(route string, next http.HandlerFunc) http.HandlerFunc {
+	spec, ok := s.cfg.Faults[route]
+	if !ok && clientRoute(route) {
+		spec, ok = s.cfg.Faults[allRoutes]
+	}
+	if !ok {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if roll(spec.DropRate) {
+			s.faultsInjected.Inc(route, "drop")
+			dropConnection(w)
+			return
+		}
+		if roll(spec.LatencyRate) {
+			s.faultsInjected.Inc(route, "latency")
+			t := time.NewTimer(spec.Latency)
+			select {
+			case <-t.C:
+			case <-r.Context().Done():
+				t.Stop()
+				return
+			}
+		}
+		if roll(spec.ErrorRate) {
+			s.faultsInjected.Inc(route, "error")
+			s.IncrementRequests()
+			w.Header().Set("X-Fault-Injected", "error")
+			http.Error(w, "Injected fault", spec.ErrorStatus)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func roll(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// dropConnection closes the client connection without a response. Where
+// the connection can't be taken over (HTTP/2), the stream is aborted
+// instead.
+func dropConnection(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	conn.Close()
+}
+
+// checkFaultRoutes warns that faults are being injected at all, and about
+// -fault-injection entries that name no route.
+func (s *Server) checkFaultRoutes(routes map[string]bool) {
+	if len(s.cfg.Faults) == 0 {
+		return
+	}
+	log.Printf("[WARN] fault injection is enabled for %d route(s); do not use in production\n", len(s.cfg.Faults))
+	for route := range s.cfg.Faults {
+		if route != allRoutes && !routes[route] {
+			log.Printf("[WARN] -fault-injection: no route %q\n", route)
+		}
+	}
+}
+
+type faultRule struct {
+	Route       string  `json:"route"`
+	Latency     string  `json:"latency,omitempty"`
+	LatencyRate float64 `json:"latency_rate,omitempty"`
+	ErrorStatus int     `json:"error_status,omitempty"`
+	ErrorRate   float64 `json:"error_rate,omitempty"`
+	DropRate    float64 `json:"drop_rate,omitempty"`
+}
+
+func newFaultRule(route string, spec config.FaultSpec) faultRule {
+	rule := faultRule{Route: route, LatencyRate: spec.LatencyRate, ErrorRate: spec.ErrorRate, DropRate: spec.DropRate}
+	if spec.Latency > 0 {
+		rule.Latency = spec.Latency.String()
+	}
+	if spec.ErrorRate > 0 {
+		rule.ErrorStatus = spec.ErrorStatus
+	}
+	return rule
+}
+
+// GET /admin/faults
+func (s *Server) GetFaults(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	rules := make([]faultRule, 0, len(s.cfg.Faults))
+	for route, spec := range s.cfg.Faults {
+		rules = append(rules, newFaultRule(route, spec))
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Route < rules[j].Route })
+	json.NewEncoder(w).Encode(map[string]interface{}{"faults": rules})
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"assignment2/internal/script"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// filterVars are the variables of a ?filter= expression.
+var filterVars = []string{"key", "value", "revision", "updated_at", "created_at", "tags"}
+
+// filterTimeLayout has a fixed width, so that times compare as strings,
+// also with dates like "2024-01-01".
+const filterTimeLayout = "2006-01-02T15:04:05.000Z"
+
+// filterCheckEvery is how many keys a filter evaluates between checks of
+// the request context.
+const filterCheckEvery = 1024
+
+// errFilterTooWide refuses to evaluate a filter on more than
+// -filter-max-keys keys.
+var errFilterTooWide = errors.New("filter too wide")
+
+func parseFilter(src string) (*script.Expr, error) {
+	f, err := script.CompileExpr(src, filterVars...)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid filter: %v", err)
+	}
+	return f, nil
+}
+
+// filterKeys returns those of the store keys, in scope, whose entries
+// match f. Values the client encrypted read as null.
+func (s *Server) filterKeys(ctx context.Context, scope string, f *script.Expr, keys []string) ([]string, error) {
+	if len(keys) > s.cfg.FilterMaxKeys {
+		return nil, errFilterTooWide
+	}
+
+	vars := make(map[string]interface{}, len(filterVars))
+	matching := keys[:0:0]
+	for i, k := range keys {
+		if i%filterCheckEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		info, ok, err := s.store.GetMeta(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		vars["key"] = strings.TrimPrefix(k, scope)
+		vars["value"] = nil
+		if f.Uses("value") && info.Encryption == nil {
+			vars["value"] = filterValue(info.Value)
+		}
+		vars["revision"] = float64(info.Revision)
+		vars["updated_at"] = info.Modified.UTC().Format(filterTimeLayout)
+		vars["created_at"] = info.Created.UTC().Format(filterTimeLayout)
+		tags := make([]interface{}, len(info.Tags))
+		for i, t := range info.Tags {
+			tags[i] = t
+		}
+		vars["tags"] = tags
+
+		if f.Match(vars) {
+			matching = append(matching, k)
+		}
+	}
+	return matching, nil
+}
+
+// filterEntries removes the entries, named as the client names them,
+// that don't match f.
+func (s *Server) filterEntries(ctx context.Context, scope string, f *script.Expr, entries map[string]string) error {
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, scope+k)
+	}
+	matching, err := s.filterKeys(ctx, scope, f, keys)
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]bool, len(matching))
+	for _, k := range matching {
+		keep[strings.TrimPrefix(k, scope)] = true
+	}
+	for k := range entries {
+		if !keep[k] {
+			delete(entries, k)
+		}
+	}
+	return nil
+}
+
+// filterValue is a value as a filter sees it: parsed if it is JSON, the
+// string itself otherwise.
+func filterValue(value string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return value
+	}
+	return v
+}
+
+func (s *Server) filterFailed(w http.ResponseWriter, err error) {
+	if errors.Is(err, errFilterTooWide) {
+		http.Error(w, fmt.Sprintf("Filter would be evaluated on more than %d keys (-filter-max-keys); narrow the listing with prefix= or tag=", s.cfg.FilterMaxKeys), http.StatusBadRequest)
+		return
+	}
+	storeFailed(w, "Failed to read: ", err)
+}
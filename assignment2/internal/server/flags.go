@@ -0,0 +1,84 @@
+package server
+
+import (
+	"assignment2/internal/flags"
+	"context"
+	"net/http"
+	"strings"
+)
+
+type flagsResponse struct {
+	User     string                  `json:"user,omitempty"`
+	Revision uint64                  `json:"revision"`
+	Flags    map[string]flags.Result `json:"flags"`
+}
+
+// validateFlag is the write validator -flag-prefix installs: values
+// under the prefix must be flag definitions.
+func (s *Server) validateFlag(ctx context.Context, key, value string) error {
+	if !strings.HasPrefix(key, s.cfg.FlagPrefix) {
+		return nil
+	}
+	_, err := flags.Parse(value)
+	return err
+}
+
+// GET /flags/evaluate
+//
+// Evaluates the feature flags under -flag-prefix for ?user=, or only
+// those named by ?flag= (repeatable). Every other query parameter is an
+// attribute for targeting rules, e.g. ?user=42&country=KZ. Flags are
+// named without the prefix; a named flag that does not exist is off with
+// reason "missing", and one that does not parse is off with reason
+// "invalid".
+func (s *Server) EvaluateFlags(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	query := r.URL.Query()
+	attrs := make(map[string]string, len(query))
+	for k, v := range query {
+		if k != "flag" && len(v) > 0 {
+			attrs[k] = v[0]
+		}
+	}
+
+	prefix := scopedKey(r, s.cfg.FlagPrefix)
+	names := query["flag"]
+	var keys []string
+	rev := s.store.Revision()
+	if len(names) == 0 {
+		var err error
+		if keys, rev, err = s.store.ScanKeys(r.Context(), prefix, 0); err != nil {
+			storeFailed(w, "Failed to read flags: ", err)
+			return
+		}
+	} else {
+		for _, name := range names {
+			keys = append(keys, prefix+name)
+		}
+	}
+	values, err := s.store.GetMany(r.Context(), keys)
+	if err != nil {
+		storeFailed(w, "Failed to read flags: ", err)
+		return
+	}
+
+	results := make(map[string]flags.Result, len(keys))
+	for _, key := range keys {
+		name := key[len(prefix):]
+		value, ok := values[key]
+		if !ok {
+			results[name] = flags.Result{Reason: flags.ReasonMissing}
+			continue
+		}
+		f, err := flags.Parse(value)
+		if err != nil {
+			results[name] = flags.Result{Reason: flags.ReasonInvalid, Error: err.Error()}
+			continue
+		}
+		results[name] = f.Evaluate(name, attrs)
+	}
+
+	setRevision(w, rev)
+	writeJSON(w, http.StatusOK, flagsResponse{User: attrs["user"], Revision: rev, Flags: results})
+}
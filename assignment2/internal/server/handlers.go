@@ -1,35 +1,352 @@
 package server
 
 import (
+	"assignment2/internal/auth"
+	"assignment2/internal/events"
+	"assignment2/internal/storage"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// statusClientClosedRequest is the (nginx) status for a request whose
+// client went away before it was answered. Nobody reads it, but it keeps
+// abandoned requests apart from real failures in the access log.
+const statusClientClosedRequest = 499
+
+// storeFailed answers a request whose store operation failed: 499 if the
+// client cancelled it, 503 if its deadline passed or the write-ahead log
+// can't be written, and 500 with msg otherwise.
+func storeFailed(w http.ResponseWriter, msg string, err error) {
+	switch {
+	case errors.Is(err, storage.ErrUnavailable):
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Persistence unavailable", http.StatusServiceUnavailable)
+	case errors.Is(err, context.Canceled):
+		http.Error(w, "Request cancelled", statusClientClosedRequest)
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, "Request timed out", http.StatusServiceUnavailable)
+	default:
+		http.Error(w, msg+err.Error(), http.StatusInternalServerError)
+	}
+}
+
 // POST /data
+//
+// With ?ttl=<duration> the keys expire after that long; otherwise keys
+// that are overwritten lose any time to live they had. With
+// ?dry_run=true nothing is stored; the response lists the keys that
+// would be created or updated. Values the client encrypted itself are
+// marked with X-Encryption-Key-Id and X-Encryption-Algorithm, which are
+// returned with them on reads. If any key is locked by someone other
+// than X-Lock-Owner, nothing is stored and the answer is 423. An API key
+// with scopes must be allowed to write every key, or nothing is stored
+// and the answer is 403; so is a key under -session-prefix, with
+// -session-ttl.
 func (s *Server) PostData(w http.ResponseWriter, r *http.Request) {
 	s.IncrementRequests()
 
+	preview, err := dryRun(r)
+	if err != nil {
+		http.Error(w, "Invalid dry_run", http.StatusBadRequest)
+		return
+	}
+
+	ttl, err := parseTTL(r)
+	if err != nil {
+		http.Error(w, "Invalid ttl", http.StatusBadRequest)
+		return
+	}
+	enc, err := parseEncryption(r)
+	if err != nil {
+		http.Error(w, "Invalid encryption headers: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	var payload map[string]string
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+	if err := readJSON(r.Body, &payload); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+	for k := range payload {
+		if !allowKey(r, auth.ScopeWrite, k) {
+			http.Error(w, "Forbidden: API key scopes do not allow write of "+k, http.StatusForbidden)
+			return
+		}
+		if s.isSessionKey(k) {
+			http.Error(w, "Forbidden: "+k+" is a session, written through /sessions", http.StatusForbidden)
+			return
+		}
+	}
+
+	if err := s.validateEntries(r.Context(), payload); err != nil {
+		writeRejected(w, err)
+		return
+	}
+	if s.checkEntryLocks(w, r, payload) {
+		return
+	}
 
-	for k, v := range payload {
-		s.store.Set(k, v)
+	scope := tenantScope(r)
+	if preview {
+		plan, err := s.planSet(r.Context(), scope, payload)
+		if err != nil {
+			storeFailed(w, "Failed to read: ", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, plan)
+		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"status": "stored"})
+	var rev uint64
+	if enc != nil {
+		rev, err = s.store.SetManyEncrypted(r.Context(), scopeEntries(scope, payload), ttl, *enc)
+	} else {
+		rev, err = s.store.SetManyTTL(r.Context(), scopeEntries(scope, payload), ttl)
+	}
+	if err != nil {
+		storeFailed(w, "Failed to persist: ", err)
+		return
+	}
+
+	resp := storedResponse{Revision: rev, Status: "stored"}
+	if ttl > 0 {
+		expires := time.Now().Add(ttl).Round(0)
+		resp.ExpiresAt = &expires
+	}
+	setRevision(w, rev)
+	writeJSON(w, http.StatusCreated, resp)
 }
 
 // GET /data
+//
+// ?prefix=p restricts the result to keys starting with p and
+// ?tag=name:value (repeatable) to keys carrying all of the given tags,
+// ?fields=a,b reduces JSON object values, except those the client
+// encrypted, to those fields and
+// ?exclude_values=true returns only the key names.
+//
+// ?sort=key|updated_at and ?order=asc|desc list the entries in that
+// order, walking the store's ordered indexes rather than sorting.
+//
+// ?filter= keeps the entries an expression in the script language
+// matches, over key, value (parsed if JSON, null if encrypted), revision,
+// updated_at, created_at and tags, on at most -filter-max-keys keys.
+//
+// ?min_revision=N returns only keys changed at or after revision N. The
+// X-Revision header carries the store revision the listing reflects, so
+// passing it plus one next time fetches just what changed since. Deleted
+// keys are not in the listing; with ?include_deleted=true it becomes
+// {"entries":...,"deleted":[...]}, the keys deleted since N taken from
+// the watch history, or 410 once that no longer reaches back to N.
+//
+// An API key with scopes only sees the keys it may read.
+//
+// Responses are cached per query until the next write, and carry an Age
+// header when served from that cache. With
+// ?format=ndjson or Accept: application/x-ndjson the listing is streamed
+// instead, see streamData.
 func (s *Server) GetData(w http.ResponseWriter, r *http.Request) {
 	s.IncrementRequests()
-	json.NewEncoder(w).Encode(s.store.GetAll())
+
+	opts, err := parseListOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if wantsStream(r) {
+		if opts.includeDeleted {
+			http.Error(w, "include_deleted cannot be streamed", http.StatusBadRequest)
+			return
+		}
+		s.streamData(w, r, opts)
+		return
+	}
+
+	scope := tenantScope(r)
+	query := scope + "?" + r.URL.Query().Encode()
+	if scopes := RequestInfoFrom(r.Context()).Scopes; len(scopes) > 0 {
+		query = scopes.String() + " " + query
+	}
+	if s.listCache != nil {
+		rev := s.store.Revision()
+		if body, rendered, ok := s.listCache.get(query, rev); ok {
+			s.setCacheControl(w, r, opts.prefix)
+			setAge(w, rendered)
+			setRevision(w, rev)
+			writeJSONBytes(w, http.StatusOK, body)
+			return
+		}
+	}
+
+	var (
+		entries map[string]string
+		keys    []string
+		rev     uint64
+	)
+	if opts.order != nil {
+		keys, rev, err = s.store.ScanOrdered(r.Context(), scope+opts.prefix, opts.minRevision, *opts.order)
+		if err == nil {
+			entries, err = s.store.GetMany(r.Context(), keys)
+		}
+	} else {
+		entries, rev, err = s.store.GetSince(r.Context(), opts.minRevision)
+	}
+	if err != nil {
+		storeFailed(w, "Failed to read: ", err)
+		return
+	}
+	if prefix := scope + opts.prefix; prefix != "" {
+		for k := range entries {
+			if !strings.HasPrefix(k, prefix) {
+				delete(entries, k)
+			}
+		}
+	}
+	var deleted []string
+	if opts.includeDeleted {
+		deleted, err = s.deletedSince(opts.minRevision, rev, scope+opts.prefix, entries)
+		if err == events.ErrTooOld {
+			http.Error(w, "Deletes since min_revision are no longer available; list without it", http.StatusGone)
+			return
+		}
+		if err != nil {
+			storeFailed(w, "Failed to read: ", err)
+			return
+		}
+	}
+	if len(opts.tags) > 0 {
+		tagged, err := s.store.GetTagged(r.Context(), opts.tags)
+		if err != nil {
+			storeFailed(w, "Failed to read: ", err)
+			return
+		}
+		for k := range entries {
+			if _, ok := tagged[k]; !ok {
+				delete(entries, k)
+			}
+		}
+	}
+
+	// Expiring keys leave the listing without a new revision, so the
+	// cached copy must not outlive the first of them.
+	var expires time.Time
+	if s.listCache != nil {
+		listed := make([]string, 0, len(entries))
+		for k := range entries {
+			listed = append(listed, k)
+		}
+		expires, _ = s.store.NextDeadline(listed)
+	}
+
+	entries = unscopeEntries(scope, entries)
+	for k := range entries {
+		if !allowKey(r, auth.ScopeRead, k) {
+			delete(entries, k)
+		}
+	}
+	if opts.filter != nil {
+		if err := s.filterEntries(r.Context(), scope, opts.filter, entries); err != nil {
+			s.filterFailed(w, err)
+			return
+		}
+	}
+	for i, k := range keys {
+		keys[i] = strings.TrimPrefix(k, scope)
+	}
+	visible := deleted[:0]
+	for _, k := range deleted {
+		if k = strings.TrimPrefix(k, scope); allowKey(r, auth.ScopeRead, k) {
+			visible = append(visible, k)
+		}
+	}
+	deleted = visible
+	if err := s.loadEncrypted(r.Context(), scope, &opts); err != nil {
+		storeFailed(w, "Failed to read: ", err)
+		return
+	}
+
+	listing := opts.render(keys, entries)
+	if opts.includeDeleted {
+		listing = deletedListing{Entries: listing, Deleted: deleted}
+	}
+
+	s.setCacheControl(w, r, opts.prefix)
+	setRevision(w, rev)
+	if s.listCache == nil {
+		writeJSON(w, http.StatusOK, listing)
+		return
+	}
+
+	body, err := json.Marshal(listing)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	body = append(body, '\n')
+	s.listCache.put(query, rev, body, expires)
+	writeJSONBytes(w, http.StatusOK, body)
+}
+
+// GET /data/{key}
+//
+// Keys with a time to live report it as expires_at. A key moved away by
+// an -archive policy gets a 404 pointing to its archive, or is restored
+// and served with -archive-reads=rehydrate.
+//
+// Answers carry ETag, Last-Modified and a Cache-Control from
+// -cache-max-age, and are 304 Not Modified for a matching If-None-Match
+// or If-Modified-Since.
+func (s *Server) GetKey(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	key := r.PathValue("key")
+	stored := scopedKey(r, key)
+	s.noteRead(stored)
+	entry, ok, err := s.store.GetEntry(r.Context(), stored)
+	if err == nil && !ok && s.archive != nil {
+		if s.serveArchived(w, r, key, stored) {
+			return
+		}
+		entry, ok, err = s.store.GetEntry(r.Context(), stored)
+	}
+	if err != nil {
+		storeFailed(w, "Failed to read: ", err)
+		return
+	}
+	if !ok {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	if s.setCacheHeaders(w, r, key, etag(entry.Value), entry.Revision, entry.Modified) {
+		return
+	}
+	writeEntry(w, key, entry)
+}
+
+// writeEntry answers with key's entry, as GET /data/{key} does.
+func writeEntry(w http.ResponseWriter, key string, entry storage.Entry) {
+	resp := keyResponse{Key: key, Revision: entry.Revision, Value: entry.Value, Encryption: entry.Encryption}
+	if !entry.ExpiresAt.IsZero() {
+		resp.ExpiresAt = &entry.ExpiresAt
+	}
+	w.Header().Set("ETag", etag(entry.Value))
+	setRevision(w, entry.Revision)
+	writeJSON(w, http.StatusOK, resp)
 }
 
 // DELETE /data/{key}
+//
+// The delete can be made conditional with an If-Match header (ETags as
+// returned by GET /data/{key}) and/or a body {"expected": "value"}; it
+// then only happens if the current value still matches. The answer's
+// "outcome" is applied, conflict (412, with the current value, revision
+// and ETag) or not_found (404). With ?dry_run=true the conditions are
+// evaluated but the key is kept.
 func (s *Server) DeleteData(w http.ResponseWriter, r *http.Request) {
 	s.IncrementRequests()
 
@@ -39,12 +356,58 @@ func (s *Server) DeleteData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !s.store.Delete(key) {
-		http.Error(w, "Key not found", http.StatusNotFound)
+	preview, err := dryRun(r)
+	if err != nil {
+		http.Error(w, "Invalid dry_run", http.StatusBadRequest)
+		return
+	}
+
+	cond, err := parseDeleteCondition(r)
+	if err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	stored := scopedKey(r, key)
+	rev, err := s.store.Update(r.Context(), stored, func(old string, exists bool) (string, bool, error) {
+		if !exists {
+			return "", false, errNotFound
+		}
+		if !cond.matches(old) {
+			return old, true, errPreconditionFailed
+		}
+		if preview {
+			return old, true, errDryRun
+		}
+		return "", false, nil
+	})
+	if cond.present() && err != errNotFound {
+		s.store.RecordCAS(stored, err == errPreconditionFailed)
+	}
+
+	switch err {
+	case nil:
+	case errDryRun:
+		json.NewEncoder(w).Encode(map[string]interface{}{"dry_run": true, "deleted": key})
+		return
+	case errNotFound:
+		notFoundOutcome(w, key)
+		return
+	case errPreconditionFailed:
+		s.conflictOutcome(w, r, http.StatusPreconditionFailed, key, stored)
+		return
+	default:
+		storeFailed(w, "", err)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{"deleted": key})
+	setRevision(w, rev)
+	json.NewEncoder(w).Encode(map[string]interface{}{"outcome": outcomeApplied, "deleted": key, "revision": rev})
+}
+
+// setRevision reports a store revision in the X-Revision header.
+func setRevision(w http.ResponseWriter, rev uint64) {
+	w.Header().Set("X-Revision", strconv.FormatUint(rev, 10))
 }
 
 // GET /stats
@@ -52,9 +415,56 @@ func (s *Server) StatsHandler(w http.ResponseWriter, r *http.Request) {
 	s.IncrementRequests()
 
 	req, size, uptime := s.Stats()
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	stats := map[string]interface{}{
 		"total_requests": req,
 		"database_size":  size,
 		"uptime_seconds": uptime,
-	})
+		"revision":       s.store.Revision(),
+	}
+	if dedup, ok := s.store.DedupStats(); ok {
+		stats["dedup"] = dedup
+	}
+	if breakers := s.breakerStats(); len(breakers) > 0 {
+		stats["upstreams"] = breakers
+	}
+	if s.listCache != nil {
+		stats["list_cache"] = s.listCache.stats()
+	}
+	stats["watch"] = s.events.Stats()
+	if wal, ok := s.store.CompactionStats(); ok {
+		stats["wal"] = newWALStats(wal)
+	}
+	if health, ok := s.walHealth(); ok {
+		stats["persistence"] = health
+	}
+	if s.cfg.MemoryLimit > 0 {
+		stats["memory"] = s.memoryStats()
+	}
+	if tier, ok := s.store.TierStats(); ok {
+		stats["tier"] = tier
+	}
+	if limits := s.concurrencyStats(); len(limits) > 0 {
+		stats["concurrency"] = limits
+	}
+	if s.lanes != nil {
+		stats["priority"] = s.lanes.Snapshot()
+	}
+	if s.archive != nil {
+		stats["archive"] = s.archiveStats()
+	}
+	if s.shipping.log != nil || s.cfg.ShipFrom != "" {
+		stats["shipping"] = s.shippingStats()
+	}
+	if s.tenants != nil {
+		if tenants, err := s.tenantStats(r.Context()); err == nil {
+			stats["tenants"] = tenants
+		}
+	}
+	if s.cfg.TenantQuota > 0 {
+		stats["quotas"] = s.quotaStats()
+	}
+	if len(s.secondaries) > 0 {
+		stats["secondaries"] = s.secondaryStats()
+	}
+	json.NewEncoder(w).Encode(stats)
 }
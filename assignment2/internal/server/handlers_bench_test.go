@@ -0,0 +1,58 @@
+package server
+
+import (
+	"assignment2/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newBenchServer(b *testing.B) http.Handler {
+	b.Helper()
+	cfg, err := config.Load(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	s, err := NewServer(cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { s.Close() })
+	return s.Routes()
+}
+
+func BenchmarkGetKey(b *testing.B) {
+	h := newBenchServer(b)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/data", strings.NewReader(`{"bench":"value"}`)))
+	if rec.Code != http.StatusCreated {
+		b.Fatalf("POST /data: %d %s", rec.Code, rec.Body)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/data/bench", nil))
+		if rec.Code != http.StatusOK {
+			b.Fatalf("GET /data/bench: %d %s", rec.Code, rec.Body)
+		}
+	}
+}
+
+func BenchmarkPostData(b *testing.B) {
+	h := newBenchServer(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		body := `{"bench` + strconv.Itoa(i%1024) + `":"value"}`
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/data", strings.NewReader(body)))
+		if rec.Code != http.StatusCreated {
+			b.Fatalf("POST /data: %d %s", rec.Code, rec.Body)
+		}
+	}
+}
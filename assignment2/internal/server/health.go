@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// GET /healthz
+func (s *Server) Healthz(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// GET /readyz
+//
+// While the write-ahead log can't be written the status is "degraded":
+// 503 once writes are refused, so that load balancers send traffic
+// elsewhere, and 200 while -wal-failure-policy=buffer still takes them.
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	s.maintenance.mu.Lock()
+	maintenance := s.maintenance.enabled
+	s.maintenance.mu.Unlock()
+
+	resp := map[string]interface{}{
+		"status":      "ready",
+		"maintenance": maintenance,
+	}
+	status := http.StatusOK
+	if health, ok := s.walHealth(); ok && health.Degraded {
+		resp["status"] = "degraded"
+		resp["persistence"] = health
+		if !health.Writable {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, resp)
+}
+
+// GET /healthz/jobs
+//
+// 503 while any background job has stalled or stopped, so that it can be
+// used as a liveness probe for the background work.
+func (s *Server) JobsHealth(w http.ResponseWriter, r *http.Request) {
+	unhealthy := make([]map[string]interface{}, 0)
+	for _, st := range s.jobs.Stats() {
+		if st.Healthy() {
+			continue
+		}
+		job := map[string]interface{}{
+			"name":     st.Name,
+			"status":   jobStatus(st),
+			"restarts": st.Restarts,
+		}
+		if st.Stalled {
+			job["running_seconds"] = time.Since(st.RunningSince).Seconds()
+			job["since_heartbeat_seconds"] = time.Since(st.LastHeartbeat).Seconds()
+		}
+		unhealthy = append(unhealthy, job)
+	}
+
+	resp := map[string]interface{}{
+		"status":    "ok",
+		"watchdog":  s.cfg.JobStallTimeout > 0,
+		"unhealthy": unhealthy,
+	}
+	status := http.StatusOK
+	if len(unhealthy) > 0 {
+		resp["status"] = "unhealthy"
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
+}
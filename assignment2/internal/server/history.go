@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const maxHistoryPage = 1000
+
+type statsPoint struct {
+	Time          time.Time `json:"time"`
+	TotalRequests int       `json:"total_requests"`
+	RequestRate   float64   `json:"request_rate"`
+	DatabaseSize  int       `json:"database_size"`
+
+	// With -data-dir: the log size, the bytes appended since startup
+	// and the append rate in bytes per second.
+	WALSize      int64   `json:"wal_size,omitempty"`
+	WALWritten   int64   `json:"wal_written,omitempty"`
+	WALWriteRate float64 `json:"wal_write_rate,omitempty"`
+}
+
+// statsHistory is a fixed-size ring of periodic stats samples.
+type statsHistory struct {
+	mu     sync.Mutex
+	points []statsPoint
+	next   int
+	full   bool
+}
+
+func newStatsHistory(size int) *statsHistory {
+	return &statsHistory{points: make([]statsPoint, size)}
+}
+
+func (h *statsHistory) add(p statsPoint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.points) == 0 {
+		return
+	}
+	h.points[h.next] = p
+	h.next = (h.next + 1) % len(h.points)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+func (h *statsHistory) last() (statsPoint, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.next == 0 && !h.full {
+		return statsPoint{}, false
+	}
+	return h.points[(h.next-1+len(h.points))%len(h.points)], true
+}
+
+// since returns up to limit points strictly newer than t, oldest first,
+// and whether more points remain after them.
+func (h *statsHistory) since(t time.Time, limit int) ([]statsPoint, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	start, count := 0, h.next
+	if h.full {
+		start, count = h.next, len(h.points)
+	}
+
+	var out []statsPoint
+	for i := 0; i < count; i++ {
+		p := h.points[(start+i)%len(h.points)]
+		if !p.Time.After(t) {
+			continue
+		}
+		if len(out) == limit {
+			return out, true
+		}
+		out = append(out, p)
+	}
+	return out, false
+}
+
+func (s *Server) sampleStats(ctx context.Context) error {
+	req, size, _ := s.Stats()
+	now := time.Now()
+
+	p := statsPoint{Time: now, TotalRequests: req, DatabaseSize: size}
+	if wal, ok := s.store.CompactionStats(); ok {
+		p.WALSize, p.WALWritten = wal.Size, wal.Written
+	}
+	if prev, ok := s.history.last(); ok {
+		if elapsed := now.Sub(prev.Time).Seconds(); elapsed > 0 {
+			p.RequestRate = float64(req-prev.TotalRequests) / elapsed
+			p.WALWriteRate = float64(p.WALWritten-prev.WALWritten) / elapsed
+		}
+	}
+
+	s.history.add(p)
+	return nil
+}
+
+// GET /stats/history
+//
+// ?since= (RFC 3339 or unix seconds) returns only newer points and
+// ?limit= caps the page size; when more points remain, next_since is the
+// value to pass as since for the following page.
+func (s *Server) StatsHistory(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	q := r.URL.Query()
+
+	var since time.Time
+	if v := q.Get("since"); v != "" {
+		var err error
+		if since, err = parseTimeParam(v); err != nil {
+			http.Error(w, "Invalid since", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := maxHistoryPage
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxHistoryPage)
+	}
+
+	points, more := s.history.since(since, limit)
+	if points == nil {
+		points = []statsPoint{}
+	}
+
+	resp := map[string]interface{}{
+		"interval_seconds": s.cfg.StatsSampleInterval.Seconds(),
+		"points":           points,
+	}
+	if more {
+		resp["next_since"] = points[len(points)-1].Time.Format(time.RFC3339Nano)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func parseTimeParam(v string) (time.Time, error) {
+	if unix, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	return time.Parse(time.RFC3339Nano, v)
+}
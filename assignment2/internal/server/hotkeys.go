@@ -0,0 +1,110 @@
+package server
+
+import (
+	"assignment2/internal/auth"
+	"assignment2/internal/events"
+	"assignment2/internal/storage"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultHotKeysLimit = 20
+
+type hotKey struct {
+	Key       string     `json:"key"`
+	Reads     uint64     `json:"reads"`
+	Writes    uint64     `json:"writes"`
+	LastRead  *time.Time `json:"last_read,omitempty"`
+	LastWrite *time.Time `json:"last_write,omitempty"`
+}
+
+// noteRead counts a point read of the store key, with -hotkeys.
+func (s *Server) noteRead(key string) {
+	if s.hotKeys != nil {
+		s.hotKeys.Read(key, time.Now())
+	}
+}
+
+// noteWrite counts the sets and deletes among the observed events, with
+// -hotkeys, whichever route or job made them.
+func (s *Server) noteWrite(e events.Event) {
+	if s.hotKeys != nil && (e.Type == storage.OpSet || e.Type == storage.OpDelete) {
+		s.hotKeys.Write(e.Key, time.Now())
+	}
+}
+
+// decayHotKeys is the hotkeys job.
+func (s *Server) decayHotKeys(ctx context.Context) error {
+	s.hotKeys.Decay()
+	return nil
+}
+
+// GET /stats/hotkeys
+//
+// Lists the most accessed keys, by reads plus writes or with
+// ?by=reads or ?by=writes alone; ?limit= caps how many (default 20).
+// Reads are GET /data/{key} and /data/{key}/meta, writes every set and
+// delete. Counts are estimates that may run high for rarely used keys,
+// and are halved every -hotkeys-half-life. With API keys only the
+// tenant's own keys that its scopes may read are listed.
+func (s *Server) HotKeys(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	if s.hotKeys == nil {
+		http.Error(w, "Hot key tracking is disabled (-hotkeys)", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	limit := defaultHotKeysLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	by := q.Get("by")
+	switch by {
+	case "":
+		by = "total"
+	case "total", "reads", "writes":
+	default:
+		http.Error(w, "Invalid by, expected total, reads or writes", http.StatusBadRequest)
+		return
+	}
+
+	scope := tenantScope(r)
+	top := s.hotKeys.Top(s.hotKeys.Tracked(), by)
+	keys := make([]hotKey, 0, limit)
+	tracked := 0
+	for i, k := range top {
+		name, ok := strings.CutPrefix(k.Key, scope)
+		if !ok || !allowKey(r, auth.ScopeRead, name) {
+			continue
+		}
+		tracked++
+		if len(keys) == limit {
+			continue
+		}
+		h := hotKey{Key: name, Reads: k.Reads, Writes: k.Writes}
+		if !k.LastRead.IsZero() {
+			h.LastRead = &top[i].LastRead
+		}
+		if !k.LastWrite.IsZero() {
+			h.LastWrite = &top[i].LastWrite
+		}
+		keys = append(keys, h)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"by":        by,
+		"keys":      keys,
+		"tracked":   tracked,
+		"half_life": s.cfg.HotKeysHalfLife.String(),
+	})
+}
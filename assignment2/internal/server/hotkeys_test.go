@@ -0,0 +1,116 @@
+package server
+
+import (
+	"assignment2/internal/config"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestServer starts a server with the flags in args.
+func newTestServer(t *testing.T, args ...string) (*Server, string) {
+	t.Helper()
+	cfg, err := config.Load(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := httptest.NewServer(s.Routes())
+	t.Cleanup(func() {
+		hs.Close()
+		s.Close()
+	})
+	return s, hs.URL
+}
+
+// newTenantServer starts a server with the API keys in keys, one
+// "key:tenant ..." line each, and the extra flags in args.
+func newTenantServer(t *testing.T, keys string, args ...string) string {
+	t.Helper()
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keysFile, []byte(keys), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, url := newTestServer(t, append([]string{"-api-keys-file", keysFile}, args...)...)
+	return url
+}
+
+// call sends a request with apiKey and returns the response status and
+// body.
+func call(t *testing.T, method, url, apiKey, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-API-Key", apiKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+func TestHotKeysAreScopedToTenant(t *testing.T) {
+	url := newTenantServer(t, "acme-key:acme\nacme-cfg:acme read:cfg:*\nglobex-key:globex\n", "-hotkeys", "100")
+
+	for _, w := range []struct{ key, body string }{
+		{"acme-key", `{"cfg:a":"1","jobs:b":"2"}`},
+		{"globex-key", `{"secret":"3"}`},
+	} {
+		if code, body := call(t, http.MethodPost, url+"/data", w.key, w.body); code != http.StatusCreated {
+			t.Fatalf("POST /data as %s: %d %s", w.key, code, body)
+		}
+	}
+
+	hot := func(apiKey string) []string {
+		t.Helper()
+		code, body := call(t, http.MethodGet, url+"/stats/hotkeys", apiKey, "")
+		if code != http.StatusOK {
+			t.Fatalf("GET /stats/hotkeys as %s: %d %s", apiKey, code, body)
+		}
+		var out struct {
+			Keys []struct {
+				Key string `json:"key"`
+			} `json:"keys"`
+			Tracked int `json:"tracked"`
+		}
+		if err := json.Unmarshal([]byte(body), &out); err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, k := range out.Keys {
+			keys = append(keys, k.Key)
+		}
+		if out.Tracked != len(keys) {
+			t.Fatalf("%s: tracked %d, but %d keys listed", apiKey, out.Tracked, len(keys))
+		}
+		return keys
+	}
+
+	if got := strings.Join(hot("acme-key"), ","); got != "cfg:a,jobs:b" {
+		t.Fatalf("acme sees hot keys %q, want cfg:a,jobs:b", got)
+	}
+	if got := strings.Join(hot("acme-cfg"), ","); got != "cfg:a" {
+		t.Fatalf("scoped acme key sees hot keys %q, want cfg:a", got)
+	}
+	if got := strings.Join(hot("globex-key"), ","); got != "secret" {
+		t.Fatalf("globex sees hot keys %q, want secret", got)
+	}
+	if code, _ := call(t, http.MethodGet, url+"/stats/hotkeys", "", ""); code != http.StatusUnauthorized {
+		t.Fatalf("GET /stats/hotkeys without a key: %d, want 401", code)
+	}
+}
@@ -0,0 +1,319 @@
+package server
+
+import (
+	"assignment2/internal/storage"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// importStaging holds the staged imports, one per tenant ("" without API
+// keys). Staged data is kept in memory on the node that received it and
+// is lost on restart; nothing of it is visible to readers until it is
+// committed.
+type importStaging struct {
+	mu    sync.Mutex
+	areas map[string]*stagedImport
+}
+
+type stagedImport struct {
+	entries map[string]string
+	bytes   int64
+	created time.Time
+	updated time.Time
+
+	// committing is set while a commit reads the entries; the area
+	// cannot be changed meanwhile.
+	committing bool
+}
+
+type importStatus struct {
+	Keys      int       `json:"keys"`
+	Bytes     int64     `json:"bytes"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (a *stagedImport) status() importStatus {
+	return importStatus{Keys: len(a.entries), Bytes: a.bytes, CreatedAt: a.created, UpdatedAt: a.updated}
+}
+
+var (
+	errNoImport         = errors.New("no staged import")
+	errImportCommitting = errors.New("import is being committed")
+)
+
+// POST /import
+//
+// Adds the entries of a JSON object to the caller's staging area,
+// creating it if there is none; a key staged again is overwritten.
+// Batches that would take the area over -import-max-keys or
+// -import-max-bytes are refused with 413 and leave it unchanged.
+func (s *Server) StageImport(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	var payload map[string]string
+	if err := readJSON(r.Body, &payload); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	st := &s.imports
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	scope := tenantScope(r)
+	area := st.areas[scope]
+	if area == nil {
+		now := time.Now().Round(0)
+		area = &stagedImport{entries: make(map[string]string), created: now}
+	}
+	if area.committing {
+		http.Error(w, errImportCommitting.Error(), http.StatusConflict)
+		return
+	}
+
+	keys, size := len(area.entries), area.bytes
+	for k, v := range payload {
+		if old, ok := area.entries[k]; ok {
+			size -= int64(len(k) + len(old))
+		} else {
+			keys++
+		}
+		size += int64(len(k) + len(v))
+	}
+	if keys > s.cfg.ImportMaxKeys || size > s.cfg.ImportMaxBytes<<20 {
+		http.Error(w, "Staged import would exceed -import-max-keys or -import-max-bytes", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	for k, v := range payload {
+		area.entries[k] = v
+	}
+	area.bytes = size
+	area.updated = time.Now().Round(0)
+	if st.areas == nil {
+		st.areas = make(map[string]*stagedImport)
+	}
+	st.areas[scope] = area
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"staged": len(payload),
+		"import": area.status(),
+	})
+}
+
+// GET /import
+func (s *Server) GetImport(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	s.imports.mu.Lock()
+	area := s.imports.areas[tenantScope(r)]
+	var status importStatus
+	if area != nil {
+		status = area.status()
+	}
+	s.imports.mu.Unlock()
+
+	if area == nil {
+		http.Error(w, errNoImport.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// DELETE /import
+//
+// Discards the caller's staging area.
+func (s *Server) DiscardImport(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	st := &s.imports
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	scope := tenantScope(r)
+	area := st.areas[scope]
+	switch {
+	case area == nil:
+		http.Error(w, errNoImport.Error(), http.StatusNotFound)
+		return
+	case area.committing:
+		http.Error(w, errImportCommitting.Error(), http.StatusConflict)
+		return
+	}
+	delete(st.areas, scope)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"discarded": len(area.entries)})
+}
+
+type importCommitRequest struct {
+	Prefix string `json:"prefix"`
+}
+
+// POST /import/validate
+//
+// Checks the staged entries as a commit with the same body would, without
+// committing: every key must be under the prefix and every value must pass
+// the write validators. Problems are answered with 422.
+func (s *Server) ValidateImport(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	var req importCommitRequest
+	if err := readImportRequest(r, &req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	area, ok := s.beginImportCommit(w, r)
+	if !ok {
+		return
+	}
+	defer s.endImportCommit(r, area, false)
+
+	if err := s.checkImport(r, area, req.Prefix); err != nil {
+		if !writeRejected(w, err) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"valid":  true,
+		"prefix": req.Prefix,
+		"import": area.status(),
+	})
+}
+
+// POST /import/commit
+//
+// Validates the staging area like POST /import/validate and then swaps
+// it into the live namespace in one batch: afterwards the keys under
+// "prefix" (all of the caller's keys if it is empty) are exactly the
+// staged ones, without tags or time to live. Readers and watchers see the
+// old keys or the new ones, never a mix. If validation or the write
+// fails, the live keys are left as they were and the staging area is kept
+// for another attempt; after a commit it is gone. A staged key locked by
+// someone other than X-Lock-Owner fails the commit with 423. Commits need
+// the admin role and are written to the audit log.
+func (s *Server) CommitImport(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	var req importCommitRequest
+	if err := readImportRequest(r, &req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	area, ok := s.beginImportCommit(w, r)
+	if !ok {
+		return
+	}
+	committed := false
+	defer func() { s.endImportCommit(r, area, committed) }()
+
+	if err := s.checkImport(r, area, req.Prefix); err != nil {
+		if !writeRejected(w, err) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		}
+		return
+	}
+	if s.checkEntryLocks(w, r, area.entries) {
+		return
+	}
+
+	scope := tenantScope(r)
+	deleted := 0
+	rev, err := s.store.Txn(r.Context(), func(tx *storage.Txn) error {
+		for _, k := range tx.Keys(scope + req.Prefix) {
+			if _, staged := area.entries[strings.TrimPrefix(k, scope)]; !staged {
+				tx.Delete(k)
+				deleted++
+			}
+		}
+		for k, v := range area.entries {
+			tx.Set(scope+k, v)
+		}
+		return nil
+	})
+	if err != nil {
+		storeFailed(w, "Import failed: ", err)
+		return
+	}
+	committed = true
+	s.audit(r, "import_commit", map[string]interface{}{
+		"prefix":   scope + req.Prefix,
+		"stored":   len(area.entries),
+		"deleted":  deleted,
+		"revision": rev,
+	})
+
+	setRevision(w, rev)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"prefix":   req.Prefix,
+		"stored":   len(area.entries),
+		"deleted":  deleted,
+		"revision": rev,
+	})
+}
+
+// readImportRequest decodes an optional JSON body.
+func readImportRequest(r *http.Request, req *importCommitRequest) error {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// beginImportCommit marks the caller's staging area as committing, which
+// keeps it unchanged until endImportCommit, or answers 404 or 409.
+func (s *Server) beginImportCommit(w http.ResponseWriter, r *http.Request) (*stagedImport, bool) {
+	st := &s.imports
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	area := st.areas[tenantScope(r)]
+	switch {
+	case area == nil:
+		http.Error(w, errNoImport.Error(), http.StatusNotFound)
+		return nil, false
+	case area.committing:
+		http.Error(w, errImportCommitting.Error(), http.StatusConflict)
+		return nil, false
+	}
+	area.committing = true
+	return area, true
+}
+
+// endImportCommit releases area, and removes it if it was committed.
+func (s *Server) endImportCommit(r *http.Request, area *stagedImport, committed bool) {
+	st := &s.imports
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	area.committing = false
+	if committed {
+		delete(st.areas, tenantScope(r))
+	}
+}
+
+// checkImport checks that every staged key is under prefix and runs the
+// write validators on the entries, in key order.
+func (s *Server) checkImport(r *http.Request, area *stagedImport, prefix string) error {
+	if prefix != "" {
+		keys := make([]string, 0, len(area.entries))
+		for k := range area.entries {
+			if !strings.HasPrefix(k, prefix) {
+				keys = append(keys, k)
+			}
+		}
+		if len(keys) > 0 {
+			sort.Strings(keys)
+			return errors.New("Staged key " + keys[0] + " is not under prefix " + prefix)
+		}
+	}
+	return s.validateEntries(r.Context(), area.entries)
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"assignment2/internal/metrics"
+	"assignment2/internal/version"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// shutdownFile holds the summary of the last clean shutdown in the data
+// directory. It is removed when it has been read at startup, so a start
+// without one after a previous run means that run did not stop cleanly.
+const shutdownFile = "shutdown.json"
+
+type storageSummary struct {
+	// Backend is "memory" without a data directory and "wal" with one.
+	Backend         string `json:"backend"`
+	DataDir         string `json:"data_dir,omitempty"`
+	Dedup           bool   `json:"dedup"`
+	TierBudgetBytes int64  `json:"tier_budget_bytes,omitempty"`
+	BlobDir         string `json:"blob_dir,omitempty"`
+	Keys            int    `json:"keys"`
+	Revision        uint64 `json:"revision"`
+	WALBytes        int64  `json:"wal_bytes,omitempty"`
+}
+
+// startupSummary is what the server started with: logged as a [STARTUP]
+// line once it listens and served by GET /admin/info.
+type startupSummary struct {
+	Time         time.Time        `json:"time"`
+	StartupMs    float64          `json:"startup_ms"`
+	PID          int              `json:"pid"`
+	Hostname     string           `json:"hostname"`
+	Listen       string           `json:"listen"`
+	Advertise    string           `json:"advertise"`
+	TLS          bool             `json:"tls"`
+	Build        version.Info     `json:"build"`
+	Storage      storageSummary   `json:"storage"`
+	LastShutdown *shutdownSummary `json:"last_shutdown,omitempty"`
+
+	// FlagsSet are the flags given on the command line and Config every
+	// flag's value in effect.
+	FlagsSet []string          `json:"flags_set"`
+	Config   map[string]string `json:"config"`
+}
+
+// shutdownSummary is what a shutdown drained and flushed, logged as a
+// [SHUTDOWN] line and kept in the data directory for the next start.
+type shutdownSummary struct {
+	Time          time.Time `json:"time"`
+	Version       string    `json:"version"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	Requests      int       `json:"requests"`
+
+	// DrainMs is how long in-flight requests took to finish; DrainError
+	// is set if some were cut off.
+	DrainMs    float64 `json:"drain_ms"`
+	DrainError string  `json:"drain_error,omitempty"`
+
+	// CloseMs is how long flushing the write-ahead log, the logs and the
+	// subscriptions took.
+	CloseMs            float64 `json:"close_ms"`
+	Keys               int     `json:"keys"`
+	Revision           uint64  `json:"revision"`
+	WALBytes           int64   `json:"wal_bytes,omitempty"`
+	WALBufferedBytes   int64   `json:"wal_buffered_bytes,omitempty"`
+	SubscriptionsSaved int     `json:"subscriptions_saved"`
+	ImportsDiscarded   int     `json:"staged_imports_discarded"`
+	Error              string  `json:"error,omitempty"`
+}
+
+// loadLastShutdown reads and removes the summary the previous run left in
+// dir, if there is one.
+func loadLastShutdown(dir string) *shutdownSummary {
+	if dir == "" {
+		return nil
+	}
+	path := filepath.Join(dir, shutdownFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[STARTUP] last shutdown: %v\n", err)
+		}
+		return nil
+	}
+	os.Remove(path)
+
+	var last shutdownSummary
+	if err := json.Unmarshal(data, &last); err != nil {
+		log.Printf("[STARTUP] last shutdown: %v\n", err)
+		return nil
+	}
+	return &last
+}
+
+func (s *Server) storageSummary() storageSummary {
+	st := storageSummary{
+		Backend:  "memory",
+		Dedup:    s.cfg.Dedup,
+		BlobDir:  s.cfg.BlobDir,
+		Keys:     s.store.Size(),
+		Revision: s.store.Revision(),
+	}
+	if s.cfg.DataDir != "" {
+		st.Backend = "wal"
+		st.DataDir = s.cfg.DataDir
+		st.TierBudgetBytes = s.cfg.TierBudget << 20
+	}
+	if wal, ok := s.store.CompactionStats(); ok {
+		st.WALBytes = wal.Size
+	}
+	return st
+}
+
+// LogStartup records and logs the startup summary once the server
+// listens on addr. It must be called before requests are served.
+func (s *Server) LogStartup(addr string) {
+	hostname, _ := os.Hostname()
+	s.startup = startupSummary{
+		Time:         time.Now().UTC(),
+		StartupMs:    float64(time.Since(s.startTime).Microseconds()) / 1000,
+		PID:          os.Getpid(),
+		Hostname:     hostname,
+		Listen:       addr,
+		Advertise:    advertiseAddr(s.cfg),
+		TLS:          s.tlsConfig != nil,
+		Build:        version.Get(),
+		Storage:      s.storageSummary(),
+		LastShutdown: s.lastShutdown,
+		FlagsSet:     s.cfg.FlagsSet,
+		Config:       s.cfg.Flags,
+	}
+	line, _ := json.Marshal(s.startup)
+	log.Printf("[STARTUP] %s\n", line)
+}
+
+// CloseAndLog closes the server like Close after the HTTP server took
+// drain to shut down, with drainErr if it gave up on some requests, and
+// logs what was flushed. With a data directory the summary is also
+// written there for the next start to report.
+func (s *Server) CloseAndLog(drain time.Duration, drainErr error) error {
+	req, size, uptime := s.Stats()
+	sum := shutdownSummary{
+		Version:       version.String(),
+		UptimeSeconds: int64(uptime),
+		Requests:      req,
+		DrainMs:       float64(drain.Microseconds()) / 1000,
+		Keys:          size,
+		Revision:      s.store.Revision(),
+	}
+	if drainErr != nil {
+		sum.DrainError = drainErr.Error()
+	}
+	if wal, ok := s.store.CompactionStats(); ok {
+		sum.WALBytes = wal.Size
+	}
+	if health, ok := s.store.LogHealth(); ok {
+		sum.WALBufferedBytes = health.Buffered
+	}
+	if s.subscriptions.path != "" {
+		s.subscriptions.mu.Lock()
+		sum.SubscriptionsSaved = len(s.subscriptions.subs)
+		s.subscriptions.mu.Unlock()
+	}
+	s.imports.mu.Lock()
+	for _, area := range s.imports.areas {
+		sum.ImportsDiscarded += len(area.entries)
+	}
+	s.imports.mu.Unlock()
+
+	start := time.Now()
+	err := s.Close()
+	sum.CloseMs = float64(time.Since(start).Microseconds()) / 1000
+	sum.Time = time.Now().UTC()
+	if err != nil {
+		sum.Error = err.Error()
+	}
+
+	line, _ := json.Marshal(sum)
+	log.Printf("[SHUTDOWN] %s\n", line)
+	if s.cfg.DataDir != "" {
+		if werr := os.WriteFile(filepath.Join(s.cfg.DataDir, shutdownFile), line, 0o644); werr != nil {
+			log.Printf("[SHUTDOWN] save summary: %v\n", werr)
+		}
+	}
+	return err
+}
+
+// GET /admin/info
+//
+// Returns the startup summary: build, storage and listen addresses, the
+// previous shutdown if it was clean, and every flag in effect.
+func (s *Server) GetInfo(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	_, _, uptime := s.Stats()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"startup":        s.startup,
+		"uptime_seconds": uptime,
+		"storage":        s.storageSummary(),
+	})
+}
+
+// GET /version
+//
+// Returns the build's version, commit, build date and Go version. It
+// needs no role, like the health checks.
+func (s *Server) GetVersion(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+	writeJSON(w, http.StatusOK, version.Get())
+}
+
+func (s *Server) collectBuildMetrics() []metrics.Family {
+	in := version.Get()
+	return []metrics.Family{{
+		Name: "kv_build_info",
+		Help: "Build of the running server; always 1.",
+		Type: metrics.TypeGauge,
+		Samples: []metrics.Sample{{
+			Labels: metrics.Pairs([]string{"version", "commit", "go_version"}, []string{in.Version, in.Commit, in.GoVersion}),
+			Value:  1,
+		}},
+	}}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"assignment2/internal/metrics"
+	"assignment2/internal/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type integrityReport struct {
+	Time       time.Time `json:"time"`
+	DurationMs float64   `json:"duration_ms"`
+	OK         bool      `json:"ok"`
+	Error      string    `json:"error,omitempty"`
+
+	storage.IntegrityReport
+}
+
+// integrityState keeps the last integrity check and run counters for
+// /metrics.
+type integrityState struct {
+	mu       sync.Mutex
+	last     *integrityReport
+	checks   int64
+	failures int64
+}
+
+// checkIntegrity compares the store with its write-ahead log and records
+// the result.
+func (s *Server) checkIntegrity(ctx context.Context) integrityReport {
+	start := time.Now()
+	result, err := s.store.VerifyLog(ctx)
+	report := integrityReport{
+		Time:            start,
+		DurationMs:      float64(time.Since(start).Microseconds()) / 1000,
+		OK:              err == nil && result.OK(),
+		IntegrityReport: result,
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	s.integrity.mu.Lock()
+	s.integrity.last = &report
+	s.integrity.checks++
+	if !report.OK {
+		s.integrity.failures++
+	}
+	s.integrity.mu.Unlock()
+	return report
+}
+
+// integrityJob is the integrity job. A failed check is logged and counted
+// as a job failure so it shows up in /admin/jobs.
+func (s *Server) integrityJob(ctx context.Context) error {
+	report := s.checkIntegrity(ctx)
+	switch {
+	case report.Error != "":
+		return errors.New(report.Error)
+	case !report.OK:
+		log.Printf("[INTEGRITY] %d discrepancies between memory (rev %d, %s) and log (rev %d, %s)\n",
+			report.Discrepancies, report.Revision, report.MemoryDigest, report.LogRevision, report.LogDigest)
+		return fmt.Errorf("%d discrepancies", report.Discrepancies)
+	}
+	return nil
+}
+
+func (s *Server) collectIntegrityMetrics() []metrics.Family {
+	s.integrity.mu.Lock()
+	defer s.integrity.mu.Unlock()
+
+	var discrepancies, lastCheck float64
+	if last := s.integrity.last; last != nil {
+		discrepancies = float64(last.Discrepancies)
+		lastCheck = float64(last.Time.UnixNano()) / 1e9
+	}
+	return []metrics.Family{
+		metrics.Single("kv_integrity_checks_total", "Integrity checks run.", metrics.TypeCounter, float64(s.integrity.checks)),
+		metrics.Single("kv_integrity_failures_total", "Integrity checks that found discrepancies or could not run.", metrics.TypeCounter, float64(s.integrity.failures)),
+		metrics.Single("kv_integrity_discrepancies", "Discrepancies found by the last integrity check.", metrics.TypeGauge, discrepancies),
+		metrics.Single("kv_integrity_last_check_timestamp_seconds", "Unix time of the last integrity check.", metrics.TypeGauge, lastCheck),
+	}
+}
+
+// persistenceEnabled answers 501 when the store is not persisted, so there
+// is no log to check or compact.
+func (s *Server) persistenceEnabled(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.DataDir == "" {
+			s.IncrementRequests()
+			http.Error(w, "Persistence is not configured (-data-dir)", http.StatusNotImplemented)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// GET /admin/integrity
+func (s *Server) GetIntegrity(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	s.integrity.mu.Lock()
+	last := s.integrity.last
+	s.integrity.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"interval":   s.cfg.IntegrityInterval.String(),
+		"last_check": last,
+	})
+}
+
+// POST /admin/integrity/check
+//
+// Runs a check now and returns its report.
+func (s *Server) CheckIntegrity(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	report := s.checkIntegrity(r.Context())
+	if report.Error != "" {
+		storeFailed(w, "Integrity check failed: ", errors.New(report.Error))
+		return
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+)
+
+type ipFilterRequest struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// filterIPs rejects clients outside the allow list or inside the deny
+// list before any authentication happens.
+func (s *Server) filterIPs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.ipFilter.Empty() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+
+		if list := s.ipFilter.Check(net.ParseIP(host)); list != "" {
+			s.IncrementRequests()
+			s.ipDenied.Inc(list)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GET /admin/ipfilter
+func (s *Server) GetIPFilter(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	allow, deny := s.ipFilter.Lists()
+	json.NewEncoder(w).Encode(ipFilterRequest{Allow: allow, Deny: deny})
+}
+
+// PUT /admin/ipfilter
+func (s *Server) PutIPFilter(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	var req ipFilterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.ipFilter.Set(req.Allow, req.Deny); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	allow, deny := s.ipFilter.Lists()
+	json.NewEncoder(w).Encode(ipFilterRequest{Allow: allow, Deny: deny})
+}
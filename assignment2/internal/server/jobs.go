@@ -0,0 +1,102 @@
+package server
+
+import (
+	"assignment2/internal/jobs"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// GET /admin/jobs
+//
+// Lists the background jobs and, under "throttle", whether maintenance
+// jobs are paused by -job-pause-rps and how much -compact-bytes-per-sec
+// slowed compactions down.
+func (s *Server) ListJobs(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	list := make([]map[string]interface{}, 0)
+	for _, st := range s.jobs.Stats() {
+		list = append(list, jobInfo(st))
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"jobs": list, "throttle": s.throttleState()})
+}
+
+// POST /admin/jobs/{name}/run
+func (s *Server) RunJob(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	name := r.PathValue("name")
+	if err := s.jobs.Trigger(name); err == jobs.ErrUnknownJob {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"triggered": name})
+}
+
+// jobInfo describes a job as GET /admin/jobs and GET /debug/vars list it.
+func jobInfo(st jobs.Stats) map[string]interface{} {
+	job := map[string]interface{}{
+		"name":             st.Name,
+		"interval_seconds": st.Interval.Seconds(),
+		"runs":             st.Runs,
+		"failures":         st.Failures,
+		"running":          st.Running,
+		"last_duration_ms": float64(st.LastDuration.Microseconds()) / 1000,
+		"restarts":         st.Restarts,
+		"status":           jobStatus(st),
+		"deferred":         st.Deferred,
+	}
+	if !st.DeferredSince.IsZero() {
+		job["deferred_since"] = st.DeferredSince.UTC().Format(time.RFC3339)
+	}
+	if st.Running {
+		job["running_since"] = st.RunningSince.UTC().Format(time.RFC3339)
+		job["last_heartbeat"] = st.LastHeartbeat.UTC().Format(time.RFC3339)
+	}
+	if !st.LastRun.IsZero() {
+		job["last_run"] = st.LastRun.UTC().Format(time.RFC3339)
+	}
+	if st.LastError != "" {
+		job["last_error"] = st.LastError
+		job["last_error_at"] = st.LastErrorAt.UTC().Format(time.RFC3339)
+	}
+	return job
+}
+
+// jobStatus is "ok", "stalled" (running without a heartbeat for longer
+// than -job-stall-timeout) or "stopped" (its goroutine exited).
+func jobStatus(st jobs.Stats) string {
+	switch {
+	case st.Stopped:
+		return "stopped"
+	case st.Stalled:
+		return "stalled"
+	default:
+		return "ok"
+	}
+}
+
+// throttleState describes how background work is held back in favour of
+// requests.
+func (s *Server) throttleState() map[string]interface{} {
+	pause := s.jobs.Paused()
+	state := map[string]interface{}{
+		"paused":                pause.Paused,
+		"pauses":                pause.Pauses,
+		"pause_rps":             s.cfg.JobPauseRPS,
+		"requests_per_sec":      s.requestRate(),
+		"compact_bytes_per_sec": s.cfg.CompactBytesPerSec,
+	}
+	if pause.Paused {
+		state["reason"] = pause.Reason
+		state["paused_since"] = pause.Since.UTC().Format(time.RFC3339)
+	}
+	if wal, ok := s.store.CompactionStats(); ok {
+		state["compaction_throttled_ms"] = float64(wal.Throttled.Microseconds()) / 1000
+	}
+	return state
+}
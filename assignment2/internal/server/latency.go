@@ -0,0 +1,48 @@
+package server
+
+import (
+	"assignment2/internal/metrics"
+	"net/http"
+	"time"
+)
+
+// streamingRoutes stay open for as long as the client watches, so their
+// durations say nothing about latency.
+var streamingRoutes = map[string]bool{
+	"GET /watch":         true,
+	"GET /cluster/watch": true,
+	"POST /v3/watch":     true,
+}
+
+func newRequestDuration() *metrics.HistogramVec {
+	return metrics.NewHistogramVec("kv_request_duration_seconds", "Time to answer requests, by route.", metrics.DefaultBuckets, "route")
+}
+
+// observeLatency records how long route takes to answer in
+// kv_request_duration_seconds and against the route's SLO, if it has
+// one. A request that is part of a sampled trace becomes its bucket's
+// exemplar, so a dashboard can go from a latency spike to a trace of one
+// of the slow requests (exemplars are only exposed to scrapers that ask
+// for OpenMetrics).
+func (s *Server) observeLatency(route string, next http.HandlerFunc) http.HandlerFunc {
+	if streamingRoutes[route] {
+		return next
+	}
+	slo := s.sloTrackerFor(route)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next(w, r)
+		took := time.Since(start)
+		elapsed := took.Seconds()
+		if slo != nil {
+			slo.observe(took)
+		}
+
+		if info := RequestInfoFrom(r.Context()); info.Sampled {
+			s.requestDuration.ObserveWithExemplar(elapsed, []metrics.Label{{Name: "trace_id", Value: info.TraceID}}, route)
+			return
+		}
+		s.requestDuration.Observe(elapsed, route)
+	}
+}
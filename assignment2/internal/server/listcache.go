@@ -0,0 +1,109 @@
+package server
+
+import (
+	"assignment2/internal/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// listCache keeps encoded GET /data responses. Every entry belongs to the
+// store revision it was rendered at and is only served while that is
+// still the current revision, so any write invalidates the whole cache.
+// Keys expiring does not change the revision, so an entry listing keys
+// with a time to live is also dropped once the first of them expires.
+type listCache struct {
+	maxBytes int
+
+	mu      sync.Mutex
+	rev     uint64
+	entries map[string]cachedList
+	size    int
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type cachedList struct {
+	body     []byte
+	rendered time.Time
+
+	// expires is the deadline of the first listed key to expire, if any.
+	expires time.Time
+}
+
+type listCacheStats struct {
+	Entries int   `json:"entries"`
+	Bytes   int   `json:"bytes"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+}
+
+func newListCache(maxBytes int) *listCache {
+	return &listCache{maxBytes: maxBytes, entries: make(map[string]cachedList)}
+}
+
+// get returns the response cached for query, and when it was rendered, if
+// that was at rev and none of its keys has expired since.
+func (c *listCache) get(query string, rev uint64) ([]byte, time.Time, bool) {
+	c.mu.Lock()
+	e, ok := c.entries[query]
+	ok = ok && c.rev == rev && (e.expires.IsZero() || time.Now().Before(e.expires))
+	c.mu.Unlock()
+
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return e.body, e.rendered, ok
+}
+
+// put caches body for query at rev, until expires unless that is zero.
+// Entries of older revisions are dropped; a response rendered at an older
+// revision than the cache holds is not stored.
+func (c *listCache) put(query string, rev uint64, body []byte, expires time.Time) {
+	if len(body) > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case rev < c.rev:
+		return
+	case rev > c.rev:
+		c.rev = rev
+		c.entries = make(map[string]cachedList)
+		c.size = 0
+	}
+
+	if old, ok := c.entries[query]; ok {
+		c.size -= len(old.body)
+	}
+	for k, v := range c.entries {
+		if c.size+len(body) <= c.maxBytes {
+			break
+		}
+		delete(c.entries, k)
+		c.size -= len(v.body)
+	}
+	c.entries[query] = cachedList{body: body, rendered: time.Now(), expires: expires}
+	c.size += len(body)
+}
+
+func (c *listCache) stats() listCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return listCacheStats{Entries: len(c.entries), Bytes: c.size, Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
+
+func (s *Server) collectListCacheMetrics() []metrics.Family {
+	st := s.listCache.stats()
+	return []metrics.Family{
+		metrics.Single("kv_list_cache_hits_total", "GET /data responses served from the cache.", metrics.TypeCounter, float64(st.Hits)),
+		metrics.Single("kv_list_cache_misses_total", "GET /data responses that had to be rendered.", metrics.TypeCounter, float64(st.Misses)),
+		metrics.Single("kv_list_cache_bytes", "Size of the cached GET /data responses.", metrics.TypeGauge, float64(st.Bytes)),
+	}
+}
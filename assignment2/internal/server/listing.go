@@ -0,0 +1,224 @@
+package server
+
+import (
+	"assignment2/internal/events"
+	"assignment2/internal/script"
+	"assignment2/internal/storage"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// listOptions are the query parameters shared by listing endpoints.
+type listOptions struct {
+	prefix        string
+	tags          []string
+	fields        []string
+	excludeValues bool
+	minRevision   uint64
+
+	// includeDeleted lists the keys deleted at or after minRevision too,
+	// from the watch history.
+	includeDeleted bool
+
+	// filter is the ?filter= expression entries must match.
+	filter *script.Expr
+
+	// encrypted holds the keys, as the client names them, whose values
+	// the client encrypted; fields are not projected out of those. It is
+	// only loaded with fields, by loadEncrypted.
+	encrypted map[string]bool
+
+	// order is set by ?sort= or ?order=; without either, listings are
+	// in key order as a JSON object, which does not keep an order.
+	order *storage.Order
+}
+
+func parseListOptions(r *http.Request) (listOptions, error) {
+	q := r.URL.Query()
+
+	opts := listOptions{
+		prefix:        q.Get("prefix"),
+		tags:          q["tag"],
+		excludeValues: q.Get("exclude_values") == "true",
+	}
+	if f := q.Get("fields"); f != "" {
+		for _, name := range strings.Split(f, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				opts.fields = append(opts.fields, name)
+			}
+		}
+	}
+	if v := q.Get("filter"); v != "" {
+		f, err := parseFilter(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.filter = f
+	}
+	if v := q.Get("min_revision"); v != "" {
+		rev, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return opts, errors.New("Invalid min_revision")
+		}
+		opts.minRevision = rev
+	}
+	if q.Get("include_deleted") == "true" {
+		if opts.minRevision == 0 {
+			return opts, errors.New("include_deleted needs min_revision")
+		}
+		opts.includeDeleted = true
+	}
+
+	if q.Has("sort") || q.Has("order") {
+		opts.order = &storage.Order{}
+		switch q.Get("sort") {
+		case "", "key":
+		case "updated_at":
+			opts.order.ByRevision = true
+		default:
+			return opts, errors.New("Invalid sort")
+		}
+		switch q.Get("order") {
+		case "", "asc":
+		case "desc":
+			opts.order.Descending = true
+		default:
+			return opts, errors.New("Invalid order")
+		}
+	}
+	return opts, nil
+}
+
+// render shapes a listing: just the sorted keys with exclude_values, or
+// the values with JSON objects reduced to the requested fields. With an
+// order, keys lists the entries in it.
+func (o listOptions) render(keys []string, entries map[string]string) interface{} {
+	if o.order != nil {
+		ordered := keys[:0:0]
+		for _, k := range keys {
+			if _, ok := entries[k]; ok {
+				ordered = append(ordered, k)
+			}
+		}
+		if o.excludeValues {
+			return map[string][]string{"keys": ordered}
+		}
+		return orderedListing{keys: ordered, entries: entries, opts: o}
+	}
+
+	if o.excludeValues {
+		keys := make([]string, 0, len(entries))
+		for k := range entries {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return map[string][]string{"keys": keys}
+	}
+
+	if len(o.fields) == 0 {
+		return entries
+	}
+
+	out := make(map[string]interface{}, len(entries))
+	for k, v := range entries {
+		out[k] = o.project(k, v)
+	}
+	return out
+}
+
+// deletedListing is a listing with ?include_deleted=true: the entries as
+// render shapes them, and the keys deleted since.
+type deletedListing struct {
+	Entries interface{} `json:"entries"`
+	Deleted []string    `json:"deleted"`
+}
+
+// deletedSince returns the keys under prefix that the watch history has
+// deleted at revisions minRev to rev and that entries, the keys the store
+// has now, does not hold again. It fails with events.ErrTooOld once the
+// history no longer reaches back to minRev.
+func (s *Server) deletedSince(minRev, rev uint64, prefix string, entries map[string]string) ([]string, error) {
+	evs, err := s.events.Since(minRev-1, func(e events.Event) bool {
+		return e.Type == storage.OpDelete && e.Seq <= rev && strings.HasPrefix(e.Key, prefix)
+	})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	deleted := []string{}
+	for _, e := range evs {
+		if _, ok := entries[e.Key]; !ok && !seen[e.Key] {
+			seen[e.Key] = true
+			deleted = append(deleted, e.Key)
+		}
+	}
+	sort.Strings(deleted)
+	return deleted, nil
+}
+
+// orderedListing is a listing encoded as a JSON object whose members
+// appear in the order of keys.
+type orderedListing struct {
+	keys    []string
+	entries map[string]string
+	opts    listOptions
+}
+
+func (l orderedListing) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range l.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		var v interface{} = l.entries[k]
+		if len(l.opts.fields) > 0 {
+			v = l.opts.project(k, l.entries[k])
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// project applies ?fields= to key's value, unless the client encrypted
+// it.
+func (o listOptions) project(key, value string) interface{} {
+	if o.encrypted[key] {
+		return value
+	}
+	return projectFields(value, o.fields)
+}
+
+// projectFields keeps only the named top-level fields of a JSON object
+// value. Values that are not JSON objects are returned unchanged.
+func projectFields(value string, fields []string) interface{} {
+	// null unmarshals without an error, into a nil map.
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(value), &obj); err != nil || obj == nil {
+		return value
+	}
+
+	projected := make(map[string]json.RawMessage, len(fields))
+	for _, f := range fields {
+		if v, ok := obj[f]; ok {
+			projected[f] = v
+		}
+	}
+	return projected
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// loadResume is the share of -job-pause-rps the request rate has to drop
+// below before paused jobs resume, so that a rate hovering around the
+// threshold does not flip the pause every second.
+const loadResume = 0.8
+
+// loadState is what the load job measured last.
+type loadState struct {
+	mu       sync.Mutex
+	requests int
+	at       time.Time
+	rate     float64
+}
+
+// requestRate returns the request rate the load job measured last.
+func (s *Server) requestRate() float64 {
+	s.load.mu.Lock()
+	defer s.load.mu.Unlock()
+	return s.load.rate
+}
+
+// loadJob measures the request rate over the last interval and pauses
+// the maintenance jobs while it is at -job-pause-rps or above.
+func (s *Server) loadJob(ctx context.Context) error {
+	req, _, _ := s.Stats()
+	now := time.Now()
+
+	l := &s.load
+	l.mu.Lock()
+	first := l.at.IsZero()
+	if !first {
+		l.rate = float64(req-l.requests) / now.Sub(l.at).Seconds()
+	}
+	l.requests, l.at = req, now
+	rate := l.rate
+	l.mu.Unlock()
+	if first {
+		return nil
+	}
+
+	paused := s.jobs.Paused().Paused
+	switch {
+	case rate >= s.cfg.JobPauseRPS:
+		s.jobs.Pause(fmt.Sprintf("%.0f requests/s", rate))
+		if !paused {
+			log.Printf("[JOBS] pausing maintenance jobs: %.0f requests/s\n", rate)
+		}
+	case paused && rate < loadResume*s.cfg.JobPauseRPS:
+		s.jobs.Resume()
+		log.Printf("[JOBS] resuming maintenance jobs: %.0f requests/s\n", rate)
+	}
+	return nil
+}
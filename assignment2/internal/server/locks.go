@@ -0,0 +1,265 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderLockOwner names the owner a write is made for, so that it is let
+// through a lock that owner holds.
+const HeaderLockOwner = "X-Lock-Owner"
+
+// defaultLockTTL is how long a lock is held when the request does not say.
+const defaultLockTTL = 5 * time.Minute
+
+// lockSweepSize is how many locks are kept before expired ones are
+// dropped on the next acquisition rather than only when looked up.
+const lockSweepSize = 1024
+
+// keyLocks holds the advisory edit locks, by tenant-scoped key. Like
+// staged imports they live in memory on the node that granted them and
+// are gone after a restart.
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]keyLock
+}
+
+type keyLock struct {
+	Owner      string    `json:"owner"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type lockRequest struct {
+	Owner string `json:"owner"`
+	TTL   string `json:"ttl"`
+}
+
+type lockResponse struct {
+	Key string `json:"key"`
+	keyLock
+}
+
+// lockedResponse answers a request that a lock of someone else stood in
+// the way of.
+type lockedResponse struct {
+	Error string       `json:"error"`
+	Key   string       `json:"key"`
+	Lock  lockResponse `json:"lock"`
+}
+
+// held returns the unexpired lock on key, forgetting it if it expired.
+// The caller holds l.mu.
+func (l *keyLocks) held(key string, now time.Time) (keyLock, bool) {
+	lock, ok := l.locks[key]
+	if ok && !now.Before(lock.ExpiresAt) {
+		delete(l.locks, key)
+		return keyLock{}, false
+	}
+	return lock, ok
+}
+
+// blocking returns the first of keys, in order, that is locked by someone
+// other than owner.
+func (l *keyLocks) blocking(keys []string, owner string) (string, keyLock, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.locks) == 0 {
+		return "", keyLock{}, false
+	}
+	now := time.Now()
+	for _, k := range keys {
+		if lock, ok := l.held(k, now); ok && lock.Owner != owner {
+			return k, lock, true
+		}
+	}
+	return "", keyLock{}, false
+}
+
+// blockingPrefix is blocking for the keys under prefix, in key order.
+func (l *keyLocks) blockingPrefix(prefix, owner string) (string, keyLock, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var keys []string
+	for k := range l.locks {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	now := time.Now()
+	for _, k := range keys {
+		if lock, ok := l.held(k, now); ok && lock.Owner != owner {
+			return k, lock, true
+		}
+	}
+	return "", keyLock{}, false
+}
+
+// checkLocks answers 423 if one of keys, as the client named them, is
+// locked by someone other than the request's X-Lock-Owner, and reports
+// whether it did.
+func (s *Server) checkLocks(w http.ResponseWriter, r *http.Request, keys ...string) bool {
+	scope := tenantScope(r)
+	scoped := make([]string, len(keys))
+	for i, k := range keys {
+		scoped[i] = scope + k
+	}
+	key, lock, locked := s.locks.blocking(scoped, r.Header.Get(HeaderLockOwner))
+	if locked {
+		writeLocked(w, key[len(scope):], lock)
+	}
+	return locked
+}
+
+func writeLocked(w http.ResponseWriter, key string, lock keyLock) {
+	writeJSON(w, http.StatusLocked, lockedResponse{
+		Error: "Key is locked",
+		Key:   key,
+		Lock:  lockResponse{Key: key, keyLock: lock},
+	})
+}
+
+// checkPrefixLocks is checkLocks for every key under prefix, as the
+// client named it, for writes that cover a whole prefix.
+func (s *Server) checkPrefixLocks(w http.ResponseWriter, r *http.Request, prefix string) bool {
+	scope := tenantScope(r)
+	key, lock, locked := s.locks.blockingPrefix(scope+prefix, r.Header.Get(HeaderLockOwner))
+	if locked {
+		writeLocked(w, key[len(scope):], lock)
+	}
+	return locked
+}
+
+// lockGate refuses writes to the {key} of the route while someone else
+// holds a lock on it.
+func (s *Server) lockGate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.checkLocks(w, r, r.PathValue("key")) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// checkEntryLocks is checkLocks for the keys of a batch, in key order.
+func (s *Server) checkEntryLocks(w http.ResponseWriter, r *http.Request, entries map[string]string) bool {
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return s.checkLocks(w, r, keys...)
+}
+
+// POST /data/{key}/lock
+//
+// Takes or renews an advisory edit lock on the key for "owner", for
+// "ttl" (5m by default, at most -lock-max-ttl). While it is held, writes
+// to the key without X-Lock-Owner set to the owner are refused with 423,
+// and so is another owner's attempt to take the lock. The key need not
+// exist.
+func (s *Server) LockKey(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	var req lockRequest
+	if err := readJSON(r.Body, &req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Owner == "" || len(req.Owner) > 128 {
+		http.Error(w, "owner must be 1 to 128 characters", http.StatusBadRequest)
+		return
+	}
+	ttl := defaultLockTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			http.Error(w, "Invalid ttl", http.StatusBadRequest)
+			return
+		}
+		ttl = d
+	}
+	if ttl > s.cfg.LockMaxTTL {
+		ttl = s.cfg.LockMaxTTL
+	}
+
+	key := r.PathValue("key")
+	scoped := scopedKey(r, key)
+
+	l := &s.locks
+	l.mu.Lock()
+	now := time.Now().Round(0)
+	lock, ok := l.held(scoped, now)
+	if ok && lock.Owner != req.Owner {
+		l.mu.Unlock()
+		writeLocked(w, key, lock)
+		return
+	}
+	if !ok {
+		lock = keyLock{Owner: req.Owner, AcquiredAt: now}
+		if len(l.locks) >= lockSweepSize {
+			for k := range l.locks {
+				l.held(k, now)
+			}
+		}
+	}
+	lock.ExpiresAt = now.Add(ttl)
+	if l.locks == nil {
+		l.locks = make(map[string]keyLock)
+	}
+	l.locks[scoped] = lock
+	l.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, lockResponse{Key: key, keyLock: lock})
+}
+
+// GET /data/{key}/lock
+func (s *Server) GetLock(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	key := r.PathValue("key")
+	l := &s.locks
+	l.mu.Lock()
+	lock, ok := l.held(scopedKey(r, key), time.Now())
+	l.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "Key is not locked", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, lockResponse{Key: key, keyLock: lock})
+}
+
+// DELETE /data/{key}/lock
+//
+// Releases the lock; X-Lock-Owner must name its owner.
+func (s *Server) UnlockKey(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	key := r.PathValue("key")
+	scoped := scopedKey(r, key)
+
+	l := &s.locks
+	l.mu.Lock()
+	lock, ok := l.held(scoped, time.Now())
+	switch {
+	case !ok:
+		l.mu.Unlock()
+		http.Error(w, "Key is not locked", http.StatusNotFound)
+		return
+	case lock.Owner != r.Header.Get(HeaderLockOwner):
+		l.mu.Unlock()
+		writeLocked(w, key, lock)
+		return
+	}
+	delete(l.locks, scoped)
+	l.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "unlocked": true})
+}
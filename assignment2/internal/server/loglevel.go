@@ -0,0 +1,42 @@
+package server
+
+import (
+	"assignment2/internal/logging"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// GET /admin/loglevel
+func (s *Server) GetLogLevel(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+	json.NewEncoder(w).Encode(logLevelRequest{Level: logging.CurrentLevel().String()})
+}
+
+// PUT /admin/loglevel
+//
+// Changes the lowest level of server log lines written until the next
+// change or restart; -log-level sets the level at startup.
+func (s *Server) PutLogLevel(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	var req logLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	level, err := logging.ParseLevel(req.Level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if old := logging.SetLevel(level); old != level {
+		log.Printf("[LOG] level changed from %s to %s\n", old, level)
+	}
+	json.NewEncoder(w).Encode(logLevelRequest{Level: level.String()})
+}
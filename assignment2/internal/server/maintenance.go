@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type maintenanceState struct {
+	mu         sync.Mutex
+	enabled    bool
+	message    string
+	retryAfter time.Duration
+	since      time.Time
+}
+
+type maintenanceRequest struct {
+	Enabled    bool   `json:"enabled"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry_after"`
+}
+
+func (m *maintenanceState) snapshot() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := map[string]interface{}{
+		"enabled":     m.enabled,
+		"message":     m.message,
+		"retry_after": int(m.retryAfter.Seconds()),
+	}
+	if m.enabled {
+		out["since"] = m.since.UTC().Format(time.RFC3339)
+	}
+	return out
+}
+
+// maintenanceGate answers 503 on data endpoints while maintenance mode
+// is on. Admin and health endpoints are not wrapped and keep working.
+func (s *Server) maintenanceGate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.maintenance.mu.Lock()
+		enabled := s.maintenance.enabled
+		message := s.maintenance.message
+		retryAfter := s.maintenance.retryAfter
+		s.maintenance.mu.Unlock()
+
+		if !enabled {
+			next(w, r)
+			return
+		}
+
+		s.IncrementRequests()
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+		http.Error(w, message, http.StatusServiceUnavailable)
+	}
+}
+
+// GET /admin/maintenance
+func (s *Server) GetMaintenance(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+	json.NewEncoder(w).Encode(s.maintenance.snapshot())
+}
+
+// POST /admin/maintenance
+func (s *Server) SetMaintenance(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	var req maintenanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RetryAfter < 0 {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	s.maintenance.mu.Lock()
+	if req.Enabled && !s.maintenance.enabled {
+		s.maintenance.since = time.Now()
+	}
+	s.maintenance.enabled = req.Enabled
+	s.maintenance.message = s.cfg.MaintenanceMessage
+	if req.Message != "" {
+		s.maintenance.message = req.Message
+	}
+	s.maintenance.retryAfter = s.cfg.MaintenanceRetryAfter
+	if req.RetryAfter > 0 {
+		s.maintenance.retryAfter = time.Duration(req.RetryAfter) * time.Second
+	}
+	s.maintenance.mu.Unlock()
+
+	json.NewEncoder(w).Encode(s.maintenance.snapshot())
+}
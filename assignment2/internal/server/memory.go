@@ -0,0 +1,203 @@
+package server
+
+import (
+	"assignment2/internal/jobs"
+	"assignment2/internal/metrics"
+	"context"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	rtmetrics "runtime/metrics"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// memoryEvictBatch is how many keys one eviction round deletes before
+// the heap is measured again.
+const memoryEvictBatch = 1000
+
+// memoryEvictRounds caps the eviction rounds per check, so one check
+// does not hold up the other jobs for long.
+const memoryEvictRounds = 10
+
+// memoryLowWater is the share of -memory-limit the heap has to fall
+// below before the pressure is over, so the server does not flap at the
+// limit.
+const memoryLowWater = 0.9
+
+// memoryMinGain is the share of -memory-limit an eviction round has to
+// free to count as freeing memory at all.
+const memoryMinGain = 0.01
+
+const heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+
+// memoryState tracks the heap against -memory-limit.
+type memoryState struct {
+	pressure atomic.Bool
+	heap     atomic.Uint64
+
+	evicted  atomic.Int64
+	rejected atomic.Int64
+	gcs      atomic.Int64
+
+	// stuck is the heap size at which evicting last stopped freeing
+	// memory; the memory job waits for the heap to grow past it by
+	// memoryMinGain before evicting again. Only the memory job uses it.
+	stuck uint64
+}
+
+// heapBytes returns the memory occupied by heap objects, live or not yet
+// swept, without stopping the world as runtime.ReadMemStats does.
+func heapBytes() uint64 {
+	sample := []rtmetrics.Sample{{Name: heapObjectsMetric}}
+	rtmetrics.Read(sample)
+	if sample[0].Value.Kind() != rtmetrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
+
+// memoryJob is the memory job. It measures the heap and, above
+// -memory-limit after a forced collection, applies -memory-policy: reject
+// turns writes away, evict deletes the keys changed longest ago (on the
+// lease holder only; a standby gets the deletes through replication) and
+// gc also returns the freed memory to the operating system.
+func (s *Server) memoryJob(ctx context.Context) error {
+	limit := uint64(s.cfg.MemoryLimit << 20)
+	wasOver := s.memory.pressure.Load()
+	heap := heapBytes()
+	if heap > limit || wasOver {
+		// Much of the heap may be garbage not collected yet; only what
+		// survives a collection counts.
+		runtime.GC()
+		s.memory.gcs.Add(1)
+		heap = heapBytes()
+	}
+	s.memory.heap.Store(heap)
+
+	over := heap > limit || (wasOver && float64(heap) > memoryLowWater*float64(limit))
+	if !over {
+		s.memory.stuck = 0
+		if wasOver {
+			s.memory.pressure.Store(false)
+			log.Printf("[MEMORY] heap %d MB is below the limit again\n", heap>>20)
+		}
+		return nil
+	}
+	if !wasOver {
+		s.memory.pressure.Store(true)
+		log.Printf("[MEMORY] heap %d MB is over -memory-limit %d MB, policy %s\n", heap>>20, s.cfg.MemoryLimit, s.cfg.MemoryPolicy)
+	}
+
+	switch s.cfg.MemoryPolicy {
+	case "gc":
+		debug.FreeOSMemory()
+	case "evict":
+		if s.isStandby() {
+			return nil
+		}
+		if s.memory.stuck != 0 && float64(heap) < float64(s.memory.stuck)+memoryMinGain*float64(limit) {
+			return nil
+		}
+		return s.evictKeys(ctx, heap, limit)
+	}
+	return nil
+}
+
+// evictKeys deletes the least recently changed keys in batches until the
+// heap, measured after a collection, is below the low-water mark. It stops
+// when a batch frees next to nothing: the store's maps keep their size
+// when keys are deleted, so past some point evicting would empty the store
+// without helping.
+func (s *Server) evictKeys(ctx context.Context, heap, limit uint64) error {
+	for round := 0; round < memoryEvictRounds; round++ {
+		cutoff := time.Now()
+		keys, err := s.store.LeastRecentlyModified(ctx, memoryEvictBatch)
+		if err != nil || len(keys) == 0 {
+			return err
+		}
+		n, err := s.store.DeleteModifiedBefore(ctx, keys, cutoff)
+		s.memory.evicted.Add(int64(n))
+		if err != nil {
+			return err
+		}
+		log.Printf("[MEMORY] evicted %d keys\n", n)
+		jobs.Heartbeat(ctx)
+
+		runtime.GC()
+		s.memory.gcs.Add(1)
+		before := heap
+		heap = heapBytes()
+		s.memory.heap.Store(heap)
+		switch {
+		case float64(heap) <= memoryLowWater*float64(limit):
+			s.memory.pressure.Store(false)
+			log.Printf("[MEMORY] heap %d MB is below the limit again\n", heap>>20)
+			return nil
+		case float64(heap)+memoryMinGain*float64(limit) > float64(before):
+			s.memory.stuck = heap
+			log.Printf("[MEMORY] evicting does not free memory at %d MB; stopped until the heap grows\n", heap>>20)
+			return nil
+		}
+	}
+	return nil
+}
+
+// memoryGate rejects writes with 503 while the heap is over -memory-limit
+// under the reject policy. Deletes pass, since they free memory.
+func (s *Server) memoryGate(next http.HandlerFunc) http.HandlerFunc {
+	if s.cfg.MemoryLimit == 0 || s.cfg.MemoryPolicy != "reject" {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete || !s.memory.pressure.Load() {
+			next(w, r)
+			return
+		}
+		s.IncrementRequests()
+		s.memory.rejected.Add(1)
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(s.cfg.MemoryInterval.Seconds()))))
+		http.Error(w, "Memory limit exceeded: writes are rejected", http.StatusServiceUnavailable)
+	}
+}
+
+type memoryStats struct {
+	HeapBytes   uint64 `json:"heap_bytes"`
+	LimitBytes  uint64 `json:"limit_bytes"`
+	Policy      string `json:"policy"`
+	Pressure    bool   `json:"pressure"`
+	EvictedKeys int64  `json:"evicted_keys"`
+	Rejected    int64  `json:"rejected_writes"`
+	ForcedGCs   int64  `json:"forced_gcs"`
+}
+
+func (s *Server) memoryStats() memoryStats {
+	return memoryStats{
+		HeapBytes:   s.memory.heap.Load(),
+		LimitBytes:  uint64(s.cfg.MemoryLimit << 20),
+		Policy:      s.cfg.MemoryPolicy,
+		Pressure:    s.memory.pressure.Load(),
+		EvictedKeys: s.memory.evicted.Load(),
+		Rejected:    s.memory.rejected.Load(),
+		ForcedGCs:   s.memory.gcs.Load(),
+	}
+}
+
+func (s *Server) collectMemoryMetrics() []metrics.Family {
+	st := s.memoryStats()
+	pressure := 0.0
+	if st.Pressure {
+		pressure = 1
+	}
+	return []metrics.Family{
+		metrics.Single("kv_memory_heap_bytes", "Heap size at the last memory check.", metrics.TypeGauge, float64(st.HeapBytes)),
+		metrics.Single("kv_memory_limit_bytes", "Heap size above which -memory-policy applies.", metrics.TypeGauge, float64(st.LimitBytes)),
+		metrics.Single("kv_memory_pressure", "1 while the heap is over -memory-limit.", metrics.TypeGauge, pressure),
+		metrics.Single("kv_memory_evicted_keys_total", "Keys deleted to bring the heap under -memory-limit.", metrics.TypeCounter, float64(st.EvictedKeys)),
+		metrics.Single("kv_memory_rejected_writes_total", "Writes rejected with 503 over -memory-limit.", metrics.TypeCounter, float64(st.Rejected)),
+		metrics.Single("kv_memory_forced_gcs_total", "Garbage collections forced to measure or relieve memory pressure.", metrics.TypeCounter, float64(st.ForcedGCs)),
+	}
+}
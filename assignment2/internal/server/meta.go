@@ -0,0 +1,72 @@
+package server
+
+import (
+	"assignment2/internal/storage"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+type metaResponse struct {
+	Key          string     `json:"key"`
+	Revision     uint64     `json:"revision"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	Size         int        `json:"size"`
+	SHA256       string     `json:"sha256"`
+	ETag         string     `json:"etag"`
+	Tags         []string   `json:"tags"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	TTLRemaining *float64   `json:"ttl_remaining_seconds,omitempty"`
+
+	Encryption *storage.Encryption `json:"encryption,omitempty"`
+}
+
+// GET /data/{key}/meta
+//
+// Everything known about the key except its value: when it was created
+// and last changed, its revision, size in bytes, SHA-256 and ETag, tags
+// and, with a time to live, when it expires, and for a value the client
+// encrypted, how. The ETag and revision
+// headers match GET /data/{key}, so tools can validate a cached value
+// without fetching it; so do Last-Modified, Cache-Control and the 304
+// answers to conditional requests.
+func (s *Server) GetMeta(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	key := r.PathValue("key")
+	s.noteRead(scopedKey(r, key))
+	info, ok, err := s.store.GetMeta(r.Context(), scopedKey(r, key))
+	if err != nil {
+		storeFailed(w, "Failed to read: ", err)
+		return
+	}
+	if !ok {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+
+	sum := sha256.Sum256([]byte(info.Value))
+	resp := metaResponse{
+		Key:       key,
+		Revision:  info.Revision,
+		CreatedAt: info.Created.UTC(),
+		UpdatedAt: info.Modified.UTC(),
+		Size:      len(info.Value),
+		SHA256:    hex.EncodeToString(sum[:]),
+		ETag:      etag(info.Value),
+		Tags:      info.Tags,
+
+		Encryption: info.Encryption,
+	}
+	if !info.ExpiresAt.IsZero() {
+		remaining := max(0, time.Until(info.ExpiresAt).Seconds())
+		resp.ExpiresAt = &info.ExpiresAt
+		resp.TTLRemaining = &remaining
+	}
+	if s.setCacheHeaders(w, r, key, resp.ETag, info.Revision, info.Modified) {
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
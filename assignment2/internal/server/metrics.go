@@ -0,0 +1,136 @@
+package server
+
+import (
+	"assignment2/internal/metrics"
+	"net/http"
+	"strings"
+)
+
+func (s *Server) registerMetrics() {
+	s.metrics.Register(metrics.CollectorFunc(func() []metrics.Family {
+		req, size, uptime := s.Stats()
+		return []metrics.Family{
+			metrics.Single("kv_requests_total", "Total number of handled requests.", metrics.TypeCounter, float64(req)),
+			metrics.Single("kv_db_keys", "Number of keys in the store.", metrics.TypeGauge, float64(size)),
+			metrics.Single("kv_uptime_seconds", "Seconds since the server started.", metrics.TypeGauge, float64(uptime)),
+		}
+	}))
+
+	s.metrics.Register(metrics.CollectorFunc(s.collectBuildMetrics))
+	s.metrics.Register(metrics.CollectorFunc(s.collectJobMetrics))
+	s.metrics.Register(metrics.CollectorFunc(s.collectConnMetrics))
+	if s.tenants != nil {
+		s.metrics.Register(s.tenantRequests)
+		s.metrics.Register(metrics.CollectorFunc(s.collectTenantMetrics))
+	}
+	if s.listCache != nil {
+		s.metrics.Register(metrics.CollectorFunc(s.collectListCacheMetrics))
+	}
+	s.metrics.Register(metrics.CollectorFunc(s.collectConcurrencyMetrics))
+	if s.lanes != nil {
+		s.metrics.Register(metrics.CollectorFunc(s.collectPriorityMetrics))
+	}
+	if s.throttle != nil {
+		s.metrics.Register(metrics.CollectorFunc(s.collectThrottleMetrics))
+	}
+	if s.archive != nil {
+		s.metrics.Register(metrics.CollectorFunc(s.collectArchiveMetrics))
+	}
+	s.metrics.Register(metrics.CollectorFunc(s.collectWatchMetrics))
+	s.metrics.Register(metrics.CollectorFunc(s.collectTTLMetrics))
+	if s.cfg.MemoryLimit > 0 {
+		s.metrics.Register(metrics.CollectorFunc(s.collectMemoryMetrics))
+	}
+	if s.cfg.TenantQuota > 0 {
+		s.metrics.Register(metrics.CollectorFunc(s.collectQuotaMetrics))
+	}
+	if len(s.cfg.Secondaries) > 0 {
+		s.metrics.Register(metrics.CollectorFunc(s.collectSecondaryMetrics))
+	}
+	if s.cfg.TierBudget > 0 {
+		s.metrics.Register(metrics.CollectorFunc(s.collectTierMetrics))
+	}
+	if s.cfg.DataDir != "" {
+		s.metrics.Register(metrics.CollectorFunc(s.collectWALMetrics))
+		s.metrics.Register(metrics.CollectorFunc(s.collectPersistenceMetrics))
+	}
+	s.metrics.Register(metrics.CollectorFunc(s.collectIntegrityMetrics))
+	if s.cfg.ContentionDepth > 0 {
+		s.metrics.Register(metrics.CollectorFunc(s.collectContentionMetrics))
+	}
+	s.metrics.Register(s.ipDenied)
+	s.metrics.Register(s.slowRequests)
+	s.metrics.Register(s.requestDuration)
+	if len(s.cfg.SLOs) > 0 {
+		s.metrics.Register(metrics.CollectorFunc(s.collectSLOMetrics))
+	}
+	if len(s.cfg.Faults) > 0 {
+		s.metrics.Register(s.faultsInjected)
+	}
+	if s.mirror != nil {
+		s.metrics.Register(s.mirror.results)
+	}
+}
+
+func (s *Server) collectJobMetrics() []metrics.Family {
+	runs := metrics.Family{Name: "kv_job_runs_total", Help: "Completed runs per background job.", Type: metrics.TypeCounter}
+	failures := metrics.Family{Name: "kv_job_failures_total", Help: "Failed runs per background job.", Type: metrics.TypeCounter}
+	total := metrics.Family{Name: "kv_job_duration_seconds_total", Help: "Total time spent running each job.", Type: metrics.TypeCounter}
+	last := metrics.Family{Name: "kv_job_last_duration_seconds", Help: "Duration of the most recent run.", Type: metrics.TypeGauge}
+	lastRun := metrics.Family{Name: "kv_job_last_run_timestamp_seconds", Help: "Unix time of the most recent run.", Type: metrics.TypeGauge}
+	running := metrics.Family{Name: "kv_job_running", Help: "1 while the job is running.", Type: metrics.TypeGauge}
+	healthy := metrics.Family{Name: "kv_job_healthy", Help: "0 while the job has stalled or its goroutine stopped.", Type: metrics.TypeGauge}
+	restarts := metrics.Family{Name: "kv_job_restarts_total", Help: "Times the watchdog restarted the job.", Type: metrics.TypeCounter}
+	deferred := metrics.Family{Name: "kv_job_deferred_total", Help: "Scheduled runs skipped while maintenance jobs were paused.", Type: metrics.TypeCounter}
+
+	for _, st := range s.jobs.Stats() {
+		labels := []metrics.Label{{Name: "job", Value: st.Name}}
+
+		runs.Samples = append(runs.Samples, metrics.Sample{Labels: labels, Value: float64(st.Runs)})
+		failures.Samples = append(failures.Samples, metrics.Sample{Labels: labels, Value: float64(st.Failures)})
+		total.Samples = append(total.Samples, metrics.Sample{Labels: labels, Value: st.TotalDuration.Seconds()})
+		last.Samples = append(last.Samples, metrics.Sample{Labels: labels, Value: st.LastDuration.Seconds()})
+
+		var ts float64
+		if !st.LastRun.IsZero() {
+			ts = float64(st.LastRun.UnixNano()) / 1e9
+		}
+		lastRun.Samples = append(lastRun.Samples, metrics.Sample{Labels: labels, Value: ts})
+
+		var r float64
+		if st.Running {
+			r = 1
+		}
+		running.Samples = append(running.Samples, metrics.Sample{Labels: labels, Value: r})
+
+		var h float64
+		if st.Healthy() {
+			h = 1
+		}
+		healthy.Samples = append(healthy.Samples, metrics.Sample{Labels: labels, Value: h})
+		restarts.Samples = append(restarts.Samples, metrics.Sample{Labels: labels, Value: float64(st.Restarts)})
+		deferred.Samples = append(deferred.Samples, metrics.Sample{Labels: labels, Value: float64(st.Deferred)})
+	}
+
+	var paused float64
+	if s.jobs.Paused().Paused {
+		paused = 1
+	}
+	return []metrics.Family{runs, failures, total, last, lastRun, running, healthy, restarts, deferred,
+		metrics.Single("kv_jobs_paused", "1 while maintenance jobs are paused by -job-pause-rps.", metrics.TypeGauge, paused),
+	}
+}
+
+// GET /metrics
+//
+// Scrapers that accept application/openmetrics-text get OpenMetrics,
+// which includes exemplars; others the Prometheus text format.
+func (s *Server) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text") {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		s.metrics.WriteOpenMetrics(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.WriteText(w)
+}
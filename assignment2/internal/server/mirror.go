@@ -0,0 +1,169 @@
+package server
+
+import (
+	"assignment2/internal/metrics"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mirrorWorkers is how many mirrored requests are in flight at once.
+const mirrorWorkers = 4
+
+// maxMirroredBody is the largest request body that is mirrored; bigger
+// writes (blob parts, mostly) are skipped.
+const maxMirroredBody = 10 << 20
+
+// mirrorHeader marks mirrored requests, so the secondary can tell them
+// apart and a secondary that mirrors too doesn't send them on.
+const mirrorHeader = "X-Mirrored-From"
+
+// mirroredRequest is a write copied for the secondary.
+type mirroredRequest struct {
+	method string
+	uri    string
+	header http.Header
+	body   []byte
+}
+
+// mirror sends a share of the write traffic to a secondary server in the
+// background, to try a new version or backend with real requests. The
+// primary never waits for it: requests are queued after they have been
+// answered, dropped when the queue is full, and failures are only logged
+// and counted.
+type mirror struct {
+	target  string
+	percent float64
+	client  *http.Client
+	origin  string
+
+	queue chan mirroredRequest
+	wg    sync.WaitGroup
+
+	results *metrics.Vec
+}
+
+func newMirror(target string, percent float64, timeout time.Duration, queueSize int, origin string) *mirror {
+	m := &mirror{
+		target:  strings.TrimSuffix(target, "/"),
+		percent: percent,
+		client:  &http.Client{Timeout: timeout},
+		origin:  origin,
+		queue:   make(chan mirroredRequest, queueSize),
+		results: metrics.NewCounterVec("kv_mirror_requests_total", "Writes mirrored to -mirror-url, by outcome.", "result"),
+	}
+	for i := 0; i < mirrorWorkers; i++ {
+		m.wg.Add(1)
+		go m.run()
+	}
+	return m
+}
+
+// mirrorable reports whether r is a write to mirror: data and script
+// changes, but not cluster traffic, admin calls or requests that were
+// mirrored to us.
+func mirrorable(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	if strings.HasPrefix(r.URL.Path, "/cluster/") || strings.HasPrefix(r.URL.Path, "/admin/") {
+		return false
+	}
+	return r.Header.Get(mirrorHeader) == ""
+}
+
+// mirrorWrites is the middleware that samples and queues writes.
+func (s *Server) mirrorWrites(next http.Handler) http.Handler {
+	if s.mirror == nil {
+		return next
+	}
+	m := s.mirror
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !mirrorable(r) || rand.Float64()*100 >= m.percent {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > maxMirroredBody {
+			m.results.Inc("skipped")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxMirroredBody+1))
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		if err != nil || len(body) > maxMirroredBody {
+			m.results.Inc("skipped")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		req := mirroredRequest{method: r.Method, uri: sentRequest(r).URL.RequestURI(), header: r.Header.Clone(), body: body}
+		req.header.Set(HeaderRequestID, RequestInfoFrom(r.Context()).ID)
+		next.ServeHTTP(w, r)
+
+		select {
+		case m.queue <- req:
+		default:
+			m.results.Inc("dropped")
+		}
+	})
+}
+
+func (m *mirror) run() {
+	defer m.wg.Done()
+
+	for req := range m.queue {
+		if err := m.send(req); err != nil {
+			m.results.Inc("error")
+			log.Printf("[MIRROR] %s %s: %v\n", req.method, req.uri, err)
+			continue
+		}
+		m.results.Inc("ok")
+	}
+}
+
+func (m *mirror) send(req mirroredRequest) error {
+	out, err := http.NewRequestWithContext(context.Background(), req.method, m.target+req.uri, bytes.NewReader(req.body))
+	if err != nil {
+		return err
+	}
+	out.Header = req.header
+	out.Header.Set(mirrorHeader, m.origin)
+
+	resp, err := m.client.Do(out)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("secondary answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Close stops accepting requests and gives the queued ones up to
+// -mirror-timeout to go out. It must only be called once the server has
+// stopped handling requests.
+func (m *mirror) Close() {
+	close(m.queue)
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(m.client.Timeout):
+	}
+}
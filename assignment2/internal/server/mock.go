@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mockFixture is a canned response of -mock. Path is matched exactly,
+// or as a prefix when it ends in "*"; an empty Method matches any.
+// Body is sent as JSON, Text as plain text.
+type mockFixture struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Status  int               `json:"status"`
+	Latency string            `json:"latency"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+	Text    string            `json:"text"`
+
+	latency time.Duration
+}
+
+// loadMockFixtures reads a -mock file: a JSON array of fixtures, tried in
+// order.
+func loadMockFixtures(path string) ([]*mockFixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("-mock: %w", err)
+	}
+	var fixtures []*mockFixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("-mock %s: %w", path, err)
+	}
+	for i, f := range fixtures {
+		if !strings.HasPrefix(f.Path, "/") {
+			return nil, fmt.Errorf("-mock %s: fixture %d: path must start with /", path, i+1)
+		}
+		f.Method = strings.ToUpper(f.Method)
+		if f.Status == 0 {
+			f.Status = http.StatusOK
+		}
+		if f.Status < 100 || f.Status > 599 {
+			return nil, fmt.Errorf("-mock %s: fixture %d: invalid status %d", path, i+1, f.Status)
+		}
+		if f.Latency != "" {
+			if f.latency, err = time.ParseDuration(f.Latency); err != nil || f.latency < 0 {
+				return nil, fmt.Errorf("-mock %s: fixture %d: invalid latency %q", path, i+1, f.Latency)
+			}
+		}
+		if len(f.Body) > 0 && f.Text != "" {
+			return nil, fmt.Errorf("-mock %s: fixture %d: body and text are exclusive", path, i+1)
+		}
+	}
+	return fixtures, nil
+}
+
+func (f *mockFixture) match(r *http.Request) bool {
+	if f.Method != "" && f.Method != r.Method {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(f.Path, "*"); ok {
+		return strings.HasPrefix(r.URL.Path, prefix)
+	}
+	return r.URL.Path == f.Path
+}
+
+// mockResponses answers requests matching a -mock fixture with its
+// canned response, after its latency, so that client teams can test
+// against fixed behaviour without seeding data. Other requests reach the
+// real handlers.
+func (s *Server) mockResponses(next http.Handler) http.Handler {
+	if len(s.mocks) == 0 {
+		return next
+	}
+	log.Printf("[WARN] mock mode: %d fixture(s) from %s answer matching requests; do not use in production\n", len(s.mocks), s.cfg.Mock)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i, f := range s.mocks {
+			if !f.match(r) {
+				continue
+			}
+			s.IncrementRequests()
+			if f.latency > 0 {
+				t := time.NewTimer(f.latency)
+				select {
+				case <-t.C:
+				case <-r.Context().Done():
+					t.Stop()
+					return
+				}
+			}
+			h := w.Header()
+			for name, v := range f.Headers {
+				h.Set(name, v)
+			}
+			h.Set("X-Mock-Fixture", strconv.Itoa(i+1))
+			if h.Get("Content-Type") == "" {
+				switch {
+				case len(f.Body) > 0:
+					h.Set("Content-Type", "application/json")
+				case f.Text != "":
+					h.Set("Content-Type", "text/plain; charset=utf-8")
+				}
+			}
+			w.WriteHeader(f.Status)
+			if len(f.Body) > 0 {
+				w.Write(f.Body)
+				w.Write([]byte("\n"))
+			} else {
+				io.WriteString(w, f.Text)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// patchMediaType is the Content-Type of a JSON Patch document.
+const patchMediaType = "application/json-patch+json"
+
+// patchOp is one operation of a JSON Patch (RFC 6902). Values are kept
+// encoded and decoded each time the patch is applied, so that a retried
+// update never sees a value a previous attempt modified.
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+
+	tokens []string
+}
+
+type patchResponse struct {
+	Key      string          `json:"key"`
+	Revision uint64          `json:"revision"`
+	Value    json.RawMessage `json:"value"`
+}
+
+// patchError is why a patch could not be applied to the stored
+// document.
+type patchError struct {
+	msg string
+}
+
+func (e *patchError) Error() string {
+	return "Patch failed: " + e.msg
+}
+
+func patchFailed(format string, args ...interface{}) error {
+	return &patchError{msg: fmt.Sprintf(format, args...)}
+}
+
+// PATCH /data/{key}
+//
+// Applies a JSON Patch (Content-Type application/json-patch+json) to the
+// key's value, which must be a JSON document. add, remove and replace
+// are supported. The operations are applied in order while the store is
+// locked, and either all of them take effect or none does: a patch that
+// does not fit the document (a missing path, an index out of range) is
+// answered with 409 and the key is left alone. The answer carries the
+// patched document and its revision.
+func (s *Server) PatchData(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != patchMediaType {
+		http.Error(w, "Content-Type must be "+patchMediaType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var ops []patchOp
+	if err := readJSON(r.Body, &ops); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	for i := range ops {
+		if err := ops[i].parse(); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid patch operation %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	key := r.PathValue("key")
+	var patched string
+	apply := func(old string, exists bool) (string, bool, error) {
+		if !exists {
+			return "", false, errNotFound
+		}
+		value, err := applyPatch(old, ops)
+		if err != nil {
+			return "", false, err
+		}
+		if len(s.validators) > 0 {
+			if err := s.validateWrite(r.Context(), key, value); err != nil {
+				return "", false, err
+			}
+		}
+		patched = value
+		return value, true, nil
+	}
+
+	var (
+		rev uint64
+		err error
+	)
+	if len(s.validators) == 0 {
+		rev, err = s.store.Update(r.Context(), scopedKey(r, key), apply)
+	} else {
+		rev, err = s.updateValidated(r.Context(), scopedKey(r, key), apply)
+	}
+	if writeRejected(w, err) {
+		return
+	}
+	var perr *patchError
+	switch {
+	case err == nil:
+	case err == errNotFound:
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	case err == errEvalConflict:
+		s.conflictOutcome(w, r, http.StatusConflict, key, scopedKey(r, key))
+		return
+	case errors.As(err, &perr):
+		http.Error(w, perr.Error(), http.StatusConflict)
+		return
+	default:
+		storeFailed(w, "Failed to persist: ", err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(patched))
+	setRevision(w, rev)
+	writeJSON(w, http.StatusOK, patchResponse{Key: key, Revision: rev, Value: json.RawMessage(patched)})
+}
+
+// parse checks the operation and splits its path into reference tokens.
+func (op *patchOp) parse() error {
+	switch op.Op {
+	case "add", "replace":
+		if len(op.Value) == 0 {
+			return fmt.Errorf("%s needs a value", op.Op)
+		}
+	case "remove":
+	case "":
+		return errors.New("op required")
+	default:
+		return fmt.Errorf("unsupported op %q", op.Op)
+	}
+
+	tokens, err := parsePointer(op.Path)
+	if err != nil {
+		return err
+	}
+	if op.Op == "remove" && len(tokens) == 0 {
+		return errors.New("cannot remove the whole document")
+	}
+	op.tokens = tokens
+	return nil
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped
+// reference tokens; "" is the whole document.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, fmt.Errorf("path %q must start with /", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// applyPatch applies ops to the JSON document doc and returns the result
+// encoded. Numbers keep their original text.
+func applyPatch(doc string, ops []patchOp) (string, error) {
+	root, err := decodePatchValue([]byte(doc))
+	if err != nil {
+		return "", patchFailed("stored value is not JSON")
+	}
+
+	for i, op := range ops {
+		value, err := decodePatchValue(op.Value)
+		if err != nil && op.Op != "remove" {
+			return "", patchFailed("operation %d: invalid value", i)
+		}
+		if len(op.tokens) == 0 {
+			root = value
+			continue
+		}
+		if root, err = patchAt(root, op.tokens, op.Op, value); err != nil {
+			return "", patchFailed("operation %d (%s %s): %v", i, op.Op, op.Path, err)
+		}
+	}
+
+	b, err := json.Marshal(root)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func decodePatchValue(raw []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// patchAt applies op at the path below node and returns node, which is a
+// new slice if an array was grown or shrunk.
+func patchAt(node interface{}, tokens []string, op string, value interface{}) (interface{}, error) {
+	tok, last := tokens[0], len(tokens) == 1
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[tok]
+		if last {
+			switch {
+			case op == "add":
+				n[tok] = value
+			case !ok:
+				return nil, fmt.Errorf("member %q not found", tok)
+			case op == "remove":
+				delete(n, tok)
+			default:
+				n[tok] = value
+			}
+			return n, nil
+		}
+		if !ok {
+			return nil, fmt.Errorf("member %q not found", tok)
+		}
+		child, err := patchAt(child, tokens[1:], op, value)
+		if err != nil {
+			return nil, err
+		}
+		n[tok] = child
+		return n, nil
+
+	case []interface{}:
+		i, err := arrayIndex(tok, len(n), last && op == "add")
+		if err != nil {
+			return nil, err
+		}
+		if last {
+			switch op {
+			case "add":
+				n = append(n, nil)
+				copy(n[i+1:], n[i:])
+				n[i] = value
+			case "remove":
+				n = append(n[:i], n[i+1:]...)
+			default:
+				n[i] = value
+			}
+			return n, nil
+		}
+		child, err := patchAt(n[i], tokens[1:], op, value)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = child
+		return n, nil
+	}
+	return nil, fmt.Errorf("%q is not inside an object or array", tok)
+}
+
+// arrayIndex resolves an array reference token against an array of size
+// n. "-" and n itself, the position after the last element, are only
+// valid when adding.
+func arrayIndex(tok string, n int, adding bool) (int, error) {
+	if tok == "-" && adding {
+		return n, nil
+	}
+	if tok == "" || strings.TrimLeft(tok, "0123456789") != "" || (len(tok) > 1 && tok[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", tok)
+	}
+	i, err := strconv.Atoi(tok)
+	if err != nil {
+		return 0, fmt.Errorf("invalid array index %q", tok)
+	}
+	if i > n || (i == n && !adding) {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"assignment2/internal/auth"
+	"crypto/tls"
+	"net/http"
+	"time"
+)
+
+// setupPeerTLS sets up TLS between peers apart from the client-facing
+// configuration: peers verify each other's serving certificates against
+// -peer-tls-ca and present -peer-tls-cert (the listener's by default).
+// Where the listener verifies client certificates, which -peer-mtls turns
+// on, it also accepts those signed by -peer-tls-ca; peerOnly can then
+// require them.
+func (s *Server) setupPeerTLS(listenerCert tls.Certificate) error {
+	var err error
+	if s.peerCAs, err = auth.LoadCertPool(s.cfg.PeerTLSCA); err != nil {
+		return err
+	}
+
+	cert := listenerCert
+	if s.cfg.PeerTLSCert != "" {
+		if cert, err = tls.LoadX509KeyPair(s.cfg.PeerTLSCert, s.cfg.PeerTLSKey); err != nil {
+			return err
+		}
+	}
+
+	if s.cfg.PeerMTLS || s.clientCAs != nil {
+		// Handshakes verify against both CAs; authenticate tells peers
+		// and clients apart afterwards.
+		paths := []string{s.cfg.PeerTLSCA}
+		if s.cfg.TLSClientCA != "" {
+			paths = append(paths, s.cfg.TLSClientCA)
+		}
+		if s.tlsConfig.ClientCAs, err = auth.LoadCertPool(paths...); err != nil {
+			return err
+		}
+		if s.tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+			s.tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	s.peerScheme = "https"
+	s.peerClient = &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      s.peerCAs,
+		}},
+	}
+	return nil
+}
+
+// peerOnly guards the endpoints only peers use (join and replication).
+// With -peer-tls-ca they refuse plain HTTP and, with -peer-mtls, callers
+// without a peer certificate.
+func (s *Server) peerOnly(next http.HandlerFunc) http.HandlerFunc {
+	if s.cfg.PeerTLSCA == "" {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil {
+			s.IncrementRequests()
+			http.Error(w, "Peer endpoints require TLS", http.StatusForbidden)
+			return
+		}
+		if s.cfg.PeerMTLS {
+			if p := RequestInfoFrom(r.Context()).Principal; p == nil || p.Method != "peer" {
+				s.IncrementRequests()
+				http.Error(w, "Peer certificate required", http.StatusForbidden)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
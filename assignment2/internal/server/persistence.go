@@ -0,0 +1,67 @@
+package server
+
+import (
+	"assignment2/internal/metrics"
+	"assignment2/internal/storage"
+	"log"
+	"time"
+)
+
+// walHealth is storage.WALHealth for /stats and /readyz.
+type walHealth struct {
+	Degraded bool       `json:"degraded"`
+	Policy   string     `json:"policy"`
+	Error    string     `json:"error,omitempty"`
+	Since    *time.Time `json:"since,omitempty"`
+	Buffered int64      `json:"buffered_bytes"`
+	Writable bool       `json:"writable"`
+}
+
+func (s *Server) walHealth() (walHealth, bool) {
+	h, ok := s.store.LogHealth()
+	if !ok {
+		return walHealth{}, false
+	}
+	out := walHealth{
+		Degraded: h.Err != nil,
+		Policy:   s.cfg.WALFailurePolicy,
+		Buffered: h.Buffered,
+		Writable: s.store.Writable(),
+	}
+	if h.Err != nil {
+		out.Error = h.Err.Error()
+		out.Since = &h.Since
+	}
+	return out, true
+}
+
+// logWALHealth is told when log writes start failing and when they
+// recover.
+func (s *Server) logWALHealth(h storage.WALHealth) {
+	if h.Err != nil {
+		what := "writes are refused until it recovers"
+		if s.cfg.WALFailurePolicy == string(storage.BufferWrites) {
+			what = "writes are buffered in memory until it recovers"
+		}
+		log.Printf("[WAL] write-ahead log unavailable, %s: %v\n", what, h.Err)
+		return
+	}
+	log.Printf("[WAL] write-ahead log recovered\n")
+}
+
+func (s *Server) collectPersistenceMetrics() []metrics.Family {
+	h, _ := s.store.LogHealth()
+	var degraded, readOnly float64
+	if h.Err != nil {
+		degraded = 1
+	}
+	if !s.store.Writable() {
+		readOnly = 1
+	}
+	return []metrics.Family{
+		metrics.Single("kv_wal_degraded", "1 while the write-ahead log can't be written.", metrics.TypeGauge, degraded),
+		metrics.Single("kv_wal_read_only", "1 while writes are refused because the write-ahead log can't be written.", metrics.TypeGauge, readOnly),
+		metrics.Single("kv_wal_buffered_bytes", "Records waiting to be written to the write-ahead log.", metrics.TypeGauge, float64(h.Buffered)),
+		metrics.Single("kv_wal_write_failures_total", "Failed write-ahead log writes and fsyncs.", metrics.TypeCounter, float64(h.Failures)),
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"assignment2/internal/storage"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxPooledBuffer keeps the occasional huge response from pinning memory
+// in the pool.
+const maxPooledBuffer = 64 << 10
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
+
+// writeJSON encodes v into a pooled buffer and writes it with a known
+// Content-Length, instead of streaming through a fresh encoder.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	writeJSONBytes(w, status, buf.Bytes())
+}
+
+// writeJSONBytes writes an already encoded JSON response.
+func writeJSONBytes(w http.ResponseWriter, status int, body []byte) {
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// readJSON reads the body into a pooled buffer and unmarshals it, which
+// avoids allocating a decoder and its internal buffer per request. The
+// handlers used to decode with a json.Decoder, which reads the first value
+// and ignores what follows it; a body Unmarshal rejects is decoded that
+// way again, so trailing data is still accepted and an empty body is
+// still io.EOF.
+func readJSON(r io.Reader, v interface{}) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if _, err := buf.ReadFrom(r); err != nil {
+		return err
+	}
+	err := json.Unmarshal(buf.Bytes(), v)
+	if _, ok := err.(*json.SyntaxError); ok {
+		return json.NewDecoder(bytes.NewReader(buf.Bytes())).Decode(v)
+	}
+	return err
+}
+
+// Response shapes of the hot handlers. Structs encode without building a
+// map per request; the field order matches the sorted map keys they
+// replace.
+type storedResponse struct {
+	Revision  uint64     `json:"revision"`
+	Status    string     `json:"status"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type keyResponse struct {
+	Key       string     `json:"key"`
+	Revision  uint64     `json:"revision"`
+	Value     string     `json:"value"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	Encryption *storage.Encryption `json:"encryption,omitempty"`
+}
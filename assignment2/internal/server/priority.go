@@ -0,0 +1,106 @@
+package server
+
+import (
+	"assignment2/internal/auth"
+	"assignment2/internal/limit"
+	"assignment2/internal/metrics"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// HeaderPriority asks for a priority class (high, normal or low) under
+// -max-in-flight.
+const HeaderPriority = "X-Priority"
+
+var priorityRank = map[string]int{"low": 1, "normal": 2, "high": 3}
+
+// longLivedRoutes stream for as long as the client stays, so they do
+// not take a slot in the priority lanes.
+var longLivedRoutes = map[string]bool{
+	"GET /watch":         true,
+	"GET /cluster/watch": true,
+	"POST /v3/watch":     true,
+}
+
+// operatorRoute reports whether route serves probes, scrapers or
+// operators, which always get the high lane.
+func operatorRoute(route string) bool {
+	switch route {
+	case "GET /healthz", "GET /readyz", "GET /healthz/jobs", "GET /metrics", "GET /debug/vars", "GET /version":
+		return true
+	}
+	return strings.Contains(route, " /admin/")
+}
+
+// requestClass is the priority lane of a request: its API key's
+// priority, or normal, lowered by an X-Priority header. Without
+// -api-keys-file the header may also raise it, since there is no way to
+// tell clients apart.
+func (s *Server) requestClass(r *http.Request) string {
+	class, ceiling := "normal", "high"
+	if s.tenants != nil {
+		ceiling = class
+		if tk, ok := s.tenants[auth.APIKey(r)]; ok && tk.Priority != "" {
+			class, ceiling = tk.Priority, tk.Priority
+		}
+	}
+	if h := r.Header.Get(HeaderPriority); priorityRank[h] > 0 && priorityRank[h] <= priorityRank[ceiling] {
+		class = h
+	}
+	return class
+}
+
+// prioritize queues the requests for route beyond -max-in-flight in the
+// lane of their class, for up to -priority-wait before a 503.
+func (s *Server) prioritize(route string, next http.HandlerFunc) http.HandlerFunc {
+	if s.lanes == nil || longLivedRoutes[route] {
+		return next
+	}
+
+	operator := operatorRoute(route)
+	return func(w http.ResponseWriter, r *http.Request) {
+		class := "high"
+		if !operator {
+			class = s.requestClass(r)
+		}
+		if !s.lanes.Acquire(r.Context(), class, s.cfg.PriorityWait) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Server busy", http.StatusServiceUnavailable)
+			return
+		}
+		defer s.lanes.Release(class)
+		next(w, r)
+	}
+}
+
+func (s *Server) collectPriorityMetrics() []metrics.Family {
+	inFlight := metrics.Family{Name: "kv_priority_in_flight", Help: "Requests running per priority class.", Type: metrics.TypeGauge}
+	waiting := metrics.Family{Name: "kv_priority_waiting", Help: "Requests queued per priority class.", Type: metrics.TypeGauge}
+	admitted := metrics.Family{Name: "kv_priority_admitted_total", Help: "Requests let in per priority class.", Type: metrics.TypeCounter}
+	rejected := metrics.Family{Name: "kv_priority_rejected_total", Help: "Requests that gave up waiting per priority class.", Type: metrics.TypeCounter}
+
+	snap := s.lanes.Snapshot()
+	classes := make([]string, 0, len(snap))
+	for class := range snap {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	for _, class := range classes {
+		labels := []metrics.Label{{Name: "class", Value: class}}
+		inFlight.Samples = append(inFlight.Samples, metrics.Sample{Labels: labels, Value: float64(snap[class].InFlight)})
+		waiting.Samples = append(waiting.Samples, metrics.Sample{Labels: labels, Value: float64(snap[class].Waiting)})
+		admitted.Samples = append(admitted.Samples, metrics.Sample{Labels: labels, Value: float64(snap[class].Admitted)})
+		rejected.Samples = append(rejected.Samples, metrics.Sample{Labels: labels, Value: float64(snap[class].Rejected)})
+	}
+	return []metrics.Family{inFlight, waiting, admitted, rejected}
+}
+
+// newLanes returns the priority lanes, or nil without -max-in-flight.
+func newLanes(s *Server) *limit.Lanes {
+	if s.cfg.MaxInFlight <= 0 {
+		return nil
+	}
+	return limit.NewLanes(s.cfg.MaxInFlight, s.cfg.PriorityWeights)
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// publicKeyResponse is a key as GET /public/data/{key} returns it: the
+// value only, no expiry.
+type publicKeyResponse struct {
+	Key      string `json:"key"`
+	Revision uint64 `json:"revision"`
+	Value    string `json:"value"`
+}
+
+// isPublic reports whether the store key is under one of -public-prefixes.
+func (s *Server) isPublic(key string) bool {
+	for _, p := range s.cfg.PublicPrefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// publicScans returns the prefixes to scan for the public keys starting
+// with prefix: for each public prefix, the longer of the two if one
+// starts with the other.
+func (s *Server) publicScans(prefix string) []string {
+	var scans []string
+	for _, p := range s.cfg.PublicPrefixes {
+		switch {
+		case strings.HasPrefix(prefix, p):
+			return []string{prefix}
+		case strings.HasPrefix(p, prefix):
+			scans = append(scans, p)
+		}
+	}
+	return scans
+}
+
+// publicAccess opens the public routes to any origin, answers CORS
+// preflights, and keeps them in maintenance mode like the other data
+// endpoints.
+func (s *Server) publicAccess(next http.HandlerFunc) http.HandlerFunc {
+	return s.maintenanceGate(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", "*")
+		h.Set("Access-Control-Expose-Headers", "ETag, Last-Modified, X-Revision")
+		if r.Method == http.MethodOptions {
+			h.Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", "If-None-Match")
+			h.Set("Access-Control-Max-Age", "86400")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// writePublic sends body with caching headers, or 304 if the client's
+// copy is current.
+func (s *Server) writePublic(w http.ResponseWriter, r *http.Request, body []byte) {
+	tag := etag(string(body))
+	h := w.Header()
+	h.Set("ETag", tag)
+	h.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(s.cfg.PublicMaxAge.Seconds())))
+	if noneMatch(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSONBytes(w, http.StatusOK, body)
+}
+
+// noneMatch reports whether an If-None-Match header matches tag.
+func noneMatch(header, tag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == tag || t == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// GET /public/data/{key}
+//
+// Returns a key under -public-prefixes to anyone, with an ETag and
+// Cache-Control; other keys are reported as not found.
+func (s *Server) GetPublicKey(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	key := r.PathValue("key")
+	if !s.isPublic(key) {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	entry, ok, err := s.store.GetEntry(r.Context(), key)
+	if err != nil {
+		storeFailed(w, "Failed to read: ", err)
+		return
+	}
+	if !ok {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+
+	body, err := json.Marshal(publicKeyResponse{Key: key, Revision: entry.Revision, Value: entry.Value})
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	setRevision(w, entry.Revision)
+	s.writePublic(w, r, append(body, '\n'))
+}
+
+// GET /public/data
+//
+// Returns the public keys and values, those starting with ?prefix= if
+// given, as one JSON object.
+func (s *Server) GetPublicData(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	var (
+		keys []string
+		rev  uint64
+	)
+	for _, p := range s.publicScans(r.URL.Query().Get("prefix")) {
+		found, current, err := s.store.ScanKeys(r.Context(), p, 0)
+		if err != nil {
+			storeFailed(w, "Failed to read: ", err)
+			return
+		}
+		keys, rev = append(keys, found...), current
+	}
+	entries, err := s.store.GetMany(r.Context(), keys)
+	if err != nil {
+		storeFailed(w, "Failed to read: ", err)
+		return
+	}
+	if entries == nil {
+		entries = map[string]string{}
+	}
+
+	body, err := json.Marshal(entries)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	if rev == 0 {
+		rev = s.store.Revision()
+	}
+	setRevision(w, rev)
+	s.writePublic(w, r, append(body, '\n'))
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"assignment2/internal/metrics"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Quota levels of a tenant.
+const (
+	quotaOK   = "ok"
+	quotaSoft = "soft"
+	quotaHard = "hard"
+)
+
+// quotaWebhookTimeout bounds each -quota-webhook request.
+const quotaWebhookTimeout = 5 * time.Second
+
+// quotaState is each tenant's usage, as of the last quotas job.
+type quotaState struct {
+	mu     sync.Mutex
+	usage  map[string]int64
+	levels map[string]string
+
+	warned        atomic.Int64
+	rejected      atomic.Int64
+	webhookErrors atomic.Int64
+}
+
+// quotaNotice is what -quota-webhook is sent when a tenant's level
+// changes.
+type quotaNotice struct {
+	Tenant    string    `json:"tenant"`
+	Level     string    `json:"level"`
+	Previous  string    `json:"previous"`
+	Usage     int64     `json:"usage_bytes"`
+	SoftLimit int64     `json:"soft_limit_bytes"`
+	Limit     int64     `json:"limit_bytes"`
+	Time      time.Time `json:"time"`
+}
+
+type tenantQuota struct {
+	Usage int64  `json:"usage_bytes"`
+	Level string `json:"level"`
+}
+
+type quotaStats struct {
+	Limit         int64                  `json:"limit_bytes"`
+	SoftLimit     int64                  `json:"soft_limit_bytes"`
+	Warned        int64                  `json:"warned_writes"`
+	Rejected      int64                  `json:"rejected_writes"`
+	WebhookErrors int64                  `json:"webhook_errors"`
+	Tenants       map[string]tenantQuota `json:"tenants"`
+}
+
+func (s *Server) quotaLimit() int64 {
+	return s.cfg.TenantQuota << 20
+}
+
+func (s *Server) softQuotaLimit() int64 {
+	return int64(float64(s.quotaLimit()) * s.cfg.TenantQuotaSoft)
+}
+
+func (s *Server) quotaLevel(usage int64) string {
+	switch {
+	case usage >= s.quotaLimit():
+		return quotaHard
+	case usage >= s.softQuotaLimit():
+		return quotaSoft
+	}
+	return quotaOK
+}
+
+// quotaJob measures every tenant's usage against -tenant-quota and
+// reports the tenants whose level changed to -quota-webhook.
+func (s *Server) quotaJob(ctx context.Context) error {
+	stats, err := s.tenantStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var notices []quotaNotice
+	s.quotas.mu.Lock()
+	if s.quotas.levels == nil {
+		s.quotas.levels = make(map[string]string)
+	}
+	s.quotas.usage = make(map[string]int64, len(stats))
+	for tenant, st := range stats {
+		s.quotas.usage[tenant] = st.Bytes
+		level, prev := s.quotaLevel(st.Bytes), s.quotas.levels[tenant]
+		if prev == "" {
+			prev = quotaOK
+		}
+		s.quotas.levels[tenant] = level
+		if level != prev {
+			notices = append(notices, quotaNotice{
+				Tenant:    tenant,
+				Level:     level,
+				Previous:  prev,
+				Usage:     st.Bytes,
+				SoftLimit: s.softQuotaLimit(),
+				Limit:     s.quotaLimit(),
+				Time:      now,
+			})
+		}
+	}
+	s.quotas.mu.Unlock()
+
+	sort.Slice(notices, func(i, j int) bool { return notices[i].Tenant < notices[j].Tenant })
+	for _, n := range notices {
+		log.Printf("[QUOTA] tenant %s: %s -> %s (%d of %d bytes)\n", n.Tenant, n.Previous, n.Level, n.Usage, n.Limit)
+		if s.cfg.QuotaWebhook != "" {
+			if err := s.notifyQuota(ctx, n); err != nil {
+				s.quotas.webhookErrors.Add(1)
+				log.Printf("[QUOTA] webhook for tenant %s failed: %s\n", n.Tenant, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) notifyQuota(ctx context.Context, n quotaNotice) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.QuotaWebhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := (&http.Client{Timeout: quotaWebhookTimeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// quotaGate rejects writes with 507 from tenants over -tenant-quota, and
+// adds an X-Quota-Warning header to those of tenants past
+// -tenant-quota-soft. Deletes pass, since they free space. Usage is that
+// of the last quotas job, so a tenant can overshoot for up to
+// -quota-interval.
+func (s *Server) quotaGate(next http.HandlerFunc) http.HandlerFunc {
+	if s.cfg.TenantQuota == 0 {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant := RequestInfoFrom(r.Context()).Tenant
+		if tenant == "" || r.Method == http.MethodDelete {
+			next(w, r)
+			return
+		}
+		s.quotas.mu.Lock()
+		usage := s.quotas.usage[tenant]
+		s.quotas.mu.Unlock()
+
+		limit := s.quotaLimit()
+		switch s.quotaLevel(usage) {
+		case quotaHard:
+			s.IncrementRequests()
+			s.quotas.rejected.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(s.cfg.QuotaInterval.Seconds()))))
+			http.Error(w, fmt.Sprintf("Tenant quota exceeded: %d of %d bytes used", usage, limit), http.StatusInsufficientStorage)
+			return
+		case quotaSoft:
+			s.quotas.warned.Add(1)
+			w.Header().Set("X-Quota-Warning", fmt.Sprintf("%d%% of quota used (%d of %d bytes)", usage*100/limit, usage, limit))
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) quotaStats() quotaStats {
+	s.quotas.mu.Lock()
+	defer s.quotas.mu.Unlock()
+
+	st := quotaStats{
+		Limit:         s.quotaLimit(),
+		SoftLimit:     s.softQuotaLimit(),
+		Warned:        s.quotas.warned.Load(),
+		Rejected:      s.quotas.rejected.Load(),
+		WebhookErrors: s.quotas.webhookErrors.Load(),
+		Tenants:       make(map[string]tenantQuota, len(s.quotas.usage)),
+	}
+	for tenant, usage := range s.quotas.usage {
+		st.Tenants[tenant] = tenantQuota{Usage: usage, Level: s.quotas.levels[tenant]}
+	}
+	return st
+}
+
+func (s *Server) collectQuotaMetrics() []metrics.Family {
+	st := s.quotaStats()
+	used := metrics.Family{Name: "kv_tenant_quota_used_ratio", Help: "Share of -tenant-quota each tenant used at the last check.", Type: metrics.TypeGauge}
+	tenants := make([]string, 0, len(st.Tenants))
+	for tenant := range st.Tenants {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	for _, tenant := range tenants {
+		used.Samples = append(used.Samples, metrics.Sample{
+			Labels: []metrics.Label{{Name: "tenant", Value: tenant}},
+			Value:  float64(st.Tenants[tenant].Usage) / float64(st.Limit),
+		})
+	}
+	return []metrics.Family{
+		used,
+		metrics.Single("kv_quota_warned_writes_total", "Writes accepted with X-Quota-Warning past -tenant-quota-soft.", metrics.TypeCounter, float64(st.Warned)),
+		metrics.Single("kv_quota_rejected_writes_total", "Writes rejected with 507 over -tenant-quota.", metrics.TypeCounter, float64(st.Rejected)),
+		metrics.Single("kv_quota_webhook_errors_total", "Failed -quota-webhook notices.", metrics.TypeCounter, float64(st.WebhookErrors)),
+	}
+}
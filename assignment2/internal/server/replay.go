@@ -0,0 +1,83 @@
+package server
+
+import (
+	"assignment2/internal/storage"
+	"errors"
+	"net/http"
+	"time"
+)
+
+type replayRequest struct {
+	Prefix   string     `json:"prefix"`
+	To       string     `json:"to"`
+	Revision uint64     `json:"revision"`
+	Time     *time.Time `json:"time"`
+}
+
+// POST /admin/replay
+//
+// Rebuilds the keys under "prefix" (every key when empty) as they were
+// at "revision" or at "time" (RFC 3339), replayed from the write-ahead
+// log, under the empty namespace "to": key k comes back as to+k, with
+// its tags and time to live, in one atomic batch. The live keys are left
+// alone, so what was lost can be looked at and copied back. The log must
+// still reach back that far (410 otherwise).
+func (s *Server) ReplayLog(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	var req replayRequest
+	if err := readJSON(r.Body, &req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.To == "" {
+		http.Error(w, "to is required", http.StatusBadRequest)
+		return
+	}
+	if (req.Revision == 0) == (req.Time == nil) {
+		http.Error(w, "Exactly one of revision and time is required", http.StatusBadRequest)
+		return
+	}
+
+	rev := req.Revision
+	if req.Time != nil {
+		var err error
+		if rev, err = s.store.RevisionAt(r.Context(), *req.Time); err != nil {
+			replayFailed(w, err)
+			return
+		}
+		if rev == 0 {
+			http.Error(w, "Nothing had been stored by then", http.StatusNotFound)
+			return
+		}
+	}
+
+	n, copyRev, err := s.store.ClonePrefix(r.Context(), req.Prefix, req.To+req.Prefix, rev)
+	if err != nil {
+		replayFailed(w, err)
+		return
+	}
+	s.audit(r, "replay", map[string]interface{}{"prefix": req.Prefix, "to": req.To, "as_of": rev, "keys": n, "revision": copyRev})
+
+	setRevision(w, copyRev)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"prefix":   req.Prefix,
+		"to":       req.To,
+		"as_of":    rev,
+		"keys":     n,
+		"revision": copyRev,
+	})
+}
+
+func replayFailed(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrPrefixNotEmpty):
+		http.Error(w, "Namespace to is not empty", http.StatusConflict)
+	case errors.Is(err, storage.ErrFutureRevision):
+		http.Error(w, "Revision is in the future", http.StatusBadRequest)
+	case errors.Is(err, storage.ErrCompacted):
+		http.Error(w, "The log no longer reaches back that far", http.StatusGone)
+	default:
+		storeFailed(w, "Replay failed: ", err)
+	}
+}
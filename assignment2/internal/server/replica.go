@@ -0,0 +1,337 @@
+package server
+
+import (
+	"assignment2/internal/events"
+	"assignment2/internal/storage"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// replicaHeartbeat is how often the holder is asked to confirm an idle
+// stream, which bounds how stale a quiet replica appears.
+const replicaHeartbeat = time.Second
+
+// replicaState tracks how current a standby's copy of the holder's data
+// is.
+type replicaState struct {
+	mu          sync.Mutex
+	holder      string
+	lastContact time.Time
+
+	// caughtUp is closed, and replaced, whenever the replica applies
+	// something from the holder.
+	caughtUp chan struct{}
+}
+
+// staleness returns how long ago the replica was last known to be in
+// sync with the holder; ok is false if it is not following one.
+func (rs *replicaState) staleness() (time.Duration, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.holder == "" {
+		return 0, false
+	}
+	return time.Since(rs.lastContact), true
+}
+
+func (rs *replicaState) touch(holder string) {
+	rs.mu.Lock()
+	rs.holder = holder
+	rs.lastContact = time.Now()
+	if rs.caughtUp != nil {
+		close(rs.caughtUp)
+		rs.caughtUp = nil
+	}
+	rs.mu.Unlock()
+}
+
+// progress returns a channel that is closed the next time the replica
+// hears from the holder.
+func (rs *replicaState) progress() <-chan struct{} {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.caughtUp == nil {
+		rs.caughtUp = make(chan struct{})
+	}
+	return rs.caughtUp
+}
+
+// waitRevision waits up to timeout for the store to reach revision min
+// and reports whether it did.
+func (s *Server) waitRevision(ctx context.Context, min uint64, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		// Take the channel first, so an event applied between the check
+		// and the wait still wakes us.
+		progress := s.replica.progress()
+		if s.store.Revision() >= min {
+			return true
+		}
+		select {
+		case <-progress:
+		case <-timer.C:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+func (rs *replicaState) stop() {
+	rs.mu.Lock()
+	rs.holder = ""
+	rs.mu.Unlock()
+}
+
+// StartReplication keeps a standby's store in sync with the lease holder
+// until ctx is cancelled. It returns immediately unless lease mode and
+// -standby-replicate are on.
+func (s *Server) StartReplication(ctx context.Context) {
+	if s.elector == nil || !s.cfg.StandbyReplicate {
+		return
+	}
+
+	var synced string // holder whose snapshot the store reflects
+	for ctx.Err() == nil {
+		holder := s.elector.Holder()
+		if s.elector.IsLeader() || holder == "" {
+			s.replica.stop()
+			synced = ""
+		} else {
+			if synced != holder {
+				if err := s.syncSnapshot(ctx, holder); err != nil {
+					log.Printf("[REPLICA] snapshot from %s failed: %v\n", holder, err)
+				} else {
+					log.Printf("[REPLICA] synced snapshot from %s at revision %d\n", holder, s.store.Revision())
+					synced = holder
+				}
+			}
+			if synced == holder {
+				err := s.follow(ctx, holder)
+				if err == events.ErrTooOld {
+					synced = ""
+					continue
+				}
+				if err != nil && ctx.Err() == nil {
+					log.Printf("[REPLICA] stream from %s ended: %v\n", holder, err)
+				}
+			}
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+		}
+	}
+	s.replica.stop()
+}
+
+// syncSnapshot replaces the local store with the holder's contents.
+func (s *Server) syncSnapshot(ctx context.Context, holder string) error {
+	resp, err := s.peerGet(ctx, holder, "/cluster/snapshot")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var snap snapshotResponse
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		return err
+	}
+	// Restore takes revision 0 for the next one, which would put an empty
+	// replica ahead of an empty holder and make it sync over and over.
+	if snap.Revision > 0 || s.store.Revision() > 0 {
+		if err := s.store.Restore(ctx, snap.Records, snap.Revision); err != nil {
+			return err
+		}
+	}
+	s.replica.touch(holder)
+	return nil
+}
+
+// follow applies the holder's changes after the local revision until the
+// stream breaks or the holder changes. It returns events.ErrTooOld when
+// the holder can no longer replay from there.
+func (s *Server) follow(ctx context.Context, holder string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	q := url.Values{}
+	q.Set("since", strconv.FormatUint(s.store.Revision(), 10))
+	q.Set("heartbeat", replicaHeartbeat.String())
+	resp, err := s.peerGet(ctx, holder, "/cluster/watch?"+q.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Give up on a silent stream or a change of holder.
+	go func() {
+		ticker := time.NewTicker(replicaHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				lag, _ := s.replica.staleness()
+				if s.elector.Holder() != holder || s.elector.IsLeader() || lag > 5*replicaHeartbeat {
+					cancel()
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e events.Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return err
+		}
+		switch e.Type {
+		case "heartbeat":
+		case events.TypeReset:
+			return events.ErrTooOld
+		default:
+			rec := storage.Record{
+				Rev: e.Seq, TS: e.Time.UnixNano(), Op: e.Type, Key: e.Key, Value: e.Value, Tags: e.Tags,
+				Expires: e.Expires, TTL: e.TTL, Enc: e.Enc,
+			}
+			if err := s.store.ApplyReplicated(ctx, rec); err != nil {
+				return err
+			}
+		}
+		s.replica.touch(holder)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("stream closed")
+}
+
+func (s *Server) peerGet(ctx context.Context, peer, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.peerScheme+"://"+peer+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The streaming request must not be cut off by the client timeout.
+	client := *s.peerClient
+	client.Timeout = 0
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusGone:
+		resp.Body.Close()
+		return nil, events.ErrTooOld
+	case resp.StatusCode != http.StatusOK:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s returned %s", peer, resp.Status)
+	}
+	return resp, nil
+}
+
+type snapshotResponse struct {
+	Revision uint64           `json:"revision"`
+	Records  []storage.Record `json:"records"`
+}
+
+// GET /cluster/snapshot
+func (s *Server) ClusterSnapshot(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	recs, rev, err := s.store.Snapshot(r.Context())
+	if err != nil {
+		storeFailed(w, "Failed to read: ", err)
+		return
+	}
+	json.NewEncoder(w).Encode(snapshotResponse{Revision: rev, Records: recs})
+}
+
+// staleGate handles ?max_stale= and X-Min-Revision on reads. A standby
+// serves the read from its replicated store only if that was in sync
+// with the holder within max_stale, and has applied the holder's
+// revision X-Min-Revision (waiting up to -min-revision-wait for it), so
+// that a client that sends the X-Revision of its last write reads that
+// write back. Otherwise the read is proxied to the holder (-standby-mode
+// proxy) or refused. Without either reads are always served locally.
+func (s *Server) staleGate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query().Get("max_stale")
+		mr := r.Header.Get("X-Min-Revision")
+		if s.elector == nil || s.elector.IsLeader() {
+			next(w, r)
+			return
+		}
+
+		if (v == "" && mr == "") || r.Header.Get(proxiedHeader) != "" {
+			if lag, following := s.replica.staleness(); following {
+				w.Header().Set("X-Staleness", lag.Round(time.Millisecond).String())
+			}
+			next(w, r)
+			return
+		}
+
+		var maxStale time.Duration
+		if v != "" {
+			var err error
+			if maxStale, err = time.ParseDuration(v); err != nil || maxStale < 0 {
+				s.IncrementRequests()
+				http.Error(w, "Invalid max_stale", http.StatusBadRequest)
+				return
+			}
+		}
+		var minRev uint64
+		if mr != "" {
+			var err error
+			if minRev, err = strconv.ParseUint(mr, 10, 64); err != nil {
+				s.IncrementRequests()
+				http.Error(w, "Invalid X-Min-Revision", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var refusal string
+		if minRev > 0 && !s.waitRevision(r.Context(), minRev, s.cfg.MinRevisionWait) {
+			refusal = "Standby: local data has not reached X-Min-Revision"
+		}
+		lag, following := s.replica.staleness()
+		if following {
+			w.Header().Set("X-Staleness", lag.Round(time.Millisecond).String())
+		}
+		if refusal == "" && v != "" && !(following && lag <= maxStale) {
+			refusal = "Standby: local data is older than max_stale"
+		}
+		if refusal == "" {
+			next(w, r)
+			return
+		}
+
+		holder := s.elector.Holder()
+		if s.cfg.StandbyMode == "proxy" && holder != "" {
+			s.IncrementRequests()
+			w.Header().Del("X-Staleness")
+			s.proxyToHolder(w, r, holder)
+			return
+		}
+
+		s.IncrementRequests()
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, refusal, http.StatusServiceUnavailable)
+	}
+}
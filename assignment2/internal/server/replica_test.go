@@ -0,0 +1,238 @@
+package server
+
+import (
+	"assignment2/internal/config"
+	"assignment2/internal/lease"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// leaseAPI is the Kubernetes Lease API as far as lease.Elector uses it,
+// for one lease that holder holds until release is called.
+type leaseAPI struct {
+	mu     sync.Mutex
+	holder string
+}
+
+func (l *leaseAPI) release() {
+	l.mu.Lock()
+	l.holder = ""
+	l.mu.Unlock()
+}
+
+func (l *leaseAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		if l.holder == "" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"metadata": map[string]string{"name": "kv", "namespace": "default", "resourceVersion": "1"},
+			"spec": map[string]interface{}{
+				"holderIdentity":       l.holder,
+				"leaseDurationSeconds": 60,
+				"renewTime":            time.Now().UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+			},
+		})
+	default:
+		var obj struct {
+			Spec struct {
+				HolderIdentity string `json:"holderIdentity"`
+			} `json:"spec"`
+		}
+		json.NewDecoder(r.Body).Decode(&obj)
+		l.holder = obj.Spec.HolderIdentity
+	}
+}
+
+// replicaPair is a lease holder and a standby replicating from it.
+type replicaPair struct {
+	holder     *Server
+	holderURL  string
+	standby    *Server
+	leases     *leaseAPI
+	standbyCtx context.Context
+}
+
+func newReplicaPair(t *testing.T) *replicaPair {
+	t.Helper()
+
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := httptest.NewServer(holder.Routes())
+
+	cfg, err = config.Load([]string{"-standby-replicate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	standby, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leases := &leaseAPI{holder: hs.Listener.Addr().String()}
+	ls := httptest.NewServer(leases)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	standby.elector = lease.NewElector(ls.URL, tokenFile, ls.Client(), "kv", "default", "standby", 3*time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		standby.StartLease(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		standby.StartReplication(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+		hs.Close()
+		ls.Close()
+		standby.Close()
+		holder.Close()
+	})
+
+	p := &replicaPair{holder: holder, holderURL: hs.URL, standby: standby, leases: leases, standbyCtx: ctx}
+	waitUntil(t, "the standby to follow the holder", 5*time.Second, func() bool {
+		_, ok := standby.replica.staleness()
+		return ok
+	})
+	return p
+}
+
+// do sends a request to the holder and fails the test unless it
+// succeeds.
+func (p *replicaPair) do(t *testing.T, method, path, body string, header http.Header) {
+	t.Helper()
+	req, err := http.NewRequest(method, p.holderURL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		t.Fatalf("%s %s: %s", method, path, resp.Status)
+	}
+}
+
+// caughtUp waits until the standby has applied everything the holder
+// wrote so far.
+func (p *replicaPair) caughtUp(t *testing.T) {
+	t.Helper()
+	if rev := p.holder.store.Revision(); !p.standby.waitRevision(p.standbyCtx, rev, 5*time.Second) {
+		t.Fatalf("standby at revision %d, holder at %d", p.standby.store.Revision(), rev)
+	}
+}
+
+func waitUntil(t *testing.T, what string, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(timeout); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStandbyReplicatesTTL(t *testing.T) {
+	p := newReplicaPair(t)
+	ctx := context.Background()
+
+	p.do(t, http.MethodPost, "/data?ttl=500ms", `{"short":"1"}`, nil)
+	p.do(t, http.MethodPost, "/data?ttl=1h", `{"long":"1"}`, nil)
+	p.do(t, http.MethodPost, "/data/long/touch", "", nil)
+	p.caughtUp(t)
+
+	for _, key := range []string{"short", "long"} {
+		e, ok, err := p.standby.store.GetEntry(ctx, key)
+		if err != nil || !ok {
+			t.Fatalf("standby GetEntry(%q) = %v, %v", key, ok, err)
+		}
+		if e.ExpiresAt.IsZero() {
+			t.Fatalf("%q has no time to live on the standby", key)
+		}
+	}
+	if e, _, _ := p.standby.store.GetEntry(ctx, "long"); time.Until(e.ExpiresAt) < 59*time.Minute {
+		t.Fatalf("touched key expires at %v on the standby, want an hour from now", e.ExpiresAt)
+	}
+
+	waitUntil(t, "short to expire on the standby", 2*time.Second, func() bool {
+		_, ok, _ := p.standby.store.GetEntry(ctx, "short")
+		return !ok
+	})
+
+	// Promoted, the standby's ttl job deletes what expired.
+	p.leases.release()
+	waitUntil(t, "the standby to take the lease", 5*time.Second, p.standby.elector.IsLeader)
+	if err := p.standby.expireKeys(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.standby.expired.Load(); n != 1 {
+		t.Fatalf("promoted standby expired %d keys, want 1", n)
+	}
+	if n := p.standby.store.Size(); n != 1 {
+		t.Fatalf("promoted standby holds %d keys, want 1", n)
+	}
+}
+
+func TestStandbyReplicatesEncryption(t *testing.T) {
+	p := newReplicaPair(t)
+	ctx := context.Background()
+
+	enc := http.Header{}
+	enc.Set(headerEncryptionKeyID, "k1")
+	enc.Set(headerEncryptionAlgorithm, "AES-256-GCM")
+	p.do(t, http.MethodPost, "/data", `{"secret":"Y2lwaGVydGV4dA==","plain":"x"}`, enc)
+	p.caughtUp(t)
+
+	e, ok, err := p.standby.store.GetEntry(ctx, "secret")
+	if err != nil || !ok {
+		t.Fatalf("standby GetEntry = %v, %v", ok, err)
+	}
+	if e.Encryption == nil || e.Encryption.KeyID != "k1" || e.Encryption.Algorithm != "AES-256-GCM" {
+		t.Fatalf("standby has encryption %+v, want k1/AES-256-GCM", e.Encryption)
+	}
+	encrypted, err := p.standby.store.Encrypted(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := encrypted["secret"]; !ok || len(encrypted) != 2 {
+		t.Fatalf("standby lists %v as encrypted, want secret and plain", encrypted)
+	}
+
+	// A set without the headers stores plaintext again.
+	p.do(t, http.MethodPost, "/data", `{"secret":"now plain"}`, nil)
+	p.caughtUp(t)
+	if e, _, _ := p.standby.store.GetEntry(ctx, "secret"); e.Encryption != nil {
+		t.Fatalf("standby kept encryption %+v after a plain set", e.Encryption)
+	}
+}
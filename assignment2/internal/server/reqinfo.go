@@ -0,0 +1,131 @@
+package server
+
+import (
+	"assignment2/internal/auth"
+	"assignment2/internal/version"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// HeaderRequestID carries the request ID. One sent by the client is kept
+// so that its logs and ours line up; otherwise the server makes one up.
+// Either way it is echoed in the response.
+const HeaderRequestID = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+// HeaderServerVersion tells clients which build answered, as in GET
+// /version.
+const HeaderServerVersion = "X-Server-Version"
+
+// HeaderTraceparent is the W3C Trace Context header through which a
+// traced caller passes its trace.
+const HeaderTraceparent = "traceparent"
+
+// RequestInfo is what the middleware establishes about a request: its ID
+// and, as far as authentication is configured, who sent it and for which
+// tenant. Handlers and logs read it through RequestInfoFrom instead of
+// looking at headers themselves.
+type RequestInfo struct {
+	ID string
+
+	// Principal is the caller authenticated by client certificate.
+	Principal *auth.Principal
+
+	// KeyID is the HMAC key that signed the request.
+	KeyID string
+
+	// Tenant owns the request's API key, and Scopes limit it to some of
+	// the tenant's keys.
+	Tenant string
+	Scopes auth.Scopes
+
+	// TraceID is the trace the request is part of, from its traceparent
+	// header, and Sampled whether the caller records that trace.
+	TraceID string
+	Sampled bool
+
+	// APIVersion is the response format the request gets, see
+	// apiVersions.
+	APIVersion int
+}
+
+// Roles returns the principal's roles, or nil without one.
+func (ri *RequestInfo) Roles() []string {
+	if ri == nil || ri.Principal == nil {
+		return nil
+	}
+	return ri.Principal.Roles
+}
+
+type requestInfoKey struct{}
+
+// RequestInfoFrom returns the request's info. Outside a request it is
+// empty (and never nil).
+func RequestInfoFrom(ctx context.Context) *RequestInfo {
+	if ri, ok := ctx.Value(requestInfoKey{}).(*RequestInfo); ok {
+		return ri
+	}
+	return &RequestInfo{}
+}
+
+// withRequestInfo attaches a RequestInfo to every request. It is the
+// outermost middleware; the ones inside fill the info in as they
+// authenticate the request, so it is read by the access log once the
+// request is done.
+func (s *Server) withRequestInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HeaderRequestID)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = newRequestID()
+		}
+		w.Header().Set(HeaderRequestID, id)
+		w.Header().Set(HeaderServerVersion, version.String())
+
+		ri := &RequestInfo{ID: id}
+		ri.TraceID, ri.Sampled = parseTraceparent(r.Header.Get(HeaderTraceparent))
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, ri)))
+	})
+}
+
+// parseTraceparent returns the trace ID and sampled flag of a traceparent
+// header, or "" if it is missing or malformed. Versions after 00 may add
+// fields, which are ignored.
+func parseTraceparent(h string) (traceID string, sampled bool) {
+	// version "-" trace-id "-" parent-id "-" flags
+	if len(h) < 55 || h[2] != '-' || h[35] != '-' || h[52] != '-' || (len(h) > 55 && h[55] != '-') {
+		return "", false
+	}
+	version, traceID, parent, flags := h[0:2], h[3:35], h[36:52], h[53:55]
+	if version == "ff" || (version == "00" && len(h) != 55) {
+		return "", false
+	}
+	for _, field := range []string{version, traceID, parent, flags} {
+		if !lowerHex(field) {
+			return "", false
+		}
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parent, "0") == "" {
+		return "", false
+	}
+	f, _ := hex.DecodeString(flags)
+	return traceID, f[0]&1 == 1
+}
+
+func lowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var raw [8]byte
+	rand.Read(raw[:])
+	return hex.EncodeToString(raw[:])
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"assignment2/internal/config"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retentionBatch caps how many keys one policy deletes per run, so a
+// large backlog is worked off over several runs instead of one long lock.
+const retentionBatch = 1000
+
+// retentionSample is how many matching keys a report lists.
+const retentionSample = 20
+
+type retentionPolicy struct {
+	Prefix string `json:"prefix"`
+	MaxAge string `json:"max_age"`
+	DryRun bool   `json:"dry_run"`
+
+	maxAge time.Duration
+}
+
+type retentionReport struct {
+	Time     time.Time            `json:"time"`
+	Policies []retentionPolicyRun `json:"policies"`
+}
+
+type retentionPolicyRun struct {
+	Prefix  string   `json:"prefix"`
+	MaxAge  string   `json:"max_age"`
+	DryRun  bool     `json:"dry_run"`
+	Matched int      `json:"matched"`
+	Deleted int      `json:"deleted"`
+	Sample  []string `json:"sample,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// retentionState holds the policies, which can be replaced at runtime,
+// and the report of the last enforcement run.
+type retentionState struct {
+	mu       sync.Mutex
+	policies []retentionPolicy
+	last     *retentionReport
+}
+
+func newRetentionPolicies(cfg config.Config) []retentionPolicy {
+	policies := make([]retentionPolicy, 0, len(cfg.Retention))
+	for _, p := range cfg.Retention {
+		policies = append(policies, retentionPolicy{
+			Prefix: p.Prefix,
+			MaxAge: p.MaxAge.String(),
+			DryRun: cfg.RetentionDryRun,
+			maxAge: p.MaxAge,
+		})
+	}
+	return policies
+}
+
+func (rs *retentionState) get() []retentionPolicy {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return append([]retentionPolicy(nil), rs.policies...)
+}
+
+// governs reports whether p is the policy for key: the one with the
+// longest matching prefix wins.
+func governs(p retentionPolicy, key string, policies []retentionPolicy) bool {
+	for _, other := range policies {
+		if len(other.Prefix) > len(p.Prefix) && strings.HasPrefix(key, other.Prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// enforceRetention evaluates every policy. Policies marked dry-run, or all
+// of them with dryRun, only report the keys they would delete.
+func (s *Server) enforceRetention(ctx context.Context, dryRun bool) retentionReport {
+	policies := s.retention.get()
+	now := time.Now()
+	report := retentionReport{Time: now, Policies: make([]retentionPolicyRun, 0, len(policies))}
+
+	for _, p := range policies {
+		run := retentionPolicyRun{Prefix: p.Prefix, MaxAge: p.MaxAge, DryRun: p.DryRun || dryRun}
+		cutoff := now.Add(-p.maxAge)
+
+		candidates, err := s.store.ModifiedBefore(ctx, p.Prefix, cutoff, retentionBatch)
+		if err != nil {
+			run.Error = err.Error()
+			report.Policies = append(report.Policies, run)
+			continue
+		}
+
+		keys := candidates[:0]
+		for _, k := range candidates {
+			if governs(p, k, policies) {
+				keys = append(keys, k)
+			}
+		}
+		run.Matched = len(keys)
+		run.Sample = keys[:min(len(keys), retentionSample)]
+
+		if !run.DryRun && len(keys) > 0 {
+			if run.Deleted, err = s.store.DeleteModifiedBefore(ctx, keys, cutoff); err != nil {
+				run.Error = err.Error()
+			}
+		}
+		report.Policies = append(report.Policies, run)
+	}
+	return report
+}
+
+// retentionJob is the retention job. Only the lease holder deletes; a
+// standby gets the deletes through replication.
+func (s *Server) retentionJob(ctx context.Context) error {
+	if s.isStandby() {
+		return nil
+	}
+
+	report := s.enforceRetention(ctx, false)
+
+	for _, run := range report.Policies {
+		switch {
+		case run.Error != "":
+			log.Printf("[RETENTION] %q: %s\n", run.Prefix, run.Error)
+		case run.DryRun && run.Matched > 0:
+			log.Printf("[RETENTION] %q (dry run): would delete %d keys\n", run.Prefix, run.Matched)
+		case run.Deleted > 0:
+			log.Printf("[RETENTION] %q: deleted %d keys\n", run.Prefix, run.Deleted)
+		}
+	}
+
+	s.retention.mu.Lock()
+	s.retention.last = &report
+	s.retention.mu.Unlock()
+	return nil
+}
+
+// GET /admin/retention
+func (s *Server) GetRetention(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	s.retention.mu.Lock()
+	resp := map[string]interface{}{
+		"policies": append([]retentionPolicy{}, s.retention.policies...),
+		"last_run": s.retention.last,
+	}
+	s.retention.mu.Unlock()
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// PUT /admin/retention
+//
+// Replaces the policies: {"policies": [{"prefix": "events:", "max_age":
+// "168h", "dry_run": true}]}.
+func (s *Server) PutRetention(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	var req struct {
+		Policies []retentionPolicy `json:"policies"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for i := range req.Policies {
+		p := &req.Policies[i]
+		d, err := time.ParseDuration(p.MaxAge)
+		if err != nil || d <= 0 {
+			http.Error(w, "Invalid max_age for prefix "+p.Prefix, http.StatusBadRequest)
+			return
+		}
+		if seen[p.Prefix] {
+			http.Error(w, "Duplicate prefix "+p.Prefix, http.StatusBadRequest)
+			return
+		}
+		seen[p.Prefix] = true
+		p.maxAge = d
+		p.MaxAge = d.String()
+	}
+
+	s.retention.mu.Lock()
+	s.retention.policies = req.Policies
+	s.retention.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"policies": req.Policies})
+}
+
+// POST /admin/retention/preview
+//
+// Reports what the current policies would delete right now, without
+// deleting anything.
+func (s *Server) PreviewRetention(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	json.NewEncoder(w).Encode(s.enforceRetention(r.Context(), true))
+}
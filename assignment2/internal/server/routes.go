@@ -0,0 +1,179 @@
+package server
+
+import (
+	"assignment2/internal/auth"
+	"net/http"
+)
+
+func (s *Server) Routes() http.Handler {
+	mux := http.NewServeMux()
+
+	// Data endpoints are unavailable in maintenance mode; writes are
+	// additionally restricted to the lease holder, held back over
+	// -memory-limit and throttled by -write-bytes-per-sec, and reads on a
+	// standby honour max_stale. With
+	// client certificates, each group also requires the matching role,
+	// and with API keys a tenant. API keys with scopes are limited to the
+	// {key} of read and write routes; readEach and writeEach routes check
+	// every key they touch instead. Keys under -session-prefix are only
+	// written through the session routes, which check scopes against the
+	// session's key and let its readers extend it on the lease holder.
+	readEach := func(h http.HandlerFunc) http.HandlerFunc {
+		return s.requireRole(auth.RoleRead, s.requireTenant(s.maintenanceGate(s.staleGate(h))))
+	}
+	writeEach := func(h http.HandlerFunc) http.HandlerFunc {
+		return s.requireRole(auth.RoleWrite, s.requireTenant(s.maintenanceGate(s.leaderOnly(s.memoryGate(s.quotaGate(s.throttleWrites(h)))))))
+	}
+	read := func(h http.HandlerFunc) http.HandlerFunc {
+		return readEach(s.requireScope(auth.ScopeRead, h))
+	}
+	write := func(h http.HandlerFunc) http.HandlerFunc {
+		return writeEach(s.requireScope(auth.ScopeWrite, s.sessionGate(h)))
+	}
+	sessionRead := func(h http.HandlerFunc) http.HandlerFunc {
+		return s.requireRole(auth.RoleRead, s.requireTenant(s.maintenanceGate(s.leaderOnly(s.sessionScope(auth.ScopeRead, h)))))
+	}
+	sessionWrite := func(h http.HandlerFunc) http.HandlerFunc {
+		return writeEach(s.sessionScope(auth.ScopeWrite, h))
+	}
+	admin := func(h http.HandlerFunc) http.HandlerFunc {
+		return s.requireRole(auth.RoleAdmin, h)
+	}
+
+	// Latency is measured around everything a route does, as the client
+	// sees it. Requests beyond -max-in-flight then queue in their priority
+	// lane, and routes named in -concurrency-limits get their limiter
+	// next, so requests waiting for a slot hold nothing else. Injected
+	// faults come after that, so injected latency occupies a slot like a
+	// slow handler.
+	routes := make(map[string]bool)
+	handle := func(pattern string, h http.HandlerFunc) {
+		routes[pattern] = true
+		mux.HandleFunc(pattern, s.observeLatency(pattern, s.prioritize(pattern, s.limitConcurrency(pattern, s.injectFaults(pattern, h)))))
+	}
+
+	handle("POST /data", writeEach(s.PostData))
+	handle("POST /data/touch", write(s.TouchPrefix))
+	handle("GET /data", readEach(s.GetData))
+	handle("GET /data/{key}", read(s.GetKey))
+	handle("GET /data/{key}/meta", read(s.GetMeta))
+	handle("DELETE /data", admin(write(s.ClearData)))
+	handle("DELETE /data/{key}", write(s.lockGate(s.DeleteData)))
+	handle("PATCH /data/{key}", write(s.lockGate(s.plaintextOnly(s.PatchData))))
+	handle("POST /data/{key}/eval", write(s.lockGate(s.plaintextOnly(s.EvalData))))
+	handle("POST /data/{key}/touch", write(s.lockGate(s.TouchKey)))
+	handle("POST /data/{key}/append", write(s.lockGate(s.plaintextOnly(s.AppendData))))
+	handle("POST /data/{key}/rehydrate", write(s.lockGate(s.archiveEnabled(s.RehydrateKey))))
+	handle("GET /data/{key}/tags", read(s.GetTags))
+	handle("PUT /data/{key}/tags", write(s.lockGate(s.PutTags)))
+	handle("POST /data/{key}/lock", write(s.LockKey))
+	handle("GET /data/{key}/lock", read(s.GetLock))
+	handle("DELETE /data/{key}/lock", write(s.UnlockKey))
+	handle("POST /data/{key}/upload", write(s.lockGate(s.blobsEnabled(s.CreateUpload))))
+	handle("PUT /uploads/{id}/{part}", write(s.blobsEnabled(s.PutUploadPart)))
+	handle("POST /uploads/{id}/commit", write(s.blobsEnabled(s.CommitUpload)))
+	handle("DELETE /uploads/{id}", write(s.blobsEnabled(s.AbortUpload)))
+	handle("GET /data/{key}/blob", read(s.blobsEnabled(s.GetBlob)))
+	handle("DELETE /data/{key}/blob", write(s.lockGate(s.blobsEnabled(s.DeleteBlob))))
+	handle("POST /buckets/{bucket}/clone", write(s.CloneBucket))
+	handle("GET /tree", readEach(s.GetTree))
+	handle("GET /tree/{path...}", readEach(s.GetTree))
+	handle("POST /import", write(s.StageImport))
+	handle("GET /import", read(s.GetImport))
+	handle("DELETE /import", write(s.DiscardImport))
+	handle("POST /import/validate", write(s.ValidateImport))
+	handle("POST /import/commit", admin(write(s.CommitImport)))
+	handle("POST /imports", write(s.CreateBulkImport))
+	handle("GET /imports", read(s.ListBulkImports))
+	handle("GET /imports/{id}", read(s.GetBulkImport))
+	handle("DELETE /imports/{id}", write(s.CancelBulkImport))
+	handle("GET /watch", readEach(s.Watch))
+	handle("GET /subscriptions", read(s.ListSubscriptions))
+	handle("DELETE /subscriptions/{name}", write(s.DeleteSubscription))
+	handle("GET /stats", s.requireRole(auth.RoleRead, s.StatsHandler))
+	handle("GET /stats/history", s.requireRole(auth.RoleRead, s.StatsHistory))
+	handle("GET /stats/contention", s.requireRole(auth.RoleRead, s.ContentionStats))
+	handle("GET /stats/hotkeys", s.requireRole(auth.RoleRead, s.requireTenant(s.HotKeys)))
+	handle("GET /stats/slo", s.requireRole(auth.RoleRead, s.SLOStats))
+
+	if s.cfg.SessionTTL > 0 {
+		handle("POST /sessions", sessionWrite(s.CreateSession))
+		handle("GET /sessions/{id}", sessionRead(s.GetSession))
+		handle("PUT /sessions/{id}", sessionWrite(s.PutSession))
+		handle("DELETE /sessions/{id}", sessionWrite(s.DeleteSession))
+	}
+
+	handle("GET /scripts", read(s.ListScripts))
+	handle("GET /scripts/{name}", read(s.GetScript))
+	handle("PUT /scripts/{name}", write(s.PutScript))
+	handle("DELETE /scripts/{name}", write(s.DeleteScript))
+
+	if s.cfg.V3API {
+		handle("POST /v3/kv/range", read(s.V3Range))
+		handle("POST /v3/kv/put", write(s.V3Put))
+		handle("POST /v3/kv/deleterange", write(s.V3DeleteRange))
+		handle("POST /v3/watch", read(s.V3Watch))
+	}
+
+	if s.cfg.FlagPrefix != "" {
+		handle("GET /flags/evaluate", read(s.EvaluateFlags))
+	}
+
+	handle("POST /cluster/join", s.peerOnly(s.ClusterJoin))
+	handle("GET /cluster/members", s.ClusterMembers)
+	handle("GET /cluster/lease", s.ClusterLease)
+	handle("GET /cluster/status", s.ClusterStatus)
+	handle("GET /cluster/keymap", s.ClusterKeymap)
+	handle("GET /cluster/snapshot", s.peerOnly(s.requireRole(auth.RoleRead, s.ClusterSnapshot)))
+	handle("GET /cluster/watch", s.peerOnly(s.requireRole(auth.RoleRead, s.Watch)))
+	handle("GET /cluster/segments", s.peerOnly(s.requireRole(auth.RoleRead, s.ListSegments)))
+	handle("GET /cluster/segments/{name}", s.peerOnly(s.requireRole(auth.RoleRead, s.GetSegment)))
+
+	if s.cfg.Checkpoints {
+		handle("POST /admin/checkpoint", admin(s.SaveCheckpoint))
+		handle("GET /admin/checkpoints", admin(s.ListCheckpoints))
+		handle("POST /admin/reset", admin(write(s.ResetToCheckpoint)))
+	}
+
+	handle("GET /admin/maintenance", admin(s.GetMaintenance))
+	handle("POST /admin/maintenance", admin(s.SetMaintenance))
+	handle("POST /admin/promote", admin(s.Promote))
+
+	handle("GET /admin/ipfilter", admin(s.GetIPFilter))
+	handle("PUT /admin/ipfilter", admin(s.PutIPFilter))
+	handle("GET /admin/retention", admin(s.GetRetention))
+	handle("PUT /admin/retention", admin(s.PutRetention))
+	handle("POST /admin/retention/preview", admin(s.PreviewRetention))
+	handle("GET /admin/integrity", admin(s.persistenceEnabled(s.GetIntegrity)))
+	handle("POST /admin/integrity/check", admin(s.persistenceEnabled(s.CheckIntegrity)))
+	handle("POST /admin/compact", admin(s.persistenceEnabled(s.CompactLog)))
+	handle("POST /admin/replay", admin(write(s.persistenceEnabled(s.ReplayLog))))
+	handle("GET /admin/info", admin(s.GetInfo))
+	handle("GET /admin/faults", admin(s.GetFaults))
+	handle("GET /admin/loglevel", admin(s.GetLogLevel))
+	handle("PUT /admin/loglevel", admin(s.PutLogLevel))
+	handle("GET /admin/jobs", admin(s.ListJobs))
+	handle("POST /admin/jobs/{name}/run", admin(s.RunJob))
+	handle("GET /admin/debug/goroutines", admin(s.DebugGoroutines))
+	handle("GET /admin/debug/mutex", admin(s.DebugMutex))
+
+	handle("GET /metrics", s.MetricsHandler)
+	handle("GET /debug/vars", s.DebugVars)
+	handle("GET /healthz", s.Healthz)
+	handle("GET /readyz", s.Readyz)
+	handle("GET /healthz/jobs", s.JobsHealth)
+	handle("GET /version", s.GetVersion)
+
+	if len(s.cfg.PublicPrefixes) > 0 {
+		handle("GET /public/data", s.publicAccess(s.GetPublicData))
+		handle("GET /public/data/{key...}", s.publicAccess(s.GetPublicKey))
+		handle("OPTIONS /public/data", s.publicAccess(nil))
+		handle("OPTIONS /public/data/{key...}", s.publicAccess(nil))
+	}
+
+	s.checkLimitedRoutes(routes)
+	s.checkFaultRoutes(routes)
+	s.checkSLORoutes(routes)
+
+	return s.withRequestInfo(s.apiVersions(s.rateLimitHeaders(s.mirrorWrites(s.accessLog(s.slowLog(s.filterIPs(s.authenticate(s.requireSignature(s.timeHandler(s.mockResponses(mux)))))))))))
+}
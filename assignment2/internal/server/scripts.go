@@ -0,0 +1,287 @@
+package server
+
+import (
+	"assignment2/internal/script"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type storedScript struct {
+	source  string
+	program *script.Program
+}
+
+type scriptRegistry struct {
+	mu      sync.Mutex
+	scripts map[string]storedScript
+}
+
+type evalRequest struct {
+	Script string      `json:"script"`
+	Source string      `json:"source"`
+	Args   interface{} `json:"args"`
+}
+
+func newScriptRegistry() *scriptRegistry {
+	return &scriptRegistry{scripts: make(map[string]storedScript)}
+}
+
+func (r *scriptRegistry) get(name string) (storedScript, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sc, ok := r.scripts[name]
+	return sc, ok
+}
+
+// PUT /scripts/{name}
+func (s *Server) PutScript(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	src, err := io.ReadAll(io.LimitReader(r.Body, script.MaxSourceLen+1))
+	if err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+
+	program, err := script.Compile(string(src))
+	if err != nil {
+		http.Error(w, "Invalid script: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := scopedKey(r, r.PathValue("name"))
+	s.scripts.mu.Lock()
+	s.scripts.scripts[name] = storedScript{source: string(src), program: program}
+	s.scripts.mu.Unlock()
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"status": "stored"})
+}
+
+// GET /scripts
+//
+// Lists the caller's scripts; like keys, stored scripts belong to the
+// tenant that stored them.
+func (s *Server) ListScripts(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	scope := tenantScope(r)
+	s.scripts.mu.Lock()
+	names := make([]string, 0, len(s.scripts.scripts))
+	for name := range s.scripts.scripts {
+		if rest, ok := strings.CutPrefix(name, scope); ok && (s.tenants == nil || !strings.Contains(rest, tenantSeparator)) {
+			names = append(names, rest)
+		}
+	}
+	s.scripts.mu.Unlock()
+
+	sort.Strings(names)
+	json.NewEncoder(w).Encode(map[string][]string{"scripts": names})
+}
+
+// GET /scripts/{name}
+func (s *Server) GetScript(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	sc, ok := s.scripts.get(scopedKey(r, r.PathValue("name")))
+	if !ok {
+		http.Error(w, "Script not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, sc.source)
+}
+
+// DELETE /scripts/{name}
+func (s *Server) DeleteScript(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	name := r.PathValue("name")
+	s.scripts.mu.Lock()
+	_, ok := s.scripts.scripts[scopedKey(r, name)]
+	delete(s.scripts.scripts, scopedKey(r, name))
+	s.scripts.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "Script not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"deleted": name})
+}
+
+// POST /data/{key}/eval
+//
+// Runs a stored Starlark script (or inline source) against the key's
+// value while the store is locked. The script's main(key, value, args)
+// gets the current value, decoded if it is JSON and None if missing, and
+// returns the new one, or a (value, result) pair to also hand result to
+// the caller; None deletes the key, and a value equal to the current one
+// leaves it untouched. If validators keep seeing the key change under the
+// script, the answer is a 409 conflict with the current value. With
+// ?dry_run=true the script runs and its outcome is returned, but nothing
+// is written.
+func (s *Server) EvalData(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	preview, err := dryRun(r)
+	if err != nil {
+		http.Error(w, "Invalid dry_run", http.StatusBadRequest)
+		return
+	}
+
+	var req evalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var program *script.Program
+	switch {
+	case req.Script != "":
+		sc, ok := s.scripts.get(scopedKey(r, req.Script))
+		if !ok || s.tenants != nil && strings.Contains(req.Script, tenantSeparator) {
+			http.Error(w, "Script not found", http.StatusNotFound)
+			return
+		}
+		program = sc.program
+	case req.Source != "":
+		var err error
+		if program, err = script.Compile(req.Source); err != nil {
+			http.Error(w, "Invalid script: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Script required", http.StatusBadRequest)
+		return
+	}
+
+	key := r.PathValue("key")
+	var value, result interface{}
+	var deleted bool
+
+	var scriptErr error
+	eval := func(old string, exists bool) (string, bool, error) {
+		var changed bool
+		value, result, changed, scriptErr = program.Run(key, decodeScriptValue(old, exists), req.Args)
+		if scriptErr != nil {
+			return "", false, scriptErr
+		}
+		if !changed && (value != nil || !exists) {
+			// The script left value alone: the stored bytes stay as they
+			// are, instead of coming back re-encoded.
+			deleted = !exists
+			if preview {
+				return old, exists, errDryRun
+			}
+			return old, exists, nil
+		}
+		encoded, keep, err := encodeScriptValue(value)
+		scriptErr = err
+		deleted = !keep
+		if err == nil && keep && len(s.validators) > 0 {
+			err = s.validateWrite(r.Context(), key, encoded)
+		}
+		if err == nil && preview {
+			return old, exists, errDryRun
+		}
+		return encoded, keep, err
+	}
+
+	var rev uint64
+	if len(s.validators) == 0 {
+		rev, err = s.store.Update(r.Context(), scopedKey(r, key), eval)
+	} else {
+		rev, err = s.updateValidated(r.Context(), scopedKey(r, key), eval)
+	}
+	if writeRejected(w, err) {
+		return
+	}
+	if err == errEvalConflict {
+		s.conflictOutcome(w, r, http.StatusConflict, key, scopedKey(r, key))
+		return
+	}
+	if preview && err == errDryRun {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"dry_run": true,
+			"key":     key,
+			"value":   value,
+			"deleted": deleted,
+			"result":  result,
+		})
+		return
+	}
+	if scriptErr != nil {
+		http.Error(w, "Script failed: "+scriptErr.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		storeFailed(w, "Failed to persist: ", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"outcome":  outcomeApplied,
+		"key":      key,
+		"value":    value,
+		"deleted":  deleted,
+		"result":   result,
+		"revision": rev,
+	})
+}
+
+// Values that are valid JSON are handed to scripts decoded; anything
+// else is passed through as a plain string. Integers too large for a
+// float64 stay json.Number, which is written back digit for digit.
+func decodeScriptValue(raw string, exists bool) interface{} {
+	if !exists {
+		return nil
+	}
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil || dec.Decode(new(json.RawMessage)) != io.EOF {
+		return raw
+	}
+	return scriptNumbers(v)
+}
+
+// scriptNumbers turns the numbers of a value decoded with UseNumber into
+// the float64s scripts compute with, where that loses nothing.
+func scriptNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		f, err := t.Float64()
+		if err != nil || !strings.ContainsAny(string(t), ".eE") && (f > 1<<53 || f < -(1<<53)) {
+			return t
+		}
+		return f
+	case []interface{}:
+		for i := range t {
+			t[i] = scriptNumbers(t[i])
+		}
+	case map[string]interface{}:
+		for k := range t {
+			t[k] = scriptNumbers(t[k])
+		}
+	}
+	return v
+}
+
+func encodeScriptValue(v interface{}) (string, bool, error) {
+	switch t := v.(type) {
+	case nil:
+		return "", false, nil
+	case string:
+		return t, true, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", false, err
+	}
+	return string(b), true, nil
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+const incrScript = `
+def main(key, value, args):
+    if value == None:
+        value = 0
+    return value + args["by"], "incremented " + key
+`
+
+func TestEvalStarlark(t *testing.T) {
+	s, url := newTestServer(t)
+	ctx := context.Background()
+
+	// Without API keys, script names may contain "/".
+	if code, body := call(t, http.MethodPut, url+"/scripts/counters%2Fincr", "", incrScript); code != http.StatusCreated {
+		t.Fatalf("PUT script: %d %s", code, body)
+	}
+	if _, body := call(t, http.MethodGet, url+"/scripts", "", ""); body != `{"scripts":["counters/incr"]}`+"\n" {
+		t.Fatalf("GET /scripts = %s", body)
+	}
+	for i := 0; i < 2; i++ {
+		code, body := call(t, http.MethodPost, url+"/data/n/eval", "", `{"script":"counters/incr","args":{"by":5}}`)
+		if code != http.StatusOK {
+			t.Fatalf("eval: %d %s", code, body)
+		}
+		var out struct {
+			Value  json.Number `json:"value"`
+			Result string      `json:"result"`
+		}
+		if err := json.Unmarshal([]byte(body), &out); err != nil {
+			t.Fatal(err)
+		}
+		if want := json.Number([]string{"5", "10"}[i]); out.Value != want || out.Result != "incremented n" {
+			t.Fatalf("eval %d returned %s", i, body)
+		}
+	}
+
+	inline := func(key, src string) (int, string) {
+		req, _ := json.Marshal(map[string]string{"source": src})
+		return call(t, http.MethodPost, url+"/data/"+key+"/eval", "", string(req))
+	}
+	stored := func(key string) (string, bool) {
+		e, ok, err := s.store.GetEntry(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e.Value, ok
+	}
+
+	if _, err := s.store.Set(ctx, "obj", `{"b":1, "a":2}`); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := inline("obj", "def main(key, value, args):\n    return value\n"); code != http.StatusOK {
+		t.Fatalf("eval: %d %s", code, body)
+	}
+	if v, _ := stored("obj"); v != `{"b":1, "a":2}` {
+		t.Fatalf("unchanged value was rewritten as %s", v)
+	}
+	if code, body := inline("obj", "def main(key, value, args):\n    value[\"c\"] = 3\n    return value\n"); code != http.StatusOK {
+		t.Fatalf("eval: %d %s", code, body)
+	}
+	if v, _ := stored("obj"); v != `{"a":2,"b":1,"c":3}` {
+		t.Fatalf("value changed in place was stored as %s", v)
+	}
+
+	if _, err := s.store.Set(ctx, "big", "12345678901234567890123"); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := inline("big", "def main(key, value, args):\n    return value + 1\n"); code != http.StatusOK {
+		t.Fatalf("eval: %d %s", code, body)
+	}
+	if v, _ := stored("big"); v != "12345678901234567890124" {
+		t.Fatalf("big int stored as %s", v)
+	}
+
+	if code, body := inline("obj", "def main(key, value, args):\n    return None\n"); code != http.StatusOK {
+		t.Fatalf("eval: %d %s", code, body)
+	}
+	if _, ok := stored("obj"); ok {
+		t.Fatal("returning None did not delete the key")
+	}
+
+	for _, c := range []struct {
+		src  string
+		want int
+	}{
+		{"x = 1\n", http.StatusBadRequest},
+		{"def main(key, value, args):\n    return value +\n", http.StatusBadRequest},
+		{"def main(key, value, args):\n    for i in range(1 << 30):\n        pass\n", http.StatusUnprocessableEntity},
+		{"def main(key, value, args):\n    return {1: 2}\n", http.StatusUnprocessableEntity},
+	} {
+		if code, body := inline("k", c.src); code != c.want {
+			t.Errorf("eval of %q: %d %s, want %d", c.src, code, body, c.want)
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package server
+
+import (
+	"assignment2/internal/archive"
+	"assignment2/internal/events"
+	"assignment2/internal/metrics"
+	"assignment2/internal/storage"
+	"assignment2/internal/storage/dynamodb"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backoff between attempts of a failing secondary.
+const (
+	secondaryRetryMin = time.Second
+	secondaryRetryMax = 30 * time.Second
+)
+
+// secondaryOpenTimeout bounds connecting to a secondary store at startup.
+const secondaryOpenTimeout = 10 * time.Second
+
+// maxDivergentKeys is how many divergent keys a reconciliation names.
+const maxDivergentKeys = 20
+
+// snapshotObject is the name of a snapshot secondary's copy.
+const snapshotObject = "snapshot.ndjson.gz"
+
+// secondary keeps a copy of the store in another backend, written in the
+// background so that the primary never waits for it. A store (DynamoDB)
+// is sent every change in order; a snapshot target (S3, a directory) is
+// rewritten in full every -secondary-snapshot-interval if anything
+// changed. Each starts with a full sync, and syncs in full again when its
+// queue overflows or the store is reset, instead of blocking writes.
+type secondary struct {
+	name   string
+	store  storage.Store
+	target archive.Target
+
+	queue  chan events.Event
+	resync atomic.Bool
+	dirty  atomic.Bool
+
+	// applied is the revision of the primary the copy is current to.
+	applied atomic.Uint64
+
+	resyncs atomic.Int64
+	dropped atomic.Int64
+	errors  atomic.Int64
+
+	mu         sync.Mutex
+	lastError  string
+	reconciled *reconcileReport
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// reconcileReport is the outcome of comparing a secondary with the store.
+// Keys changed since the secondary's revision are not compared, and keys
+// only the secondary has are only counted when it was caught up.
+type reconcileReport struct {
+	Time       time.Time `json:"time"`
+	Revision   uint64    `json:"revision"`
+	Checked    int       `json:"checked"`
+	Missing    int       `json:"missing"`
+	Extra      int       `json:"extra"`
+	Mismatched int       `json:"mismatched"`
+	Keys       []string  `json:"divergent_keys,omitempty"`
+}
+
+func (r *reconcileReport) divergent() int {
+	return r.Missing + r.Extra + r.Mismatched
+}
+
+type secondaryStats struct {
+	Name      string           `json:"name"`
+	Kind      string           `json:"kind"`
+	Revision  uint64           `json:"revision"`
+	Lag       uint64           `json:"lag"`
+	Pending   int              `json:"pending"`
+	Resyncs   int64            `json:"resyncs"`
+	Dropped   int64            `json:"dropped"`
+	Errors    int64            `json:"errors"`
+	LastError string           `json:"last_error,omitempty"`
+	Reconcile *reconcileReport `json:"reconcile,omitempty"`
+}
+
+// openSecondaries connects to every -secondary and starts copying to it.
+func (s *Server) openSecondaries() error {
+	for _, raw := range s.cfg.Secondaries {
+		sec := &secondary{name: raw, done: make(chan struct{})}
+		var err error
+		if sec.store, sec.target, err = openSecondary(raw); err != nil {
+			s.closeSecondaries()
+			return fmt.Errorf("-secondary %s: %w", raw, err)
+		}
+		if sec.store != nil {
+			sec.queue = make(chan events.Event, s.cfg.SecondaryQueue)
+		}
+		sec.resync.Store(true)
+
+		var ctx context.Context
+		ctx, sec.cancel = context.WithCancel(context.Background())
+		s.secondaries = append(s.secondaries, sec)
+		go s.runSecondary(ctx, sec)
+	}
+	return nil
+}
+
+// openSecondary opens a -secondary URL, checked by config: either a store
+// or a snapshot target.
+func openSecondary(raw string) (storage.Store, archive.Target, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	q := u.Query()
+	switch u.Scheme {
+	case "dynamodb":
+		ctx, cancel := context.WithTimeout(context.Background(), secondaryOpenTimeout)
+		defer cancel()
+		st, err := dynamodb.Open(ctx, dynamodb.Options{Table: u.Host, Region: q.Get("region"), Endpoint: q.Get("endpoint")})
+		if err != nil {
+			return nil, nil, err
+		}
+		return st, nil, nil
+	case "s3":
+		region := q.Get("region")
+		if region == "" {
+			region = "us-east-1"
+		}
+		t, err := archive.NewS3(u.Host, strings.TrimPrefix(u.Path, "/"), archive.S3Options{Region: region, Endpoint: q.Get("endpoint")})
+		return nil, t, err
+	case "file":
+		t, err := archive.NewDir(u.Path)
+		return nil, t, err
+	}
+	t, err := archive.NewDir(raw)
+	return nil, t, err
+}
+
+// copyToSecondaries queues a change for every secondary. It runs under
+// the store lock, so it never waits: a secondary whose queue is full
+// drops it and syncs in full instead.
+func (s *Server) copyToSecondaries(e events.Event) {
+	for _, sec := range s.secondaries {
+		if sec.store == nil {
+			sec.dirty.Store(true)
+			continue
+		}
+		select {
+		case sec.queue <- e:
+		default:
+			sec.dropped.Add(1)
+			sec.resync.Store(true)
+		}
+	}
+}
+
+func (s *Server) runSecondary(ctx context.Context, sec *secondary) {
+	defer close(sec.done)
+
+	var tick <-chan time.Time
+	if sec.target != nil {
+		t := time.NewTicker(s.cfg.SecondarySnapshotInterval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		if sec.resync.Swap(false) {
+			if !sec.retry(ctx, "sync", func() error { return s.syncSecondary(ctx, sec) }) {
+				return
+			}
+		}
+		select {
+		case e := <-sec.queue:
+			if !sec.retry(ctx, "copy", func() error { return sec.apply(ctx, e) }) {
+				return
+			}
+		case <-tick:
+			if sec.dirty.Swap(false) {
+				sec.resync.Store(true)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// apply copies one change to a secondary store, unless the copy is
+// already past it or about to be synced in full.
+func (sec *secondary) apply(ctx context.Context, e events.Event) error {
+	if e.Seq <= sec.applied.Load() || sec.resync.Load() {
+		return nil
+	}
+	var err error
+	switch e.Type {
+	case storage.OpSet:
+		_, err = sec.store.Set(ctx, e.Key, e.Value)
+	case storage.OpDelete:
+		_, err = sec.store.Delete(ctx, e.Key)
+	case events.TypeReset:
+		sec.resync.Store(true)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	sec.applied.Store(e.Seq)
+	return nil
+}
+
+// syncSecondary makes the copy match the store as of now: a snapshot
+// target gets a new snapshot, a store the changes that bring it in line.
+func (s *Server) syncSecondary(ctx context.Context, sec *secondary) error {
+	recs, rev, err := s.store.Snapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	if sec.target != nil {
+		data, err := archive.Encode(snapshotEntries(recs))
+		if err != nil {
+			return err
+		}
+		if err := sec.target.Put(ctx, snapshotObject, data); err != nil {
+			return err
+		}
+	} else {
+		have, err := sec.store.GetAll(ctx)
+		if err != nil {
+			return err
+		}
+		want := make(map[string]string, len(recs))
+		for _, rec := range recs {
+			if rec.Op == storage.OpSet {
+				want[rec.Key] = rec.Value
+			}
+		}
+		changed := make(map[string]string)
+		for k, v := range want {
+			if old, ok := have[k]; !ok || old != v {
+				changed[k] = v
+			}
+		}
+		if len(changed) > 0 {
+			if _, err := sec.store.SetMany(ctx, changed); err != nil {
+				return err
+			}
+		}
+		for k := range have {
+			if _, ok := want[k]; !ok {
+				if _, err := sec.store.Delete(ctx, k); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	sec.applied.Store(rev)
+	sec.resyncs.Add(1)
+	return nil
+}
+
+// snapshotEntries turns a store snapshot into the entries of an archive
+// object.
+func snapshotEntries(recs []storage.Record) []archive.Entry {
+	tags := make(map[string][]string)
+	var entries []archive.Entry
+	for _, rec := range recs {
+		switch rec.Op {
+		case storage.OpSet:
+			e := archive.Entry{Key: rec.Key, Value: rec.Value, Revision: rec.Rev, Modified: time.Unix(0, rec.TS).UTC()}
+			if rec.Created != 0 {
+				e.Created = time.Unix(0, rec.Created).UTC()
+			}
+			entries = append(entries, e)
+		case storage.OpTags:
+			tags[rec.Key] = rec.Tags
+		}
+	}
+	for i := range entries {
+		entries[i].Tags = tags[entries[i].Key]
+	}
+	return entries
+}
+
+// load reads the whole copy.
+func (sec *secondary) load(ctx context.Context) (map[string]string, error) {
+	if sec.store != nil {
+		return sec.store.GetAll(ctx)
+	}
+	data, err := sec.target.Get(ctx, snapshotObject)
+	if errors.Is(err, archive.ErrNotFound) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries, err := archive.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(entries))
+	for _, e := range entries {
+		out[e.Key] = e.Value
+	}
+	return out, nil
+}
+
+// retry runs fn until it succeeds, backing off after failures, and
+// reports false if the secondary was closed first.
+func (sec *secondary) retry(ctx context.Context, what string, fn func() error) bool {
+	wait := secondaryRetryMin
+	for {
+		err := fn()
+		if err == nil {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		sec.fail(what, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return false
+		}
+		wait = min(2*wait, secondaryRetryMax)
+	}
+}
+
+func (sec *secondary) fail(what string, err error) {
+	sec.errors.Add(1)
+	sec.mu.Lock()
+	sec.lastError = what + ": " + err.Error()
+	sec.mu.Unlock()
+	log.Printf("[SECONDARY] %s: %s failed: %v\n", sec.name, what, err)
+}
+
+// reconcileJob compares every secondary with the store and reports the
+// keys where they differ.
+func (s *Server) reconcileJob(ctx context.Context) error {
+	var failed error
+	for _, sec := range s.secondaries {
+		if err := s.reconcile(ctx, sec); err != nil {
+			sec.fail("reconcile", err)
+			failed = err
+		}
+	}
+	return failed
+}
+
+func (s *Server) reconcile(ctx context.Context, sec *secondary) error {
+	if sec.resync.Load() {
+		return nil
+	}
+	from := sec.applied.Load()
+	have, err := sec.load(ctx)
+	if err != nil {
+		return err
+	}
+	want, rev, err := s.store.GetSince(ctx, 0)
+	if err != nil {
+		return err
+	}
+	changed, _, err := s.store.ScanKeys(ctx, "", from+1)
+	if err != nil {
+		return err
+	}
+	skip := make(map[string]bool, len(changed))
+	for _, k := range changed {
+		skip[k] = true
+	}
+
+	report := &reconcileReport{Time: time.Now().UTC(), Revision: from}
+	var divergent []string
+	for k, v := range want {
+		if skip[k] {
+			continue
+		}
+		report.Checked++
+		switch old, ok := have[k]; {
+		case !ok:
+			report.Missing++
+			divergent = append(divergent, k)
+		case old != v:
+			report.Mismatched++
+			divergent = append(divergent, k)
+		}
+	}
+	if rev == from {
+		for k := range have {
+			if _, ok := want[k]; !ok {
+				report.Extra++
+				divergent = append(divergent, k)
+			}
+		}
+	}
+	sort.Strings(divergent)
+	if len(divergent) > maxDivergentKeys {
+		divergent = divergent[:maxDivergentKeys]
+	}
+	report.Keys = divergent
+
+	sec.mu.Lock()
+	sec.reconciled = report
+	sec.mu.Unlock()
+	if n := report.divergent(); n > 0 {
+		log.Printf("[SECONDARY] %s diverges at revision %d: %d missing, %d extra, %d mismatched (%s)\n",
+			sec.name, from, report.Missing, report.Extra, report.Mismatched, strings.Join(divergent, ", "))
+	}
+	return nil
+}
+
+func (s *Server) secondaryStats() []secondaryStats {
+	rev := s.store.Revision()
+	out := make([]secondaryStats, 0, len(s.secondaries))
+	for _, sec := range s.secondaries {
+		st := secondaryStats{
+			Name:     sec.name,
+			Kind:     "store",
+			Revision: sec.applied.Load(),
+			Pending:  len(sec.queue),
+			Resyncs:  sec.resyncs.Load(),
+			Dropped:  sec.dropped.Load(),
+			Errors:   sec.errors.Load(),
+		}
+		if sec.target != nil {
+			st.Kind = "snapshots"
+		}
+		if rev > st.Revision {
+			st.Lag = rev - st.Revision
+		}
+		sec.mu.Lock()
+		st.LastError, st.Reconcile = sec.lastError, sec.reconciled
+		sec.mu.Unlock()
+		out = append(out, st)
+	}
+	return out
+}
+
+func (s *Server) collectSecondaryMetrics() []metrics.Family {
+	lag := metrics.Family{Name: "kv_secondary_lag_revisions", Help: "Revisions each -secondary is behind the store.", Type: metrics.TypeGauge}
+	pending := metrics.Family{Name: "kv_secondary_pending_changes", Help: "Changes queued for each -secondary.", Type: metrics.TypeGauge}
+	divergent := metrics.Family{Name: "kv_secondary_divergent_keys", Help: "Keys that differed at the last reconciliation of each -secondary.", Type: metrics.TypeGauge}
+	resyncs := metrics.Family{Name: "kv_secondary_resyncs_total", Help: "Full syncs of each -secondary.", Type: metrics.TypeCounter}
+	failures := metrics.Family{Name: "kv_secondary_errors_total", Help: "Failed copies, syncs and reconciliations of each -secondary.", Type: metrics.TypeCounter}
+	for _, st := range s.secondaryStats() {
+		labels := []metrics.Label{{Name: "secondary", Value: st.Name}}
+		lag.Samples = append(lag.Samples, metrics.Sample{Labels: labels, Value: float64(st.Lag)})
+		pending.Samples = append(pending.Samples, metrics.Sample{Labels: labels, Value: float64(st.Pending)})
+		if st.Reconcile != nil {
+			divergent.Samples = append(divergent.Samples, metrics.Sample{Labels: labels, Value: float64(st.Reconcile.divergent())})
+		}
+		resyncs.Samples = append(resyncs.Samples, metrics.Sample{Labels: labels, Value: float64(st.Resyncs)})
+		failures.Samples = append(failures.Samples, metrics.Sample{Labels: labels, Value: float64(st.Errors)})
+	}
+	return []metrics.Family{lag, pending, divergent, resyncs, failures}
+}
+
+// closeSecondaries stops copying. Changes still queued are not written;
+// the next start syncs in full.
+func (s *Server) closeSecondaries() {
+	for _, sec := range s.secondaries {
+		sec.cancel()
+		<-sec.done
+		if sec.store != nil {
+			sec.store.Close()
+		}
+	}
+}
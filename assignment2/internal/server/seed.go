@@ -0,0 +1,54 @@
+package server
+
+import (
+	"assignment2/internal/seed"
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Seed loads the -seed dataset. In first-boot mode that only happens
+// while the store has never been written to; merge mode sets the
+// dataset's keys over whatever is there, and replace mode also deletes
+// every key the dataset does not have.
+func (s *Server) Seed(ctx context.Context) error {
+	if s.cfg.Seed == "" {
+		return nil
+	}
+	if s.cfg.SeedMode == "first-boot" && s.store.Revision() > 0 {
+		return nil
+	}
+
+	data, err := seed.Load(ctx, s.cfg.Seed)
+	if err != nil {
+		return fmt.Errorf("seed: %w", err)
+	}
+
+	deleted := 0
+	if s.cfg.SeedMode == "replace" {
+		existing, err := s.store.GetAll(ctx)
+		if err != nil {
+			return fmt.Errorf("seed: %w", err)
+		}
+		var stale []string
+		for k := range existing {
+			if _, ok := data[k]; !ok {
+				stale = append(stale, k)
+			}
+		}
+		// Keys changed from here on were written after the seed
+		// started and are left alone.
+		if deleted, err = s.store.DeleteModifiedBefore(ctx, stale, time.Now()); err != nil {
+			return fmt.Errorf("seed: %w", err)
+		}
+	}
+
+	if len(data) > 0 {
+		if _, err := s.store.SetMany(ctx, data); err != nil {
+			return fmt.Errorf("seed: %w", err)
+		}
+	}
+	log.Printf("[SEED] loaded %d keys from %s (%s, %d deleted)\n", len(data), s.cfg.Seed, s.cfg.SeedMode, deleted)
+	return nil
+}
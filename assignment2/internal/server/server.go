@@ -1,23 +1,324 @@
 package server
 
 import (
+	"assignment2/internal/auth"
+	"assignment2/internal/blob"
+	"assignment2/internal/breaker"
+	"assignment2/internal/cluster"
+	"assignment2/internal/config"
+	"assignment2/internal/events"
+	"assignment2/internal/hotkeys"
+	"assignment2/internal/ipfilter"
+	"assignment2/internal/jobs"
+	"assignment2/internal/lease"
+	"assignment2/internal/limit"
+	"assignment2/internal/metrics"
+	"assignment2/internal/rotate"
 	"assignment2/internal/storage"
+	"assignment2/kv"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Server struct {
+	cfg       config.Config
+	db        *kv.DB
 	store     *storage.MemoryStore
+	blobs     *blob.Store
 	mu        sync.Mutex
 	requests  int
 	startTime time.Time
+
+	members   *cluster.Membership
+	discovery *cluster.Discovery
+	elector   *lease.Elector
+	replica   replicaState
+	shipping  shippingState
+
+	limiters map[string]*limit.Limiter
+
+	// lanes is nil without -max-in-flight.
+	lanes *limit.Lanes
+
+	throttle *writeThrottle
+
+	breakersMu sync.Mutex
+	breakers   map[string]*breaker.Breaker
+
+	scripts *scriptRegistry
+	events  *events.Broker
+
+	validators []WriteValidator
+
+	mirror *mirror
+
+	listCache *listCache
+
+	verifier *auth.HMACVerifier
+
+	tenants        map[string]auth.TenantKey
+	tenantRequests *metrics.Vec
+
+	ipFilter *ipfilter.Filter
+	ipDenied *metrics.Vec
+
+	tlsConfig  *tls.Config
+	clientCAs  *x509.CertPool
+	peerCAs    *x509.CertPool
+	roles      *auth.RoleMap
+	peerScheme string
+	peerClient *http.Client
+
+	conns connStats
+
+	accessLogOut *rotate.Writer
+	eventLog     *eventLog
+	auditLog     *rotate.Writer
+	clearTokens  clearTokens
+
+	subscriptions *subscriptionStore
+	imports       importStaging
+	locks         keyLocks
+	checkpoints   checkpoints
+	bulkImports   bulkImports
+	slowRequests  *metrics.Vec
+
+	requestDuration *metrics.HistogramVec
+	slos            []*sloTracker
+
+	faultsInjected *metrics.Vec
+	mocks          []*mockFixture
+
+	maintenance maintenanceState
+	retention   retentionState
+	archive     *archiveState
+	secondaries []*secondary
+	hotKeys     *hotkeys.Tracker
+	integrity   integrityState
+	memory      memoryState
+	quotas      quotaState
+	expired     atomic.Int64
+
+	watchCoalesced atomic.Int64
+
+	jobs    *jobs.Scheduler
+	load    loadState
+	metrics *metrics.Registry
+	history *statsHistory
+
+	startup      startupSummary
+	lastShutdown *shutdownSummary
 }
 
-func NewServer() *Server {
-	return &Server{
-		store:     storage.NewMemoryStore(),
+func NewServer(cfg config.Config) (_ *Server, err error) {
+	s := &Server{
+		cfg:       cfg,
 		startTime: time.Now(),
+		scripts:   newScriptRegistry(),
+		breakers:  make(map[string]*breaker.Breaker),
+		jobs:      jobs.NewScheduler(),
+		metrics:   metrics.NewRegistry(),
+		history:   newStatsHistory(cfg.StatsHistorySize),
+
+		peerScheme: "http",
+		peerClient: &http.Client{Timeout: 5 * time.Second},
+	}
+
+	// The ttl job deletes expired keys instead of the database's own
+	// sweep, so that only the lease holder does, and the tier job moves
+	// values to disk so that it shows up in /admin/jobs.
+	s.db, err = kv.Open(kv.Options{
+		Dir:                cfg.DataDir,
+		SyncWindow:         cfg.WALSyncWindow,
+		NoSync:             cfg.WALNoSync,
+		Dedup:              cfg.Dedup,
+		TierBudget:         cfg.TierBudget << 20,
+		TierInterval:       -1,
+		CompactBytesPerSec: cfg.CompactBytesPerSec,
+		OnLogFailure:       kv.FailurePolicy(cfg.WALFailurePolicy),
+		MaxBuffered:        cfg.WALFailureBuffer << 20,
+		OnLogHealth:        s.logWALHealth,
+		ContentionDepth:    cfg.ContentionDepth,
+		WatchHistory:       cfg.WatchHistory,
+		WatchBuffer:        cfg.WatchBuffer,
+		TTLSweepInterval:   -1,
+		Observer:           s.logEvent,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			s.db.Close()
+		}
+	}()
+	s.store = s.db.Store()
+	s.events = s.db.Events()
+	if err = s.openShipLog(); err != nil {
+		return nil, err
+	}
+	if s.subscriptions, err = loadSubscriptions(cfg.DataDir); err != nil {
+		return nil, err
+	}
+	s.lastShutdown = loadLastShutdown(cfg.DataDir)
+
+	s.maintenance.message = cfg.MaintenanceMessage
+	s.maintenance.retryAfter = cfg.MaintenanceRetryAfter
+	s.retention.policies = newRetentionPolicies(cfg)
+	if s.archive, err = newArchive(s); err != nil {
+		return nil, err
 	}
+	if err = s.openSecondaries(); err != nil {
+		return nil, err
+	}
+	if cfg.FlagPrefix != "" {
+		s.AddWriteValidator(WriteValidatorFunc(s.validateFlag))
+	}
+	if cfg.HotKeys > 0 {
+		s.hotKeys = hotkeys.New(cfg.HotKeys)
+	}
+	s.limiters = newLimiters(s)
+	s.lanes = newLanes(s)
+	if cfg.ListCacheSize > 0 {
+		s.listCache = newListCache(cfg.ListCacheSize << 20)
+	}
+
+	if cfg.BlobDir != "" {
+		if s.blobs, err = blob.Open(cfg.BlobDir); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.ipFilter, err = ipfilter.New(cfg.IPAllow, cfg.IPDeny); err != nil {
+		return nil, err
+	}
+	s.ipDenied = metrics.NewCounterVec("kv_ip_denied_total", "Requests rejected by the IP allow/deny lists.", "list")
+
+	if cfg.WriteBytesPerSec > 0 {
+		s.throttle = newWriteThrottle(cfg.WriteBytesPerSec, cfg.WriteBurstBytes, cfg.WriteThrottleWait)
+	}
+
+	s.slowRequests = metrics.NewCounterVec("kv_slow_requests_total", "Requests that took longer than -slow-request.", "method")
+	s.requestDuration = newRequestDuration()
+	s.faultsInjected = metrics.NewCounterVec("kv_faults_injected_total", "Faults injected by -fault-injection.", "route", "fault")
+
+	if err := s.setupTLS(); err != nil {
+		return nil, err
+	}
+
+	if cfg.ClusterEnabled() {
+		s.members = cluster.NewMembership(advertiseAddr(cfg))
+		s.discovery = &cluster.Discovery{
+			Seeds:    cfg.ClusterSeeds,
+			SRV:      cfg.ClusterSRV,
+			Interval: cfg.ClusterRefresh,
+			Members:  s.members,
+			Scheme:   s.peerScheme,
+			Client:   s.peerClient,
+		}
+	}
+
+	if cfg.LeaseName != "" {
+		elector, err := lease.NewInClusterElector(cfg.LeaseName, cfg.LeaseNamespace, advertiseAddr(cfg), cfg.LeaseDuration)
+		if err != nil {
+			return nil, err
+		}
+		s.elector = elector
+	}
+
+	if cfg.Mock != "" {
+		if s.mocks, err = loadMockFixtures(cfg.Mock); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.HMACKeysFile != "" {
+		keys, err := auth.LoadKeyFile(cfg.HMACKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		s.verifier = &auth.HMACVerifier{
+			Keys:    keys,
+			MaxSkew: cfg.HMACMaxSkew,
+			Nonces:  auth.NewNonceStore(cfg.NonceCapacity),
+		}
+	}
+
+	if cfg.APIKeysFile != "" {
+		if s.tenants, err = auth.LoadTenantKeys(cfg.APIKeysFile); err != nil {
+			return nil, err
+		}
+		s.tenantRequests = metrics.NewCounterVec("kv_tenant_requests_total", "Data requests per tenant.", "tenant")
+	}
+
+	if cfg.AccessLog != "" {
+		s.accessLogOut = &rotate.Writer{
+			Path:       cfg.AccessLog,
+			MaxSize:    cfg.AccessLogMaxSize << 20,
+			MaxAge:     cfg.AccessLogMaxAge,
+			MaxBackups: cfg.AccessLogMaxBackups,
+			Compress:   cfg.AccessLogCompress,
+		}
+	}
+
+	if cfg.EventLog != "" {
+		s.eventLog = newEventLog(&rotate.Writer{
+			Path:       cfg.EventLog,
+			MaxSize:    cfg.EventLogMaxSize << 20,
+			MaxAge:     cfg.EventLogMaxAge,
+			MaxBackups: cfg.EventLogMaxBackups,
+			Compress:   cfg.EventLogCompress,
+		}, cfg.EventLogPrefixes)
+	}
+
+	if cfg.AuditLog != "" {
+		s.auditLog = &rotate.Writer{Path: cfg.AuditLog}
+	}
+
+	if cfg.MirrorURL != "" {
+		s.mirror = newMirror(cfg.MirrorURL, cfg.MirrorPercent, cfg.MirrorTimeout, cfg.MirrorQueue, advertiseAddr(cfg))
+	}
+
+	s.registerJobs()
+	s.registerMetrics()
+
+	return s, nil
+}
+
+// Close releases resources held by the server once it has stopped
+// serving requests.
+func (s *Server) Close() error {
+	s.bulkImports.discard()
+	s.closeSecondaries()
+	err := s.db.Close()
+	if s.accessLogOut != nil {
+		if cerr := s.accessLogOut.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if s.eventLog != nil {
+		if cerr := s.eventLog.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if s.auditLog != nil {
+		if cerr := s.auditLog.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := s.subscriptions.save(); err == nil {
+		err = cerr
+	}
+	if s.mirror != nil {
+		s.mirror.Close()
+	}
+	return err
 }
 
 func (s *Server) IncrementRequests() {
@@ -32,3 +333,22 @@ func (s *Server) Stats() (int, int, int) {
 
 	return s.requests, s.store.Size(), int(time.Since(s.startTime).Seconds())
 }
+
+// advertiseAddr falls back to the hostname plus the listen port, which is
+// the stable per-pod DNS name in a StatefulSet behind a headless service.
+func advertiseAddr(cfg config.Config) string {
+	if cfg.AdvertiseAddr != "" {
+		return cfg.AdvertiseAddr
+	}
+
+	host, port, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return cfg.Addr
+	}
+	if host == "" {
+		if name, err := os.Hostname(); err == nil {
+			host = name
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
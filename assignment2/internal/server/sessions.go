@@ -0,0 +1,192 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Sessions are ordinary keys under -session-prefix whose time to live
+// slides: every read or write of a session through /sessions gives it
+// -session-ttl again, so a session expires once it has gone unused that
+// long.
+
+type sessionRequest struct {
+	Value string `json:"value"`
+}
+
+type sessionResponse struct {
+	ID        string    `json:"id"`
+	Value     string    `json:"value"`
+	Revision  uint64    `json:"revision"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func newSessionID() string {
+	var raw [16]byte
+	rand.Read(raw[:])
+	return hex.EncodeToString(raw[:])
+}
+
+func (s *Server) sessionKey(r *http.Request, id string) string {
+	return scopedKey(r, s.cfg.SessionPrefix+id)
+}
+
+// sessionScope refuses a session route unless the API key's scopes allow
+// access to the session's key. POST /sessions has no {id} yet, so its
+// scopes must cover the whole -session-prefix.
+func (s *Server) sessionScope(access string, next http.HandlerFunc) http.HandlerFunc {
+	if s.tenants == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := s.cfg.SessionPrefix + r.PathValue("id")
+		if !allowKey(r, access, key) {
+			s.IncrementRequests()
+			http.Error(w, "Forbidden: API key scopes do not allow "+access+" of "+key, http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isSessionKey reports whether key, as the client names it, is one that
+// only /sessions may write.
+func (s *Server) isSessionKey(key string) bool {
+	return s.cfg.SessionTTL > 0 && strings.HasPrefix(key, s.cfg.SessionPrefix)
+}
+
+// sessionGate refuses writes to the {key} of the route under
+// -session-prefix, so that clients cannot make up session IDs through
+// /data.
+func (s *Server) sessionGate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if key := r.PathValue("key"); s.isSessionKey(key) {
+			s.IncrementRequests()
+			http.Error(w, "Forbidden: "+key+" is a session, written through /sessions", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writeSession extends the session and answers with it.
+func (s *Server) writeSession(w http.ResponseWriter, r *http.Request, id string) {
+	entry, ok, err := s.store.Expire(r.Context(), s.sessionKey(r, id), s.cfg.SessionTTL)
+	if err != nil {
+		storeFailed(w, "Failed to extend session: ", err)
+		return
+	}
+	if !ok {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	setRevision(w, entry.Revision)
+	writeJSON(w, http.StatusOK, sessionResponse{ID: id, Value: entry.Value, Revision: entry.Revision, ExpiresAt: entry.ExpiresAt})
+}
+
+// readSession reads the optional {"value": ...} body of a session write.
+func readSession(r *http.Request) (sessionRequest, bool) {
+	var req sessionRequest
+	if r.ContentLength == 0 {
+		return req, true
+	}
+	return req, readJSON(r.Body, &req) == nil
+}
+
+// POST /sessions
+//
+// Starts a session holding the body's "value" (may be left out) and
+// returns its ID, a random 128-bit hex string.
+func (s *Server) CreateSession(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	req, ok := readSession(r)
+	if !ok {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	id := newSessionID()
+	expires := time.Now().Add(s.cfg.SessionTTL).Round(0)
+	rev, err := s.store.SetManyTTL(r.Context(), map[string]string{s.sessionKey(r, id): req.Value}, s.cfg.SessionTTL)
+	if err != nil {
+		storeFailed(w, "Failed to store: ", err)
+		return
+	}
+	setRevision(w, rev)
+	writeJSON(w, http.StatusCreated, sessionResponse{ID: id, Value: req.Value, Revision: rev, ExpiresAt: expires})
+}
+
+// GET /sessions/{id}
+//
+// Returns the session and extends it by -session-ttl.
+func (s *Server) GetSession(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	s.writeSession(w, r, r.PathValue("id"))
+}
+
+// PUT /sessions/{id}
+//
+// Replaces the value of an existing session and extends it by
+// -session-ttl. Sessions are only created by POST /sessions, so that
+// clients cannot pick their IDs.
+func (s *Server) PutSession(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	req, ok := readSession(r)
+	if !ok {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	id := r.PathValue("id")
+	key := s.sessionKey(r, id)
+	_, err := s.store.Update(r.Context(), key, func(old string, exists bool) (string, bool, error) {
+		if !exists {
+			return "", false, errNotFound
+		}
+		return req.Value, true, nil
+	})
+	switch err {
+	case nil:
+	case errNotFound:
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	default:
+		storeFailed(w, "Failed to store: ", err)
+		return
+	}
+
+	s.writeSession(w, r, id)
+}
+
+// DELETE /sessions/{id}
+//
+// Ends the session, e.g. on logout.
+func (s *Server) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	id := r.PathValue("id")
+	rev, err := s.store.Update(r.Context(), s.sessionKey(r, id), func(old string, exists bool) (string, bool, error) {
+		if !exists {
+			return "", false, errNotFound
+		}
+		return "", false, nil
+	})
+	switch err {
+	case nil:
+	case errNotFound:
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	default:
+		storeFailed(w, "Failed to delete: ", err)
+		return
+	}
+	setRevision(w, rev)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": id, "deleted": true})
+}
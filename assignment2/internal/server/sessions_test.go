@@ -0,0 +1,42 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestSessionAccess(t *testing.T) {
+	url := newTenantServer(t, "app:acme\nreader:acme read:sessions/*\nother:acme write:jobs:* read:jobs:*\n", "-session-ttl", "1h")
+
+	code, body := call(t, http.MethodPost, url+"/sessions", "app", `{"value":"alice"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("POST /sessions: %d %s", code, body)
+	}
+	var created sessionResponse
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		method, path, apiKey, body string
+		want                       int
+	}{
+		{http.MethodGet, "/sessions/" + created.ID, "reader", "", http.StatusOK},
+		{http.MethodPut, "/sessions/" + created.ID, "reader", `{"value":"mallory"}`, http.StatusForbidden},
+		{http.MethodPost, "/sessions", "reader", "", http.StatusForbidden},
+		{http.MethodGet, "/sessions/" + created.ID, "other", "", http.StatusForbidden},
+		{http.MethodPut, "/sessions/" + created.ID, "app", `{"value":"bob"}`, http.StatusOK},
+
+		// Session keys can't be written around /sessions.
+		{http.MethodPost, "/data", "app", `{"sessions/chosen":"mallory"}`, http.StatusForbidden},
+		{http.MethodPatch, "/data/sessions%2F" + created.ID, "app", `{"value":"mallory"}`, http.StatusForbidden},
+		{http.MethodDelete, "/data/sessions%2F" + created.ID, "app", "", http.StatusForbidden},
+		{http.MethodGet, "/sessions/chosen", "app", "", http.StatusNotFound},
+		{http.MethodGet, "/data/sessions%2F" + created.ID, "app", "", http.StatusOK},
+	} {
+		if code, body := call(t, c.method, url+c.path, c.apiKey, c.body); code != c.want {
+			t.Errorf("%s %s as %s: %d %s, want %d", c.method, c.path, c.apiKey, code, body, c.want)
+		}
+	}
+}
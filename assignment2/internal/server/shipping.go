@@ -0,0 +1,287 @@
+package server
+
+import (
+	"assignment2/internal/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errResync means a standby has to start over from a snapshot: the
+// primary no longer has the segments it needs, or restored a snapshot
+// itself.
+var errResync = errors.New("log shipping needs a new snapshot")
+
+// shippingState is the primary's shipping log, and a standby's progress
+// pulling it.
+type shippingState struct {
+	// log is nil without -log-shipping.
+	log *storage.ShipLog
+
+	promoted atomic.Bool
+
+	mu        sync.Mutex
+	lastPoll  time.Time
+	lastApply time.Time
+	lastErr   string
+	behind    int
+	applied   int64
+	resyncs   int64
+}
+
+type shippingStats struct {
+	// Primary
+	Segments    *int    `json:"segments,omitempty"`
+	PendingSize *int    `json:"pending_bytes,omitempty"`
+	LastSealed  *uint64 `json:"last_sealed_revision,omitempty"`
+
+	// Standby
+	Primary   string     `json:"primary,omitempty"`
+	Following *bool      `json:"following,omitempty"`
+	Revision  *uint64    `json:"revision,omitempty"`
+	Behind    *int       `json:"segments_behind,omitempty"`
+	Applied   *int64     `json:"segments_applied,omitempty"`
+	Resyncs   *int64     `json:"resyncs,omitempty"`
+	LastPoll  *time.Time `json:"last_poll,omitempty"`
+	LastApply *time.Time `json:"last_apply,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// openShipLog starts the shipping log with -log-shipping.
+func (s *Server) openShipLog() error {
+	if !s.cfg.LogShipping {
+		return nil
+	}
+	l, err := storage.OpenShipLog(filepath.Join(s.cfg.DataDir, "shipping"))
+	if err != nil {
+		return err
+	}
+	s.shipping.log = l
+	s.store.ShipTo(l)
+	return nil
+}
+
+// following reports whether this server is a log shipping standby that
+// has not been promoted.
+func (s *Server) following() bool {
+	return s.cfg.ShipFrom != "" && !s.shipping.promoted.Load()
+}
+
+// isStandby reports whether another server is the one taking writes:
+// the lease holder, or the primary a log shipping standby follows.
+func (s *Server) isStandby() bool {
+	return s.elector != nil && !s.elector.IsLeader() || s.following()
+}
+
+// sealSegments is the ship-seal job: it seals the pending changes once
+// they reach -ship-segment-size or -ship-segment-age, and drops the
+// segments beyond -ship-retain.
+func (s *Server) sealSegments(ctx context.Context) error {
+	size, since := s.shipping.log.Pending()
+	if size == 0 || int64(size) < s.cfg.ShipSegmentSize<<20 && time.Since(since) < s.cfg.ShipSegmentAge {
+		return nil
+	}
+	if _, _, err := s.shipping.log.Seal(); err != nil {
+		return err
+	}
+	_, err := s.shipping.log.Prune(s.cfg.ShipRetain)
+	return err
+}
+
+// pullSegments is the ship-pull job of a standby: it applies the
+// primary's segments after the local revision, in order, and starts over
+// from the primary's snapshot when there is a gap.
+func (s *Server) pullSegments(ctx context.Context) error {
+	if !s.following() {
+		return nil
+	}
+	err := s.pullSegmentsFrom(ctx, s.cfg.ShipFrom)
+	if err == errResync {
+		log.Printf("[SHIPPING] revision %d is no longer covered by %s's segments, syncing a snapshot\n", s.store.Revision(), s.cfg.ShipFrom)
+		if err = s.syncSnapshot(ctx, s.cfg.ShipFrom); err == nil {
+			s.shipping.mu.Lock()
+			s.shipping.resyncs++
+			s.shipping.mu.Unlock()
+		}
+	}
+
+	s.shipping.mu.Lock()
+	s.shipping.lastPoll = time.Now()
+	s.shipping.lastErr = ""
+	if err != nil {
+		s.shipping.lastErr = err.Error()
+	}
+	s.shipping.mu.Unlock()
+	return err
+}
+
+func (s *Server) pullSegmentsFrom(ctx context.Context, primary string) error {
+	resp, err := s.peerGet(ctx, primary, "/cluster/segments?after="+strconv.FormatUint(s.store.Revision(), 10))
+	if err != nil {
+		return err
+	}
+	var list struct {
+		Segments []storage.Segment `json:"segments"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	for i, seg := range list.Segments {
+		s.shipping.mu.Lock()
+		s.shipping.behind = len(list.Segments) - i
+		s.shipping.mu.Unlock()
+
+		if ctx.Err() != nil || !s.following() {
+			return ctx.Err()
+		}
+		if seg.First > s.store.Revision()+1 {
+			return errResync
+		}
+		if err := s.applySegment(ctx, primary, seg); err != nil {
+			return err
+		}
+
+		s.shipping.mu.Lock()
+		s.shipping.applied++
+		s.shipping.lastApply = time.Now()
+		s.shipping.mu.Unlock()
+	}
+
+	s.shipping.mu.Lock()
+	s.shipping.behind = 0
+	s.shipping.mu.Unlock()
+	return nil
+}
+
+func (s *Server) applySegment(ctx context.Context, primary string, seg storage.Segment) error {
+	resp, err := s.peerGet(ctx, primary, "/cluster/segments/"+url.PathEscape(seg.Name))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return storage.ReadSegment(resp.Body, func(rec storage.Record) error {
+		if rec.Op == storage.OpReset {
+			return errResync
+		}
+		return s.store.ApplyReplicated(ctx, rec)
+	})
+}
+
+// GET /cluster/segments
+//
+// Lists the sealed log segments with changes after revision ?after=,
+// oldest first.
+func (s *Server) ListSegments(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	if s.shipping.log == nil {
+		http.Error(w, "Log shipping is not enabled (-log-shipping)", http.StatusNotImplemented)
+		return
+	}
+	var after uint64
+	if v := r.URL.Query().Get("after"); v != "" {
+		var err error
+		if after, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, "Invalid after", http.StatusBadRequest)
+			return
+		}
+	}
+
+	segments := s.shipping.log.Segments(after)
+	if segments == nil {
+		segments = []storage.Segment{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"revision": s.store.Revision(),
+		"segments": segments,
+	})
+}
+
+// GET /cluster/segments/{name}
+//
+// Serves a sealed segment: gzip-compressed JSON lines in the format of
+// the write-ahead log.
+func (s *Server) GetSegment(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	if s.shipping.log == nil {
+		http.Error(w, "Log shipping is not enabled (-log-shipping)", http.StatusNotImplemented)
+		return
+	}
+	f, err := s.shipping.log.OpenSegment(r.PathValue("name"))
+	if err != nil {
+		http.Error(w, "Segment not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/gzip")
+	io.Copy(w, f)
+}
+
+// POST /admin/promote
+//
+// Turns a log shipping standby into a primary: it stops pulling segments
+// and takes writes. Restart it without -ship-from to keep it that way.
+func (s *Server) Promote(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	if s.cfg.ShipFrom == "" {
+		http.Error(w, "Not a log shipping standby (-ship-from)", http.StatusConflict)
+		return
+	}
+	if s.shipping.promoted.CompareAndSwap(false, true) {
+		log.Printf("[SHIPPING] promoted at revision %d; no longer following %s\n", s.store.Revision(), s.cfg.ShipFrom)
+		s.audit(r, "promote", map[string]interface{}{"revision": s.store.Revision()})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"promoted": true,
+		"revision": s.store.Revision(),
+	})
+}
+
+func (s *Server) shippingStats() shippingStats {
+	var st shippingStats
+	if s.shipping.log != nil {
+		segments := s.shipping.log.Segments(0)
+		pending, _ := s.shipping.log.Pending()
+		n := len(segments)
+		st.Segments, st.PendingSize = &n, &pending
+		if n > 0 {
+			st.LastSealed = &segments[n-1].Last
+		}
+	}
+	if s.cfg.ShipFrom != "" {
+		s.shipping.mu.Lock()
+		defer s.shipping.mu.Unlock()
+		following := s.following()
+		rev := s.store.Revision()
+		behind, applied, resyncs := s.shipping.behind, s.shipping.applied, s.shipping.resyncs
+		st.Primary = s.cfg.ShipFrom
+		st.Following, st.Revision = &following, &rev
+		st.Behind, st.Applied, st.Resyncs = &behind, &applied, &resyncs
+		if !s.shipping.lastPoll.IsZero() {
+			poll := s.shipping.lastPoll
+			st.LastPoll = &poll
+		}
+		if !s.shipping.lastApply.IsZero() {
+			apply := s.shipping.lastApply
+			st.LastApply = &apply
+		}
+		st.LastError = s.shipping.lastErr
+	}
+	return st
+}
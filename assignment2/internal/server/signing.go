@@ -0,0 +1,59 @@
+package server
+
+import (
+	"assignment2/internal/auth"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// requireSignature rejects requests without a valid HMAC signature when
+// signing is configured. The signature covers a hash of the body, which
+// is read into memory, up to -hmac-max-body, before anything acts on it;
+// larger bodies get 413. Replayed nonces get 409 so clients can tell a
+// duplicate apart from a bad signature. Peer traffic under /cluster/ and
+// the anonymous reads under /public/ are exempt. The signature covers
+// the URI as sent, /v2/ prefix included.
+func (s *Server) requireSignature(next http.Handler) http.Handler {
+	if s.verifier == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/cluster/") || strings.HasPrefix(r.URL.Path, "/public/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit := s.cfg.HMACMaxBody << 20
+		body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+		if err != nil {
+			s.IncrementRequests()
+			http.Error(w, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		if int64(len(body)) > limit {
+			s.IncrementRequests()
+			http.Error(w, "Signed request body exceeds -hmac-max-body", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		switch err := s.verifier.Verify(sentRequest(r), body); err {
+		case nil:
+			RequestInfoFrom(r.Context()).KeyID = r.Header.Get(auth.HeaderKeyID)
+			next.ServeHTTP(w, r)
+		case auth.ErrReplay:
+			s.IncrementRequests()
+			http.Error(w, "Replayed request", http.StatusConflict)
+		case auth.ErrNonceStoreFull:
+			s.IncrementRequests()
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Too many recent requests", http.StatusServiceUnavailable)
+		default:
+			s.IncrementRequests()
+			http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+		}
+	})
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"assignment2/internal/config"
+	"assignment2/internal/metrics"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// burnWindows are the windows burn rates are reported for, shortest
+// first; sloAlerts
+// pair them as in the multiwindow, multi-burn-rate alerts of the Google
+// SRE workbook: an alert fires while both its windows burn faster than
+// its rate, so it starts quickly and stops soon after the burn does.
+var burnWindows = []time.Duration{5 * time.Minute, 30 * time.Minute, time.Hour, 2 * time.Hour, 6 * time.Hour, 24 * time.Hour}
+
+var sloAlerts = []struct {
+	Severity string
+	Long     time.Duration
+	Short    time.Duration
+	BurnRate float64
+}{
+	{"page", time.Hour, 5 * time.Minute, 14.4},
+	{"page", 6 * time.Hour, 30 * time.Minute, 6},
+	{"ticket", 24 * time.Hour, 2 * time.Hour, 3},
+}
+
+// sloTracker counts one route's requests within and over its threshold,
+// per minute for the burn windows and per hour for the SLO window.
+type sloTracker struct {
+	route string
+	slo   config.SLO
+
+	mu      sync.Mutex
+	minutes sloRing
+	hours   sloRing
+	good    int64
+	total   int64
+}
+
+// sloRing holds the counts of the last len(slots) periods of width.
+type sloRing struct {
+	width time.Duration
+	slots []sloCount
+}
+
+type sloCount struct {
+	period      int64
+	good, total int64
+}
+
+func newSLORing(width, span time.Duration) sloRing {
+	return sloRing{width: width, slots: make([]sloCount, (span+width-1)/width)}
+}
+
+func (r *sloRing) add(now time.Time, good bool) {
+	p := now.UnixNano() / int64(r.width)
+	c := &r.slots[p%int64(len(r.slots))]
+	if c.period != p {
+		*c = sloCount{period: p}
+	}
+	c.total++
+	if good {
+		c.good++
+	}
+}
+
+// sum counts the periods overlapping the window ending now.
+func (r *sloRing) sum(now time.Time, window time.Duration) (good, total int64) {
+	end := now.UnixNano() / int64(r.width)
+	n := min(int64((window+r.width-1)/r.width), int64(len(r.slots)))
+	for p := end - n + 1; p <= end; p++ {
+		if c := r.slots[p%int64(len(r.slots))]; c.period == p {
+			good += c.good
+			total += c.total
+		}
+	}
+	return good, total
+}
+
+func newSLOTracker(route string, slo config.SLO, window time.Duration) *sloTracker {
+	return &sloTracker{
+		route:   route,
+		slo:     slo,
+		minutes: newSLORing(time.Minute, burnWindows[len(burnWindows)-1]),
+		hours:   newSLORing(time.Hour, window),
+	}
+}
+
+func (t *sloTracker) observe(d time.Duration) {
+	now := time.Now()
+	good := d <= t.slo.Threshold
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.minutes.add(now, good)
+	t.hours.add(now, good)
+	t.total++
+	if good {
+		t.good++
+	}
+}
+
+// sum counts the requests of the last window, to the minute up to a day
+// and to the hour beyond.
+func (t *sloTracker) sum(window time.Duration) (good, total int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if window <= burnWindows[len(burnWindows)-1] {
+		return t.minutes.sum(time.Now(), window)
+	}
+	return t.hours.sum(time.Now(), window)
+}
+
+// burnRate is how fast the window spends the error budget: 1 spends it
+// exactly over the SLO window, 10 ten times as fast.
+func (t *sloTracker) burnRate(window time.Duration) float64 {
+	good, total := t.sum(window)
+	if total == 0 {
+		return 0
+	}
+	return float64(total-good) / float64(total) / (1 - t.slo.Objective)
+}
+
+type sloAlert struct {
+	Severity    string  `json:"severity"`
+	LongWindow  string  `json:"long_window"`
+	ShortWindow string  `json:"short_window"`
+	BurnRate    float64 `json:"burn_rate"`
+	Firing      bool    `json:"firing"`
+}
+
+type sloStatus struct {
+	Route       string  `json:"route"`
+	Objective   float64 `json:"objective"`
+	ThresholdMS float64 `json:"threshold_ms"`
+
+	// Over the SLO window.
+	Good                 int64   `json:"good"`
+	Total                int64   `json:"total"`
+	Compliance           float64 `json:"compliance"`
+	ErrorBudgetRemaining float64 `json:"error_budget_remaining"`
+
+	BurnRates map[string]float64 `json:"burn_rates"`
+	Alerts    []sloAlert         `json:"alerts"`
+}
+
+func (t *sloTracker) status(window time.Duration) sloStatus {
+	st := sloStatus{
+		Route:       t.route,
+		Objective:   t.slo.Objective,
+		ThresholdMS: float64(t.slo.Threshold.Microseconds()) / 1000,
+		Compliance:  1,
+		BurnRates:   make(map[string]float64, len(burnWindows)),
+	}
+	st.Good, st.Total = t.sum(window)
+	st.ErrorBudgetRemaining = 1
+	if st.Total > 0 {
+		bad := float64(st.Total - st.Good)
+		st.Compliance = float64(st.Good) / float64(st.Total)
+		st.ErrorBudgetRemaining = 1 - bad/((1-t.slo.Objective)*float64(st.Total))
+	}
+
+	rates := make(map[time.Duration]float64, len(burnWindows))
+	for _, w := range burnWindows {
+		rates[w] = t.burnRate(w)
+		st.BurnRates[formatWindow(w)] = rates[w]
+	}
+	for _, a := range sloAlerts {
+		st.Alerts = append(st.Alerts, sloAlert{
+			Severity:    a.Severity,
+			LongWindow:  formatWindow(a.Long),
+			ShortWindow: formatWindow(a.Short),
+			BurnRate:    a.BurnRate,
+			Firing:      rates[a.Long] > a.BurnRate && rates[a.Short] > a.BurnRate,
+		})
+	}
+	return st
+}
+
+// formatWindow writes 5m, 1h or 1d rather than 5m0s, 1h0m0s or 24h0m0s.
+func formatWindow(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return strconv.FormatInt(int64(d/(24*time.Hour)), 10) + "d"
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	default:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	}
+}
+
+// sloTrackerFor makes the tracker of route if it has an SLO of its own
+// or, for client routes, through "*".
+func (s *Server) sloTrackerFor(route string) *sloTracker {
+	slo, ok := s.cfg.SLOs[route]
+	if !ok && clientRoute(route) {
+		slo, ok = s.cfg.SLOs[allRoutes]
+	}
+	if !ok || streamingRoutes[route] {
+		return nil
+	}
+	t := newSLOTracker(route, slo, s.cfg.SLOWindow)
+	s.slos = append(s.slos, t)
+	return t
+}
+
+// checkSLORoutes warns about -slo entries that name no route, and puts
+// the trackers in route order for reporting.
+func (s *Server) checkSLORoutes(routes map[string]bool) {
+	for route := range s.cfg.SLOs {
+		if route != allRoutes && !routes[route] {
+			log.Printf("[SLO] -slo: no route %q\n", route)
+		}
+	}
+	sort.Slice(s.slos, func(i, j int) bool { return s.slos[i].route < s.slos[j].route })
+}
+
+// GET /stats/slo
+//
+// Compliance and remaining error budget of every route with an SLO over
+// -slo-window, burn rates over shorter windows and which burn-rate
+// alerts are firing.
+func (s *Server) SLOStats(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	if len(s.slos) == 0 {
+		http.Error(w, "No SLOs configured", http.StatusNotFound)
+		return
+	}
+
+	out := make([]sloStatus, len(s.slos))
+	firing := 0
+	for i, t := range s.slos {
+		out[i] = t.status(s.cfg.SLOWindow)
+		for _, a := range out[i].Alerts {
+			if a.Firing {
+				firing++
+			}
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"window": formatWindow(s.cfg.SLOWindow),
+		"slos":   out,
+		"firing": firing,
+	})
+}
+
+func (s *Server) collectSLOMetrics() []metrics.Family {
+	objective := metrics.Family{Name: "kv_slo_objective", Help: "Share of requests that should be answered within the route's SLO threshold.", Type: metrics.TypeGauge}
+	compliance := metrics.Family{Name: "kv_slo_compliance", Help: "Share of requests within the SLO threshold over -slo-window.", Type: metrics.TypeGauge}
+	budget := metrics.Family{Name: "kv_slo_error_budget_remaining", Help: "Share of the error budget left over -slo-window.", Type: metrics.TypeGauge}
+	burn := metrics.Family{Name: "kv_slo_burn_rate", Help: "Rate at which the error budget is spent, by window (1 = exactly over -slo-window).", Type: metrics.TypeGauge}
+	alerts := metrics.Family{Name: "kv_slo_alert_firing", Help: "1 while a burn-rate alert fires.", Type: metrics.TypeGauge}
+	good := metrics.Family{Name: "kv_slo_good_requests_total", Help: "Requests answered within the SLO threshold.", Type: metrics.TypeCounter}
+	total := metrics.Family{Name: "kv_slo_requests_total", Help: "Requests counted towards an SLO.", Type: metrics.TypeCounter}
+
+	for _, t := range s.slos {
+		st := t.status(s.cfg.SLOWindow)
+		route := metrics.Label{Name: "route", Value: t.route}
+		labels := []metrics.Label{route}
+		objective.Samples = append(objective.Samples, metrics.Sample{Labels: labels, Value: st.Objective})
+		compliance.Samples = append(compliance.Samples, metrics.Sample{Labels: labels, Value: st.Compliance})
+		budget.Samples = append(budget.Samples, metrics.Sample{Labels: labels, Value: st.ErrorBudgetRemaining})
+		for _, w := range burnWindows {
+			name := formatWindow(w)
+			burn.Samples = append(burn.Samples, metrics.Sample{Labels: []metrics.Label{route, {Name: "window", Value: name}}, Value: st.BurnRates[name]})
+		}
+		for _, a := range st.Alerts {
+			v := 0.0
+			if a.Firing {
+				v = 1
+			}
+			labels := []metrics.Label{route, {Name: "severity", Value: a.Severity}, {Name: "windows", Value: a.LongWindow + "/" + a.ShortWindow}}
+			alerts.Samples = append(alerts.Samples, metrics.Sample{Labels: labels, Value: v})
+		}
+
+		t.mu.Lock()
+		good.Samples = append(good.Samples, metrics.Sample{Labels: labels, Value: float64(t.good)})
+		total.Samples = append(total.Samples, metrics.Sample{Labels: labels, Value: float64(t.total)})
+		t.mu.Unlock()
+	}
+	return []metrics.Family{objective, compliance, budget, burn, alerts, good, total}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"assignment2/internal/storage"
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// requestTiming collects where a request spent its time.
+type requestTiming struct {
+	handlerStart time.Time
+	handlerEnd   time.Time
+	store        *storage.OpTimer
+}
+
+type requestTimingKey struct{}
+
+// slowLog logs requests that take longer than -slow-request with a
+// breakdown into middleware (authentication, signature checks, IP
+// filtering), handler and store time, and counts them in
+// kv_slow_requests_total. Watch streams are long-lived by design and
+// are left out.
+func (s *Server) slowLog(next http.Handler) http.Handler {
+	threshold := s.cfg.SlowRequest
+	if threshold <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/watch" || r.URL.Path == "/cluster/watch" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		timing := &requestTiming{}
+		ctx, store := storage.WithOpTimer(r.Context())
+		timing.store = store
+		ctx = context.WithValue(ctx, requestTimingKey{}, timing)
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		total := time.Since(start)
+		if total < threshold {
+			return
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		var handler time.Duration
+		if !timing.handlerStart.IsZero() {
+			handler = timing.handlerEnd.Sub(timing.handlerStart)
+		}
+		storeTime := store.Total()
+		s.slowRequests.Inc(r.Method)
+		log.Printf("[WARN] slow request: %s %s status=%d total=%s middleware=%s handler=%s store=%s (%d ops) remote=%s id=%s\n",
+			r.Method, r.URL.RequestURI(), rec.status, total, total-handler, max(handler-storeTime, 0), storeTime, store.Ops(), r.RemoteAddr, RequestInfoFrom(r.Context()).ID)
+	})
+}
+
+// timeHandler marks when routing hands the request to its handler and
+// when the handler returns, for slowLog's breakdown.
+func (s *Server) timeHandler(next http.Handler) http.Handler {
+	if s.cfg.SlowRequest <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timing, ok := r.Context().Value(requestTimingKey{}).(*requestTiming)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		timing.handlerStart = time.Now()
+		defer func() { timing.handlerEnd = time.Now() }()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"assignment2/internal/breaker"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+)
+
+// proxiedHeader marks requests a standby forwarded, so two nodes that
+// disagree about the holder can't bounce a write back and forth.
+const proxiedHeader = "X-Standby-Proxied"
+
+// StartLease competes for the Kubernetes Lease until ctx is cancelled.
+// It returns immediately when lease mode is off.
+func (s *Server) StartLease(ctx context.Context) {
+	if s.elector == nil {
+		return
+	}
+	s.elector.Run(ctx)
+}
+
+// leaderOnly lets writes through only on the lease holder. A standby
+// either rejects them or proxies them to the holder, per -standby-mode;
+// a log shipping standby rejects them until it is promoted.
+func (s *Server) leaderOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.following() {
+			s.IncrementRequests()
+			http.Error(w, "Standby: writes are accepted only by the primary (-ship-from)", http.StatusServiceUnavailable)
+			return
+		}
+		if s.elector == nil || s.elector.IsLeader() {
+			next(w, r)
+			return
+		}
+
+		holder := s.elector.Holder()
+		if s.cfg.StandbyMode == "proxy" && holder != "" && r.Header.Get(proxiedHeader) == "" {
+			s.IncrementRequests()
+			s.proxyToHolder(w, r, holder)
+			return
+		}
+
+		s.IncrementRequests()
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Standby: writes are accepted only by the lease holder", http.StatusServiceUnavailable)
+	}
+}
+
+// proxyToHolder forwards a write through the upstream's circuit breaker,
+// so a dead holder fails fast instead of timing out every client.
+func (s *Server) proxyToHolder(w http.ResponseWriter, r *http.Request, holder string) {
+	b := s.breakerFor(holder)
+	if !b.Allow() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(s.cfg.BreakerCooldown.Seconds())))
+		http.Error(w, "Lease holder unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: s.peerScheme, Host: holder})
+	proxy.Transport = s.peerClient.Transport
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		resp.Header.Del(HeaderAPIVersion)
+		answeredInVersion(resp.Request)
+		if resp.StatusCode >= 500 {
+			b.Failure()
+		} else {
+			b.Success()
+		}
+		return nil
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		b.Failure()
+		http.Error(w, "Lease holder unavailable", http.StatusBadGateway)
+	}
+
+	// The holder gets the request as the client sent it, so that the
+	// signature still matches, and answers in the client's format.
+	r = sentRequest(r)
+	r.Header.Set(proxiedHeader, s.elector.Identity)
+	r.Header.Set(HeaderRequestID, RequestInfoFrom(r.Context()).ID)
+	proxy.ServeHTTP(w, r)
+}
+
+func (s *Server) breakerFor(upstream string) *breaker.Breaker {
+	s.breakersMu.Lock()
+	defer s.breakersMu.Unlock()
+
+	b, ok := s.breakers[upstream]
+	if !ok {
+		b = breaker.New(s.cfg.BreakerFailures, s.cfg.BreakerCooldown)
+		s.breakers[upstream] = b
+	}
+	return b
+}
+
+func (s *Server) breakerStats() map[string]breaker.Snapshot {
+	s.breakersMu.Lock()
+	defer s.breakersMu.Unlock()
+
+	out := make(map[string]breaker.Snapshot, len(s.breakers))
+	for upstream, b := range s.breakers {
+		out[upstream] = b.Snapshot()
+	}
+	return out
+}
+
+// GET /cluster/lease
+func (s *Server) ClusterLease(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	if s.elector == nil {
+		http.Error(w, "Lease mode disabled", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"identity": s.elector.Identity,
+		"holder":   s.elector.Holder(),
+		"leading":  s.elector.IsLeader(),
+	})
+}
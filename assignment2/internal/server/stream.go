@@ -0,0 +1,142 @@
+package server
+
+import (
+	"assignment2/internal/auth"
+	"assignment2/internal/storage"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// streamBatch is how many values a streamed listing reads from the store
+// at a time; the response is flushed after each batch.
+const streamBatch = 1000
+
+// streamRecord is one line of a streamed listing.
+type streamRecord struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// streamSummary is the last line of a streamed listing. Without it the
+// stream was cut off.
+type streamSummary struct {
+	Summary struct {
+		Count     int    `json:"count"`
+		Truncated bool   `json:"truncated"`
+		Revision  uint64 `json:"revision"`
+	} `json:"summary"`
+}
+
+// wantsStream reports whether a listing is asked for as NDJSON, with
+// ?format=ndjson or an Accept header naming application/x-ndjson.
+func wantsStream(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "ndjson" ||
+		strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+}
+
+// streamData is GET /data as NDJSON: one {"key","value"} line per key in
+// key order, or that of ?sort= and ?order=, flushed every streamBatch keys, and a summary line with the
+// count and whether ?limit= cut the listing short. Keys are listed as of
+// the start of the scan, values as they are when their batch is read;
+// keys deleted in between are skipped.
+func (s *Server) streamData(w http.ResponseWriter, r *http.Request, opts listOptions) {
+	limit := -1
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	scope := tenantScope(r)
+	if err := s.loadEncrypted(r.Context(), scope, &opts); err != nil {
+		storeFailed(w, "Failed to read: ", err)
+		return
+	}
+	var order storage.Order
+	if opts.order != nil {
+		order = *opts.order
+	}
+	keys, rev, err := s.store.ScanOrdered(r.Context(), scope+opts.prefix, opts.minRevision, order)
+	if err != nil {
+		storeFailed(w, "Failed to read: ", err)
+		return
+	}
+	if len(opts.tags) > 0 {
+		tagged, err := s.store.GetTagged(r.Context(), opts.tags)
+		if err != nil {
+			storeFailed(w, "Failed to read: ", err)
+			return
+		}
+		matching := keys[:0]
+		for _, k := range keys {
+			if _, ok := tagged[k]; ok {
+				matching = append(matching, k)
+			}
+		}
+		keys = matching
+	}
+	if len(RequestInfoFrom(r.Context()).Scopes) > 0 {
+		readable := keys[:0]
+		for _, k := range keys {
+			if allowKey(r, auth.ScopeRead, strings.TrimPrefix(k, scope)) {
+				readable = append(readable, k)
+			}
+		}
+		keys = readable
+	}
+	if opts.filter != nil {
+		if keys, err = s.filterKeys(r.Context(), scope, opts.filter, keys); err != nil {
+			s.filterFailed(w, err)
+			return
+		}
+	}
+
+	var summary streamSummary
+	summary.Summary.Revision = rev
+	if limit >= 0 && len(keys) > limit {
+		keys = keys[:limit]
+		summary.Summary.Truncated = true
+	}
+
+	setRevision(w, rev)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	for start := 0; start < len(keys); start += streamBatch {
+		batch := keys[start:min(start+streamBatch, len(keys))]
+		var values map[string]string
+		if !opts.excludeValues {
+			if values, err = s.store.GetMany(r.Context(), batch); err != nil {
+				// The status is out; leaving off the summary tells the
+				// client the listing is incomplete.
+				return
+			}
+		}
+		for _, k := range batch {
+			rec := streamRecord{Key: strings.TrimPrefix(k, scope)}
+			if !opts.excludeValues {
+				v, ok := values[k]
+				if !ok {
+					continue
+				}
+				rec.Value = v
+				if len(opts.fields) > 0 {
+					rec.Value = opts.project(rec.Key, v)
+				}
+			}
+			if err := enc.Encode(rec); err != nil {
+				return
+			}
+			summary.Summary.Count++
+		}
+		rc.Flush()
+	}
+	enc.Encode(summary)
+}
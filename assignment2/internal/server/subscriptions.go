@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// subscriptionsFile holds the cursors of named subscriptions in the data
+// directory.
+const subscriptionsFile = "subscriptions.json"
+
+// maxSubscriptions caps the named subscriptions kept, over all tenants.
+const maxSubscriptions = 10000
+
+var (
+	errSubscriptionBusy     = errors.New("subscription is in use")
+	errSubscriptionNotFound = errors.New("subscription not found")
+	errTooManySubscriptions = errors.New("too many subscriptions")
+)
+
+// durableSubscription is a named watch whose position, the seq of the
+// last event delivered, outlives the connection.
+type durableSubscription struct {
+	Seq     uint64    `json:"seq"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+
+	connected bool
+}
+
+// subscriptionStore keeps the named subscriptions, by tenant-scoped name,
+// and writes them to path (if set) when they changed.
+type subscriptionStore struct {
+	path string
+
+	mu    sync.Mutex
+	subs  map[string]*durableSubscription
+	dirty bool
+}
+
+func validSubscription(name string) bool {
+	if name == "" || len(name) > 128 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// loadSubscriptions reads the subscriptions saved in dir; without a
+// directory they only live as long as the process.
+func loadSubscriptions(dir string) (*subscriptionStore, error) {
+	st := &subscriptionStore{subs: make(map[string]*durableSubscription)}
+	if dir == "" {
+		return st, nil
+	}
+	st.path = filepath.Join(dir, subscriptionsFile)
+
+	data, err := os.ReadFile(st.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &st.subs); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// attach connects to the subscription name, creating it at seq if it
+// does not exist. Only one watcher at a time may use a subscription.
+func (st *subscriptionStore) attach(name string, seq uint64) (*durableSubscription, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	sub, ok := st.subs[name]
+	if !ok {
+		if len(st.subs) >= maxSubscriptions {
+			return nil, errTooManySubscriptions
+		}
+		now := time.Now()
+		sub = &durableSubscription{Seq: seq, Created: now, Updated: now}
+		st.subs[name] = sub
+		st.dirty = true
+	}
+	if sub.connected {
+		return nil, errSubscriptionBusy
+	}
+	sub.connected = true
+	return sub, nil
+}
+
+func (st *subscriptionStore) detach(sub *durableSubscription) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	sub.connected = false
+}
+
+// advance records that the events up to seq have been delivered.
+func (st *subscriptionStore) advance(sub *durableSubscription, seq uint64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if seq > sub.Seq {
+		sub.Seq = seq
+		sub.Updated = time.Now()
+		st.dirty = true
+	}
+}
+
+func (st *subscriptionStore) remove(name string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	sub, ok := st.subs[name]
+	switch {
+	case !ok:
+		return errSubscriptionNotFound
+	case sub.connected:
+		return errSubscriptionBusy
+	}
+	delete(st.subs, name)
+	st.dirty = true
+	return nil
+}
+
+// save writes the subscriptions if they changed since the last save.
+func (st *subscriptionStore) save() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.path == "" || !st.dirty {
+		return nil
+	}
+	data, err := json.Marshal(st.subs)
+	if err != nil {
+		return err
+	}
+	tmp := st.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, st.path); err != nil {
+		return err
+	}
+	st.dirty = false
+	return nil
+}
+
+type subscriptionInfo struct {
+	Name      string    `json:"name"`
+	Seq       uint64    `json:"seq"`
+	Lag       uint64    `json:"lag"`
+	Connected bool      `json:"connected"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GET /subscriptions
+//
+// The caller's named subscriptions with their positions and how many
+// events they are behind.
+func (s *Server) ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	scope := tenantScope(r)
+	head := s.events.Seq()
+	st := s.subscriptions
+
+	st.mu.Lock()
+	list := make([]subscriptionInfo, 0)
+	for name, sub := range st.subs {
+		if !strings.HasPrefix(name, scope) {
+			continue
+		}
+		info := subscriptionInfo{
+			Name:      strings.TrimPrefix(name, scope),
+			Seq:       sub.Seq,
+			Connected: sub.connected,
+			CreatedAt: sub.Created.UTC(),
+			UpdatedAt: sub.Updated.UTC(),
+		}
+		if head > sub.Seq {
+			info.Lag = head - sub.Seq
+		}
+		list = append(list, info)
+	}
+	st.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	json.NewEncoder(w).Encode(map[string]interface{}{"subscriptions": list, "seq": head})
+}
+
+// DELETE /subscriptions/{name}
+func (s *Server) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	switch err := s.subscriptions.remove(tenantScope(r) + r.PathValue("name")); err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errSubscriptionNotFound:
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+	case errSubscriptionBusy:
+		http.Error(w, "Subscription is connected", http.StatusConflict)
+	}
+}
+
+// saveSubscriptions is the subscriptions job.
+func (s *Server) saveSubscriptions(ctx context.Context) error {
+	return s.subscriptions.save()
+}
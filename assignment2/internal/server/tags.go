@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const maxTags = 64
+
+type tagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// PUT /data/{key}/tags
+//
+// With ?dry_run=true the tags are validated and the key checked, but not
+// changed.
+func (s *Server) PutTags(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	preview, err := dryRun(r)
+	if err != nil {
+		http.Error(w, "Invalid dry_run", http.StatusBadRequest)
+		return
+	}
+
+	var req tagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(req.Tags) > maxTags {
+		http.Error(w, "Too many tags", http.StatusBadRequest)
+		return
+	}
+
+	tags := make([]string, 0, len(req.Tags))
+	for _, tag := range req.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			http.Error(w, "Empty tag", http.StatusBadRequest)
+			return
+		}
+		tags = append(tags, tag)
+	}
+
+	key := r.PathValue("key")
+	if preview {
+		old, ok, err := s.store.Tags(r.Context(), scopedKey(r, key))
+		if err != nil {
+			storeFailed(w, "Failed to read: ", err)
+			return
+		}
+		if !ok {
+			http.Error(w, "Key not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"dry_run": true, "key": key, "old": old, "tags": tags})
+		return
+	}
+
+	rev, err := s.store.SetTags(r.Context(), scopedKey(r, key), tags)
+	if err != nil {
+		storeFailed(w, "Failed to persist: ", err)
+		return
+	}
+	if rev == 0 {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+
+	stored, _, _ := s.store.Tags(r.Context(), scopedKey(r, key))
+	setRevision(w, rev)
+	json.NewEncoder(w).Encode(map[string]interface{}{"key": key, "tags": stored, "revision": rev})
+}
+
+// GET /data/{key}/tags
+func (s *Server) GetTags(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	key := r.PathValue("key")
+	tags, ok, err := s.store.Tags(r.Context(), scopedKey(r, key))
+	if err != nil {
+		storeFailed(w, "Failed to read: ", err)
+		return
+	}
+	if !ok {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"key": key, "tags": tags})
+}
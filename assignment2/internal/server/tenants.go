@@ -0,0 +1,171 @@
+package server
+
+import (
+	"assignment2/internal/auth"
+	"assignment2/internal/metrics"
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// tenantSeparator joins a tenant's name and its keys in the store.
+const tenantSeparator = "/"
+
+// requireTenant resolves the request's API key to its tenant. It is a
+// no-op unless -api-keys-file is set; then data endpoints need a key and
+// only see keys in the tenant's namespace.
+func (s *Server) requireTenant(next http.HandlerFunc) http.HandlerFunc {
+	if s.tenants == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := auth.APIKey(r)
+		if key == "" {
+			s.IncrementRequests()
+			http.Error(w, "API key required", http.StatusUnauthorized)
+			return
+		}
+		tk, ok := s.tenants[key]
+		if !ok {
+			s.IncrementRequests()
+			http.Error(w, "Unknown API key", http.StatusUnauthorized)
+			return
+		}
+
+		s.tenantRequests.Inc(tk.Tenant)
+		info := RequestInfoFrom(r.Context())
+		info.Tenant, info.Scopes = tk.Tenant, tk.Scopes
+		next(w, r)
+	}
+}
+
+// requireScope checks the request's {key} against the scopes of its API
+// key. Routes without a {key} are refused to API keys with scopes, except
+// those registered without requireScope, which check each key they touch
+// with allowKey.
+func (s *Server) requireScope(access string, next http.HandlerFunc) http.HandlerFunc {
+	if s.tenants == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		scopes := RequestInfoFrom(r.Context()).Scopes
+		if len(scopes) == 0 {
+			next(w, r)
+			return
+		}
+		key := r.PathValue("key")
+		if key == "" {
+			s.IncrementRequests()
+			http.Error(w, "Forbidden: API key scopes do not cover this endpoint", http.StatusForbidden)
+			return
+		}
+		if !scopes.Allow(access, key) {
+			s.IncrementRequests()
+			http.Error(w, "Forbidden: API key scopes do not allow "+access+" of "+key, http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// allowKey reports whether the request's API key may read or write key,
+// named as the tenant knows it.
+func allowKey(r *http.Request, access, key string) bool {
+	return RequestInfoFrom(r.Context()).Scopes.Allow(access, key)
+}
+
+// tenantScope is the prefix of the request tenant's keys in the store.
+func tenantScope(r *http.Request) string {
+	if t := RequestInfoFrom(r.Context()).Tenant; t != "" {
+		return t + tenantSeparator
+	}
+	return ""
+}
+
+// scopedKey maps a key as the client names it to its store key.
+func scopedKey(r *http.Request, key string) string {
+	return tenantScope(r) + key
+}
+
+// scopeEntries maps client keys to store keys.
+func scopeEntries(scope string, entries map[string]string) map[string]string {
+	if scope == "" {
+		return entries
+	}
+	out := make(map[string]string, len(entries))
+	for k, v := range entries {
+		out[scope+k] = v
+	}
+	return out
+}
+
+// unscopeEntries keeps the entries in scope, named as the client knows
+// them.
+func unscopeEntries(scope string, entries map[string]string) map[string]string {
+	if scope == "" {
+		return entries
+	}
+	out := make(map[string]string)
+	for k, v := range entries {
+		if name, ok := strings.CutPrefix(k, scope); ok {
+			out[name] = v
+		}
+	}
+	return out
+}
+
+type tenantStats struct {
+	Requests int64 `json:"requests"`
+	Keys     int   `json:"keys"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// tenantStats breaks requests, keys and bytes (keys plus values) down per
+// tenant. It scans the whole store.
+func (s *Server) tenantStats(ctx context.Context) (map[string]tenantStats, error) {
+	stats := make(map[string]tenantStats)
+	for _, tk := range s.tenants {
+		stats[tk.Tenant] = tenantStats{Requests: int64(s.tenantRequests.Value(tk.Tenant))}
+	}
+
+	all, err := s.store.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range all {
+		tenant, name, ok := strings.Cut(k, tenantSeparator)
+		st, known := stats[tenant]
+		if !ok || !known {
+			continue
+		}
+		st.Keys++
+		st.Bytes += int64(len(name) + len(v))
+		stats[tenant] = st
+	}
+	return stats, nil
+}
+
+func (s *Server) collectTenantMetrics() []metrics.Family {
+	keys := metrics.Family{Name: "kv_tenant_keys", Help: "Keys per tenant.", Type: metrics.TypeGauge}
+	bytes := metrics.Family{Name: "kv_tenant_bytes", Help: "Size of keys and values per tenant.", Type: metrics.TypeGauge}
+
+	stats, err := s.tenantStats(context.Background())
+	if err != nil {
+		return nil
+	}
+	tenants := make([]string, 0, len(stats))
+	for tenant := range stats {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+
+	for _, tenant := range tenants {
+		labels := []metrics.Label{{Name: "tenant", Value: tenant}}
+		keys.Samples = append(keys.Samples, metrics.Sample{Labels: labels, Value: float64(stats[tenant].Keys)})
+		bytes.Samples = append(bytes.Samples, metrics.Sample{Labels: labels, Value: float64(stats[tenant].Bytes)})
+	}
+	return []metrics.Family{keys, bytes}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"assignment2/internal/auth"
+	"assignment2/internal/limit"
+	"assignment2/internal/metrics"
+	"context"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxThrottleClients is how many clients get a bucket before idle ones
+// are dropped.
+const maxThrottleClients = 10000
+
+// writeThrottle limits the request body bytes each client writes per
+// second. Clients are API keys, or client IPs for requests without one.
+type writeThrottle struct {
+	perSecond float64
+	burst     float64
+	maxWait   time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*limit.Rate
+
+	bytes    atomic.Int64
+	delayed  atomic.Int64 // nanoseconds
+	rejected atomic.Int64
+}
+
+func newWriteThrottle(perSecond, burst int64, maxWait time.Duration) *writeThrottle {
+	if burst <= 0 {
+		burst = perSecond
+	}
+	return &writeThrottle{
+		perSecond: float64(perSecond),
+		burst:     float64(burst),
+		maxWait:   maxWait,
+		buckets:   make(map[string]*limit.Rate),
+	}
+}
+
+func (t *writeThrottle) bucket(client string) *limit.Rate {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if b, ok := t.buckets[client]; ok {
+		return b
+	}
+	if len(t.buckets) >= maxThrottleClients {
+		for c, b := range t.buckets {
+			if b.Full() {
+				delete(t.buckets, c)
+			}
+		}
+	}
+	b := limit.NewRate(t.perSecond, t.burst)
+	t.buckets[client] = b
+	return b
+}
+
+// peek returns the client's bucket without making one.
+func (t *writeThrottle) peek(client string) *limit.Rate {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.buckets[client]
+}
+
+// setHeaders describes the client's bucket b, nil for one not made yet,
+// in bytes: X-RateLimit-Limit is the burst, X-RateLimit-Remaining what
+// can be written at once now and X-RateLimit-Reset the seconds until the
+// bucket is full again.
+func (t *writeThrottle) setHeaders(h http.Header, b *limit.Rate) {
+	remaining, reset := t.burst, time.Duration(0)
+	if b != nil {
+		remaining, reset = b.Available()
+	}
+	h.Set("X-RateLimit-Limit", strconv.FormatInt(int64(t.burst), 10))
+	h.Set("X-RateLimit-Remaining", strconv.FormatInt(int64(max(0, remaining)), 10))
+	h.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+}
+
+// rateLimitHeaders puts the client's -write-bytes-per-sec state on every
+// response, so clients can slow down before they get 429. Throttled
+// writes update the headers once their body is reserved.
+func (s *Server) rateLimitHeaders(next http.Handler) http.Handler {
+	t := s.throttle
+	if t == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.setHeaders(w.Header(), t.peek(throttleClient(r)))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func throttleClient(r *http.Request) string {
+	if key := auth.APIKey(r); key != "" {
+		return "key:" + key
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// throttleWrites paces how fast write requests' bodies are read to the
+// client's -write-bytes-per-sec, so a bulk import slows down instead of
+// crowding out the log and everyone else. A body with a Content-Length
+// reserves its bytes up front, so concurrent requests queue behind each
+// other; a client whose queue would keep a new request waiting longer
+// than -write-throttle-wait is turned away with 429 and told when to
+// retry.
+func (s *Server) throttleWrites(next http.HandlerFunc) http.HandlerFunc {
+	t := s.throttle
+	if t == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		b := t.bucket(throttleClient(r))
+		if d := b.Delay(0); d > t.maxWait {
+			s.IncrementRequests()
+			t.rejected.Add(1)
+			t.setHeaders(w.Header(), b)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+			http.Error(w, "Write rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		body := &throttledBody{ReadCloser: r.Body, ctx: r.Context(), rate: b, t: t}
+		if r.ContentLength > 0 {
+			body.size = r.ContentLength
+			body.start = time.Now()
+			body.paid = body.start.Add(b.Take(float64(r.ContentLength)))
+		}
+		t.setHeaders(w.Header(), b)
+		r.Body = body
+		next(w, r)
+	}
+}
+
+// throttledBody holds reads back until the bytes read are paid for:
+// with a known size, in proportion to the reservation made for it,
+// otherwise by taking tokens for each read.
+type throttledBody struct {
+	io.ReadCloser
+	ctx  context.Context
+	rate *limit.Rate
+	t    *writeThrottle
+
+	size        int64
+	read        int64
+	start, paid time.Time
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n == 0 {
+		return n, err
+	}
+	b.t.bytes.Add(int64(n))
+
+	var d time.Duration
+	if b.size > 0 {
+		b.read += int64(n)
+		share := float64(min(b.read, b.size)) / float64(b.size)
+		d = time.Until(b.start.Add(time.Duration(float64(b.paid.Sub(b.start)) * share)))
+	} else {
+		d = b.rate.Take(float64(n))
+	}
+	if d <= 0 {
+		return n, err
+	}
+
+	b.t.delayed.Add(int64(d))
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return n, err
+	case <-b.ctx.Done():
+		return n, b.ctx.Err()
+	}
+}
+
+func (s *Server) collectThrottleMetrics() []metrics.Family {
+	t := s.throttle
+	return []metrics.Family{
+		metrics.Single("kv_write_throttle_bytes_total", "Request body bytes read by throttled write routes.", metrics.TypeCounter, float64(t.bytes.Load())),
+		metrics.Single("kv_write_throttle_delay_seconds_total", "Time writes were held back by -write-bytes-per-sec.", metrics.TypeCounter, time.Duration(t.delayed.Load()).Seconds()),
+		metrics.Single("kv_write_throttle_rejected_total", "Writes rejected with 429 by -write-bytes-per-sec.", metrics.TypeCounter, float64(t.rejected.Load())),
+	}
+}
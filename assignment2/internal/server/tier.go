@@ -0,0 +1,37 @@
+package server
+
+import (
+	"assignment2/internal/logging"
+	"assignment2/internal/metrics"
+	"context"
+	"log"
+)
+
+// tierJob is the tier job: it moves the least recently accessed values
+// to disk once the ones in memory exceed -tier-budget. Unlike eviction
+// under -memory-limit nothing is deleted, so every node runs it.
+func (s *Server) tierJob(ctx context.Context) error {
+	before, _ := s.store.TierStats()
+	n, err := s.store.EvictCold(ctx)
+	if n > 0 {
+		logging.Debugf("[TIER] moved %d values to disk\n", n)
+	}
+	if after, _ := s.store.TierStats(); after.ReadErrors > before.ReadErrors {
+		log.Printf("[ERROR] [TIER] %d values could not be read back from disk\n", after.ReadErrors-before.ReadErrors)
+	}
+	return err
+}
+
+func (s *Server) collectTierMetrics() []metrics.Family {
+	st, _ := s.store.TierStats()
+	return []metrics.Family{
+		metrics.Single("kv_tier_hot_bytes", "Bytes of keys and values kept in memory.", metrics.TypeGauge, float64(st.HotBytes)),
+		metrics.Single("kv_tier_hot_keys", "Keys whose value is kept in memory.", metrics.TypeGauge, float64(st.HotKeys)),
+		metrics.Single("kv_tier_cold_keys", "Keys whose value is kept on disk.", metrics.TypeGauge, float64(st.ColdKeys)),
+		metrics.Single("kv_tier_cold_bytes", "Bytes of values kept on disk.", metrics.TypeGauge, float64(st.ColdBytes)),
+		metrics.Single("kv_tier_hits_total", "Single-key reads of values in memory.", metrics.TypeCounter, float64(st.Hits)),
+		metrics.Single("kv_tier_misses_total", "Single-key reads of values read back from disk.", metrics.TypeCounter, float64(st.Misses)),
+		metrics.Single("kv_tier_evicted_total", "Values moved to disk.", metrics.TypeCounter, float64(st.Evicted)),
+		metrics.Single("kv_tier_read_errors_total", "Values that could not be read back from disk.", metrics.TypeCounter, float64(st.ReadErrors)),
+	}
+}
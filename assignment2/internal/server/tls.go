@@ -0,0 +1,117 @@
+package server
+
+import (
+	"assignment2/internal/auth"
+	"crypto/tls"
+	"net/http"
+	"time"
+)
+
+// setupTLS loads the listener certificate and, with -tls-client-ca, the
+// CA used to verify client certificates.
+func (s *Server) setupTLS() error {
+	if s.cfg.TLSCert == "" {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.cfg.TLSCert, s.cfg.TLSKey)
+	if err != nil {
+		return err
+	}
+
+	s.tlsConfig = &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if s.cfg.TLSClientCA != "" {
+		if s.clientCAs, err = auth.LoadCertPool(s.cfg.TLSClientCA); err != nil {
+			return err
+		}
+		s.tlsConfig.ClientCAs = s.clientCAs
+		s.tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if s.cfg.TLSClientAuth == "optional" {
+			s.tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+
+		if s.roles, err = auth.ParseRoleMap(s.cfg.TLSRoleMap); err != nil {
+			return err
+		}
+	}
+
+	if s.cfg.PeerTLSCA != "" {
+		return s.setupPeerTLS(cert)
+	}
+
+	// Peers present the node's own certificate and are expected to be
+	// signed by the same CA as clients.
+	s.peerScheme = "https"
+	s.peerClient = &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      s.clientCAs,
+		}},
+	}
+	return nil
+}
+
+// TLSConfig returns the listener's TLS configuration, or nil when the
+// server speaks plain HTTP.
+func (s *Server) TLSConfig() *tls.Config {
+	return s.tlsConfig
+}
+
+func (s *Server) clientCertAuth() bool {
+	return s.clientCAs != nil
+}
+
+// authenticate records the principal derived from a verified client
+// certificate in the request's info. With -peer-tls-ca the listener may
+// accept peer certificates too, so each certificate is checked against
+// the CA it must belong to.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	if !s.clientCertAuth() && s.peerCAs == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			info := RequestInfoFrom(r.Context())
+			chain := r.TLS.PeerCertificates
+			switch {
+			case s.peerCAs == nil:
+				info.Principal = s.roles.PrincipalFromCert(chain[0])
+			case auth.VerifyClientChain(chain, s.peerCAs):
+				info.Principal = auth.PeerPrincipal(chain[0])
+			case auth.VerifyClientChain(chain, s.clientCAs):
+				info.Principal = s.roles.PrincipalFromCert(chain[0])
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireRole rejects callers that lack role. It is a no-op unless
+// client certificate authentication is configured.
+func (s *Server) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	if !s.clientCertAuth() {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := RequestInfoFrom(r.Context()).Principal
+		if p == nil {
+			s.IncrementRequests()
+			http.Error(w, "Client certificate required", http.StatusUnauthorized)
+			return
+		}
+		if !p.HasRole(role) {
+			s.IncrementRequests()
+			http.Error(w, "Forbidden: "+role+" role required", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
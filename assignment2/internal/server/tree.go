@@ -0,0 +1,85 @@
+package server
+
+import (
+	"assignment2/internal/auth"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Keys are browsed as a hierarchy of <dir>/<dir>/<name>.
+const treeSeparator = "/"
+
+// treeDir is a directory of a tree listing, with the number of keys at
+// any depth beneath it.
+type treeDir struct {
+	Name string `json:"name"`
+	Keys int    `json:"keys"`
+}
+
+type treeResponse struct {
+	Path  string    `json:"path"`
+	Dirs  []treeDir `json:"dirs"`
+	Keys  []string  `json:"keys"`
+	Depth int       `json:"depth"`
+}
+
+// GET /tree/{path...}
+//
+// Lists the keys under path, split on "/", like a directory: the
+// directories ?depth= levels down (default 1), each with how many keys
+// are beneath it, and the keys above that depth. Names are full paths,
+// directories ending in "/". GET /tree lists from the top.
+func (s *Server) GetTree(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	depth := 1
+	if v := r.URL.Query().Get("depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid depth", http.StatusBadRequest)
+			return
+		}
+		depth = n
+	}
+	path := strings.TrimPrefix(r.PathValue("path"), treeSeparator)
+	if path != "" && !strings.HasSuffix(path, treeSeparator) {
+		path += treeSeparator
+	}
+
+	scope := tenantScope(r)
+	keys, rev, err := s.store.ScanKeys(r.Context(), scope+path, 0)
+	if err != nil {
+		storeFailed(w, "Failed to read: ", err)
+		return
+	}
+
+	resp := treeResponse{Path: path, Dirs: []treeDir{}, Keys: []string{}, Depth: depth}
+	found := false
+	for _, k := range keys {
+		k = strings.TrimPrefix(k, scope)
+		if !allowKey(r, auth.ScopeRead, k) {
+			continue
+		}
+		found = true
+		parts := strings.SplitAfterN(k[len(path):], treeSeparator, depth+1)
+		if len(parts) <= depth {
+			resp.Keys = append(resp.Keys, k)
+			continue
+		}
+		// Keys are sorted, so those of a directory are consecutive.
+		dir := path + strings.Join(parts[:depth], "")
+		if n := len(resp.Dirs); n > 0 && resp.Dirs[n-1].Name == dir {
+			resp.Dirs[n-1].Keys++
+		} else {
+			resp.Dirs = append(resp.Dirs, treeDir{Name: dir, Keys: 1})
+		}
+	}
+	if path != "" && !found {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+
+	setRevision(w, rev)
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"assignment2/internal/metrics"
+	"assignment2/internal/storage"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var errInvalidTTL = errors.New("ttl must be positive")
+
+// parseTTL reads ?ttl=; 0 means none.
+func parseTTL(r *http.Request) (time.Duration, error) {
+	v := r.URL.Query().Get("ttl")
+	if v == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if ttl <= 0 {
+		return 0, errInvalidTTL
+	}
+	return ttl, nil
+}
+
+type touchResponse struct {
+	Key       string    `json:"key"`
+	Revision  uint64    `json:"revision"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// POST /data/{key}/touch?ttl=30s
+//
+// Renews the key's time to live without rewriting its value: to ttl from
+// now, or without ttl to the time to live it was last given, which is
+// what heartbeats keeping a lease alive want. A key without a time to
+// live needs ttl (409 otherwise).
+func (s *Server) TouchKey(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	ttl, err := parseTTL(r)
+	if err != nil {
+		http.Error(w, "Invalid ttl", http.StatusBadRequest)
+		return
+	}
+
+	key := r.PathValue("key")
+	entry, ok, err := s.store.Touch(r.Context(), scopedKey(r, key), ttl)
+	if errors.Is(err, storage.ErrNoTTL) {
+		http.Error(w, "Key has no time to live; pass ?ttl=", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		storeFailed(w, "Failed to persist: ", err)
+		return
+	}
+	if !ok {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+
+	setRevision(w, entry.Revision)
+	writeJSON(w, http.StatusOK, touchResponse{Key: key, Revision: entry.Revision, ExpiresAt: entry.ExpiresAt})
+}
+
+// POST /data/touch?prefix=lease:&ttl=30s
+//
+// Touches every key under prefix that has a time to live, in one batch;
+// keys without one are left alone. An empty prefix touches the caller's
+// whole namespace. Like other writes, it is refused (423) while another
+// owner holds an edit lock on a key under prefix.
+func (s *Server) TouchPrefix(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	q := r.URL.Query()
+	if !q.Has("prefix") {
+		http.Error(w, "prefix is required (use ?prefix= to touch everything)", http.StatusBadRequest)
+		return
+	}
+	ttl, err := parseTTL(r)
+	if err != nil {
+		http.Error(w, "Invalid ttl", http.StatusBadRequest)
+		return
+	}
+
+	prefix := q.Get("prefix")
+	if s.checkPrefixLocks(w, r, prefix) {
+		return
+	}
+	n, rev, err := s.store.TouchPrefix(r.Context(), scopedKey(r, prefix), ttl)
+	if err != nil {
+		storeFailed(w, "Touch failed: ", err)
+		return
+	}
+
+	setRevision(w, rev)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"prefix":   prefix,
+		"touched":  n,
+		"revision": rev,
+	})
+}
+
+// expireKeys is the ttl job: it deletes keys whose time to live has run
+// out until none are left. Like retention, only the lease holder
+// deletes; a standby gets the deletes through replication.
+func (s *Server) expireKeys(ctx context.Context) error {
+	if s.isStandby() {
+		return nil
+	}
+
+	n, err := s.db.DeleteExpired(ctx)
+	s.expired.Add(int64(n))
+	return err
+}
+
+func (s *Server) collectTTLMetrics() []metrics.Family {
+	return []metrics.Family{
+		metrics.Single("kv_ttl_keys", "Keys with a time to live.", metrics.TypeGauge, float64(s.store.Expiring())),
+		metrics.Single("kv_ttl_expired_total", "Keys deleted because their time to live ran out.", metrics.TypeCounter, float64(s.expired.Load())),
+	}
+}
@@ -0,0 +1,413 @@
+package server
+
+import (
+	"assignment2/internal/events"
+	"assignment2/internal/storage"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The v3 JSON API: KV Range, Put and DeleteRange plus Watch, borrowing
+// the request and response shapes of etcd's v3 JSON gateway. It is not
+// etcd: there is no gRPC, so etcdctl and the etcd client libraries cannot
+// connect. Keys and values are base64 in JSON and 64-bit integers are
+// strings. The store keeps no per-key history, so create_revision is
+// reported as the key's last revision and version as 1.
+
+// etcdInt is an int64 that, like etcd's gateway, is written as a JSON
+// string and read from either a string or a number.
+type etcdInt int64
+
+func (n etcdInt) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + strconv.FormatInt(int64(n), 10) + `"`), nil
+}
+
+func (n *etcdInt) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	*n = etcdInt(v)
+	return err
+}
+
+type etcdHeader struct {
+	ClusterID etcdInt `json:"cluster_id"`
+	MemberID  etcdInt `json:"member_id"`
+	Revision  etcdInt `json:"revision"`
+	RaftTerm  etcdInt `json:"raft_term"`
+}
+
+type etcdKV struct {
+	Key            []byte  `json:"key"`
+	CreateRevision etcdInt `json:"create_revision"`
+	ModRevision    etcdInt `json:"mod_revision"`
+	Version        etcdInt `json:"version"`
+	Value          []byte  `json:"value,omitempty"`
+}
+
+type etcdRangeRequest struct {
+	Key       []byte  `json:"key"`
+	RangeEnd  []byte  `json:"range_end"`
+	Limit     etcdInt `json:"limit"`
+	Revision  etcdInt `json:"revision"`
+	KeysOnly  bool    `json:"keys_only"`
+	CountOnly bool    `json:"count_only"`
+}
+
+type etcdRangeResponse struct {
+	Header etcdHeader `json:"header"`
+	KVs    []etcdKV   `json:"kvs,omitempty"`
+	More   bool       `json:"more,omitempty"`
+	Count  etcdInt    `json:"count"`
+}
+
+type etcdPutRequest struct {
+	Key    []byte `json:"key"`
+	Value  []byte `json:"value"`
+	PrevKV bool   `json:"prev_kv"`
+}
+
+type etcdPutResponse struct {
+	Header etcdHeader `json:"header"`
+	PrevKV *etcdKV    `json:"prev_kv,omitempty"`
+}
+
+type etcdDeleteRangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end"`
+	PrevKV   bool   `json:"prev_kv"`
+}
+
+type etcdDeleteRangeResponse struct {
+	Header  etcdHeader `json:"header"`
+	Deleted etcdInt    `json:"deleted"`
+	PrevKVs []etcdKV   `json:"prev_kvs,omitempty"`
+}
+
+type etcdWatchRequest struct {
+	CreateRequest *struct {
+		Key           []byte  `json:"key"`
+		RangeEnd      []byte  `json:"range_end"`
+		StartRevision etcdInt `json:"start_revision"`
+		PrevKV        bool    `json:"prev_kv"`
+	} `json:"create_request"`
+}
+
+type etcdEvent struct {
+	Type string `json:"type"`
+	KV   etcdKV `json:"kv"`
+}
+
+type etcdWatchResponse struct {
+	Header       etcdHeader  `json:"header"`
+	WatchID      etcdInt     `json:"watch_id"`
+	Created      bool        `json:"created,omitempty"`
+	Canceled     bool        `json:"canceled,omitempty"`
+	CancelReason string      `json:"cancel_reason,omitempty"`
+	Events       []etcdEvent `json:"events,omitempty"`
+}
+
+// etcdRange is a key range as etcd defines it: just key without an end,
+// everything from key on with an end of "\x00", and [key, end) otherwise.
+type etcdRange struct {
+	key, end []byte
+}
+
+func (rg etcdRange) contains(key string) bool {
+	k := []byte(key)
+	switch {
+	case len(rg.end) == 0:
+		return bytes.Equal(k, rg.key)
+	case bytes.Equal(rg.end, []byte{0}):
+		return bytes.Compare(k, rg.key) >= 0
+	}
+	return bytes.Compare(k, rg.key) >= 0 && bytes.Compare(k, rg.end) < 0
+}
+
+func (s *Server) etcdHeader(rev uint64) etcdHeader {
+	h := fnv.New64a()
+	h.Write([]byte(advertiseAddr(s.cfg)))
+	return etcdHeader{ClusterID: 1, MemberID: etcdInt(h.Sum64() >> 1), Revision: etcdInt(rev), RaftTerm: 1}
+}
+
+// etcdError answers in etcd's gateway error shape, with the gRPC status
+// code etcd would use.
+func etcdError(w http.ResponseWriter, status, code int, msg string) {
+	writeJSON(w, status, map[string]interface{}{"error": msg, "code": code, "message": msg})
+}
+
+// etcdLocked is checkLocks in etcd's error format: writes through the v3
+// API honour edit locks like the rest of the API.
+func (s *Server) etcdLocked(w http.ResponseWriter, r *http.Request, keys ...string) bool {
+	scope := tenantScope(r)
+	scoped := make([]string, len(keys))
+	for i, k := range keys {
+		scoped[i] = scope + k
+	}
+	key, lock, locked := s.locks.blocking(scoped, r.Header.Get(HeaderLockOwner))
+	if locked {
+		etcdError(w, http.StatusLocked, grpcFailedPrecondition, fmt.Sprintf("key %q is locked by %q until %s", key[len(scope):], lock.Owner, lock.ExpiresAt.UTC().Format(time.RFC3339)))
+	}
+	return locked
+}
+
+const (
+	grpcInvalidArgument    = 3
+	grpcFailedPrecondition = 9
+	grpcOutOfRange         = 11
+	grpcUnavailable        = 14
+)
+
+// errEtcdCanceled ends a watch stream after its cancel response.
+var errEtcdCanceled = errors.New("watch canceled")
+
+func etcdStoreFailed(w http.ResponseWriter, err error) {
+	etcdError(w, http.StatusServiceUnavailable, grpcUnavailable, err.Error())
+}
+
+// etcdRangeKVs returns the keys of the caller's namespace in rg, sorted,
+// and the store revision they reflect.
+func (s *Server) etcdRangeKVs(r *http.Request, rg etcdRange) ([]etcdKV, uint64, error) {
+	recs, rev, err := s.store.Snapshot(r.Context())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	scope := tenantScope(r)
+	var kvs []etcdKV
+	for _, rec := range recs {
+		name, ok := strings.CutPrefix(rec.Key, scope)
+		if rec.Op != storage.OpSet || !ok || !rg.contains(name) {
+			continue
+		}
+		kvs = append(kvs, etcdKV{
+			Key:            []byte(name),
+			CreateRevision: etcdInt(rec.Rev),
+			ModRevision:    etcdInt(rec.Rev),
+			Version:        1,
+			Value:          []byte(rec.Value),
+		})
+	}
+	sort.Slice(kvs, func(i, j int) bool { return bytes.Compare(kvs[i].Key, kvs[j].Key) < 0 })
+	return kvs, rev, nil
+}
+
+// POST /v3/kv/range
+func (s *Server) V3Range(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	var req etcdRangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		etcdError(w, http.StatusBadRequest, grpcInvalidArgument, "invalid request: "+err.Error())
+		return
+	}
+
+	kvs, rev, err := s.etcdRangeKVs(r, etcdRange{req.Key, req.RangeEnd})
+	if err != nil {
+		etcdStoreFailed(w, err)
+		return
+	}
+	if req.Revision > 0 && uint64(req.Revision) != rev {
+		etcdError(w, http.StatusBadRequest, grpcOutOfRange, "mvcc: required revision has been compacted")
+		return
+	}
+
+	resp := etcdRangeResponse{Header: s.etcdHeader(rev), Count: etcdInt(len(kvs))}
+	if !req.CountOnly {
+		if req.Limit > 0 && int(req.Limit) < len(kvs) {
+			kvs, resp.More = kvs[:req.Limit], true
+		}
+		if req.KeysOnly {
+			for i := range kvs {
+				kvs[i].Value = nil
+			}
+		}
+		resp.KVs = kvs
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// POST /v3/kv/put
+func (s *Server) V3Put(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	var req etcdPutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		etcdError(w, http.StatusBadRequest, grpcInvalidArgument, "invalid request: "+err.Error())
+		return
+	}
+	if len(req.Key) == 0 {
+		etcdError(w, http.StatusBadRequest, grpcInvalidArgument, "etcdserver: key is not provided")
+		return
+	}
+
+	if err := s.validateWrite(r.Context(), string(req.Key), string(req.Value)); err != nil {
+		etcdError(w, http.StatusBadRequest, grpcFailedPrecondition, err.Error())
+		return
+	}
+	if s.etcdLocked(w, r, string(req.Key)) {
+		return
+	}
+
+	key := scopedKey(r, string(req.Key))
+	var prev *etcdKV
+	var prevRev uint64
+	if req.PrevKV {
+		if _, rev, ok, err := s.store.GetRevision(r.Context(), key); err == nil && ok {
+			prevRev = rev
+		}
+	}
+	rev, err := s.store.Update(r.Context(), key, func(old string, exists bool) (string, bool, error) {
+		if exists && req.PrevKV {
+			prev = &etcdKV{Key: req.Key, CreateRevision: etcdInt(prevRev), ModRevision: etcdInt(prevRev), Version: 1, Value: []byte(old)}
+		}
+		return string(req.Value), true, nil
+	})
+	if err != nil {
+		etcdStoreFailed(w, err)
+		return
+	}
+
+	setRevision(w, rev)
+	writeJSON(w, http.StatusOK, etcdPutResponse{Header: s.etcdHeader(s.store.Revision()), PrevKV: prev})
+}
+
+// POST /v3/kv/deleterange
+func (s *Server) V3DeleteRange(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	var req etcdDeleteRangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		etcdError(w, http.StatusBadRequest, grpcInvalidArgument, "invalid request: "+err.Error())
+		return
+	}
+
+	kvs, _, err := s.etcdRangeKVs(r, etcdRange{req.Key, req.RangeEnd})
+	if err != nil {
+		etcdStoreFailed(w, err)
+		return
+	}
+
+	names := make([]string, len(kvs))
+	for i, kv := range kvs {
+		names[i] = string(kv.Key)
+	}
+	if s.etcdLocked(w, r, names...) {
+		return
+	}
+
+	// Keys written after the range was read are left alone, as if the
+	// delete had happened first.
+	scope := tenantScope(r)
+	keys := make([]string, len(kvs))
+	for i, name := range names {
+		keys[i] = scope + name
+	}
+	deleted, err := s.store.DeleteModifiedBefore(r.Context(), keys, time.Now())
+	if err != nil {
+		etcdStoreFailed(w, err)
+		return
+	}
+
+	resp := etcdDeleteRangeResponse{Header: s.etcdHeader(s.store.Revision()), Deleted: etcdInt(deleted)}
+	if req.PrevKV {
+		resp.PrevKVs = kvs
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// POST /v3/watch
+//
+// Takes one create_request and streams {"result": WatchResponse} lines.
+// A reset of the store (e.g. a standby restored from a snapshot) cancels
+// the watch, as compaction does in etcd.
+func (s *Server) V3Watch(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	var req etcdWatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CreateRequest == nil {
+		etcdError(w, http.StatusBadRequest, grpcInvalidArgument, "a create_request is required")
+		return
+	}
+	create := req.CreateRequest
+	rg := etcdRange{create.Key, create.RangeEnd}
+	scope := tenantScope(r)
+
+	filter := func(e events.Event) bool {
+		if e.Type == events.TypeReset {
+			return true
+		}
+		name, ok := strings.CutPrefix(e.Key, scope)
+		return ok && (e.Type == storage.OpSet || e.Type == storage.OpDelete) && rg.contains(name)
+	}
+
+	var since uint64
+	if create.StartRevision > 1 {
+		since = uint64(create.StartRevision) - 1
+	}
+	sub, backlog, err := s.events.Subscribe(since, filter, events.Overflow(s.cfg.WatchOverflow))
+	if err == events.ErrTooOld {
+		etcdError(w, http.StatusBadRequest, grpcOutOfRange, "mvcc: required revision has been compacted")
+		return
+	}
+	if err != nil {
+		etcdError(w, http.StatusServiceUnavailable, grpcUnavailable, "server is shutting down")
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	send := func(resp etcdWatchResponse) error {
+		if err := enc.Encode(map[string]etcdWatchResponse{"result": resp}); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if err := send(etcdWatchResponse{Header: s.etcdHeader(s.store.Revision()), Created: true}); err != nil {
+		return
+	}
+	deliver := func(e events.Event) error {
+		if e.Type == events.TypeReset {
+			send(etcdWatchResponse{Header: s.etcdHeader(e.Seq), Canceled: true, CancelReason: "store was reset"})
+			return errEtcdCanceled
+		}
+		ev := etcdEvent{Type: "PUT", KV: etcdKV{
+			Key:            []byte(strings.TrimPrefix(e.Key, scope)),
+			CreateRevision: etcdInt(e.Seq),
+			ModRevision:    etcdInt(e.Seq),
+			Version:        1,
+			Value:          []byte(e.Value),
+		}}
+		if e.Type == storage.OpDelete {
+			ev = etcdEvent{Type: "DELETE", KV: etcdKV{Key: ev.KV.Key, ModRevision: etcdInt(e.Seq)}}
+		}
+		return send(etcdWatchResponse{Header: s.etcdHeader(e.Seq), Events: []etcdEvent{ev}})
+	}
+
+	for _, e := range backlog {
+		if deliver(e) != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok || deliver(e) != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+)
+
+// WriteValidator vets a value before it is stored under key, so that
+// programs embedding the server can enforce their own rules (naming
+// conventions, value schemas, references to other keys) without changing
+// the handlers. Returning an error rejects the whole request with 422 and
+// the error's text.
+//
+// key is the key as the client named it; with tenants the tenant is in
+// RequestInfoFrom(ctx). Validators are called without any store lock
+// held, so they may read the store, and for every value a request sets:
+// POST /data (also with ?dry_run=true), eval results that keep the key,
+// JSON patches and v3 API puts. Deletes and tag changes are not
+// validated.
+type WriteValidator interface {
+	ValidateWrite(ctx context.Context, key, value string) error
+}
+
+// WriteValidatorFunc lets an ordinary function be a WriteValidator.
+type WriteValidatorFunc func(ctx context.Context, key, value string) error
+
+func (f WriteValidatorFunc) ValidateWrite(ctx context.Context, key, value string) error {
+	return f(ctx, key, value)
+}
+
+// AddWriteValidator registers v after the validators added before it.
+// It must be called before the server starts handling requests.
+func (s *Server) AddWriteValidator(v WriteValidator) {
+	s.validators = append(s.validators, v)
+}
+
+// validationError is a write a validator rejected.
+type validationError struct {
+	key string
+	err error
+}
+
+func (e *validationError) Error() string {
+	return "Invalid write to " + e.key + ": " + e.err.Error()
+}
+
+func (e *validationError) Unwrap() error {
+	return e.err
+}
+
+// validateWrite runs the validators on one value.
+func (s *Server) validateWrite(ctx context.Context, key, value string) error {
+	for _, v := range s.validators {
+		if err := v.ValidateWrite(ctx, key, value); err != nil {
+			return &validationError{key: key, err: err}
+		}
+	}
+	return nil
+}
+
+// validateEntries runs the validators on every entry, in key order so
+// that the error reported for a batch does not depend on map order.
+func (s *Server) validateEntries(ctx context.Context, entries map[string]string) error {
+	if len(s.validators) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := s.validateWrite(ctx, k, entries[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRejected answers 422 if err is a validator's rejection and reports
+// whether it was.
+func writeRejected(w http.ResponseWriter, err error) bool {
+	var verr *validationError
+	if !errors.As(err, &verr) {
+		return false
+	}
+	http.Error(w, verr.Error(), http.StatusUnprocessableEntity)
+	return true
+}
+
+// updateAttempts bounds how often updateValidated retries a key that
+// keeps changing under it.
+const updateAttempts = 5
+
+var errEvalConflict = errors.New("key changed during evaluation")
+
+// updateValidated is Update for a fn that runs validators, which must not
+// run under the store lock: fn runs on a read of the key, and its result
+// is stored only if the key still holds what fn saw, retrying otherwise.
+func (s *Server) updateValidated(ctx context.Context, key string, fn func(old string, exists bool) (string, bool, error)) (uint64, error) {
+	for attempt := 0; attempt < updateAttempts; attempt++ {
+		old, _, exists, err := s.store.GetRevision(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		value, keep, err := fn(old, exists)
+		if err != nil {
+			return 0, err
+		}
+
+		rev, err := s.store.Update(ctx, key, func(cur string, curExists bool) (string, bool, error) {
+			if cur != old || curExists != exists {
+				return "", false, errEvalConflict
+			}
+			return value, keep, nil
+		})
+		s.store.RecordCAS(key, err == errEvalConflict)
+		if err != errEvalConflict {
+			return rev, err
+		}
+	}
+	return 0, errEvalConflict
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Response formats. Version 1 is the bare bodies the API has always
+// answered with; version 2 wraps every JSON answer in an envelope. The
+// routes are the same for both: a handler writes version 1 and
+// envelopeWriter turns that into version 2, so a format change can ship
+// in a new version while clients on the old one keep theirs.
+const (
+	apiV1 = 1
+	apiV2 = 2
+
+	latestAPIVersion = apiV2
+)
+
+// HeaderAPIVersion tells clients which response format they got.
+const HeaderAPIVersion = "X-API-Version"
+
+// acceptVersion matches the media type a client asks for a version with
+// in Accept, application/vnd.kv.v2+json.
+var acceptVersion = regexp.MustCompile(`application/vnd\.kv\.v(\d+)\+json`)
+
+func versionMediaType(version int) string {
+	return "application/vnd.kv.v" + strconv.Itoa(version) + "+json"
+}
+
+// envelope is a version 2 response: the version 1 body as data, or what
+// went wrong as error.
+type envelope struct {
+	Data      json.RawMessage `json:"data,omitempty"`
+	Error     *envelopeError  `json:"error,omitempty"`
+	Revision  uint64          `json:"revision,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+}
+
+type envelopeError struct {
+	Status  int             `json:"status"`
+	Message string          `json:"message"`
+	Details json.RawMessage `json:"details,omitempty"`
+}
+
+type versionedKey struct{}
+
+// versioned is what apiVersions remembers about a request.
+type versioned struct {
+	// url is the URL as the client sent it, with its /v1/ or /v2/ prefix.
+	url *url.URL
+
+	// answered means the response is already in the client's format, as
+	// when the lease holder answered a proxied write.
+	answered bool
+}
+
+// apiVersions picks each request's response format: a /v1/ or /v2/ path
+// prefix, which it strips so that the routes below exist once for all
+// versions, else an Accept: application/vnd.kv.vN+json header, else
+// -api-version. Peer traffic under /cluster/ is always version 1.
+func (s *Server) apiVersions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := s.cfg.APIVersion
+		vr := &versioned{url: r.URL}
+		if v, prefix, ok := versionPrefix(r.URL.Path); ok {
+			version = v
+			r = stripVersion(r, prefix)
+		} else if m := acceptVersion.FindStringSubmatch(r.Header.Get("Accept")); m != nil {
+			version, _ = strconv.Atoi(m[1])
+			if version < apiV1 || version > latestAPIVersion {
+				s.IncrementRequests()
+				http.Error(w, "Unknown API version "+m[1]+" (latest is "+strconv.Itoa(latestAPIVersion)+")", http.StatusNotAcceptable)
+				return
+			}
+		}
+		if strings.HasPrefix(r.URL.Path, "/cluster/") {
+			version = apiV1
+		}
+
+		RequestInfoFrom(r.Context()).APIVersion = version
+		w.Header().Set(HeaderAPIVersion, strconv.Itoa(version))
+		r = r.WithContext(context.WithValue(r.Context(), versionedKey{}, vr))
+		if version == apiV1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ew := &envelopeWriter{ResponseWriter: w, r: r, vr: vr}
+		defer ew.finish()
+		next.ServeHTTP(ew, r)
+	})
+}
+
+// versionPrefix returns the version a path starts with, /v2/data being
+// version 2.
+func versionPrefix(path string) (version int, prefix string, ok bool) {
+	for v := apiV1; v <= latestAPIVersion; v++ {
+		prefix := "/v" + strconv.Itoa(v)
+		if strings.HasPrefix(path, prefix+"/") {
+			return v, prefix, true
+		}
+	}
+	return 0, "", false
+}
+
+// stripVersion is r without the version prefix, as http.StripPrefix
+// makes it.
+func stripVersion(r *http.Request, prefix string) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+	r2.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, prefix)
+	return r2
+}
+
+// sentRequest is r with the URL the client sent, version prefix
+// included, which is what it signed and what a proxied request passes
+// on.
+func sentRequest(r *http.Request) *http.Request {
+	vr, ok := r.Context().Value(versionedKey{}).(*versioned)
+	if !ok || vr.url == r.URL {
+		return r
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = vr.url
+	return r2
+}
+
+// answeredInVersion marks r's response as already in the client's
+// format, so that it is passed on as it is.
+func answeredInVersion(r *http.Request) {
+	if vr, ok := r.Context().Value(versionedKey{}).(*versioned); ok {
+		vr.answered = true
+	}
+}
+
+// envelopeWriter turns a version 1 response into a version 2 one. JSON
+// bodies, and the plain text of errors, are held back until the handler
+// returns and then written inside an envelope; anything else (streams,
+// blobs, metrics, empty bodies) goes through as it is.
+type envelopeWriter struct {
+	http.ResponseWriter
+	r  *http.Request
+	vr *versioned
+
+	status  int
+	decided bool
+	buf     *bytes.Buffer // nil once passing through
+}
+
+func (ew *envelopeWriter) WriteHeader(status int) {
+	if ew.decided && ew.buf == nil {
+		ew.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if ew.status == 0 && status >= 200 {
+		ew.status = status
+	}
+}
+
+func (ew *envelopeWriter) Write(p []byte) (int, error) {
+	if !ew.decided {
+		ew.decide()
+	}
+	if ew.buf == nil {
+		return ew.ResponseWriter.Write(p)
+	}
+	return ew.buf.Write(p)
+}
+
+// decide holds the body back if it is one to put in an envelope.
+func (ew *envelopeWriter) decide() {
+	ew.decided = true
+	if ew.status == 0 {
+		ew.status = http.StatusOK
+	}
+	if enveloped(ew.Header().Get("Content-Type"), ew.status) {
+		ew.buf = getBuffer()
+		return
+	}
+	ew.ResponseWriter.WriteHeader(ew.status)
+}
+
+// enveloped reports whether a body of content type ct goes into an
+// envelope: JSON, which handlers encoding straight to the writer leave
+// unlabelled, and the text of http.Error.
+func enveloped(ct string, status int) bool {
+	if ct == "" {
+		return true
+	}
+	mt, _, _ := mime.ParseMediaType(ct)
+	switch mt {
+	case "application/json":
+		return true
+	case "text/plain":
+		return status >= 400
+	}
+	return false
+}
+
+// FlushError passes the response through from here on: a handler that
+// flushes is streaming, and a stream is not held back for an envelope.
+func (ew *envelopeWriter) FlushError() error {
+	if !ew.decided {
+		ew.decide()
+	}
+	if ew.buf != nil {
+		ew.passThrough()
+	}
+	return http.NewResponseController(ew.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach deadlines.
+func (ew *envelopeWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+func (ew *envelopeWriter) passThrough() {
+	buf := ew.buf
+	ew.buf = nil
+	ew.ResponseWriter.WriteHeader(ew.status)
+	ew.ResponseWriter.Write(buf.Bytes())
+	putBuffer(buf)
+}
+
+func (ew *envelopeWriter) finish() {
+	if !ew.decided {
+		// Nothing was written: a 204, a 304 or an empty 200.
+		if ew.status != 0 {
+			ew.ResponseWriter.WriteHeader(ew.status)
+		}
+		return
+	}
+	if ew.buf == nil {
+		return
+	}
+	if ew.vr.answered {
+		ew.passThrough()
+		return
+	}
+
+	body := ew.buf.Bytes()
+	env := envelope{RequestID: RequestInfoFrom(ew.r.Context()).ID}
+	env.Revision, _ = strconv.ParseUint(ew.Header().Get("X-Revision"), 10, 64)
+	isJSON := !strings.HasPrefix(ew.Header().Get("Content-Type"), "text/plain") && json.Valid(body)
+	switch {
+	case ew.status < 400 && isJSON:
+		env.Data = body
+	case ew.status < 400:
+		env.Data, _ = json.Marshal(string(body))
+	case isJSON:
+		env.Error = &envelopeError{Status: ew.status, Message: http.StatusText(ew.status), Details: body}
+	default:
+		env.Error = &envelopeError{Status: ew.status, Message: strings.TrimSpace(string(body))}
+	}
+
+	out, err := json.Marshal(env)
+	putBuffer(ew.buf)
+	ew.buf = nil
+	if err != nil {
+		http.Error(ew.ResponseWriter, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	out = append(out, '\n')
+
+	h := ew.Header()
+	h.Set("Content-Type", versionMediaType(apiV2))
+	h.Set("Content-Length", strconv.Itoa(len(out)))
+	ew.ResponseWriter.WriteHeader(ew.status)
+	ew.ResponseWriter.Write(out)
+}
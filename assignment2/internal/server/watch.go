@@ -0,0 +1,361 @@
+package server
+
+import (
+	"assignment2/internal/auth"
+	"assignment2/internal/events"
+	"assignment2/internal/logging"
+	"assignment2/internal/metrics"
+	"assignment2/internal/storage"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	watchHeartbeat    = 15 * time.Second
+	minWatchHeartbeat = 100 * time.Millisecond
+)
+
+// logEvent is the database's observer: it writes each published event to
+// the event log, queues it for the secondaries and tells the archive and
+// hot key tracking about writes.
+func (s *Server) logEvent(e events.Event) {
+	if s.eventLog != nil {
+		s.eventLog.add(e)
+	}
+	s.copyToSecondaries(e)
+	s.noteWrite(e)
+	if s.archive != nil {
+		s.archive.noteWrite(e)
+	}
+}
+
+// initialEvents turns the snapshot entries under prefix into "initial"
+// events, in key order.
+func initialEvents(recs []storage.Record, prefix string) []events.Event {
+	tags := make(map[string][]string)
+	var out []events.Event
+	for _, rec := range recs {
+		if !strings.HasPrefix(rec.Key, prefix) {
+			continue
+		}
+		switch rec.Op {
+		case storage.OpSet:
+			out = append(out, events.Event{Seq: rec.Rev, Type: "initial", Key: rec.Key, Value: rec.Value, Time: time.Unix(0, rec.TS)})
+		case storage.OpTags:
+			tags[rec.Key] = rec.Tags
+		}
+	}
+	for i := range out {
+		out[i].Tags = tags[out[i].Key]
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// CloseWatchers ends all watch streams. http.Server.Shutdown waits for
+// active requests, so it has to be registered with RegisterOnShutdown.
+func (s *Server) CloseWatchers() {
+	s.events.Close()
+}
+
+// GET /watch
+//
+// Streams mutation events as NDJSON. ?prefix= limits them to matching
+// keys, ?events=set,delete to the given types, and ?since=<seq> replays
+// what happened after seq first (410 if that is no longer available).
+// A {"type":"heartbeat"} line is sent every 15s, or every ?heartbeat=.
+//
+// ?overflow= overrides -watch-overflow for this watcher. When events are
+// dropped or coalesced, a {"type":"overflow","dropped":n} line with the
+// running total precedes the next event. Peers on /cluster/watch always
+// get disconnect, since a replica must not miss anything.
+//
+// With ?send_initial=true (instead of since=) the stream starts with the
+// current matching entries as "initial" events, each with the revision
+// of its last change, and a {"type":"synced","seq":N} line once they are
+// all sent; the live events that follow are exactly those after
+// revision N. A client can build a local cache from the one call, and
+// resume with since=N or a later seq if the stream breaks.
+//
+// With ?subscription=<name> (instead of since= and send_initial=) the
+// server keeps the position: a new subscription starts at the current
+// seq, and each connection resumes after the last event delivered on the
+// previous one. Only one watcher at a time may use a subscription (409);
+// a position no longer in the history gets 410 until the subscription is
+// deleted.
+//
+// With ?coalesce=<duration> (at most 1m) changes are held for that long
+// after the first one, then only the latest change of each key and type
+// is sent, in seq order, with "coalesced":n for the n earlier changes it
+// replaces. Replayed events are coalesced too; initial events and resets
+// (which discard what is held) are not.
+func (s *Server) Watch(w http.ResponseWriter, r *http.Request) {
+	s.IncrementRequests()
+
+	q := r.URL.Query()
+	scope := tenantScope(r)
+	prefix := scope + q.Get("prefix")
+
+	var types map[string]bool
+	if list := q.Get("events"); list != "" {
+		types = make(map[string]bool)
+		for _, t := range strings.Split(list, ",") {
+			types[strings.TrimSpace(t)] = true
+		}
+	}
+
+	var since uint64
+	if v := q.Get("since"); v != "" {
+		var err error
+		if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, "Invalid since", http.StatusBadRequest)
+			return
+		}
+	}
+
+	sendInitial := false
+	if v := q.Get("send_initial"); v != "" {
+		var err error
+		if sendInitial, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Invalid send_initial", http.StatusBadRequest)
+			return
+		}
+		if sendInitial && since > 0 {
+			http.Error(w, "send_initial and since are mutually exclusive", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var durable *durableSubscription
+	if name := q.Get("subscription"); name != "" && r.URL.Path != "/cluster/watch" {
+		if since > 0 || sendInitial {
+			http.Error(w, "subscription cannot be combined with since or send_initial", http.StatusBadRequest)
+			return
+		}
+		if !validSubscription(name) {
+			http.Error(w, "Invalid subscription", http.StatusBadRequest)
+			return
+		}
+		var err error
+		durable, err = s.subscriptions.attach(scope+name, s.events.Seq())
+		switch err {
+		case nil:
+		case errSubscriptionBusy:
+			http.Error(w, "Subscription is already connected", http.StatusConflict)
+			return
+		default:
+			http.Error(w, "Too many subscriptions", http.StatusServiceUnavailable)
+			return
+		}
+		defer s.subscriptions.detach(durable)
+		since = durable.Seq
+	}
+
+	overflow := events.Overflow(s.cfg.WatchOverflow)
+	if v := q.Get("overflow"); v != "" {
+		var err error
+		if overflow, err = events.ParseOverflow(v); err != nil {
+			http.Error(w, "Invalid overflow", http.StatusBadRequest)
+			return
+		}
+	}
+	if r.URL.Path == "/cluster/watch" {
+		overflow = events.OverflowDisconnect
+	}
+
+	interval := watchHeartbeat
+	if v := q.Get("heartbeat"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < minWatchHeartbeat {
+			http.Error(w, "Invalid heartbeat", http.StatusBadRequest)
+			return
+		}
+		interval = d
+	}
+
+	var coalescer *watchCoalescer
+	if v := q.Get("coalesce"); v != "" && r.URL.Path != "/cluster/watch" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxWatchCoalesce {
+			http.Error(w, "Invalid coalesce", http.StatusBadRequest)
+			return
+		}
+		coalescer = newWatchCoalescer(d)
+	}
+
+	filter := func(e events.Event) bool {
+		if e.Type == events.TypeReset {
+			return true
+		}
+		return strings.HasPrefix(e.Key, prefix) && (types == nil || types[e.Type]) &&
+			allowKey(r, auth.ScopeRead, strings.TrimPrefix(e.Key, scope))
+	}
+
+	sub, backlog, err := s.events.Subscribe(since, filter, overflow)
+	if err == events.ErrTooOld {
+		if durable != nil {
+			http.Error(w, "Subscription position is no longer available; delete the subscription to start over", http.StatusGone)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer sub.Close()
+	logging.Debugf("[WATCH] %s watching %q from seq %d\n", r.RemoteAddr, prefix, since)
+	defer logging.Debugf("[WATCH] %s stopped watching %q\n", r.RemoteAddr, prefix)
+
+	// Subscribing first and skipping events up to the snapshot's
+	// revision leaves neither a gap nor a duplicate between the two.
+	var initial []events.Event
+	var synced uint64
+	if sendInitial {
+		recs, rev, err := s.store.Snapshot(r.Context())
+		if err != nil {
+			storeFailed(w, "Failed to read: ", err)
+			return
+		}
+		initial, synced = initialEvents(recs, prefix), rev
+		readable := initial[:0]
+		for _, e := range initial {
+			if allowKey(r, auth.ScopeRead, strings.TrimPrefix(e.Key, scope)) {
+				readable = append(readable, e)
+			}
+		}
+		initial = readable
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	if coalescer != nil {
+		w.Header().Set("X-Watch-Coalesce", coalescer.window.String())
+	}
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	var dropped int64
+	var sent uint64
+	send := func(e coalescedEvent) error {
+		if n := sub.Dropped(); n > dropped {
+			dropped = n
+			if err := enc.Encode(map[string]interface{}{"type": "overflow", "dropped": n}); err != nil {
+				return err
+			}
+		}
+		e.Key = strings.TrimPrefix(e.Key, scope)
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+		sent = e.Seq
+		return nil
+	}
+	// flushOut sends what is buffered and, once it is out, moves the
+	// subscription past it.
+	flushOut := func() {
+		if rc.Flush() == nil && durable != nil {
+			s.subscriptions.advance(durable, sent)
+		}
+	}
+
+	// window fires when the coalescer's window is over; it is nil while
+	// nothing is held.
+	var window <-chan time.Time
+	flush := func() error {
+		window = nil
+		for _, e := range coalescer.flush() {
+			s.watchCoalesced.Add(int64(e.Coalesced))
+			if err := send(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, e := range backlog {
+		if coalescer != nil {
+			coalescer.add(e)
+			continue
+		}
+		send(coalescedEvent{Event: e})
+	}
+	if coalescer != nil {
+		flush()
+	}
+	if sendInitial {
+		for _, e := range initial {
+			send(coalescedEvent{Event: e})
+		}
+		enc.Encode(map[string]interface{}{"type": "synced", "seq": synced})
+	}
+	flushOut()
+
+	heartbeat := time.NewTicker(interval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind, or the server is shutting
+				// down; either way the client reconnects with since=.
+				return
+			}
+			if e.Seq <= synced {
+				continue
+			}
+			if coalescer != nil {
+				if e.Type != events.TypeReset {
+					if coalescer.add(e) {
+						window = time.After(coalescer.window)
+					}
+					continue
+				}
+				// Nothing held before a reset is worth sending.
+				coalescer.flush()
+				window = nil
+			}
+			if err := send(coalescedEvent{Event: e}); err != nil {
+				return
+			}
+			flushOut()
+
+		case <-window:
+			if err := flush(); err != nil {
+				return
+			}
+			flushOut()
+
+		case <-heartbeat.C:
+			if err := enc.Encode(map[string]string{"type": "heartbeat"}); err != nil {
+				return
+			}
+			flushOut()
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) collectWatchMetrics() []metrics.Family {
+	st := s.events.Stats()
+	dropped := metrics.Family{Name: "kv_watch_events_dropped_total", Help: "Events watchers lost to their overflow policy.", Type: metrics.TypeCounter, Samples: []metrics.Sample{
+		{Labels: []metrics.Label{{Name: "policy", Value: string(events.OverflowDropOldest)}}, Value: float64(st.Dropped)},
+		{Labels: []metrics.Label{{Name: "policy", Value: string(events.OverflowCoalesce)}}, Value: float64(st.Coalesced)},
+	}}
+	return []metrics.Family{
+		metrics.Single("kv_watch_subscribers", "Open watch streams.", metrics.TypeGauge, float64(st.Subscribers)),
+		metrics.Single("kv_watch_queued_events", "Events queued for watchers.", metrics.TypeGauge, float64(st.Queued)),
+		dropped,
+		metrics.Single("kv_watch_coalesced_events_total", "Events replaced by a later change of the same key within a watcher's ?coalesce= window.", metrics.TypeCounter, float64(s.watchCoalesced.Load())),
+		metrics.Single("kv_watch_disconnects_total", "Watchers disconnected because their queue overflowed.", metrics.TypeCounter, float64(st.Disconnected)),
+	}
+}
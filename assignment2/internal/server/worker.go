@@ -1,24 +1,174 @@
 package server
 
 import (
+	"assignment2/internal/jobs"
 	"context"
 	"log"
 	"time"
 )
 
-func (s *Server) StartWorker(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+// registerJobs sets up the background jobs. They start with StartWorker.
+func (s *Server) registerJobs() {
+	s.jobs.Watch(s.cfg.JobStallTimeout, s.cfg.JobRestart)
+	s.jobs.DeferFor(s.cfg.JobMaxDefer)
 
-	for {
-		select {
-		case <-ticker.C:
+	s.jobs.Register(jobs.Job{
+		Name:     "stats-log",
+		Interval: 5 * time.Second,
+		Run: func(ctx context.Context) error {
 			req, size, _ := s.Stats()
 			log.Printf("[WORKER] requests=%d db_size=%d\n", req, size)
+			return nil
+		},
+	})
+
+	s.jobs.Register(jobs.Job{
+		Name:     "stats-history",
+		Interval: s.cfg.StatsSampleInterval,
+		Run:      s.sampleStats,
+	})
+
+	s.jobs.Register(jobs.Job{
+		Name:       "retention",
+		Interval:   s.cfg.RetentionInterval,
+		Run:        s.retentionJob,
+		Deferrable: true,
+	})
+
+	if s.archive != nil {
+		s.jobs.Register(jobs.Job{
+			Name:       "archive",
+			Interval:   s.cfg.ArchiveInterval,
+			Run:        s.archiveJob,
+			Deferrable: true,
+		})
+		s.jobs.Register(jobs.Job{
+			Name:     "archive-tombstones",
+			Interval: time.Second,
+			Run:      s.dropStaleTombstones,
+		})
+	}
+
+	if s.shipping.log != nil {
+		s.jobs.Register(jobs.Job{
+			Name:     "ship-seal",
+			Interval: time.Second,
+			Run:      s.sealSegments,
+		})
+	}
+	if s.cfg.ShipFrom != "" {
+		s.jobs.Register(jobs.Job{
+			Name:     "ship-pull",
+			Interval: s.cfg.ShipPoll,
+			Run:      s.pullSegments,
+		})
+	}
+
+	if s.hotKeys != nil {
+		s.jobs.Register(jobs.Job{
+			Name:     "hotkeys",
+			Interval: s.cfg.HotKeysHalfLife,
+			Run:      s.decayHotKeys,
+		})
+	}
+
+	s.jobs.Register(jobs.Job{
+		Name:       "ttl",
+		Interval:   s.cfg.TTLSweepInterval,
+		Run:        s.expireKeys,
+		Deferrable: true,
+	})
+
+	if s.cfg.MemoryLimit > 0 {
+		s.jobs.Register(jobs.Job{
+			Name:     "memory",
+			Interval: s.cfg.MemoryInterval,
+			Run:      s.memoryJob,
+		})
+	}
+
+	if s.cfg.TenantQuota > 0 {
+		s.jobs.Register(jobs.Job{
+			Name:     "quotas",
+			Interval: s.cfg.QuotaInterval,
+			Run:      s.quotaJob,
+		})
+	}
 
-		case <-ctx.Done():
-			log.Println("[WORKER] stopped")
-			return
-		}
+	if len(s.cfg.Secondaries) > 0 {
+		s.jobs.Register(jobs.Job{
+			Name:       "reconcile",
+			Interval:   s.cfg.ReconcileInterval,
+			Run:        s.reconcileJob,
+			Deferrable: true,
+		})
 	}
+
+	if s.cfg.TierBudget > 0 {
+		s.jobs.Register(jobs.Job{
+			Name:     "tier",
+			Interval: s.cfg.TierInterval,
+			Run:      s.tierJob,
+		})
+	}
+
+	if s.cfg.DataDir != "" {
+		s.jobs.Register(jobs.Job{
+			Name:       "integrity",
+			Interval:   s.cfg.IntegrityInterval,
+			Run:        s.integrityJob,
+			Deferrable: true,
+		})
+		s.jobs.Register(jobs.Job{
+			Name:       "compaction",
+			Interval:   s.cfg.CompactInterval,
+			Run:        s.compactionJob,
+			Deferrable: true,
+		})
+		s.jobs.Register(jobs.Job{
+			Name:     "subscriptions",
+			Interval: time.Second,
+			Run:      s.saveSubscriptions,
+		})
+	}
+
+	if s.blobs != nil {
+		s.jobs.Register(jobs.Job{
+			Name:       "upload-cleanup",
+			Interval:   time.Minute,
+			Run:        s.cleanupUploads,
+			Deferrable: true,
+		})
+	}
+
+	s.jobs.Register(jobs.Job{
+		Name:       "bulk-import",
+		Interval:   time.Second,
+		Run:        s.bulkImportJob,
+		Deferrable: true,
+	})
+
+	if s.cfg.JobPauseRPS > 0 {
+		s.jobs.Register(jobs.Job{
+			Name:     "load",
+			Interval: time.Second,
+			Run:      s.loadJob,
+		})
+	}
+
+	if s.verifier != nil {
+		s.jobs.Register(jobs.Job{
+			Name:     "nonce-cleanup",
+			Interval: 5 * time.Second,
+			Run: func(ctx context.Context) error {
+				s.verifier.Nonces.Cleanup(time.Now())
+				return nil
+			},
+		})
+	}
+}
+
+func (s *Server) StartWorker(ctx context.Context) {
+	s.jobs.Run(ctx)
+	log.Println("[WORKER] stopped")
 }
@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrCompacted means a revision is older than anything the
+	// write-ahead log can replay to, or the store keeps no log.
+	ErrCompacted = errors.New("revision has been compacted")
+
+	// ErrFutureRevision means a revision has not been reached yet.
+	ErrFutureRevision = errors.New("revision is in the future")
+
+	// ErrPrefixNotEmpty means a clone's destination already has keys.
+	ErrPrefixNotEmpty = errors.New("destination prefix is not empty")
+)
+
+// ClonePrefix copies every live key under src, with its tags and time to
+// live, to the same name under dst, as one write-ahead log batch: readers
+// and watchers see all of the copy or none of it. dst must hold no keys.
+//
+// With rev 0 the keys are copied as they are now. Otherwise they are
+// copied as they were at revision rev, as replayed from the log; that
+// fails with ErrCompacted once a compaction has folded rev into a
+// snapshot, and for an in-memory store unless rev is the current one.
+// ClonePrefix returns the number of keys copied and the revision of the
+// copy.
+func (m *MemoryStore) ClonePrefix(ctx context.Context, src, dst string, rev uint64) (int, uint64, error) {
+	defer track(ctx, time.Now())
+
+	var recs []Record
+	replayed := false
+	if rev != 0 && m.wal != nil {
+		if err := m.lock(ctx); err != nil {
+			return 0, 0, err
+		}
+		current := m.rev
+		m.mu.Unlock()
+		if rev > current {
+			return 0, 0, ErrFutureRevision
+		}
+
+		var err error
+		if recs, err = m.recordsAt(ctx, rev); err != nil {
+			return 0, 0, err
+		}
+		replayed = true
+	}
+
+	if err := m.lockWrite(ctx); err != nil {
+		return 0, 0, err
+	}
+	fail := func(err error) (int, uint64, error) {
+		m.mu.Unlock()
+		return 0, 0, err
+	}
+	switch {
+	case replayed:
+	case rev > m.rev:
+		return fail(ErrFutureRevision)
+	case rev != 0 && rev != m.rev:
+		return fail(ErrCompacted)
+	default:
+		var err error
+		if recs, _, err = m.snapshotLocked(ctx); err != nil {
+			return fail(err)
+		}
+	}
+
+	now := time.Now()
+	for k := range m.data {
+		if strings.HasPrefix(k, dst) && !m.expiredLocked(k, now) {
+			return fail(ErrPrefixNotEmpty)
+		}
+	}
+
+	var out []Record
+	n := 0
+	for _, rec := range recs {
+		name, ok := strings.CutPrefix(rec.Key, src)
+		if !ok {
+			continue
+		}
+		key := dst + name
+		switch rec.Op {
+		case OpSet:
+			deadline := deadlineFromWall(rec.Expires)
+			if !deadline.IsZero() && !now.Before(deadline) {
+				continue
+			}
+			m.putLocked(key, rec.Value)
+			m.setExpiryLocked(key, deadline)
+			if rec.Enc != nil {
+				m.enc[key] = *rec.Enc
+			}
+			out = append(out, Record{Op: OpSet, Key: key, Value: rec.Value, Expires: rec.Expires, TTL: rec.TTL, Enc: rec.Enc})
+			n++
+		case OpTags:
+			if _, ok := m.data[key]; ok {
+				m.setTagsLocked(key, rec.Tags)
+				out = append(out, Record{Op: OpTags, Key: key, Tags: rec.Tags})
+			}
+		}
+	}
+	if len(out) == 0 {
+		cur := m.rev
+		m.mu.Unlock()
+		return 0, cur, nil
+	}
+	cloneRev, wait := m.logLocked(out...)
+	m.mu.Unlock()
+
+	return n, cloneRev, wait()
+}
+
+// recordsAt replays the write-ahead log up to revision rev and returns
+// the contents at that point in the form of Snapshot.
+func (m *MemoryStore) recordsAt(ctx context.Context, rev uint64) ([]Record, error) {
+	// The records of a snapshot follow their reset with the older
+	// revisions of their keys, so only records outside one end the
+	// replay. A log that starts with a reset past rev was compacted.
+	scratch := NewMemoryStore()
+	first, inSnapshot, done := true, false, false
+	err := m.readLog(ctx, func(rec Record) error {
+		if done {
+			return nil
+		}
+		if rec.Rev == 0 {
+			rec.Rev = scratch.rev + 1
+		}
+		switch {
+		case rec.Op == OpReset && rec.Rev > rev:
+			if first {
+				return ErrCompacted
+			}
+			done = true
+			return nil
+		case rec.Op == OpReset:
+			inSnapshot = true
+		case inSnapshot && rec.Rev <= scratch.rev:
+		case rec.Rev > rev:
+			done = true
+			return nil
+		default:
+			inSnapshot = false
+		}
+		first = false
+		return scratch.applyLocked(rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	recs, _, err := scratch.snapshotLocked(ctx)
+	return recs, err
+}
+
+// RevisionAt returns the revision the store was at as of t: that of the
+// last change logged at or before t, or 0 if there was none. It fails
+// with ErrCompacted if a compaction has folded t into a snapshot, or the
+// store keeps no log.
+func (m *MemoryStore) RevisionAt(ctx context.Context, t time.Time) (uint64, error) {
+	if m.wal == nil {
+		return 0, ErrCompacted
+	}
+
+	until := t.UnixNano()
+	var rev uint64
+	first, inSnapshot, done := true, false, false
+	err := m.readLog(ctx, func(rec Record) error {
+		if done {
+			return nil
+		}
+		if rec.Rev == 0 {
+			rec.Rev = rev + 1
+		}
+		switch {
+		case rec.Op == OpReset && rec.TS > until:
+			if first {
+				return ErrCompacted
+			}
+			done = true
+			return nil
+		case rec.Op == OpReset:
+			inSnapshot = true
+			rev = rec.Rev
+		case inSnapshot && rec.Rev <= rev:
+		case rec.TS > until:
+			done = true
+			return nil
+		default:
+			inSnapshot = false
+			rev = max(rev, rec.Rev)
+		}
+		first = false
+		return nil
+	})
+	return rev, err
+}
+
+// readLog calls fn for every record of the write-ahead log up to the
+// latest write, with compactions held off.
+func (m *MemoryStore) readLog(ctx context.Context, fn func(Record) error) error {
+	m.compactMu.Lock()
+	defer m.compactMu.Unlock()
+
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	offset, wait := m.wal.Barrier()
+	m.mu.Unlock()
+
+	if err := wait(); err != nil {
+		return err
+	}
+
+	f, err := os.Open(m.wal.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n := 0
+	_, err = replay(io.LimitReader(f, offset), func(rec Record) error {
+		if n++; n%checkEvery == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fn(rec)
+	})
+	return err
+}
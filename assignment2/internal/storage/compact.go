@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// CompactionStats describe the write-ahead log and its compactions.
+type CompactionStats struct {
+	// Size is the size of the log file and Written the number of bytes
+	// appended to it since the store was opened.
+	Size    int64
+	Written int64
+
+	Compactions int64
+
+	// SnapshotSize is the size of the snapshot the last compaction
+	// started the log with.
+	SnapshotSize int64
+
+	LastDuration  time.Duration
+	TotalDuration time.Duration
+	LastTime      time.Time
+
+	// Throttled is how long compactions waited for
+	// Options.CompactBytesPerSec.
+	Throttled time.Duration
+}
+
+// Compact rewrites the write-ahead log as a snapshot of the current
+// contents plus whatever is written while the snapshot is saved, so the
+// log stops growing with every overwrite and delete. Writers are held up
+// only while the contents are copied and, briefly, when the files are
+// swapped. With Options.CompactBytesPerSec the snapshot is written no
+// faster than that; ctx cancels the wait.
+func (m *MemoryStore) Compact(ctx context.Context) (CompactionStats, error) {
+	defer track(ctx, time.Now())
+
+	if m.wal == nil {
+		return CompactionStats{}, ErrNotPersisted
+	}
+
+	m.compactMu.Lock()
+	defer m.compactMu.Unlock()
+
+	start := time.Now()
+	if err := m.lock(ctx); err != nil {
+		return CompactionStats{}, err
+	}
+	recs, rev, err := m.snapshotLocked(ctx)
+	if err != nil {
+		m.mu.Unlock()
+		return CompactionStats{}, err
+	}
+	offset, wait := m.wal.Barrier()
+	m.mu.Unlock()
+
+	if err := wait(); err != nil {
+		return CompactionStats{}, err
+	}
+
+	var throttle *throttledWriter
+	var wrap func(io.Writer) io.Writer
+	if m.compactRate != nil {
+		wrap = func(f io.Writer) io.Writer {
+			throttle = &throttledWriter{ctx: ctx, w: f, rate: m.compactRate}
+			return throttle
+		}
+	}
+	snapshot := append([]Record{{Rev: rev, TS: start.UnixNano(), Op: OpReset}}, recs...)
+	size, err := m.wal.Rewrite(snapshot, offset, wrap)
+
+	elapsed := time.Since(start)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if throttle != nil {
+		m.compaction.Throttled += throttle.waited
+	}
+	if err != nil {
+		return CompactionStats{}, err
+	}
+	m.compaction.Compactions++
+	m.compaction.SnapshotSize = size
+	m.compaction.LastDuration = elapsed
+	m.compaction.TotalDuration += elapsed
+	m.compaction.LastTime = start
+	return m.compactionStatsLocked(), nil
+}
+
+// CompactionStats returns the log's size and compaction history; ok is
+// false for an in-memory store.
+func (m *MemoryStore) CompactionStats() (stats CompactionStats, ok bool) {
+	if m.wal == nil {
+		return CompactionStats{}, false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.compactionStatsLocked(), true
+}
+
+func (m *MemoryStore) compactionStatsLocked() CompactionStats {
+	stats := m.compaction
+	stats.Size = m.wal.Size()
+	stats.Written = m.wal.Written()
+	return stats
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxContentionPrefixes bounds how many prefixes are tracked; keys under
+// prefixes seen later are counted under OtherPrefix.
+const maxContentionPrefixes = 1000
+
+// OtherPrefix collects keys beyond maxContentionPrefixes.
+const OtherPrefix = "(other)"
+
+// PrefixContention is what contention tracking knows about the keys
+// under one prefix.
+type PrefixContention struct {
+	Prefix string
+
+	// Writes is the number of writes that waited for the store lock, and
+	// LockWait and MaxLockWait their total and longest wait.
+	Writes      int64
+	LockWait    time.Duration
+	MaxLockWait time.Duration
+
+	// CASAttempts counts conditional writes and CASConflicts those that
+	// failed because the key had changed.
+	CASAttempts  int64
+	CASConflicts int64
+}
+
+// ConflictRate is the share of conditional writes that conflicted.
+func (p PrefixContention) ConflictRate() float64 {
+	if p.CASAttempts == 0 {
+		return 0
+	}
+	return float64(p.CASConflicts) / float64(p.CASAttempts)
+}
+
+// contentionTracker attributes lock waits and CAS outcomes to key
+// prefixes of depth segments.
+type contentionTracker struct {
+	depth int
+
+	mu       sync.Mutex
+	prefixes map[string]*PrefixContention
+	total    PrefixContention
+}
+
+func newContentionTracker(depth int) *contentionTracker {
+	return &contentionTracker{depth: depth, prefixes: make(map[string]*PrefixContention)}
+}
+
+// KeyPrefix returns the first depth segments of key, each ending in ':'
+// or '/', or all of key if it has fewer.
+func KeyPrefix(key string, depth int) string {
+	end := 0
+	for i := 0; i < depth; i++ {
+		j := strings.IndexAny(key[end:], ":/")
+		if j < 0 {
+			return key
+		}
+		end += j + 1
+	}
+	return key[:end]
+}
+
+// getLocked returns the entry for key's prefix.
+func (t *contentionTracker) getLocked(key string) *PrefixContention {
+	prefix := KeyPrefix(key, t.depth)
+	p, ok := t.prefixes[prefix]
+	if !ok {
+		if len(t.prefixes) >= maxContentionPrefixes {
+			prefix = OtherPrefix
+			if p, ok = t.prefixes[prefix]; ok {
+				return p
+			}
+		}
+		p = &PrefixContention{Prefix: prefix}
+		t.prefixes[prefix] = p
+	}
+	return p
+}
+
+// waited records that a write to keys waited d for the lock, once per
+// prefix.
+func (t *contentionTracker) waited(keys []string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	seen := make(map[*PrefixContention]bool, 1)
+	for _, k := range keys {
+		p := t.getLocked(k)
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		p.Writes++
+		p.LockWait += d
+		p.MaxLockWait = max(p.MaxLockWait, d)
+	}
+	t.total.Writes++
+	t.total.LockWait += d
+	t.total.MaxLockWait = max(t.total.MaxLockWait, d)
+}
+
+func (t *contentionTracker) cas(key string, conflict bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, p := range []*PrefixContention{t.getLocked(key), &t.total} {
+		p.CASAttempts++
+		if conflict {
+			p.CASConflicts++
+		}
+	}
+}
+
+// contentionKeys lists the keys of entries if contention is tracked.
+func (m *MemoryStore) contentionKeys(entries map[string]string) []string {
+	if m.contention == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// RecordCAS counts a conditional write to key, which conflicted if the
+// key had changed since the caller read it. Without contention tracking
+// it does nothing.
+func (m *MemoryStore) RecordCAS(key string, conflict bool) {
+	if m.contention != nil {
+		m.contention.cas(key, conflict)
+	}
+}
+
+// Contention returns the n most contended prefixes, by CAS conflicts
+// and then lock wait, and the totals over all keys; ok is false without
+// contention tracking.
+func (m *MemoryStore) Contention(n int) (top []PrefixContention, total PrefixContention, ok bool) {
+	t := m.contention
+	if t == nil {
+		return nil, PrefixContention{}, false
+	}
+
+	t.mu.Lock()
+	top = make([]PrefixContention, 0, len(t.prefixes))
+	for _, p := range t.prefixes {
+		top = append(top, *p)
+	}
+	total = t.total
+	t.mu.Unlock()
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].CASConflicts != top[j].CASConflicts {
+			return top[i].CASConflicts > top[j].CASConflicts
+		}
+		if top[i].LockWait != top[j].LockWait {
+			return top[i].LockWait > top[j].LockWait
+		}
+		return top[i].Prefix < top[j].Prefix
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top, total, true
+}
@@ -0,0 +1,67 @@
+package storage
+
+import "crypto/sha256"
+
+// dedupTable stores each distinct value once. Keys map to their value's
+// content hash and the table maps hashes to the shared bytes; Go strings
+// are immutable, so handing out the same string to every key is safe.
+type dedupTable struct {
+	byHash map[[sha256.Size]byte]*dedupEntry
+
+	logical int64 // bytes of all values, counted once per key
+	stored  int64 // bytes actually held
+}
+
+type dedupEntry struct {
+	value string
+	refs  int
+}
+
+// DedupStats describes how much storage value deduplication saves.
+type DedupStats struct {
+	UniqueValues int   `json:"unique_values"`
+	LogicalBytes int64 `json:"logical_bytes"`
+	StoredBytes  int64 `json:"stored_bytes"`
+	SavedBytes   int64 `json:"saved_bytes"`
+}
+
+func newDedupTable() *dedupTable {
+	return &dedupTable{byHash: make(map[[sha256.Size]byte]*dedupEntry)}
+}
+
+// intern returns the shared copy of value and takes a reference to it.
+func (t *dedupTable) intern(value string) string {
+	h := sha256.Sum256([]byte(value))
+	t.logical += int64(len(value))
+
+	if e, ok := t.byHash[h]; ok {
+		e.refs++
+		return e.value
+	}
+	t.byHash[h] = &dedupEntry{value: value, refs: 1}
+	t.stored += int64(len(value))
+	return value
+}
+
+// release drops a reference taken by intern.
+func (t *dedupTable) release(value string) {
+	h := sha256.Sum256([]byte(value))
+	e, ok := t.byHash[h]
+	if !ok {
+		return
+	}
+	t.logical -= int64(len(value))
+	if e.refs--; e.refs == 0 {
+		delete(t.byHash, h)
+		t.stored -= int64(len(value))
+	}
+}
+
+func (t *dedupTable) stats() DedupStats {
+	return DedupStats{
+		UniqueValues: len(t.byHash),
+		LogicalBytes: t.logical,
+		StoredBytes:  t.stored,
+		SavedBytes:   t.logical - t.stored,
+	}
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrDirLocked means another process has the data directory open.
+var ErrDirLocked = errors.New("data directory is in use by another process")
+
+// dirLockName is the file in the data directory that the process using
+// it holds locked and writes its PID to.
+const dirLockName = "LOCK"
+
+// dirLock keeps a data directory to one process, so that two servers
+// started on the same directory cannot interleave writes to one log. The
+// lock is the operating system's and goes away with the process, however
+// it exits; the file is left behind and reused.
+type dirLock struct {
+	f *os.File
+}
+
+func lockDir(dir string) (*dirLock, error) {
+	path := filepath.Join(dir, dirLockName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if errors.Is(err, ErrDirLocked) {
+			if pid := lockHolder(path); pid != 0 {
+				return nil, fmt.Errorf("%s: %w (pid %d)", dir, ErrDirLocked, pid)
+			}
+			return nil, fmt.Errorf("%s: %w", dir, ErrDirLocked)
+		}
+		return nil, err
+	}
+
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &dirLock{f: f}, nil
+}
+
+// lockHolder returns the PID recorded in the lock file, or 0.
+func lockHolder(path string) int {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+	return pid
+}
+
+func (l *dirLock) release() error {
+	return l.f.Close()
+}
@@ -0,0 +1,213 @@
+package dynamodb
+
+import (
+	"assignment2/internal/sigv4"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// attr is a DynamoDB attribute value; only strings and numbers are used.
+type attr struct {
+	S *string `json:"S,omitempty"`
+	N *string `json:"N,omitempty"`
+}
+
+type item map[string]attr
+
+func str(s string) attr { return attr{S: &s} }
+func num(n int64) attr  { v := fmt.Sprint(n); return attr{N: &v} }
+
+func (it item) str(name string) string {
+	if a, ok := it[name]; ok && a.S != nil {
+		return *a.S
+	}
+	return ""
+}
+
+func (it item) num(name string) int64 {
+	var n int64
+	if a, ok := it[name]; ok && a.N != nil {
+		fmt.Sscan(*a.N, &n)
+	}
+	return n
+}
+
+// apiError is an error answer of the DynamoDB API.
+type apiError struct {
+	Status  int
+	Type    string
+	Message string
+	Reasons []cancellationReason
+}
+
+type cancellationReason struct {
+	Code    string `json:"Code"`
+	Message string `json:"Message"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("dynamodb: %s: %s (HTTP %d)", e.Type, e.Message, e.Status)
+}
+
+// retryable reports whether the request may succeed if sent again: the
+// table is throttled, the service failed, or a transaction ran into
+// another one.
+func (e *apiError) retryable() bool {
+	switch e.Type {
+	case "ThrottlingException", "ProvisionedThroughputExceededException", "RequestLimitExceeded", "InternalServerError", "ServiceUnavailable":
+		return true
+	}
+	for _, r := range e.Reasons {
+		if r.Code == "TransactionConflict" || r.Code == "ThrottlingError" {
+			return true
+		}
+	}
+	return e.Status >= 500
+}
+
+// reason returns the cancellation reason of the i-th action of a
+// cancelled transaction.
+func (e *apiError) reason(i int) string {
+	if i < len(e.Reasons) {
+		return e.Reasons[i].Code
+	}
+	return ""
+}
+
+// call sends one DynamoDB API request and decodes its answer into out.
+func (s *Store) call(ctx context.Context, op string, in, out interface{}) error {
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810."+op)
+	sigv4.Sign(req, payload, s.creds, s.region, "dynamodb", time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var answer struct {
+			Type                string               `json:"__type"`
+			Message             string               `json:"message"`
+			MessageUpper        string               `json:"Message"`
+			CancellationReasons []cancellationReason `json:"CancellationReasons"`
+		}
+		json.Unmarshal(body, &answer)
+		e := &apiError{Status: resp.StatusCode, Message: answer.Message, Reasons: answer.CancellationReasons}
+		if e.Message == "" {
+			e.Message = answer.MessageUpper
+		}
+		// "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException"
+		e.Type = answer.Type[strings.LastIndex(answer.Type, "#")+1:]
+		return e
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
+
+type getItemInput struct {
+	TableName      string `json:"TableName"`
+	Key            item   `json:"Key"`
+	ConsistentRead bool   `json:"ConsistentRead"`
+}
+
+func (s *Store) getItem(ctx context.Context, pk string) (item, error) {
+	var out struct {
+		Item item `json:"Item"`
+	}
+	err := s.call(ctx, "GetItem", getItemInput{TableName: s.table, Key: item{attrPK: str(pk)}, ConsistentRead: true}, &out)
+	return out.Item, err
+}
+
+// writeAction is one action of TransactWriteItems.
+type writeAction struct {
+	Put    *putAction    `json:"Put,omitempty"`
+	Update *updateAction `json:"Update,omitempty"`
+	Delete *deleteAction `json:"Delete,omitempty"`
+}
+
+type putAction struct {
+	TableName                 string            `json:"TableName"`
+	Item                      item              `json:"Item"`
+	ConditionExpression       string            `json:"ConditionExpression,omitempty"`
+	ExpressionAttributeNames  map[string]string `json:"ExpressionAttributeNames,omitempty"`
+	ExpressionAttributeValues item              `json:"ExpressionAttributeValues,omitempty"`
+}
+
+type updateAction struct {
+	TableName                 string            `json:"TableName"`
+	Key                       item              `json:"Key"`
+	UpdateExpression          string            `json:"UpdateExpression"`
+	ConditionExpression       string            `json:"ConditionExpression,omitempty"`
+	ExpressionAttributeNames  map[string]string `json:"ExpressionAttributeNames,omitempty"`
+	ExpressionAttributeValues item              `json:"ExpressionAttributeValues,omitempty"`
+}
+
+type deleteAction struct {
+	TableName                 string            `json:"TableName"`
+	Key                       item              `json:"Key"`
+	ConditionExpression       string            `json:"ConditionExpression,omitempty"`
+	ExpressionAttributeNames  map[string]string `json:"ExpressionAttributeNames,omitempty"`
+	ExpressionAttributeValues item              `json:"ExpressionAttributeValues,omitempty"`
+}
+
+func (s *Store) transact(ctx context.Context, actions []writeAction) error {
+	return s.call(ctx, "TransactWriteItems", map[string]interface{}{"TransactItems": actions}, nil)
+}
+
+type scanInput struct {
+	TableName                 string            `json:"TableName"`
+	ConsistentRead            bool              `json:"ConsistentRead"`
+	FilterExpression          string            `json:"FilterExpression,omitempty"`
+	ExpressionAttributeNames  map[string]string `json:"ExpressionAttributeNames,omitempty"`
+	ExpressionAttributeValues item              `json:"ExpressionAttributeValues,omitempty"`
+	ExclusiveStartKey         item              `json:"ExclusiveStartKey,omitempty"`
+}
+
+// scan calls fn for every item matching filter, a page at a time.
+func (s *Store) scan(ctx context.Context, filter string, names map[string]string, values item, fn func(item)) error {
+	in := scanInput{
+		TableName:                 s.table,
+		ConsistentRead:            true,
+		FilterExpression:          filter,
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}
+	for {
+		var out struct {
+			Items            []item `json:"Items"`
+			LastEvaluatedKey item   `json:"LastEvaluatedKey"`
+		}
+		if err := s.call(ctx, "Scan", in, &out); err != nil {
+			return err
+		}
+		for _, it := range out.Items {
+			fn(it)
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		in.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
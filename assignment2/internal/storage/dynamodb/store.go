@@ -0,0 +1,469 @@
+// Package dynamodb implements storage.Store on a DynamoDB table, so the
+// store can be kept outside the process with managed durability.
+//
+// Everything lives in one table whose partition key is the string
+// attribute "pk": "data#<key>" items hold entries and the "meta#revision"
+// item holds the revision counter. An entry has its value in "v" and the
+// revision of its last change in "rev"; a key with a time to live also
+// has "exp", the expiry in unix nanoseconds, which reads check, and
+// "ttl", the same in unix seconds, which should be enabled as the table's
+// time to live attribute so that DynamoDB deletes expired items.
+//
+// Every write is a transaction that also moves the counter from the value
+// the store last saw to the next revision, under a condition, so
+// revisions are unique and follow the commit order even with several
+// processes on one table; Update adds a condition on the entry's
+// revision, which makes it compare-and-swap. As all writes to a table go
+// through the counter item they are serialized, which bounds the write
+// rate to what one item sustains. Values are limited by DynamoDB's item
+// size of 400 KB.
+package dynamodb
+
+import (
+	"assignment2/internal/sigv4"
+	"assignment2/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	attrPK    = "pk"
+	attrValue = "v"
+	attrRev   = "rev"
+	attrExp   = "exp"
+	attrTTL   = "ttl"
+
+	dataPrefix = "data#"
+	counterPK  = "meta#revision"
+)
+
+// A transaction holds at most 100 actions and 4 MB; one is the counter.
+const (
+	maxBatchItems = 99
+	maxBatchBytes = 3 << 20
+)
+
+const (
+	liveCondition = "(attribute_not_exists(#exp) OR #exp > :now)"
+	maxBackoff    = 100 * time.Millisecond
+)
+
+type Options struct {
+	Table  string
+	Region string
+
+	// Endpoint replaces https://dynamodb.<Region>.amazonaws.com, e.g. for
+	// DynamoDB Local.
+	Endpoint string
+
+	// Credentials are read from the environment if not set.
+	Credentials sigv4.Credentials
+
+	// HTTPClient defaults to one with a 10 second timeout.
+	HTTPClient *http.Client
+}
+
+type Store struct {
+	table    string
+	region   string
+	endpoint string
+	creds    sigv4.Credentials
+	client   *http.Client
+
+	// rev is the latest revision this store has seen.
+	rev atomic.Uint64
+}
+
+var _ storage.Store = (*Store)(nil)
+
+// Open connects to the table in opts and reads its current revision. The
+// table must exist, with the string partition key "pk".
+func Open(ctx context.Context, opts Options) (*Store, error) {
+	if opts.Table == "" || opts.Region == "" {
+		return nil, errors.New("dynamodb: table and region are required")
+	}
+	s := &Store{
+		table:    opts.Table,
+		region:   opts.Region,
+		endpoint: opts.Endpoint,
+		creds:    opts.Credentials,
+		client:   opts.HTTPClient,
+	}
+	if s.endpoint == "" {
+		s.endpoint = "https://dynamodb." + opts.Region + ".amazonaws.com"
+	}
+	if s.creds.AccessKeyID == "" {
+		var err error
+		if s.creds, err = sigv4.CredentialsFromEnv(); err != nil {
+			return nil, fmt.Errorf("dynamodb: %w", err)
+		}
+	}
+	if s.client == nil {
+		s.client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if _, err := s.readCounter(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close releases idle connections; the table is untouched.
+func (s *Store) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// Revision returns the latest revision this store wrote or read from the
+// counter. Other processes' writes show once this one reads the counter
+// again, which every write conflict and GetSince does.
+func (s *Store) Revision() uint64 {
+	return s.rev.Load()
+}
+
+func (s *Store) observe(rev uint64) {
+	for {
+		cur := s.rev.Load()
+		if rev <= cur || s.rev.CompareAndSwap(cur, rev) {
+			return
+		}
+	}
+}
+
+func (s *Store) readCounter(ctx context.Context) (uint64, error) {
+	it, err := s.getItem(ctx, counterPK)
+	if err != nil {
+		return 0, err
+	}
+	rev := uint64(it.num(attrRev))
+	s.observe(rev)
+	return rev, nil
+}
+
+func dataKey(key string) string {
+	return dataPrefix + key
+}
+
+// attrNames returns the placeholders the expressions use; DynamoDB
+// rejects unused ones.
+func attrNames(exprs ...string) map[string]string {
+	all := strings.Join(exprs, " ")
+	names := make(map[string]string)
+	for _, name := range []string{attrPK, attrValue, attrRev, attrExp} {
+		if strings.Contains(all, "#"+name) {
+			names["#"+name] = name
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return names
+}
+
+func expiry(it item) time.Time {
+	if exp := it.num(attrExp); exp != 0 {
+		return time.Unix(0, exp)
+	}
+	return time.Time{}
+}
+
+func live(it item, now time.Time) bool {
+	if it == nil {
+		return false
+	}
+	exp := expiry(it)
+	return exp.IsZero() || now.Before(exp)
+}
+
+func entryItem(key, value string, rev uint64, exp time.Time) item {
+	it := item{
+		attrPK:    str(dataKey(key)),
+		attrValue: str(value),
+		attrRev:   num(int64(rev)),
+	}
+	if !exp.IsZero() {
+		it[attrExp] = num(exp.UnixNano())
+		it[attrTTL] = num((exp.UnixNano() + int64(time.Second) - 1) / int64(time.Second))
+	}
+	return it
+}
+
+// counterAction moves the counter from cur to next, provided nobody else
+// moved it first.
+func (s *Store) counterAction(cur, next uint64) writeAction {
+	cond := "#rev = :cur"
+	values := item{":cur": num(int64(cur)), ":next": num(int64(next))}
+	if cur == 0 {
+		cond = "attribute_not_exists(#rev)"
+		delete(values, ":cur")
+	}
+	update := "SET #rev = :next"
+	return writeAction{Update: &updateAction{
+		TableName:                 s.table,
+		Key:                       item{attrPK: str(counterPK)},
+		UpdateExpression:          update,
+		ConditionExpression:       cond,
+		ExpressionAttributeNames:  attrNames(update, cond),
+		ExpressionAttributeValues: values,
+	}}
+}
+
+// commit writes the entry actions build returns for revisions first to
+// first+n-1, together with the counter. While other writers move the
+// counter first it is read again and the transaction retried. applied is
+// false if one of the entry actions' conditions failed.
+func (s *Store) commit(ctx context.Context, n int, build func(first uint64) []writeAction) (rev uint64, applied bool, err error) {
+	cur := s.rev.Load()
+	for attempt := 0; ; attempt++ {
+		next := cur + uint64(n)
+		actions := append([]writeAction{s.counterAction(cur, next)}, build(cur+1)...)
+		err := s.transact(ctx, actions)
+		if err == nil {
+			s.observe(next)
+			return next, true, nil
+		}
+
+		var ae *apiError
+		if !errors.As(err, &ae) {
+			return 0, false, err
+		}
+		switch {
+		case ae.Type == "TransactionCanceledException" && entryConditionFailed(ae, len(actions)):
+			return 0, false, nil
+		case ae.Type == "TransactionCanceledException" && ae.reason(0) == "ConditionalCheckFailed":
+			if cur, err = s.readCounter(ctx); err != nil {
+				return 0, false, err
+			}
+		case !ae.retryable():
+			return 0, false, err
+		}
+		if err := backoff(ctx, attempt); err != nil {
+			return 0, false, err
+		}
+	}
+}
+
+func entryConditionFailed(e *apiError, actions int) bool {
+	for i := 1; i < actions; i++ {
+		if e.reason(i) == "ConditionalCheckFailed" {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff waits before the next attempt, without waiting for the first
+// retry, which usually only needed a fresh counter.
+func backoff(ctx context.Context, attempt int) error {
+	if attempt == 0 {
+		return ctx.Err()
+	}
+	d := min(time.Duration(1<<min(attempt, 10))*time.Millisecond, maxBackoff)
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(d)) + 1))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Store) GetEntry(ctx context.Context, key string) (storage.Entry, bool, error) {
+	it, err := s.getItem(ctx, dataKey(key))
+	if err != nil || !live(it, time.Now()) {
+		return storage.Entry{}, false, err
+	}
+	return storage.Entry{
+		Value:     it.str(attrValue),
+		Revision:  uint64(it.num(attrRev)),
+		ExpiresAt: expiry(it),
+	}, true, nil
+}
+
+func (s *Store) Get(ctx context.Context, key string) (string, bool, error) {
+	e, ok, err := s.GetEntry(ctx, key)
+	return e.Value, ok, err
+}
+
+func (s *Store) GetRevision(ctx context.Context, key string) (string, uint64, bool, error) {
+	e, ok, err := s.GetEntry(ctx, key)
+	return e.Value, e.Revision, ok, err
+}
+
+func (s *Store) GetAll(ctx context.Context) (map[string]string, error) {
+	return s.scanEntries(ctx, 0)
+}
+
+// GetSince returns the entries changed at or after minRev and the
+// revision read from the counter before the scan. A scan is not a
+// snapshot: writes made while it runs may or may not be included.
+func (s *Store) GetSince(ctx context.Context, minRev uint64) (map[string]string, uint64, error) {
+	rev, err := s.readCounter(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	since, err := s.scanEntries(ctx, minRev)
+	return since, rev, err
+}
+
+func (s *Store) scanEntries(ctx context.Context, minRev uint64) (map[string]string, error) {
+	filter := "begins_with(#pk, :prefix) AND " + liveCondition
+	values := item{":prefix": str(dataPrefix), ":now": num(time.Now().UnixNano())}
+	if minRev > 0 {
+		filter += " AND #rev >= :min"
+		values[":min"] = num(int64(minRev))
+	}
+
+	out := make(map[string]string)
+	err := s.scan(ctx, filter, attrNames(filter), values, func(it item) {
+		out[strings.TrimPrefix(it.str(attrPK), dataPrefix)] = it.str(attrValue)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *Store) Set(ctx context.Context, key, value string) (uint64, error) {
+	rev, _, err := s.commit(ctx, 1, func(first uint64) []writeAction {
+		return []writeAction{{Put: &putAction{TableName: s.table, Item: entryItem(key, value, first, time.Time{})}}}
+	})
+	return rev, err
+}
+
+func (s *Store) SetMany(ctx context.Context, entries map[string]string) (uint64, error) {
+	return s.SetManyTTL(ctx, entries, 0)
+}
+
+// SetManyTTL writes the entries in transactions of up to 99 keys each,
+// in key order; each transaction is atomic, the whole batch is not.
+// Readers can see the first transactions before the last one commits,
+// and if one fails those before it stay written: the error is returned
+// and the batch has to be written again, as a secondary's sync does.
+func (s *Store) SetManyTTL(ctx context.Context, entries map[string]string, ttl time.Duration) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var exp time.Time
+	if ttl > 0 {
+		exp = time.Now().Add(ttl)
+	}
+	rev := s.rev.Load()
+	for len(keys) > 0 {
+		n, size := 0, 0
+		for n < len(keys) && n < maxBatchItems && (n == 0 || size+len(keys[n])+len(entries[keys[n]]) <= maxBatchBytes) {
+			size += len(keys[n]) + len(entries[keys[n]])
+			n++
+		}
+		batch := keys[:n]
+		keys = keys[n:]
+
+		var err error
+		rev, _, err = s.commit(ctx, len(batch), func(first uint64) []writeAction {
+			actions := make([]writeAction, len(batch))
+			for i, k := range batch {
+				actions[i] = writeAction{Put: &putAction{TableName: s.table, Item: entryItem(k, entries[k], first+uint64(i), exp)}}
+			}
+			return actions
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return rev, nil
+}
+
+// Delete removes key and returns the revision of the delete, or 0 if the
+// key did not exist.
+func (s *Store) Delete(ctx context.Context, key string) (uint64, error) {
+	cond := "attribute_exists(#pk) AND " + liveCondition
+	rev, _, err := s.commit(ctx, 1, func(uint64) []writeAction {
+		return []writeAction{{Delete: &deleteAction{
+			TableName:                 s.table,
+			Key:                       item{attrPK: str(dataKey(key))},
+			ConditionExpression:       cond,
+			ExpressionAttributeNames:  attrNames(cond),
+			ExpressionAttributeValues: item{":now": num(time.Now().UnixNano())},
+		}}}
+	})
+	return rev, err
+}
+
+// Update reads key, calls fn and writes the result on the condition that
+// the entry has not changed since it was read; otherwise it starts over.
+// A kept key keeps its time to live.
+func (s *Store) Update(ctx context.Context, key string, fn func(old string, exists bool) (value string, keep bool, err error)) (uint64, error) {
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		it, err := s.getItem(ctx, dataKey(key))
+		if err != nil {
+			return 0, err
+		}
+		exists := live(it, time.Now())
+		old := ""
+		if exists {
+			old = it.str(attrValue)
+		}
+
+		value, keep, err := fn(old, exists)
+		if err != nil {
+			return 0, err
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		switch {
+		case !keep && !exists:
+			return 0, nil
+		case keep && exists && value == old:
+			return uint64(it.num(attrRev)), nil
+		}
+
+		// An expired item still has to be the one that was read.
+		cond := "attribute_not_exists(#pk)"
+		var values item
+		if it != nil {
+			cond = "#rev = :seen"
+			values = item{":seen": num(it.num(attrRev))}
+		}
+		var exp time.Time
+		if exists {
+			exp = expiry(it)
+		}
+
+		rev, applied, err := s.commit(ctx, 1, func(first uint64) []writeAction {
+			if !keep {
+				return []writeAction{{Delete: &deleteAction{
+					TableName: s.table, Key: item{attrPK: str(dataKey(key))},
+					ConditionExpression: cond, ExpressionAttributeNames: attrNames(cond), ExpressionAttributeValues: values,
+				}}}
+			}
+			return []writeAction{{Put: &putAction{
+				TableName: s.table, Item: entryItem(key, value, first, exp),
+				ConditionExpression: cond, ExpressionAttributeNames: attrNames(cond), ExpressionAttributeValues: values,
+			}}}
+		})
+		if err != nil || applied {
+			return rev, err
+		}
+		if err := backoff(ctx, attempt+1); err != nil {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package dynamodb
+
+import (
+	"assignment2/internal/sigv4"
+	"assignment2/internal/storage"
+	"assignment2/internal/storage/storetest"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConformance runs the storetest suite against the DynamoDB endpoint
+// in DYNAMODB_TEST_ENDPOINT, such as DynamoDB Local on
+// http://localhost:8000, with a table of its own for every test. It is
+// skipped when that is not set.
+func TestConformance(t *testing.T) {
+	endpoint := os.Getenv("DYNAMODB_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("DYNAMODB_TEST_ENDPOINT is not set")
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	// DynamoDB Local accepts any credentials.
+	creds, err := sigv4.CredentialsFromEnv()
+	if err != nil {
+		creds = sigv4.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}
+	}
+	admin := &Store{region: region, endpoint: endpoint, creds: creds, client: &http.Client{Timeout: 10 * time.Second}}
+
+	// A test reopens its store with the same dir, which has to find the
+	// same table.
+	var mu sync.Mutex
+	tables := make(map[string]string)
+	t.Cleanup(func() {
+		for _, table := range tables {
+			if err := admin.call(context.Background(), "DeleteTable", map[string]string{"TableName": table}, nil); err != nil {
+				t.Logf("DeleteTable %s: %v", table, err)
+			}
+		}
+	})
+
+	storetest.Run(t, func(t *testing.T, dir string) storage.Store {
+		ctx := context.Background()
+		mu.Lock()
+		table, ok := tables[dir]
+		if !ok {
+			table = fmt.Sprintf("kvtest-%d-%d", time.Now().UnixNano(), len(tables))
+			tables[dir] = table
+		}
+		mu.Unlock()
+		if !ok {
+			if err := createTable(ctx, admin, table); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		s, err := Open(ctx, Options{Table: table, Region: region, Endpoint: endpoint, Credentials: creds})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+// createTable creates table with on-demand capacity and waits until it
+// is active, which DynamoDB Local is at once.
+func createTable(ctx context.Context, admin *Store, table string) error {
+	in := map[string]interface{}{
+		"TableName":            table,
+		"AttributeDefinitions": []map[string]string{{"AttributeName": attrPK, "AttributeType": "S"}},
+		"KeySchema":            []map[string]string{{"AttributeName": attrPK, "KeyType": "HASH"}},
+		"BillingMode":          "PAY_PER_REQUEST",
+	}
+	if err := admin.call(ctx, "CreateTable", in, nil); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(time.Minute); ; {
+		var out struct {
+			Table struct {
+				TableStatus string `json:"TableStatus"`
+			} `json:"Table"`
+		}
+		if err := admin.call(ctx, "DescribeTable", map[string]string{"TableName": table}, &out); err != nil {
+			return err
+		}
+		if out.Table.TableStatus == "ACTIVE" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("table %s is still %s", table, out.Table.TableStatus)
+		}
+		time.Sleep(time.Second)
+	}
+}
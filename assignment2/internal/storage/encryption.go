@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Encryption describes how a client encrypted a value before storing it.
+// The store never sees the key; it only keeps this with the value so that
+// readers know how to decrypt it.
+type Encryption struct {
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"algorithm"`
+}
+
+func (m *MemoryStore) encryptionLocked(key string) *Encryption {
+	enc, ok := m.enc[key]
+	if !ok {
+		return nil
+	}
+	return &enc
+}
+
+// Encrypted returns the encryption of every live value the client
+// encrypted, by key.
+func (m *MemoryStore) Encrypted(ctx context.Context) (map[string]Encryption, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	out := make(map[string]Encryption, len(m.enc))
+	for k, enc := range m.enc {
+		if !m.expiredLocked(k, now) {
+			out[k] = enc
+		}
+	}
+	return out, nil
+}
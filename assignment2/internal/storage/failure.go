@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// FailurePolicy is how a store with a write-ahead log handles writes
+// while the log can't be written (disk full, volume gone). Either way
+// the records are kept and written as soon as the log works again.
+type FailurePolicy string
+
+const (
+	// FailWrites refuses writes with ErrUnavailable until the log
+	// recovers, so nothing is acknowledged that isn't durable. Only the
+	// writes in flight when it failed are applied in memory; they are
+	// reported as failed but written once the log recovers.
+	FailWrites FailurePolicy = "fail"
+
+	// BufferWrites keeps acknowledging writes from memory, holding their
+	// records for the log, until MaxBuffered bytes are waiting; after
+	// that it refuses writes like FailWrites. Buffered writes are lost
+	// if the process stops before the log recovers.
+	BufferWrites FailurePolicy = "buffer"
+)
+
+// errReadOnly refuses writes while the log can't be written.
+var errReadOnly = fmt.Errorf("%w: writes are refused until it recovers", ErrUnavailable)
+
+// lockWrite is lock for operations that change the store. While the log
+// is failing, writes the policy refuses are turned away before anything
+// changes; readers are unaffected. With contention tracking, the wait
+// for the lock is attributed to the prefixes of keys.
+func (m *MemoryStore) lockWrite(ctx context.Context, keys ...string) error {
+	if err := m.writable(); err != nil {
+		return err
+	}
+	if m.contention == nil || len(keys) == 0 {
+		return m.lock(ctx)
+	}
+	start := time.Now()
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	m.contention.waited(keys, time.Since(start))
+	return nil
+}
+
+func (m *MemoryStore) writable() error {
+	if m.wal == nil || m.wal.Health().Err == nil {
+		return nil
+	}
+	if m.onLogFailure == BufferWrites && m.wal.Buffered() <= m.maxBuffered {
+		return nil
+	}
+	return errReadOnly
+}
+
+// LogHealth reports the state of the write-ahead log's writes; ok is
+// false without a log.
+func (m *MemoryStore) LogHealth() (health WALHealth, ok bool) {
+	if m.wal == nil {
+		return WALHealth{}, false
+	}
+	return m.wal.Health(), true
+}
+
+// Writable reports whether the store accepts writes, which it doesn't
+// while its log is failing unless the policy is BufferWrites and there is
+// room left to buffer.
+func (m *MemoryStore) Writable() bool {
+	return m.writable() == nil
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotPersisted is returned by VerifyLog and Compact for an in-memory
+// store.
+var ErrNotPersisted = errors.New("store has no write-ahead log")
+
+// integritySample is how many keys of each kind of discrepancy a report
+// lists.
+const integritySample = 20
+
+// IntegrityReport compares the store with the state its write-ahead log
+// replays to. Digests are SHA-256 over every key, value and tag set in
+// key order, so two stores with equal digests hold the same data.
+type IntegrityReport struct {
+	Revision     uint64 `json:"revision"`
+	LogRevision  uint64 `json:"log_revision"`
+	Keys         int    `json:"keys"`
+	LogKeys      int    `json:"log_keys"`
+	MemoryDigest string `json:"memory_digest"`
+	LogDigest    string `json:"log_digest"`
+
+	// Discrepancies counts keys missing on either side or with
+	// different values or tags.
+	Discrepancies   int      `json:"discrepancies"`
+	MissingInLog    []string `json:"missing_in_log,omitempty"`
+	MissingInMemory []string `json:"missing_in_memory,omitempty"`
+	Mismatched      []string `json:"mismatched,omitempty"`
+}
+
+// OK reports whether memory and log agree.
+func (r IntegrityReport) OK() bool {
+	return r.Discrepancies == 0 && r.Revision == r.LogRevision
+}
+
+// VerifyLog reads the write-ahead log back from disk and compares what it
+// replays to with the store as of the call. The store is locked only to
+// copy its contents; writes made meanwhile are not part of the check.
+func (m *MemoryStore) VerifyLog(ctx context.Context) (IntegrityReport, error) {
+	defer track(ctx, time.Now())
+
+	if m.wal == nil {
+		return IntegrityReport{}, ErrNotPersisted
+	}
+
+	m.compactMu.Lock()
+	defer m.compactMu.Unlock()
+
+	if err := m.lock(ctx); err != nil {
+		return IntegrityReport{}, err
+	}
+	memory := make(map[string]string, len(m.data))
+	n := 0
+	for k := range m.data {
+		if n++; n%checkEvery == 0 && ctx.Err() != nil {
+			m.mu.Unlock()
+			return IntegrityReport{}, ctx.Err()
+		}
+		memory[k] = m.valueLocked(k) + "\x00" + m.tagString(k)
+	}
+	rev := m.rev
+	offset, wait := m.wal.Barrier()
+	m.mu.Unlock()
+
+	if err := wait(); err != nil {
+		return IntegrityReport{}, err
+	}
+
+	logged, logRev, err := replayState(ctx, m.wal.path, offset)
+	if err != nil {
+		return IntegrityReport{}, err
+	}
+
+	report := IntegrityReport{
+		Revision:     rev,
+		LogRevision:  logRev,
+		Keys:         len(memory),
+		LogKeys:      len(logged),
+		MemoryDigest: digest(memory),
+		LogDigest:    digest(logged),
+	}
+	for _, k := range sortedKeys(memory) {
+		other, ok := logged[k]
+		switch {
+		case !ok:
+			report.MissingInLog = appendSample(report.MissingInLog, k)
+		case other != memory[k]:
+			report.Mismatched = appendSample(report.Mismatched, k)
+		default:
+			continue
+		}
+		report.Discrepancies++
+	}
+	for _, k := range sortedKeys(logged) {
+		if _, ok := memory[k]; !ok {
+			report.MissingInMemory = appendSample(report.MissingInMemory, k)
+			report.Discrepancies++
+		}
+	}
+	return report, nil
+}
+
+// replayState replays the first size bytes of the log at path into a
+// scratch store and returns its contents in the form VerifyLog compares.
+func replayState(ctx context.Context, path string, size int64) (map[string]string, uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	scratch := NewMemoryStore()
+	n := 0
+	_, err = replay(io.LimitReader(f, size), func(rec Record) error {
+		if n++; n%checkEvery == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if rec.Rev == 0 {
+			rec.Rev = scratch.rev + 1
+		}
+		return scratch.applyLocked(rec)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	state := make(map[string]string, len(scratch.data))
+	for k, v := range scratch.data {
+		state[k] = v + "\x00" + scratch.tagString(k)
+	}
+	return state, scratch.rev, nil
+}
+
+// tagString returns the tags of key sorted and joined. m.mu must be held.
+func (m *MemoryStore) tagString(key string) string {
+	if len(m.tags[key]) == 0 {
+		return ""
+	}
+	tags := make([]string, 0, len(m.tags[key]))
+	for tag := range m.tags[key] {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return strings.Join(tags, "\x00")
+}
+
+func digest(state map[string]string) string {
+	h := sha256.New()
+	for _, k := range sortedKeys(state) {
+		io.WriteString(h, k)
+		h.Write([]byte{0})
+		io.WriteString(h, state[k])
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func appendSample(sample []string, key string) []string {
+	if len(sample) < integritySample {
+		sample = append(sample, key)
+	}
+	return sample
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"assignment2/internal/limit"
+	"context"
+	"io"
+	"time"
+)
+
+// compactChunk is the size of the writes a throttled compaction makes,
+// and so the granularity of its waits.
+const compactChunk = 64 << 10
+
+type progressKey struct{}
+
+// WithProgress returns a context whose long-running store operations
+// call fn now and then while they wait, e.g. a throttled compaction, so
+// that a caller can tell a slow operation from a stuck one.
+func WithProgress(ctx context.Context, fn func()) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+func progress(ctx context.Context) {
+	if fn, ok := ctx.Value(progressKey{}).(func()); ok {
+		fn()
+	}
+}
+
+// throttledWriter writes to w no faster than rate allows, waiting out
+// the debt of each write before making it, and adds up how long it
+// waited. It gives up when ctx is done.
+type throttledWriter struct {
+	ctx    context.Context
+	w      io.Writer
+	rate   *limit.Rate
+	waited time.Duration
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	if d := t.rate.Take(float64(len(p))); d > 0 {
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-t.ctx.Done():
+			timer.Stop()
+			return 0, t.ctx.Err()
+		}
+		t.waited += d
+		progress(t.ctx)
+	}
+	return t.w.Write(p)
+}
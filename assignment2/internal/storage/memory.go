@@ -1,44 +1,627 @@
 package storage
 
-import "sync"
+import (
+	"assignment2/internal/limit"
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
 
 type MemoryStore struct {
 	mu   sync.Mutex
 	data map[string]string
+
+	// wal and dirLock are nil for a purely in-memory store.
+	wal          *WAL
+	dirLock      *dirLock
+	onLogFailure FailurePolicy
+	maxBuffered  int64
+
+	// dedup is nil unless Options.Dedup is set.
+	dedup *dedupTable
+
+	// tier is nil unless Options.TierBudget is set.
+	tier *tier
+
+	// contention is nil unless Options.ContentionDepth is set.
+	contention *contentionTracker
+
+	// observer sees every mutation in apply order; onReplay sees those
+	// replayed from the write-ahead log while Open runs.
+	observer func(Record)
+	onReplay func(Record)
+
+	// ship is nil unless ShipTo was called.
+	ship *ShipLog
+
+	// rev is the revision of the latest mutation; meta holds the
+	// revision and time at which each key was last changed.
+	rev  uint64
+	meta map[string]keyMeta
+
+	// byKey and byRev order the keys in meta by name and by revision,
+	// see ScanOrdered.
+	byKey *skipList[string]
+	byRev *skipList[revKey]
+
+	// tags holds the tags of each key; tagIndex is the reverse mapping
+	// used to answer tag queries without scanning every key.
+	tags     map[string]map[string]struct{}
+	tagIndex map[string]map[string]struct{}
+
+	// expiry holds the deadline of each key with a time to live.
+	expiry map[string]time.Time
+
+	// enc holds the encryption of each value the client encrypted.
+	enc map[string]Encryption
+
+	// compactMu keeps compaction and log verification apart, since the
+	// latter reads the log file by offset. compaction is guarded by mu.
+	compactMu  sync.Mutex
+	compaction CompactionStats
+
+	// compactRate throttles the snapshot writes of compactions; nil
+	// without Options.CompactBytesPerSec.
+	compactRate *limit.Rate
 }
 
 func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{
-		data: make(map[string]string),
+		data:     make(map[string]string),
+		meta:     make(map[string]keyMeta),
+		byKey:    newKeyIndex(),
+		byRev:    newRevIndex(),
+		tags:     make(map[string]map[string]struct{}),
+		tagIndex: make(map[string]map[string]struct{}),
+		expiry:   make(map[string]time.Time),
+		enc:      make(map[string]Encryption),
+	}
+}
+
+type Options struct {
+	SyncWindow time.Duration
+	NoSync     bool
+
+	// Dedup stores identical values once, addressed by their SHA-256.
+	Dedup bool
+
+	// TierBudget, if positive, is how many bytes of keys and values are
+	// kept in memory; the least recently accessed values beyond it are
+	// kept on disk (see EvictCold). It needs a data directory.
+	TierBudget int64
+
+	// OnLogFailure is what writes get while the write-ahead log can't be
+	// written (FailWrites by default); with BufferWrites, up to
+	// MaxBuffered bytes of records are held for it.
+	OnLogFailure FailurePolicy
+	MaxBuffered  int64
+
+	// OnLogHealth is called when log writes start failing and when they
+	// recover.
+	OnLogHealth func(WALHealth)
+
+	// ContentionDepth enables contention tracking by key prefixes of
+	// that many segments (see KeyPrefix); 0 disables it.
+	ContentionDepth int
+
+	// CompactBytesPerSec, if positive, limits how fast Compact writes
+	// the snapshot, so that it leaves disk bandwidth to the log.
+	CompactBytesPerSec int64
+
+	// OnReplay is called for each change replayed from the write-ahead
+	// log on Open, in order, with its revision set: the mutations and
+	// resets, not the records of a snapshot that follow a reset.
+	OnReplay func(Record)
+}
+
+// Open loads the store persisted in dir, creating it if needed. Every
+// mutation is written to the write-ahead log before it is acknowledged.
+// With an empty dir the store is kept in memory only.
+func Open(dir string, opts Options) (*MemoryStore, error) {
+	m := NewMemoryStore()
+	if opts.Dedup {
+		m.dedup = newDedupTable()
+	}
+	if opts.ContentionDepth > 0 {
+		m.contention = newContentionTracker(opts.ContentionDepth)
+	}
+	if dir == "" {
+		if opts.TierBudget > 0 {
+			return nil, errTierNeedsDir
+		}
+		return m, nil
 	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.TierBudget > 0 {
+		if m.tier, err = openTier(dir, opts.TierBudget); err != nil {
+			lock.release()
+			return nil, err
+		}
+	}
+	m.onReplay = opts.OnReplay
+	wal, err := OpenWAL(filepath.Join(dir, "wal.log"), opts.SyncWindow, opts.NoSync, m.apply)
+	m.onReplay = nil
+	if err != nil {
+		if m.tier != nil {
+			m.tier.close()
+		}
+		lock.release()
+		return nil, err
+	}
+	m.wal = wal
+	m.dirLock = lock
+	if opts.CompactBytesPerSec > 0 {
+		m.compactRate = limit.NewRate(float64(opts.CompactBytesPerSec), float64(opts.CompactBytesPerSec))
+	}
+	m.onLogFailure = opts.OnLogFailure
+	m.maxBuffered = opts.MaxBuffered
+	if opts.OnLogHealth != nil {
+		wal.SetHealthObserver(opts.OnLogHealth)
+	}
+	return m, nil
 }
 
-func (m *MemoryStore) Set(key, value string) {
+// Close flushes and closes the write-ahead log, if any, and then lets
+// other processes open the data directory.
+func (m *MemoryStore) Close() error {
+	if m.wal == nil {
+		return nil
+	}
+	err := m.wal.Close()
+	if m.tier != nil {
+		if cerr := m.tier.close(); err == nil {
+			err = cerr
+		}
+	}
+	if m.dirLock != nil {
+		if cerr := m.dirLock.release(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// SetObserver registers fn to be called, under the store lock, for every
+// mutation after it is applied. fn must not block or call back into the
+// store.
+func (m *MemoryStore) SetObserver(fn func(Record)) {
+	m.mu.Lock()
+	m.observer = fn
+	m.mu.Unlock()
+}
+
+// Revision returns the revision of the latest mutation. Every set,
+// delete and tag change gets the next number; with a data directory the
+// numbering survives restarts.
+func (m *MemoryStore) Revision() uint64 {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.data[key] = value
+	return m.rev
 }
 
-func (m *MemoryStore) GetAll() map[string]string {
+// checkEvery is how many entries a long scan visits between checks for
+// cancellation.
+const checkEvery = 1024
+
+// lock acquires m.mu unless ctx is done, either beforehand or by the time
+// the lock is acquired. Operations that take a context check it again
+// before they change anything, so a cancelled one has no effect; once a
+// change is applied it is logged and waited for regardless.
+func (m *MemoryStore) lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	m.mu.Lock()
+	if err := ctx.Err(); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Set stores value and returns the revision of the change.
+func (m *MemoryStore) Set(ctx context.Context, key, value string) (uint64, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lockWrite(ctx, key); err != nil {
+		return 0, err
+	}
+	m.putLocked(key, value)
+	delete(m.expiry, key)
+	rev, wait := m.logLocked(Record{Op: OpSet, Key: key, Value: value})
+	m.mu.Unlock()
+
+	return rev, wait()
+}
+
+// SetMany stores all entries as one write-ahead log batch and returns the
+// revision of the last one. Keys it overwrites lose their time to live.
+func (m *MemoryStore) SetMany(ctx context.Context, entries map[string]string) (uint64, error) {
+	return m.SetManyTTL(ctx, entries, 0)
+}
+
+// SetManyTTL is SetMany for keys that expire after ttl (never if ttl is
+// not positive).
+func (m *MemoryStore) SetManyTTL(ctx context.Context, entries map[string]string, ttl time.Duration) (uint64, error) {
+	return m.setMany(ctx, entries, ttl, nil)
+}
+
+// SetManyEncrypted is SetManyTTL for values the client encrypted as enc
+// says. The store keeps enc with each value until it is next changed.
+func (m *MemoryStore) SetManyEncrypted(ctx context.Context, entries map[string]string, ttl time.Duration, enc Encryption) (uint64, error) {
+	return m.setMany(ctx, entries, ttl, &enc)
+}
+
+func (m *MemoryStore) setMany(ctx context.Context, entries map[string]string, ttl time.Duration, enc *Encryption) (uint64, error) {
+	defer track(ctx, time.Now())
+
+	recs := make([]Record, 0, len(entries))
+
+	if err := m.lockWrite(ctx, m.contentionKeys(entries)...); err != nil {
+		return 0, err
+	}
+	var deadline time.Time
+	var expires int64
+	if ttl > 0 {
+		deadline = time.Now().Add(ttl)
+		expires = wallClock(deadline).UnixNano()
+	}
+	for k, v := range entries {
+		m.putLocked(k, v)
+		m.setExpiryLocked(k, deadline)
+		if enc != nil {
+			m.enc[k] = *enc
+		}
+		recs = append(recs, Record{Op: OpSet, Key: k, Value: v, Expires: expires, TTL: int64(max(ttl, 0)), Enc: enc})
+	}
+	rev, wait := m.logLocked(recs...)
+	m.mu.Unlock()
+
+	return rev, wait()
+}
+
+func (m *MemoryStore) Get(ctx context.Context, key string) (string, bool, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lock(ctx); err != nil {
+		return "", false, err
+	}
+	defer m.mu.Unlock()
+	value, ok := m.liveLocked(key)
+	return value, ok, nil
+}
+
+// GetRevision is Get that also returns the revision at which key was last
+// changed.
+func (m *MemoryStore) GetRevision(ctx context.Context, key string) (string, uint64, bool, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lock(ctx); err != nil {
+		return "", 0, false, err
+	}
+	defer m.mu.Unlock()
+	value, ok := m.liveLocked(key)
+	return value, m.meta[key].rev, ok, nil
+}
+
+// Entry is a key's value with what the store knows about it.
+type Entry struct {
+	Value    string
+	Revision uint64
+
+	// Modified is when the key was last changed.
+	Modified time.Time
+
+	// Encryption is set for values the client encrypted itself.
+	Encryption *Encryption
+
+	// ExpiresAt is when the key expires, on the current wall clock; zero
+	// without a time to live.
+	ExpiresAt time.Time
+}
+
+// GetEntry returns key's value, revision, last change and expiry.
+func (m *MemoryStore) GetEntry(ctx context.Context, key string) (Entry, bool, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lock(ctx); err != nil {
+		return Entry{}, false, err
+	}
+	defer m.mu.Unlock()
+	value, ok := m.liveLocked(key)
+	if !ok {
+		return Entry{}, false, nil
+	}
+	meta := m.meta[key]
+	e := Entry{Value: value, Revision: meta.rev, Modified: meta.modified, Encryption: m.encryptionLocked(key)}
+	if d, ok := m.expiry[key]; ok {
+		e.ExpiresAt = wallClock(d)
+	}
+	return e, true, nil
+}
+
+// KeyInfo is what GetMeta reports about a key.
+type KeyInfo struct {
+	Entry
+	Created time.Time
+	Tags    []string
+}
+
+// GetMeta returns key's entry together with when it was created and last
+// changed and its tags, sorted.
+func (m *MemoryStore) GetMeta(ctx context.Context, key string) (KeyInfo, bool, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lock(ctx); err != nil {
+		return KeyInfo{}, false, err
+	}
+	defer m.mu.Unlock()
+	value, ok := m.liveLocked(key)
+	if !ok {
+		return KeyInfo{}, false, nil
+	}
+	meta := m.meta[key]
+	info := KeyInfo{
+		Entry:   Entry{Value: value, Revision: meta.rev, Modified: meta.modified, Encryption: m.encryptionLocked(key)},
+		Created: meta.created,
+		Tags:    make([]string, 0, len(m.tags[key])),
+	}
+	if d, ok := m.expiry[key]; ok {
+		info.ExpiresAt = wallClock(d)
+	}
+	for tag := range m.tags[key] {
+		info.Tags = append(info.Tags, tag)
+	}
+	sort.Strings(info.Tags)
+	return info, true, nil
+}
+
+// GetSince returns the entries changed at or after revision minRev,
+// together with the current revision.
+func (m *MemoryStore) GetSince(ctx context.Context, minRev uint64) (map[string]string, uint64, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lock(ctx); err != nil {
+		return nil, 0, err
+	}
+	defer m.mu.Unlock()
+
+	out := make(map[string]string)
+	now := time.Now()
+	n := 0
+	for k, meta := range m.meta {
+		if n++; n%checkEvery == 0 && ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+		if meta.rev >= minRev && !m.expiredLocked(k, now) {
+			out[k] = m.valueLocked(k)
+		}
+	}
+	return out, m.rev, nil
+}
+
+func (m *MemoryStore) GetAll(ctx context.Context) (map[string]string, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
 	defer m.mu.Unlock()
 
 	copy := make(map[string]string)
-	for k, v := range m.data {
-		copy[k] = v
+	now := time.Now()
+	n := 0
+	for k := range m.data {
+		if n++; n%checkEvery == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !m.expiredLocked(k, now) {
+			copy[k] = m.valueLocked(k)
+		}
+	}
+	return copy, nil
+}
+
+// Delete removes key and returns the revision of the delete, or 0 if the
+// key did not exist.
+func (m *MemoryStore) Delete(ctx context.Context, key string) (uint64, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lockWrite(ctx, key); err != nil {
+		return 0, err
+	}
+	if _, ok := m.data[key]; !ok {
+		m.mu.Unlock()
+		return 0, nil
+	}
+	m.remove(key)
+	rev, wait := m.logLocked(Record{Op: OpDelete, Key: key})
+	m.mu.Unlock()
+
+	return rev, wait()
+}
+
+// ModifiedBefore returns up to limit keys with the given prefix that
+// were last changed before t.
+func (m *MemoryStore) ModifiedBefore(ctx context.Context, prefix string, t time.Time, limit int) ([]string, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lock(ctx); err != nil {
+		return nil, err
 	}
-	return copy
+	defer m.mu.Unlock()
+
+	var keys []string
+	n := 0
+	for k, meta := range m.meta {
+		if n++; n%checkEvery == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if strings.HasPrefix(k, prefix) && meta.modified.Before(t) {
+			keys = append(keys, k)
+			if len(keys) == limit {
+				break
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
 }
 
-func (m *MemoryStore) Delete(key string) bool {
+// LeastRecentlyModified returns up to limit keys, those changed longest
+// ago first. Revisions follow the order of changes, so these are the keys
+// with the lowest ones.
+func (m *MemoryStore) LeastRecentlyModified(ctx context.Context, limit int) ([]string, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	// oldest is a max-heap by revision of the oldest keys seen so far.
+	oldest := &revHeap{meta: m.meta}
+	n := 0
+	for k, meta := range m.meta {
+		if n++; n%checkEvery == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		switch {
+		case oldest.Len() < limit:
+			heap.Push(oldest, k)
+		case limit > 0 && meta.rev < m.meta[oldest.keys[0]].rev:
+			oldest.keys[0] = k
+			heap.Fix(oldest, 0)
+		}
+	}
+	keys := make([]string, oldest.Len())
+	for i := len(keys) - 1; i >= 0; i-- {
+		keys[i] = heap.Pop(oldest).(string)
+	}
+	return keys, nil
+}
+
+type revHeap struct {
+	meta map[string]keyMeta
+	keys []string
+}
+
+func (h *revHeap) Len() int           { return len(h.keys) }
+func (h *revHeap) Less(i, j int) bool { return h.meta[h.keys[i]].rev > h.meta[h.keys[j]].rev }
+func (h *revHeap) Swap(i, j int)      { h.keys[i], h.keys[j] = h.keys[j], h.keys[i] }
+func (h *revHeap) Push(x interface{}) { h.keys = append(h.keys, x.(string)) }
+func (h *revHeap) Pop() interface{} {
+	k := h.keys[len(h.keys)-1]
+	h.keys = h.keys[:len(h.keys)-1]
+	return k
+}
+
+// DeleteModifiedBefore deletes, as one batch, those of keys that still
+// were last changed before t, and returns how many it deleted. Keys
+// written in the meantime are kept.
+func (m *MemoryStore) DeleteModifiedBefore(ctx context.Context, keys []string, t time.Time) (int, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lockWrite(ctx); err != nil {
+		return 0, err
+	}
+
+	var recs []Record
+	for _, k := range keys {
+		meta, ok := m.meta[k]
+		if !ok || !meta.modified.Before(t) {
+			continue
+		}
+		m.remove(k)
+		recs = append(recs, Record{Op: OpDelete, Key: k})
+	}
+	if len(recs) == 0 {
+		m.mu.Unlock()
+		return 0, nil
+	}
+	_, wait := m.logLocked(recs...)
+	m.mu.Unlock()
+
+	return len(recs), wait()
+}
+
+// CountPrefix returns the number of live keys starting with prefix.
+func (m *MemoryStore) CountPrefix(ctx context.Context, prefix string) (int, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	count := 0
+	now := time.Now()
+	n := 0
+	for k := range m.data {
+		if n++; n%checkEvery == 0 && ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		if strings.HasPrefix(k, prefix) && !m.expiredLocked(k, now) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// DeletePrefix deletes every key starting with prefix as one batch and
+// returns how many it deleted and the revision of the last delete (the
+// current revision if there were none).
+func (m *MemoryStore) DeletePrefix(ctx context.Context, prefix string) (int, uint64, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lockWrite(ctx); err != nil {
+		return 0, 0, err
+	}
+
+	var recs []Record
+	for k := range m.data {
+		if strings.HasPrefix(k, prefix) {
+			recs = append(recs, Record{Op: OpDelete, Key: k})
+		}
+	}
+	if len(recs) == 0 {
+		rev := m.rev
+		m.mu.Unlock()
+		return 0, rev, nil
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Key < recs[j].Key })
+	for _, rec := range recs {
+		m.remove(rec.Key)
+	}
+	rev, wait := m.logLocked(recs...)
+	m.mu.Unlock()
+
+	return len(recs), rev, wait()
+}
+
+// DedupStats reports deduplication savings; ok is false when it is off.
+func (m *MemoryStore) DedupStats() (stats DedupStats, ok bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if _, ok := m.data[key]; !ok {
-		return false
+	if m.dedup == nil {
+		return DedupStats{}, false
 	}
-	delete(m.data, key)
-	return true
+	return m.dedup.stats(), true
 }
 
 func (m *MemoryStore) Size() int {
@@ -46,3 +629,472 @@ func (m *MemoryStore) Size() int {
 	defer m.mu.Unlock()
 	return len(m.data)
 }
+
+// Update runs fn with the current value of key while holding the lock, so
+// read-modify-write sequences are atomic. fn returns the new value and
+// whether the key should be kept; returning keep=false deletes it.
+//
+// An expired key counts as absent. A key that is kept keeps its time to
+// live.
+//
+// The returned revision is that of the change, or the key's current
+// revision (0 if absent) when fn left it as it was. If ctx is done by the
+// time fn returns, nothing is changed.
+func (m *MemoryStore) Update(ctx context.Context, key string, fn func(old string, exists bool) (value string, keep bool, err error)) (uint64, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lockWrite(ctx, key); err != nil {
+		return 0, err
+	}
+
+	old, exists := m.liveLocked(key)
+	value, keep, err := fn(old, exists)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		m.mu.Unlock()
+		return 0, err
+	}
+
+	rev, wait := m.meta[key].rev, noWait
+	switch {
+	case keep && (!exists || value != old):
+		rec := Record{Op: OpSet, Key: key, Value: value}
+		if d, ok := m.expiry[key]; ok && exists {
+			rec.Expires = wallClock(d).UnixNano()
+			rec.TTL = int64(m.meta[key].ttl)
+		} else {
+			delete(m.expiry, key)
+		}
+		m.putLocked(key, value)
+		rev, wait = m.logLocked(rec)
+	case !keep && exists:
+		m.remove(key)
+		rev, wait = m.logLocked(Record{Op: OpDelete, Key: key})
+	}
+	m.mu.Unlock()
+
+	return rev, wait()
+}
+
+// SetTags replaces the tags of an existing key and returns the revision
+// of the change, or 0 if the key does not exist.
+func (m *MemoryStore) SetTags(ctx context.Context, key string, tags []string) (uint64, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lockWrite(ctx, key); err != nil {
+		return 0, err
+	}
+	if _, ok := m.liveLocked(key); !ok {
+		m.mu.Unlock()
+		return 0, nil
+	}
+	m.setTagsLocked(key, tags)
+	rev, wait := m.logLocked(Record{Op: OpTags, Key: key, Tags: tags})
+	m.mu.Unlock()
+
+	return rev, wait()
+}
+
+func (m *MemoryStore) setTagsLocked(key string, tags []string) {
+	m.untag(key)
+	if len(tags) == 0 {
+		return
+	}
+
+	set := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		set[tag] = struct{}{}
+		if m.tagIndex[tag] == nil {
+			m.tagIndex[tag] = make(map[string]struct{})
+		}
+		m.tagIndex[tag][key] = struct{}{}
+	}
+	m.tags[key] = set
+}
+
+// Tags returns the sorted tags of key.
+func (m *MemoryStore) Tags(ctx context.Context, key string) ([]string, bool, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lock(ctx); err != nil {
+		return nil, false, err
+	}
+	defer m.mu.Unlock()
+
+	if _, ok := m.liveLocked(key); !ok {
+		return nil, false, nil
+	}
+
+	tags := make([]string, 0, len(m.tags[key]))
+	for tag := range m.tags[key] {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags, true, nil
+}
+
+// GetTagged returns the entries that carry every one of the given tags.
+func (m *MemoryStore) GetTagged(ctx context.Context, tags []string) (map[string]string, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	out := make(map[string]string)
+	if len(tags) == 0 {
+		return out, nil
+	}
+
+	// Walk the smallest posting list and check the others against it.
+	smallest := m.tagIndex[tags[0]]
+	for _, tag := range tags[1:] {
+		if len(m.tagIndex[tag]) < len(smallest) {
+			smallest = m.tagIndex[tag]
+		}
+	}
+
+	now := time.Now()
+	n := 0
+	for key := range smallest {
+		if n++; n%checkEvery == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		matches := !m.expiredLocked(key, now)
+		for _, tag := range tags {
+			if _, ok := m.tagIndex[tag][key]; !ok {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			out[key] = m.valueLocked(key)
+		}
+	}
+	return out, nil
+}
+
+// Snapshot returns the current contents as records that Restore accepts:
+// a set for every key, followed by its tags if it has any, each with the
+// revision of the key's last change. The store revision is returned too.
+func (m *MemoryStore) Snapshot(ctx context.Context) ([]Record, uint64, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lock(ctx); err != nil {
+		return nil, 0, err
+	}
+	defer m.mu.Unlock()
+	return m.snapshotLocked(ctx)
+}
+
+func (m *MemoryStore) snapshotLocked(ctx context.Context) ([]Record, uint64, error) {
+	recs := make([]Record, 0, len(m.data)+len(m.tags))
+	now := time.Now()
+	n := 0
+	for k := range m.data {
+		if n++; n%checkEvery == 0 && ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+		if m.expiredLocked(k, now) {
+			continue
+		}
+		meta := m.meta[k]
+		ts := meta.modified.UnixNano()
+		rec := Record{Rev: meta.rev, TS: ts, Created: meta.created.UnixNano(), Op: OpSet, Key: k, Value: m.valueLocked(k)}
+		if d, ok := m.expiry[k]; ok {
+			rec.Expires = wallClock(d).UnixNano()
+			rec.TTL = int64(meta.ttl)
+		}
+		rec.Enc = m.encryptionLocked(k)
+		recs = append(recs, rec)
+		if len(m.tags[k]) > 0 {
+			tags := make([]string, 0, len(m.tags[k]))
+			for tag := range m.tags[k] {
+				tags = append(tags, tag)
+			}
+			sort.Strings(tags)
+			recs = append(recs, Record{Rev: meta.rev, TS: ts, Op: OpTags, Key: k, Tags: tags})
+		}
+	}
+	return recs, m.rev, nil
+}
+
+// Restore replaces the contents with a snapshot taken at revision rev.
+// The observer sees a single reset record.
+func (m *MemoryStore) Restore(ctx context.Context, recs []Record, rev uint64) error {
+	_, err := m.restore(ctx, recs, rev)
+	return err
+}
+
+// Reset is Restore at the revision after the current one, so that the
+// revision keeps moving forward, e.g. to go back to an earlier Snapshot.
+// It returns the revision of the reset.
+func (m *MemoryStore) Reset(ctx context.Context, recs []Record) (uint64, error) {
+	return m.restore(ctx, recs, 0)
+}
+
+func (m *MemoryStore) restore(ctx context.Context, recs []Record, rev uint64) (uint64, error) {
+	defer track(ctx, time.Now())
+
+	for _, rec := range recs {
+		if rec.Op != OpSet && rec.Op != OpTags {
+			return 0, fmt.Errorf("unexpected snapshot record op %q", rec.Op)
+		}
+	}
+
+	if err := m.lockWrite(ctx); err != nil {
+		return 0, err
+	}
+	if rev == 0 {
+		rev = m.rev + 1
+	}
+	reset := Record{Rev: rev, Op: OpReset}
+	m.applyLocked(reset)
+	for _, rec := range recs {
+		m.applyLocked(rec)
+	}
+	m.rev = rev
+
+	if m.observer != nil {
+		m.observer(reset)
+	}
+	if m.ship != nil {
+		// A standby starts over from a snapshot when it gets here.
+		m.ship.add([]Record{reset})
+	}
+	wait := noWait
+	if m.wal != nil {
+		wait = m.wal.Enqueue(append([]Record{reset}, recs...)...)
+	}
+	m.mu.Unlock()
+
+	return rev, wait()
+}
+
+// ApplyReplicated applies a mutation made, and numbered, elsewhere.
+// Records at or below the current revision have been seen already and are
+// ignored.
+func (m *MemoryStore) ApplyReplicated(ctx context.Context, rec Record) error {
+	defer track(ctx, time.Now())
+
+	if err := m.lockWrite(ctx); err != nil {
+		return err
+	}
+	if rec.Rev <= m.rev {
+		m.mu.Unlock()
+		return nil
+	}
+	if err := m.applyLocked(rec); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	wait := m.appendLocked(rec)
+	m.mu.Unlock()
+
+	return wait()
+}
+
+// apply replays a logged mutation.
+func (m *MemoryStore) apply(rec Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rec.Rev == 0 {
+		rec.Rev = m.rev + 1
+	}
+	change := rec.Op == OpReset || rec.Rev > m.rev
+	if err := m.applyLocked(rec); err != nil {
+		return err
+	}
+	if m.tier != nil {
+		// Replay keeps to the budget as it goes, so that a store larger
+		// than memory can be opened at all.
+		if _, err := m.evictLocked(); err != nil {
+			return err
+		}
+	}
+	if change && m.onReplay != nil {
+		m.onReplay(rec)
+	}
+	return nil
+}
+
+// applyLocked applies rec, which already carries its revision. Records
+// of a restored snapshot keep the older revisions of their keys, so the
+// store revision only ever moves forward, except on reset.
+func (m *MemoryStore) applyLocked(rec Record) error {
+	if rec.Op == OpReset {
+		m.data = make(map[string]string)
+		m.meta = make(map[string]keyMeta)
+		m.byKey, m.byRev = newKeyIndex(), newRevIndex()
+		if m.dedup != nil {
+			m.dedup = newDedupTable()
+		}
+		m.tags = make(map[string]map[string]struct{})
+		m.tagIndex = make(map[string]map[string]struct{})
+		m.expiry = make(map[string]time.Time)
+		m.enc = make(map[string]Encryption)
+		m.tier.reset()
+		m.rev = rec.Rev
+		return nil
+	}
+	if rec.Rev > m.rev {
+		m.rev = rec.Rev
+	}
+	defer m.trackLocked(rec)
+
+	switch rec.Op {
+	case OpSet:
+		m.putLocked(rec.Key, rec.Value)
+		m.setExpiryLocked(rec.Key, deadlineFromWall(rec.Expires))
+		if rec.Enc != nil {
+			m.enc[rec.Key] = *rec.Enc
+		}
+	case OpDelete:
+		m.remove(rec.Key)
+	case OpTags:
+		if _, ok := m.data[rec.Key]; ok {
+			m.setTagsLocked(rec.Key, rec.Tags)
+		}
+	case OpExpire:
+		if _, ok := m.data[rec.Key]; ok {
+			m.setExpiryLocked(rec.Key, deadlineFromWall(rec.Expires))
+		}
+	default:
+		return fmt.Errorf("unknown log record op %q", rec.Op)
+	}
+	return nil
+}
+
+// keyMeta is what the store knows about a key's last change, and since
+// when it has existed.
+type keyMeta struct {
+	rev      uint64
+	modified time.Time
+	created  time.Time
+
+	// ttl is the time to live the key's deadline was set from, if the
+	// log says.
+	ttl time.Duration
+}
+
+// trackLocked records the revision and time of rec, which has been
+// applied, for its key. Records logged before times were kept count as
+// changed now. A key is created by the first change after which it
+// exists; snapshot records carry the original time.
+func (m *MemoryStore) trackLocked(rec Record) {
+	old, had := m.meta[rec.Key]
+	if _, ok := m.data[rec.Key]; !ok {
+		delete(m.meta, rec.Key)
+		m.reindexLocked(rec.Key, old, had, 0, false)
+		return
+	}
+	modified := time.Now()
+	if rec.TS != 0 {
+		modified = time.Unix(0, rec.TS)
+	}
+	created := modified
+	if had {
+		created = old.created
+	} else if rec.Created != 0 {
+		created = time.Unix(0, rec.Created)
+	}
+	// Sets and expires set the deadline; other changes keep it.
+	ttl := old.ttl
+	if rec.Op == OpSet || rec.Op == OpExpire {
+		ttl = time.Duration(rec.TTL)
+	}
+	m.meta[rec.Key] = keyMeta{rev: rec.Rev, modified: modified, created: created, ttl: ttl}
+	m.reindexLocked(rec.Key, old, had, rec.Rev, true)
+}
+
+func noWait() error { return nil }
+
+// logLocked numbers recs with the next revisions and queues them in the
+// write-ahead log. It returns the last revision and a function that waits
+// for the records to be durable. It is called with m.mu held so the log
+// order matches the order changes were applied; the wait happens after
+// unlocking so concurrent writers share one fsync.
+func (m *MemoryStore) logLocked(recs ...Record) (uint64, func() error) {
+	now := time.Now().UnixNano()
+	for i := range recs {
+		m.rev++
+		recs[i].Rev = m.rev
+		recs[i].TS = now
+		m.trackLocked(recs[i])
+	}
+	return m.rev, m.appendLocked(recs...)
+}
+
+// appendLocked notifies the observer of recs and queues them in the
+// write-ahead log and the shipping log.
+func (m *MemoryStore) appendLocked(recs ...Record) func() error {
+	if m.observer != nil {
+		for _, rec := range recs {
+			m.observer(rec)
+		}
+	}
+	if m.ship != nil {
+		m.ship.add(recs)
+	}
+	if m.wal == nil {
+		return noWait
+	}
+	wait := m.wal.Enqueue(recs...)
+	if m.onLogFailure != BufferWrites {
+		return wait
+	}
+	return func() error {
+		err := wait()
+		if errors.Is(err, ErrUnavailable) && m.wal.Buffered() <= m.maxBuffered {
+			return nil
+		}
+		return err
+	}
+}
+
+// putLocked stores value for key, sharing it with identical values when
+// deduplication is on. The encryption of the old value no longer applies.
+func (m *MemoryStore) putLocked(key, value string) {
+	m.releaseLocked(key)
+	if m.dedup != nil {
+		value = m.dedup.intern(value)
+	}
+	m.data[key] = value
+	m.tier.addHot(key, value)
+	delete(m.enc, key)
+}
+
+// releaseLocked lets go of key's current value, if it has one: its
+// share of a deduplicated value or its place in the cold file.
+func (m *MemoryStore) releaseLocked(key string) {
+	old, ok := m.data[key]
+	if !ok || m.tier.drop(key, old) {
+		return
+	}
+	if m.dedup != nil {
+		m.dedup.release(old)
+	}
+}
+
+// remove deletes key and everything attached to it. m.mu must be held.
+func (m *MemoryStore) remove(key string) {
+	m.releaseLocked(key)
+	delete(m.data, key)
+	delete(m.expiry, key)
+	delete(m.enc, key)
+	m.untag(key)
+}
+
+func (m *MemoryStore) untag(key string) {
+	for tag := range m.tags[key] {
+		delete(m.tagIndex[tag], key)
+		if len(m.tagIndex[tag]) == 0 {
+			delete(m.tagIndex, tag)
+		}
+	}
+	delete(m.tags, key)
+}
@@ -0,0 +1,17 @@
+package storage_test
+
+import (
+	"assignment2/internal/storage"
+	"assignment2/internal/storage/storetest"
+	"testing"
+)
+
+func TestMemoryStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, dir string) storage.Store {
+		s, err := storage.Open(dir, storage.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
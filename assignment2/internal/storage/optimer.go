@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// OpTimer adds up the time spent in store operations made with a
+// context carrying it, including waiting for the lock and for the
+// write-ahead log.
+type OpTimer struct {
+	nanos atomic.Int64
+	ops   atomic.Int64
+}
+
+type opTimerKey struct{}
+
+// WithOpTimer returns a context whose store operations are timed by the
+// returned OpTimer.
+func WithOpTimer(ctx context.Context) (context.Context, *OpTimer) {
+	t := &OpTimer{}
+	return context.WithValue(ctx, opTimerKey{}, t), t
+}
+
+// Total returns the time spent in store operations so far.
+func (t *OpTimer) Total() time.Duration {
+	return time.Duration(t.nanos.Load())
+}
+
+// Ops returns the number of store operations so far.
+func (t *OpTimer) Ops() int64 {
+	return t.ops.Load()
+}
+
+// track is deferred by operations with the time they started.
+func track(ctx context.Context, start time.Time) {
+	if t, ok := ctx.Value(opTimerKey{}).(*OpTimer); ok {
+		t.nanos.Add(int64(time.Since(start)))
+		t.ops.Add(1)
+	}
+}
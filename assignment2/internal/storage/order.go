@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// The store keeps its keys ordered by name and by the revision of their
+// last change in two skip lists, updated with every change, so that
+// sorted listings walk the keys in order instead of sorting all of them
+// per request.
+
+// Order is the order of ScanOrdered: by key or by the revision of the
+// last change, which is the order the keys were last updated in.
+type Order struct {
+	ByRevision bool
+	Descending bool
+}
+
+// ScanOrdered returns the live keys starting with prefix that were
+// changed at or after revision minRev, in the given order, together with
+// the current revision.
+func (m *MemoryStore) ScanOrdered(ctx context.Context, prefix string, minRev uint64, order Order) ([]string, uint64, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lock(ctx); err != nil {
+		return nil, 0, err
+	}
+	defer m.mu.Unlock()
+
+	var keys []string
+	now := time.Now()
+	n := 0
+	visit := func(k string) error {
+		if n++; n%checkEvery == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if m.meta[k].rev >= minRev && strings.HasPrefix(k, prefix) && !m.expiredLocked(k, now) {
+			keys = append(keys, k)
+		}
+		return nil
+	}
+
+	switch {
+	case !order.ByRevision:
+		for x := m.byKey.seek(prefix); x != nil && strings.HasPrefix(x.item, prefix); x = x.next[0] {
+			if err := visit(x.item); err != nil {
+				return nil, 0, err
+			}
+		}
+		if order.Descending {
+			for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+				keys[i], keys[j] = keys[j], keys[i]
+			}
+		}
+	case !order.Descending:
+		for x := m.byRev.seek(revKey{rev: minRev}); x != nil; x = x.next[0] {
+			if err := visit(x.item.key); err != nil {
+				return nil, 0, err
+			}
+		}
+	default:
+		for x := m.byRev.tail; x != nil && x.item.rev >= minRev; x = x.prev {
+			if err := visit(x.item.key); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	return keys, m.rev, nil
+}
+
+// revKey orders keys by the revision of their last change.
+type revKey struct {
+	rev uint64
+	key string
+}
+
+func (a revKey) less(b revKey) bool {
+	return a.rev < b.rev || a.rev == b.rev && a.key < b.key
+}
+
+func newKeyIndex() *skipList[string] {
+	return newSkipList(func(a, b string) bool { return a < b })
+}
+
+func newRevIndex() *skipList[revKey] {
+	return newSkipList(revKey.less)
+}
+
+// reindexLocked moves key in the ordered indexes from its old revision,
+// if it had one, to rev, or takes it out if it no longer exists.
+func (m *MemoryStore) reindexLocked(key string, old keyMeta, had bool, rev uint64, exists bool) {
+	if had {
+		m.byRev.remove(revKey{rev: old.rev, key: key})
+	}
+	switch {
+	case exists:
+		if !had {
+			m.byKey.insert(key)
+		}
+		m.byRev.insert(revKey{rev: rev, key: key})
+	case had:
+		m.byKey.remove(key)
+	}
+}
+
+// skipMaxLevel bounds the height of skip list nodes; with a quarter of
+// the nodes reaching each next level it suits billions of keys.
+const skipMaxLevel = 16
+
+type skipNode[T any] struct {
+	item T
+	next []*skipNode[T]
+
+	// prev links the bottom level backwards, for descending walks.
+	prev *skipNode[T]
+}
+
+// skipList is a sorted set of items ordered by less.
+type skipList[T any] struct {
+	less  func(a, b T) bool
+	head  skipNode[T]
+	tail  *skipNode[T]
+	level int
+	seed  uint64
+}
+
+func newSkipList[T any](less func(a, b T) bool) *skipList[T] {
+	l := &skipList[T]{less: less, level: 1, seed: 0x9e3779b97f4a7c15}
+	l.head.next = make([]*skipNode[T], skipMaxLevel)
+	return l
+}
+
+// randomLevel is the height of a new node: 1, and one more with
+// probability 1/4 each time.
+func (l *skipList[T]) randomLevel() int {
+	l.seed ^= l.seed << 13
+	l.seed ^= l.seed >> 7
+	l.seed ^= l.seed << 17
+	level := 1
+	for x := l.seed; level < skipMaxLevel && x&3 == 0; x >>= 2 {
+		level++
+	}
+	return level
+}
+
+// path fills before with the last node on each level that is less than
+// item and returns the first node that is not.
+func (l *skipList[T]) path(item T, before *[skipMaxLevel]*skipNode[T]) *skipNode[T] {
+	x := &l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && l.less(x.next[i].item, item) {
+			x = x.next[i]
+		}
+		before[i] = x
+	}
+	return x.next[0]
+}
+
+// seek returns the first node that is not less than item.
+func (l *skipList[T]) seek(item T) *skipNode[T] {
+	var before [skipMaxLevel]*skipNode[T]
+	return l.path(item, &before)
+}
+
+func (l *skipList[T]) insert(item T) {
+	var before [skipMaxLevel]*skipNode[T]
+	l.path(item, &before)
+
+	level := l.randomLevel()
+	for i := l.level; i < level; i++ {
+		before[i] = &l.head
+	}
+	if level > l.level {
+		l.level = level
+	}
+
+	n := &skipNode[T]{item: item, next: make([]*skipNode[T], level)}
+	for i := range n.next {
+		n.next[i] = before[i].next[i]
+		before[i].next[i] = n
+	}
+	if before[0] != &l.head {
+		n.prev = before[0]
+	}
+	if n.next[0] != nil {
+		n.next[0].prev = n
+	} else {
+		l.tail = n
+	}
+}
+
+func (l *skipList[T]) remove(item T) {
+	var before [skipMaxLevel]*skipNode[T]
+	n := l.path(item, &before)
+	if n == nil || l.less(item, n.item) {
+		return
+	}
+
+	for i := range n.next {
+		before[i].next[i] = n.next[i]
+	}
+	if n.next[0] != nil {
+		n.next[0].prev = n.prev
+	} else {
+		l.tail = n.prev
+	}
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+}
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// ScanKeys returns the live keys starting with prefix that were changed
+// at or after revision minRev, sorted, together with the current revision.
+// Callers fetch the values in batches with GetMany, so a long scan does not
+// hold the store lock throughout.
+func (m *MemoryStore) ScanKeys(ctx context.Context, prefix string, minRev uint64) ([]string, uint64, error) {
+	return m.ScanOrdered(ctx, prefix, minRev, Order{})
+}
+
+// GetMany returns the live values of keys; keys that do not exist (any
+// more) are left out.
+func (m *MemoryStore) GetMany(ctx context.Context, keys []string) (map[string]string, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	out := make(map[string]string, len(keys))
+	now := time.Now()
+	for _, k := range keys {
+		if _, ok := m.data[k]; ok && !m.expiredLocked(k, now) {
+			out[k] = m.valueLocked(k)
+		}
+	}
+	return out, nil
+}
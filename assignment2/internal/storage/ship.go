@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoSegment is returned by OpenSegment for a name that is not a sealed
+// segment.
+var ErrNoSegment = errors.New("no such log segment")
+
+const segmentSuffix = ".wal.gz"
+
+// Segment is a sealed piece of the shipping log: the records with
+// revisions First to Last, in order.
+type Segment struct {
+	Name   string    `json:"name"`
+	First  uint64    `json:"first"`
+	Last   uint64    `json:"last"`
+	Size   int64     `json:"size"`
+	Sealed time.Time `json:"sealed"`
+}
+
+// ShipLog keeps a copy of the store's mutations for log shipping. They
+// collect in memory until Seal writes them to a segment file, named after
+// the revisions it holds, which never changes again; a standby fetches
+// the segments it has not applied yet. Mutations not sealed yet are lost
+// on a crash, so a standby that finds a gap between segments has to start
+// over from a snapshot.
+type ShipLog struct {
+	dir string
+
+	// sealMu serializes Seal and Prune; mu guards the rest.
+	sealMu sync.Mutex
+
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	first    uint64
+	last     uint64
+	opened   time.Time
+	segments []Segment
+}
+
+// OpenShipLog opens the segments in dir, creating it if needed.
+func OpenShipLog(dir string) (*ShipLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &ShipLog{dir: dir}
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		var first, last uint64
+		if _, err := fmt.Sscanf(name, "%020d-%020d"+segmentSuffix, &first, &last); err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		l.segments = append(l.segments, Segment{Name: name, First: first, Last: last, Size: info.Size(), Sealed: info.ModTime()})
+	}
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i].First < l.segments[j].First })
+	return l, nil
+}
+
+// ShipTo copies every mutation from now on to l.
+func (m *MemoryStore) ShipTo(l *ShipLog) {
+	m.mu.Lock()
+	m.ship = l
+	m.mu.Unlock()
+}
+
+// add appends recs to the open segment. It is called under the store lock.
+func (l *ShipLog) add(recs []Record) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, rec := range recs {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			continue
+		}
+		if l.buf.Len() == 0 {
+			l.first = rec.Rev
+			l.opened = time.Now()
+		}
+		l.last = max(l.last, rec.Rev)
+		l.buf.Write(line)
+		l.buf.WriteByte('\n')
+	}
+}
+
+// Pending returns the size of the records waiting to be sealed and when
+// the first of them came in.
+func (l *ShipLog) Pending() (int, time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Len(), l.opened
+}
+
+// Seal writes the pending records to a new segment. It reports false if
+// there were none.
+func (l *ShipLog) Seal() (Segment, bool, error) {
+	l.sealMu.Lock()
+	defer l.sealMu.Unlock()
+
+	l.mu.Lock()
+	if l.buf.Len() == 0 {
+		l.mu.Unlock()
+		return Segment{}, false, nil
+	}
+	data := append([]byte(nil), l.buf.Bytes()...)
+	first, last := l.first, l.last
+	l.buf.Reset()
+	l.mu.Unlock()
+
+	seg := Segment{Name: fmt.Sprintf("%020d-%020d"+segmentSuffix, first, last), First: first, Last: last}
+	path := filepath.Join(l.dir, seg.Name)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return Segment{}, false, err
+	}
+	zw := gzip.NewWriter(f)
+	_, err = zw.Write(data)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return Segment{}, false, err
+	}
+	if info, err := os.Stat(path); err == nil {
+		seg.Size = info.Size()
+		seg.Sealed = info.ModTime()
+	}
+
+	l.mu.Lock()
+	l.segments = append(l.segments, seg)
+	l.mu.Unlock()
+	return seg, true, nil
+}
+
+// Segments returns the sealed segments with records after revision after,
+// oldest first.
+func (l *ShipLog) Segments(after uint64) []Segment {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var out []Segment
+	for _, seg := range l.segments {
+		if seg.Last > after {
+			out = append(out, seg)
+		}
+	}
+	return out
+}
+
+// OpenSegment opens the sealed segment name for reading.
+func (l *ShipLog) OpenSegment(name string) (*os.File, error) {
+	l.mu.Lock()
+	known := false
+	for _, seg := range l.segments {
+		known = known || seg.Name == name
+	}
+	l.mu.Unlock()
+	if !known {
+		return nil, ErrNoSegment
+	}
+	return os.Open(filepath.Join(l.dir, name))
+}
+
+// Prune deletes all but the newest keep segments and returns how many it
+// deleted.
+func (l *ShipLog) Prune(keep int) (int, error) {
+	l.sealMu.Lock()
+	defer l.sealMu.Unlock()
+
+	l.mu.Lock()
+	var old []Segment
+	if n := len(l.segments) - keep; n > 0 {
+		old = append(old, l.segments[:n]...)
+		l.segments = append([]Segment(nil), l.segments[n:]...)
+	}
+	l.mu.Unlock()
+
+	for i, seg := range old {
+		if err := os.Remove(filepath.Join(l.dir, seg.Name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return i, err
+		}
+	}
+	return len(old), nil
+}
+
+// ReadSegment calls apply for each record of a segment, in order.
+func ReadSegment(r io.Reader, apply func(Record) error) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	_, err = replay(zr, apply)
+	return err
+}
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Store is the key-value contract MemoryStore implements: values with
+// revisions, atomic read-modify-write, time to live and listing. Other
+// backends implement it to be checked against the same semantics with
+// the storetest package.
+//
+// Every mutation gets the next revision, and an operation whose ctx is
+// done before it takes effect has none. MemoryStore applies SetMany and
+// SetManyTTL atomically; a backend that has to split a large batch
+// documents it, and storetest does not require more.
+type Store interface {
+	Get(ctx context.Context, key string) (string, bool, error)
+	GetRevision(ctx context.Context, key string) (string, uint64, bool, error)
+	GetEntry(ctx context.Context, key string) (Entry, bool, error)
+	GetAll(ctx context.Context) (map[string]string, error)
+	GetSince(ctx context.Context, minRev uint64) (map[string]string, uint64, error)
+
+	Set(ctx context.Context, key, value string) (uint64, error)
+	SetMany(ctx context.Context, entries map[string]string) (uint64, error)
+	SetManyTTL(ctx context.Context, entries map[string]string, ttl time.Duration) (uint64, error)
+	Delete(ctx context.Context, key string) (uint64, error)
+	Update(ctx context.Context, key string, fn func(old string, exists bool) (value string, keep bool, err error)) (uint64, error)
+
+	Revision() uint64
+	Close() error
+}
+
+var _ Store = (*MemoryStore)(nil)
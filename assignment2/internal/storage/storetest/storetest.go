@@ -0,0 +1,580 @@
+// Package storetest checks a storage.Store implementation against the
+// semantics the server relies on. A backend's tests call Run with a
+// function that opens the backend:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T, dir string) storage.Store {
+//			s, err := mybackend.Open(dir)
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			return s
+//		})
+//	}
+package storetest
+
+import (
+	"assignment2/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// NewStore opens the store kept in dir, an empty directory of its own
+// for each test. Opened again with the same dir after Close, the store
+// must hold what was stored before. Run closes every store it opens.
+type NewStore func(t *testing.T, dir string) storage.Store
+
+// ttl is the time to live given to keys that are meant to expire during
+// a test, and ttlWait how long those tests wait for them to.
+const (
+	ttl     = 100 * time.Millisecond
+	ttlWait = 3 * ttl
+)
+
+// Run runs the conformance tests as subtests of t.
+func Run(t *testing.T, newStore NewStore) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, newStore NewStore)
+	}{
+		{"Basic", testBasic},
+		{"Revisions", testRevisions},
+		{"Update", testUpdate},
+		{"Iteration", testIteration},
+		{"TTL", testTTL},
+		{"Cancellation", testCancellation},
+		{"Concurrency", testConcurrency},
+		{"Persistence", testPersistence},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore)
+		})
+	}
+}
+
+// open opens a store in a fresh directory, closed when the test ends.
+func open(t *testing.T, newStore NewStore) storage.Store {
+	t.Helper()
+	s := newStore(t, t.TempDir())
+	t.Cleanup(func() {
+		if err := s.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	})
+	return s
+}
+
+func mustSet(t *testing.T, s storage.Store, key, value string) uint64 {
+	t.Helper()
+	rev, err := s.Set(context.Background(), key, value)
+	if err != nil {
+		t.Fatalf("Set(%q): %v", key, err)
+	}
+	return rev
+}
+
+// wantValue fails t unless key holds value, or is absent if exists is
+// false.
+func wantValue(t *testing.T, s storage.Store, key, value string, exists bool) {
+	t.Helper()
+	got, ok, err := s.Get(context.Background(), key)
+	switch {
+	case err != nil:
+		t.Fatalf("Get(%q): %v", key, err)
+	case ok != exists:
+		t.Fatalf("Get(%q): exists = %t, want %t", key, ok, exists)
+	case ok && got != value:
+		t.Fatalf("Get(%q) = %q, want %q", key, got, value)
+	}
+}
+
+func wantEntries(t *testing.T, what string, got, want map[string]string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: %d entries, want %d", what, len(got), len(want))
+	}
+	for k, v := range want {
+		if g, ok := got[k]; !ok || g != v {
+			t.Fatalf("%s[%q] = %q (present %t), want %q", what, k, g, ok, v)
+		}
+	}
+}
+
+func testBasic(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	s := open(t, newStore)
+
+	wantValue(t, s, "a", "", false)
+	if _, rev, ok, err := s.GetRevision(ctx, "a"); err != nil || ok || rev != 0 {
+		t.Fatalf("GetRevision of a missing key = %d, %t, %v; want 0, false, nil", rev, ok, err)
+	}
+	if _, ok, err := s.GetEntry(ctx, "a"); err != nil || ok {
+		t.Fatalf("GetEntry of a missing key = %t, %v; want false, nil", ok, err)
+	}
+
+	rev := mustSet(t, s, "a", "1")
+	wantValue(t, s, "a", "1", true)
+	e, ok, err := s.GetEntry(ctx, "a")
+	if err != nil || !ok {
+		t.Fatalf("GetEntry(a) = %t, %v", ok, err)
+	}
+	if e.Value != "1" || e.Revision != rev || !e.ExpiresAt.IsZero() {
+		t.Fatalf("GetEntry(a) = %+v, want value 1 at revision %d without expiry", e, rev)
+	}
+
+	rev = mustSet(t, s, "a", "2")
+	wantValue(t, s, "a", "2", true)
+	if _, got, _, _ := s.GetRevision(ctx, "a"); got != rev {
+		t.Fatalf("GetRevision(a) after overwrite = %d, want %d", got, rev)
+	}
+
+	mustSet(t, s, "empty", "")
+	wantValue(t, s, "empty", "", true)
+
+	del, err := s.Delete(ctx, "a")
+	if err != nil || del <= rev {
+		t.Fatalf("Delete(a) = %d, %v; want a revision after %d", del, err, rev)
+	}
+	wantValue(t, s, "a", "", false)
+
+	before := s.Revision()
+	if rev, err := s.Delete(ctx, "a"); err != nil || rev != 0 {
+		t.Fatalf("Delete of a missing key = %d, %v; want 0, nil", rev, err)
+	}
+	if s.Revision() != before {
+		t.Fatalf("Delete of a missing key moved the revision from %d to %d", before, s.Revision())
+	}
+}
+
+func testRevisions(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	s := open(t, newStore)
+
+	if rev := s.Revision(); rev != 0 {
+		t.Fatalf("Revision of a new store = %d, want 0", rev)
+	}
+
+	var last uint64
+	next := func(what string, rev uint64, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", what, err)
+		}
+		if rev <= last {
+			t.Fatalf("%s: revision %d, want one after %d", what, rev, last)
+		}
+		if cur := s.Revision(); cur != rev {
+			t.Fatalf("%s: Revision() = %d, want %d", what, cur, rev)
+		}
+		last = rev
+	}
+
+	rev, err := s.Set(ctx, "a", "1")
+	next("Set", rev, err)
+	rev, err = s.SetMany(ctx, map[string]string{"b": "1", "c": "1", "d": "1"})
+	next("SetMany", rev, err)
+	for _, k := range []string{"b", "c", "d"} {
+		if _, got, _, _ := s.GetRevision(ctx, k); got == 0 || got > rev {
+			t.Fatalf("SetMany: %s at revision %d, want one up to %d", k, got, rev)
+		}
+	}
+	rev, err = s.Update(ctx, "a", func(string, bool) (string, bool, error) { return "2", true, nil })
+	next("Update", rev, err)
+	rev, err = s.Delete(ctx, "b")
+	next("Delete", rev, err)
+	rev, err = s.SetManyTTL(ctx, map[string]string{"e": "1"}, time.Hour)
+	next("SetManyTTL", rev, err)
+}
+
+func testUpdate(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	s := open(t, newStore)
+
+	// Compare-and-swap on top of Update, the way conditional requests do.
+	cas := func(key, expected, value string) (uint64, error) {
+		return s.Update(ctx, key, func(old string, exists bool) (string, bool, error) {
+			if !exists || old != expected {
+				return old, exists, errMismatch
+			}
+			return value, true, nil
+		})
+	}
+
+	var seen []string
+	rev, err := s.Update(ctx, "k", func(old string, exists bool) (string, bool, error) {
+		seen = append(seen, fmt.Sprintf("%q %t", old, exists))
+		return "v1", true, nil
+	})
+	if err != nil || rev == 0 {
+		t.Fatalf("Update creating k = %d, %v", rev, err)
+	}
+	if len(seen) != 1 || seen[0] != `"" false` {
+		t.Fatalf("Update of a missing key: fn saw %v, want one call with \"\" false", seen)
+	}
+	wantValue(t, s, "k", "v1", true)
+
+	swapped, err := cas("k", "v1", "v2")
+	if err != nil || swapped <= rev {
+		t.Fatalf("CAS v1 -> v2 = %d, %v; want a revision after %d", swapped, err, rev)
+	}
+	wantValue(t, s, "k", "v2", true)
+
+	before := s.Revision()
+	if rev, err := cas("k", "v1", "v3"); !errors.Is(err, errMismatch) || rev != 0 {
+		t.Fatalf("CAS with a stale value = %d, %v; want 0, %v", rev, err, errMismatch)
+	}
+	wantValue(t, s, "k", "v2", true)
+	if s.Revision() != before {
+		t.Fatal("a failed Update changed the revision")
+	}
+
+	rev, err = s.Update(ctx, "k", func(old string, exists bool) (string, bool, error) { return old, true, nil })
+	if err != nil || rev != swapped {
+		t.Fatalf("Update leaving k as it was = %d, %v; want its revision %d", rev, err, swapped)
+	}
+	if s.Revision() != before {
+		t.Fatal("an Update that changed nothing moved the revision")
+	}
+
+	rev, err = s.Update(ctx, "missing", func(string, bool) (string, bool, error) { return "", false, nil })
+	if err != nil || rev != 0 {
+		t.Fatalf("Update leaving a missing key absent = %d, %v; want 0, nil", rev, err)
+	}
+	wantValue(t, s, "missing", "", false)
+
+	rev, err = s.Update(ctx, "k", func(string, bool) (string, bool, error) { return "", false, nil })
+	if err != nil || rev <= before {
+		t.Fatalf("Update deleting k = %d, %v; want a revision after %d", rev, err, before)
+	}
+	wantValue(t, s, "k", "", false)
+}
+
+var errMismatch = errors.New("storetest: value does not match")
+
+func testIteration(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	s := open(t, newStore)
+
+	all, err := s.GetAll(ctx)
+	if err != nil || len(all) != 0 {
+		t.Fatalf("GetAll of a new store = %d entries, %v", len(all), err)
+	}
+
+	// Enough keys for backends that page or check for cancellation as
+	// they scan.
+	want := make(map[string]string)
+	for i := 0; i < 5000; i++ {
+		want[fmt.Sprintf("key/%05d", i)] = fmt.Sprint(i)
+	}
+	mid, err := s.SetMany(ctx, want)
+	if err != nil {
+		t.Fatalf("SetMany: %v", err)
+	}
+
+	all, err = s.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	wantEntries(t, "GetAll", all, want)
+
+	// The result is the caller's.
+	all["key/00000"] = "changed"
+	delete(all, "key/00001")
+	wantValue(t, s, "key/00000", "0", true)
+	wantValue(t, s, "key/00001", "1", true)
+
+	mustSet(t, s, "key/00002", "changed")
+	s.Delete(ctx, "key/00003")
+	mustSet(t, s, "new", "x")
+	want["key/00002"] = "changed"
+	delete(want, "key/00003")
+	want["new"] = "x"
+
+	all, err = s.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	wantEntries(t, "GetAll after changes", all, want)
+
+	since, rev, err := s.GetSince(ctx, mid+1)
+	if err != nil {
+		t.Fatalf("GetSince: %v", err)
+	}
+	wantEntries(t, "GetSince", since, map[string]string{"key/00002": "changed", "new": "x"})
+	if rev != s.Revision() {
+		t.Fatalf("GetSince revision = %d, want the current %d", rev, s.Revision())
+	}
+
+	since, _, err = s.GetSince(ctx, 0)
+	if err != nil {
+		t.Fatalf("GetSince(0): %v", err)
+	}
+	wantEntries(t, "GetSince(0)", since, want)
+
+	since, _, err = s.GetSince(ctx, rev+1)
+	if err != nil || len(since) != 0 {
+		t.Fatalf("GetSince past the current revision = %d entries, %v", len(since), err)
+	}
+}
+
+func testTTL(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	s := open(t, newStore)
+
+	start := time.Now()
+	if _, err := s.SetManyTTL(ctx, map[string]string{"short": "1", "kept": "1", "overwritten": "1"}, ttl); err != nil {
+		t.Fatalf("SetManyTTL: %v", err)
+	}
+	if _, err := s.SetManyTTL(ctx, map[string]string{"long": "1"}, time.Hour); err != nil {
+		t.Fatalf("SetManyTTL: %v", err)
+	}
+	if _, err := s.SetManyTTL(ctx, map[string]string{"forever": "1"}, 0); err != nil {
+		t.Fatalf("SetManyTTL without a ttl: %v", err)
+	}
+
+	e, ok, err := s.GetEntry(ctx, "short")
+	if err != nil || !ok {
+		t.Fatalf("GetEntry(short) = %t, %v", ok, err)
+	}
+	if e.ExpiresAt.Before(start.Add(ttl)) || e.ExpiresAt.After(time.Now().Add(ttl)) {
+		t.Fatalf("GetEntry(short).ExpiresAt = %v, want about %v", e.ExpiresAt, start.Add(ttl))
+	}
+	if e, _, _ := s.GetEntry(ctx, "forever"); !e.ExpiresAt.IsZero() {
+		t.Fatalf("a key stored without ttl expires at %v", e.ExpiresAt)
+	}
+
+	// Update keeps the time to live of a key it keeps; Set drops it.
+	if _, err := s.Update(ctx, "kept", func(string, bool) (string, bool, error) { return "2", true, nil }); err != nil {
+		t.Fatalf("Update(kept): %v", err)
+	}
+	if e, _, _ := s.GetEntry(ctx, "kept"); e.ExpiresAt.IsZero() {
+		t.Fatal("Update dropped the time to live of a key it kept")
+	}
+	mustSet(t, s, "overwritten", "2")
+	if e, _, _ := s.GetEntry(ctx, "overwritten"); !e.ExpiresAt.IsZero() {
+		t.Fatal("Set kept a time to live")
+	}
+
+	time.Sleep(ttlWait)
+
+	for _, k := range []string{"short", "kept"} {
+		wantValue(t, s, k, "", false)
+		if _, _, ok, _ := s.GetRevision(ctx, k); ok {
+			t.Fatalf("GetRevision returns the expired key %s", k)
+		}
+		if _, ok, _ := s.GetEntry(ctx, k); ok {
+			t.Fatalf("GetEntry returns the expired key %s", k)
+		}
+	}
+	want := map[string]string{"long": "1", "forever": "1", "overwritten": "2"}
+	all, err := s.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	wantEntries(t, "GetAll after expiry", all, want)
+	since, _, err := s.GetSince(ctx, 0)
+	if err != nil {
+		t.Fatalf("GetSince: %v", err)
+	}
+	wantEntries(t, "GetSince after expiry", since, want)
+
+	// An expired key is absent to Update, and setting it again starts
+	// over without a time to live.
+	var existed bool
+	if _, err := s.Update(ctx, "short", func(old string, exists bool) (string, bool, error) {
+		existed = exists
+		return "again", true, nil
+	}); err != nil {
+		t.Fatalf("Update(short): %v", err)
+	}
+	if existed {
+		t.Fatal("Update passed an expired key as existing")
+	}
+	if e, ok, _ := s.GetEntry(ctx, "short"); !ok || e.Value != "again" || !e.ExpiresAt.IsZero() {
+		t.Fatalf("GetEntry(short) after Update = %+v, %t; want again without expiry", e, ok)
+	}
+}
+
+func testCancellation(t *testing.T, newStore NewStore) {
+	s := open(t, newStore)
+	mustSet(t, s, "a", "1")
+	before := s.Revision()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := s.Set(ctx, "a", "2"); err == nil {
+		t.Fatal("Set with a cancelled context succeeded")
+	}
+	if _, err := s.SetMany(ctx, map[string]string{"b": "1"}); err == nil {
+		t.Fatal("SetMany with a cancelled context succeeded")
+	}
+	if _, err := s.Delete(ctx, "a"); err == nil {
+		t.Fatal("Delete with a cancelled context succeeded")
+	}
+	called := false
+	if _, err := s.Update(ctx, "a", func(string, bool) (string, bool, error) {
+		called = true
+		return "3", true, nil
+	}); err == nil {
+		t.Fatal("Update with a cancelled context succeeded")
+	}
+	if called {
+		t.Fatal("Update called fn with a cancelled context")
+	}
+	if _, _, err := s.Get(ctx, "a"); err == nil {
+		t.Fatal("Get with a cancelled context succeeded")
+	}
+
+	// Cancelled while fn runs: nothing is changed.
+	ctx, cancel = context.WithCancel(context.Background())
+	if _, err := s.Update(ctx, "a", func(string, bool) (string, bool, error) {
+		cancel()
+		return "4", true, nil
+	}); err == nil {
+		t.Fatal("Update cancelled during fn succeeded")
+	}
+
+	wantValue(t, s, "a", "1", true)
+	wantValue(t, s, "b", "", false)
+	if s.Revision() != before {
+		t.Fatalf("cancelled operations moved the revision from %d to %d", before, s.Revision())
+	}
+}
+
+func testConcurrency(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	s := open(t, newStore)
+
+	const writers, perWriter = 8, 100
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	revs := make(map[uint64]string)
+	record := func(rev uint64, what string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if prev, ok := revs[rev]; ok {
+			t.Errorf("revision %d given to both %s and %s", rev, prev, what)
+		}
+		revs[rev] = what
+	}
+
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				rev, err := s.Update(ctx, "counter", func(old string, exists bool) (string, bool, error) {
+					var n int
+					if exists {
+						fmt.Sscan(old, &n)
+					}
+					return fmt.Sprint(n + 1), true, nil
+				})
+				if err != nil {
+					t.Errorf("Update(counter): %v", err)
+					return
+				}
+				record(rev, fmt.Sprintf("increment %d/%d", w, i))
+
+				key := fmt.Sprintf("w%d/%d", w, i)
+				rev, err = s.Set(ctx, key, "x")
+				if err != nil {
+					t.Errorf("Set(%s): %v", key, err)
+					return
+				}
+				record(rev, "Set "+key)
+
+				if _, _, err := s.Get(ctx, "counter"); err != nil {
+					t.Errorf("Get(counter): %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	wantValue(t, s, "counter", fmt.Sprint(writers*perWriter), true)
+	all, err := s.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(all) != writers*perWriter+1 {
+		t.Fatalf("GetAll: %d entries, want %d", len(all), writers*perWriter+1)
+	}
+	if rev := s.Revision(); rev < uint64(len(revs)) {
+		t.Fatalf("Revision() = %d after %d changes", rev, len(revs))
+	}
+}
+
+func testPersistence(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s := newStore(t, dir)
+	mustSet(t, s, "a", "1")
+	mustSet(t, s, "b", "1")
+	if _, err := s.SetMany(ctx, map[string]string{"c": "1", "d": "1"}); err != nil {
+		t.Fatalf("SetMany: %v", err)
+	}
+	if _, err := s.SetManyTTL(ctx, map[string]string{"long": "1"}, time.Hour); err != nil {
+		t.Fatalf("SetManyTTL: %v", err)
+	}
+	if _, err := s.SetManyTTL(ctx, map[string]string{"short": "1"}, ttl); err != nil {
+		t.Fatalf("SetManyTTL: %v", err)
+	}
+	if _, err := s.Update(ctx, "b", func(string, bool) (string, bool, error) { return "2", true, nil }); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if _, err := s.Delete(ctx, "c"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	_, bRev, _, _ := s.GetRevision(ctx, "b")
+	long, _, _ := s.GetEntry(ctx, "long")
+	rev := s.Revision()
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	time.Sleep(ttlWait)
+
+	s = newStore(t, dir)
+	defer func() {
+		if err := s.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	}()
+
+	if got := s.Revision(); got != rev {
+		t.Fatalf("Revision after reopening = %d, want %d", got, rev)
+	}
+	all, err := s.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	wantEntries(t, "GetAll after reopening", all, map[string]string{"a": "1", "b": "2", "d": "1", "long": "1"})
+	if _, got, _, _ := s.GetRevision(ctx, "b"); got != bRev {
+		t.Fatalf("revision of b after reopening = %d, want %d", got, bRev)
+	}
+
+	e, ok, err := s.GetEntry(ctx, "long")
+	if err != nil || !ok {
+		t.Fatalf("GetEntry(long) = %t, %v", ok, err)
+	}
+	if d := e.ExpiresAt.Sub(long.ExpiresAt); d < -time.Second || d > time.Second {
+		t.Fatalf("long expires at %v after reopening, %v before", e.ExpiresAt, long.ExpiresAt)
+	}
+
+	if next := mustSet(t, s, "e", "1"); next <= rev {
+		t.Fatalf("Set after reopening got revision %d, want one after %d", next, rev)
+	}
+}
@@ -0,0 +1,333 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// With Options.TierBudget, values are kept in two tiers. Hot values are
+// in memory as usual; cold ones, those read or written least recently
+// once the hot ones exceed the budget, are moved to a file in the data
+// directory and read back when they are accessed. Keys, revisions, tags
+// and expiry always stay in memory, so only values count against the
+// budget.
+//
+// The cold file is a cache of the write-ahead log, which stays the only
+// durable copy: it is started empty on Open and removed on Close.
+// Single-key reads and writes bring a value back into memory. Listings,
+// snapshots and compactions read cold values from the file without
+// doing so.
+
+// tierLowWater is the share of the budget eviction brings the hot values
+// down to, so that it does not run again right away.
+const tierLowWater = 0.9
+
+// tierCompactMin is how many bytes of overwritten and deleted values the
+// cold file may hold before it is rewritten; it is also rewritten only
+// once they make up half of it.
+const tierCompactMin = 1 << 20
+
+var errTierNeedsDir = errors.New("storage: TierBudget needs a data directory")
+
+type tier struct {
+	budget int64
+	path   string
+	file   *os.File
+
+	// size is the length of the file and live the bytes in it that cold
+	// values still refer to.
+	size int64
+	live int64
+
+	cold map[string]coldRef
+
+	// access holds, for every hot key, the clock reading of its last
+	// access; hot is the size of the hot keys and values.
+	access map[string]uint64
+	clock  uint64
+	hot    int64
+
+	hits       int64
+	misses     int64
+	evicted    int64
+	readErrors int64
+}
+
+// coldRef locates a cold value in the file.
+type coldRef struct {
+	off int64
+	n   int
+}
+
+// TierStats describe the hot and cold tiers.
+type TierStats struct {
+	BudgetBytes int64 `json:"budget_bytes"`
+	HotKeys     int   `json:"hot_keys"`
+	HotBytes    int64 `json:"hot_bytes"`
+	ColdKeys    int   `json:"cold_keys"`
+	ColdBytes   int64 `json:"cold_bytes"`
+	FileBytes   int64 `json:"file_bytes"`
+
+	// Hits and Misses count single-key reads of values that were in
+	// memory and that had to be read from the cold file.
+	Hits       int64 `json:"hits"`
+	Misses     int64 `json:"misses"`
+	Evicted    int64 `json:"evicted"`
+	ReadErrors int64 `json:"read_errors"`
+}
+
+func openTier(dir string, budget int64) (*tier, error) {
+	path := filepath.Join(dir, "cold.dat")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &tier{
+		budget: budget,
+		path:   path,
+		file:   f,
+		cold:   make(map[string]coldRef),
+		access: make(map[string]uint64),
+	}, nil
+}
+
+func (t *tier) close() error {
+	err := t.file.Close()
+	os.Remove(t.path)
+	return err
+}
+
+// reset forgets every value, for a store that is being cleared.
+func (t *tier) reset() {
+	if t == nil {
+		return
+	}
+	t.cold = make(map[string]coldRef)
+	t.access = make(map[string]uint64)
+	t.hot = 0
+	t.live = 0
+}
+
+// touch records an access to the hot key.
+func (t *tier) touch(key string) {
+	t.clock++
+	t.access[key] = t.clock
+}
+
+func (t *tier) addHot(key, value string) {
+	if t == nil {
+		return
+	}
+	t.hot += int64(len(key) + len(value))
+	t.touch(key)
+}
+
+// drop forgets key's current value and reports whether it was cold.
+func (t *tier) drop(key, value string) (cold bool) {
+	if t == nil {
+		return false
+	}
+	if ref, ok := t.cold[key]; ok {
+		delete(t.cold, key)
+		t.live -= int64(ref.n)
+		return true
+	}
+	delete(t.access, key)
+	t.hot -= int64(len(key) + len(value))
+	return false
+}
+
+func (t *tier) read(ref coldRef) (string, error) {
+	buf := make([]byte, ref.n)
+	if _, err := t.file.ReadAt(buf, ref.off); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// valueLocked returns the value of a key in m.data, reading it from the
+// cold file if it is there, but leaving it cold.
+func (m *MemoryStore) valueLocked(key string) string {
+	if m.tier == nil {
+		return m.data[key]
+	}
+	ref, ok := m.tier.cold[key]
+	if !ok {
+		return m.data[key]
+	}
+	value, err := m.tier.read(ref)
+	if err != nil {
+		m.tier.readErrors++
+	}
+	return value
+}
+
+// accessLocked returns the value of a key in m.data for a single-key
+// read or write, bringing it back into memory if it is cold.
+func (m *MemoryStore) accessLocked(key string) string {
+	t := m.tier
+	if t == nil {
+		return m.data[key]
+	}
+	ref, ok := t.cold[key]
+	if !ok {
+		t.hits++
+		t.touch(key)
+		return m.data[key]
+	}
+
+	t.misses++
+	value, err := t.read(ref)
+	if err != nil {
+		t.readErrors++
+		return ""
+	}
+	delete(t.cold, key)
+	t.live -= int64(ref.n)
+	if m.dedup != nil {
+		value = m.dedup.intern(value)
+	}
+	m.data[key] = value
+	t.addHot(key, value)
+	return value
+}
+
+// EvictCold moves the least recently accessed values to the cold file
+// until the hot ones fit in Options.TierBudget again, and returns how
+// many it moved. Once the file is mostly overwritten and deleted
+// values, it is rewritten. It does nothing without a budget.
+func (m *MemoryStore) EvictCold(ctx context.Context) (int, error) {
+	defer track(ctx, time.Now())
+
+	if m.tier == nil {
+		return 0, nil
+	}
+	if err := m.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	n, err := m.evictLocked()
+	if err != nil {
+		return n, err
+	}
+	t := m.tier
+	if garbage := t.size - t.live; garbage > tierCompactMin && garbage > t.live {
+		err = m.compactTierLocked()
+	}
+	return n, err
+}
+
+func (m *MemoryStore) evictLocked() (int, error) {
+	t := m.tier
+	if t.hot <= t.budget {
+		return 0, nil
+	}
+
+	keys := make([]string, 0, len(t.access))
+	for k := range t.access {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return t.access[keys[i]] < t.access[keys[j]] })
+
+	// The values are written in one go before anything is changed, so a
+	// failed write leaves them all hot.
+	target := int64(tierLowWater * float64(t.budget))
+	hot := t.hot
+	var buf bytes.Buffer
+	var evict []string
+	for _, k := range keys {
+		if hot <= target {
+			break
+		}
+		v := m.data[k]
+		buf.WriteString(v)
+		hot -= int64(len(k) + len(v))
+		evict = append(evict, k)
+	}
+	if _, err := t.file.WriteAt(buf.Bytes(), t.size); err != nil {
+		return 0, err
+	}
+
+	off := t.size
+	for _, k := range evict {
+		v := m.data[k]
+		t.drop(k, v)
+		if m.dedup != nil {
+			m.dedup.release(v)
+		}
+		m.data[k] = ""
+		t.cold[k] = coldRef{off: off, n: len(v)}
+		off += int64(len(v))
+		t.live += int64(len(v))
+	}
+	t.size = off
+	t.evicted += int64(len(evict))
+	return len(evict), nil
+}
+
+// compactTierLocked rewrites the cold file with only the values still
+// referred to.
+func (m *MemoryStore) compactTierLocked() error {
+	t := m.tier
+	tmp := t.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	refs := make(map[string]coldRef, len(t.cold))
+	var off int64
+	for k, ref := range t.cold {
+		if _, err := io.Copy(f, io.NewSectionReader(t.file, ref.off, int64(ref.n))); err != nil {
+			return fail(err)
+		}
+		refs[k] = coldRef{off: off, n: ref.n}
+		off += int64(ref.n)
+	}
+	if err := os.Rename(tmp, t.path); err != nil {
+		return fail(err)
+	}
+
+	t.file.Close()
+	t.file = f
+	t.cold = refs
+	t.size = off
+	t.live = off
+	return nil
+}
+
+// TierStats reports the hot and cold tiers; ok is false without a
+// budget.
+func (m *MemoryStore) TierStats() (stats TierStats, ok bool) {
+	if m.tier == nil {
+		return TierStats{}, false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.tier
+	return TierStats{
+		BudgetBytes: t.budget,
+		HotKeys:     len(t.access),
+		HotBytes:    t.hot,
+		ColdKeys:    len(t.cold),
+		ColdBytes:   t.live,
+		FileBytes:   t.size,
+		Hits:        t.hits,
+		Misses:      t.misses,
+		Evicted:     t.evicted,
+		ReadErrors:  t.readErrors,
+	}, true
+}
@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNoTTL means a key has no time to live to renew.
+var ErrNoTTL = errors.New("key has no time to live")
+
+// Keys can have a time to live. Their deadlines are taken from time.Now
+// and so carry its monotonic reading: a key expires after its TTL has
+// elapsed even if the wall clock is stepped (NTP corrections, a VM
+// resumed on another host) in the meantime. Only where expiry leaves the
+// process is it converted to wall-clock time: in the API, against the
+// wall clock at that moment, and in the log (Record.Expires), which is
+// all that can survive a restart. Loading the log converts the remaining
+// time back to a monotonic deadline once; keys whose time ran out while
+// the server was down are hidden at once and deleted by the first
+// DeleteExpired.
+//
+// Expired keys are hidden from every read until DeleteExpired removes
+// them, which logs a delete so that watchers and replicas see it.
+
+// deadlineFromWall converts a logged expiry to a deadline; 0 gives none.
+func deadlineFromWall(expires int64) time.Time {
+	if expires == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Until(time.Unix(0, expires)))
+}
+
+// wallClock reports deadline as a time on the current wall clock.
+func wallClock(deadline time.Time) time.Time {
+	return time.Now().Add(time.Until(deadline)).Round(0)
+}
+
+// setExpiryLocked sets or, with a zero deadline, clears key's deadline.
+func (m *MemoryStore) setExpiryLocked(key string, deadline time.Time) {
+	if deadline.IsZero() {
+		delete(m.expiry, key)
+		return
+	}
+	m.expiry[key] = deadline
+}
+
+func (m *MemoryStore) expiredLocked(key string, now time.Time) bool {
+	d, ok := m.expiry[key]
+	return ok && !now.Before(d)
+}
+
+// liveLocked returns key's value unless it is missing or expired. A
+// cold value is brought back into memory.
+func (m *MemoryStore) liveLocked(key string) (string, bool) {
+	if _, ok := m.data[key]; !ok || m.expiredLocked(key, time.Now()) {
+		return "", false
+	}
+	return m.accessLocked(key), true
+}
+
+// Expire gives an existing key a time to live of ttl from now, or none
+// if ttl is not positive, leaving its value as it is. It returns the key
+// as it is after the change, or false if the key does not exist.
+func (m *MemoryStore) Expire(ctx context.Context, key string, ttl time.Duration) (Entry, bool, error) {
+	return m.expire(ctx, key, ttl, false)
+}
+
+// Touch renews key's time to live without changing its value: to ttl
+// from now, or with ttl 0 to the time to live it was last given. A key
+// without one gets ErrNoTTL unless ttl is given. It returns the key as
+// it is after the change, or false if the key does not exist.
+func (m *MemoryStore) Touch(ctx context.Context, key string, ttl time.Duration) (Entry, bool, error) {
+	return m.expire(ctx, key, ttl, true)
+}
+
+func (m *MemoryStore) expire(ctx context.Context, key string, ttl time.Duration, renew bool) (Entry, bool, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lockWrite(ctx, key); err != nil {
+		return Entry{}, false, err
+	}
+	value, ok := m.liveLocked(key)
+	if !ok {
+		m.mu.Unlock()
+		return Entry{}, false, nil
+	}
+	if renew && ttl <= 0 {
+		if ttl, ok = m.grantedTTLLocked(key); !ok {
+			m.mu.Unlock()
+			return Entry{}, true, ErrNoTTL
+		}
+	}
+	rec := m.expireLocked(key, ttl)
+	rev, wait := m.logLocked(rec)
+	m.mu.Unlock()
+
+	e := Entry{Value: value, Revision: rev}
+	if rec.Expires != 0 {
+		e.ExpiresAt = time.Unix(0, rec.Expires)
+	}
+	return e, true, wait()
+}
+
+// TouchPrefix renews, as one batch, the time to live of every key under
+// prefix that has one: to ttl from now, or with ttl 0 to the time to live
+// each was last given. Keys without one are left alone. It returns how
+// many keys it renewed and the revision of the last (the current
+// revision if none).
+func (m *MemoryStore) TouchPrefix(ctx context.Context, prefix string, ttl time.Duration) (int, uint64, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lockWrite(ctx); err != nil {
+		return 0, 0, err
+	}
+
+	now := time.Now()
+	var keys []string
+	for k := range m.expiry {
+		if strings.HasPrefix(k, prefix) && !m.expiredLocked(k, now) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		rev := m.rev
+		m.mu.Unlock()
+		return 0, rev, nil
+	}
+	sort.Strings(keys)
+	recs := make([]Record, len(keys))
+	for i, k := range keys {
+		d := ttl
+		if d <= 0 {
+			d, _ = m.grantedTTLLocked(k)
+		}
+		recs[i] = m.expireLocked(k, d)
+	}
+	rev, wait := m.logLocked(recs...)
+	m.mu.Unlock()
+
+	return len(recs), rev, wait()
+}
+
+// expireLocked gives key a time to live of ttl from now, or none, and
+// returns the record to log.
+func (m *MemoryStore) expireLocked(key string, ttl time.Duration) Record {
+	rec := Record{Op: OpExpire, Key: key}
+	if ttl > 0 {
+		deadline := time.Now().Add(ttl)
+		rec.Expires = wallClock(deadline).UnixNano()
+		rec.TTL = int64(ttl)
+		m.setExpiryLocked(key, deadline)
+	} else {
+		m.setExpiryLocked(key, time.Time{})
+	}
+	return rec
+}
+
+// grantedTTLLocked is the time to live key was last given. Logs written
+// before it was recorded only have the deadline; for their keys it is
+// taken to run from the key's last change.
+func (m *MemoryStore) grantedTTLLocked(key string) (time.Duration, bool) {
+	d, ok := m.expiry[key]
+	if !ok {
+		return 0, false
+	}
+	meta := m.meta[key]
+	if meta.ttl > 0 {
+		return meta.ttl, true
+	}
+	return max(d.Sub(meta.modified).Round(time.Millisecond), time.Millisecond), true
+}
+
+// DeleteExpired deletes up to limit expired keys as one batch and
+// returns how many it deleted.
+func (m *MemoryStore) DeleteExpired(ctx context.Context, limit int) (int, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lock(ctx); err != nil {
+		return 0, err
+	}
+
+	var keys []string
+	now := time.Now()
+	for k, d := range m.expiry {
+		if len(keys) == limit {
+			break
+		}
+		if !now.Before(d) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		m.mu.Unlock()
+		return 0, nil
+	}
+	// Expired keys stay hidden while the log refuses writes.
+	if err := m.writable(); err != nil {
+		m.mu.Unlock()
+		return 0, err
+	}
+
+	recs := make([]Record, len(keys))
+	for i, k := range keys {
+		m.remove(k)
+		recs[i] = Record{Op: OpDelete, Key: k}
+	}
+	_, wait := m.logLocked(recs...)
+	m.mu.Unlock()
+
+	return len(recs), wait()
+}
+
+// NextDeadline returns the earliest deadline, to compare with time.Now,
+// of those of keys that have a time to live, and false if none has.
+func (m *MemoryStore) NextDeadline(keys []string) (time.Time, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var next time.Time
+	for _, k := range keys {
+		if d, ok := m.expiry[k]; ok && (next.IsZero() || d.Before(next)) {
+			next = d
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// Expiring returns the number of keys with a time to live.
+func (m *MemoryStore) Expiring() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.expiry)
+}
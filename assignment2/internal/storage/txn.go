@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Txn is the view of the store inside Txn: reads see the store as it was
+// when the transaction started plus its own writes, which take effect
+// together when it commits.
+type Txn struct {
+	m      *MemoryStore
+	writes map[string]txnWrite
+	order  []string
+}
+
+type txnWrite struct {
+	value   string
+	deleted bool
+	ttl     time.Duration
+}
+
+// Get returns key's value as the transaction sees it.
+func (tx *Txn) Get(key string) (string, bool) {
+	if w, ok := tx.writes[key]; ok {
+		return w.value, !w.deleted
+	}
+	return tx.m.liveLocked(key)
+}
+
+// Keys returns the keys starting with prefix as the transaction sees
+// them, in no particular order.
+func (tx *Txn) Keys(prefix string) []string {
+	var keys []string
+	now := time.Now()
+	for k := range tx.m.data {
+		if _, written := tx.writes[k]; !written && strings.HasPrefix(k, prefix) && !tx.m.expiredLocked(k, now) {
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range tx.order {
+		if !tx.writes[k].deleted && strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Set stores value for key, without a time to live.
+func (tx *Txn) Set(key, value string) {
+	tx.SetTTL(key, value, 0)
+}
+
+// SetTTL stores value for key, expiring after ttl (never if ttl is not
+// positive).
+func (tx *Txn) SetTTL(key, value string, ttl time.Duration) {
+	tx.put(key, txnWrite{value: value, ttl: ttl})
+}
+
+// Delete removes key.
+func (tx *Txn) Delete(key string) {
+	tx.put(key, txnWrite{deleted: true})
+}
+
+func (tx *Txn) put(key string, w txnWrite) {
+	if _, ok := tx.writes[key]; !ok {
+		tx.order = append(tx.order, key)
+	}
+	tx.writes[key] = w
+}
+
+// Txn runs fn while holding the lock and then applies its writes as one
+// write-ahead log batch, so they are seen by readers, watchers and
+// replicas all at once or not at all. If fn returns an error, or ctx is
+// done by then, nothing is changed. fn must not call back into the
+// store.
+//
+// The returned revision is that of the last write, or the current one if
+// the transaction wrote nothing (deleting absent keys writes nothing).
+func (m *MemoryStore) Txn(ctx context.Context, fn func(tx *Txn) error) (uint64, error) {
+	defer track(ctx, time.Now())
+
+	if err := m.lockWrite(ctx); err != nil {
+		return 0, err
+	}
+
+	tx := &Txn{m: m, writes: make(map[string]txnWrite)}
+	err := fn(tx)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		m.mu.Unlock()
+		return 0, err
+	}
+
+	var recs []Record
+	now := time.Now()
+	for _, k := range tx.order {
+		w := tx.writes[k]
+		if w.deleted {
+			if _, ok := m.data[k]; ok {
+				m.remove(k)
+				recs = append(recs, Record{Op: OpDelete, Key: k})
+			}
+			continue
+		}
+
+		rec := Record{Op: OpSet, Key: k, Value: w.value}
+		var deadline time.Time
+		if w.ttl > 0 {
+			deadline = now.Add(w.ttl)
+			rec.Expires = wallClock(deadline).UnixNano()
+			rec.TTL = int64(w.ttl)
+		}
+		m.putLocked(k, w.value)
+		m.setExpiryLocked(k, deadline)
+		recs = append(recs, rec)
+	}
+	if len(recs) == 0 {
+		rev := m.rev
+		m.mu.Unlock()
+		return rev, nil
+	}
+	rev, wait := m.logLocked(recs...)
+	m.mu.Unlock()
+
+	return rev, wait()
+}
@@ -0,0 +1,492 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrClosed = errors.New("write-ahead log closed")
+
+// ErrUnavailable means records could not be written to the log. They are
+// kept and retried every walRetryInterval, and ahead of the next batch.
+var ErrUnavailable = errors.New("write-ahead log unavailable")
+
+const walRetryInterval = time.Second
+
+const (
+	OpSet    = "set"
+	OpDelete = "delete"
+	OpTags   = "tags"
+
+	// OpExpire sets the Expires of an existing key without changing its
+	// value; 0 removes its time to live.
+	OpExpire = "expire"
+
+	// OpReset clears the store and sets its revision to Rev; it precedes
+	// the records of a restored snapshot.
+	OpReset = "reset"
+)
+
+// Record is one mutation in the write-ahead log.
+type Record struct {
+	// Rev is the store revision assigned to the mutation. Logs written
+	// before revisions existed have none; replay numbers them in order.
+	Rev uint64 `json:"rev,omitempty"`
+
+	// TS is the time of the mutation in Unix nanoseconds.
+	TS int64 `json:"ts,omitempty"`
+
+	// Created is, in snapshot records, when the key was created, in Unix
+	// nanoseconds.
+	Created int64 `json:"created,omitempty"`
+
+	Op    string   `json:"op"`
+	Key   string   `json:"key"`
+	Value string   `json:"value,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+
+	// Expires is the wall-clock time, in Unix nanoseconds, at which the
+	// key of a set expires; 0 means never.
+	Expires int64 `json:"expires,omitempty"`
+
+	// TTL is, with Expires, the time to live in nanoseconds that the
+	// deadline was set from, which Touch renews the key to.
+	TTL int64 `json:"ttl,omitempty"`
+
+	// Enc is, for a set, how the client encrypted the value.
+	Enc *Encryption `json:"enc,omitempty"`
+}
+
+// WAL is an append-only log of JSON records with group commit: records
+// enqueued while a flush is pending share a single write and fsync.
+//
+// SyncWindow trades latency for throughput. With 0 a flush starts as soon
+// as the flusher is idle, so only writes that queue up behind an fsync in
+// progress are grouped. A larger window delays every flush by up to that
+// long to collect more writes per fsync. Either way a write is only
+// acknowledged once it is on disk, unless NoSync is set, in which case
+// acknowledged writes can be lost on a crash or power failure.
+type WAL struct {
+	// fileMu is held while writing to file, so that Rewrite can swap it.
+	fileMu     sync.Mutex
+	file       *os.File
+	path       string
+	size       int64
+	syncWindow time.Duration
+	noSync     bool
+
+	// written counts the bytes appended since the log was opened, and
+	// logSize mirrors size for readers that don't take fileMu.
+	written atomic.Int64
+	logSize atomic.Int64
+
+	mu      sync.Mutex
+	current *walBatch
+	closed  bool
+
+	// end is the offset just past the last enqueued record, whether or
+	// not it has been written yet.
+	end int64
+
+	kick    chan struct{}
+	stopped chan struct{}
+
+	// pending holds records whose write failed and unsynced is set when
+	// an fsync did; both are only touched by the flusher. The outcome of
+	// the last attempt is in health, guarded by mu.
+	pending  []byte
+	unsynced bool
+	buffered atomic.Int64
+	failures atomic.Int64
+	health   WALHealth
+	onHealth func(WALHealth)
+}
+
+// WALHealth is the state of the log's writes.
+type WALHealth struct {
+	// Err is the error of the last write or fsync, nil if it succeeded.
+	Err error
+
+	// Since is when writes started failing.
+	Since time.Time
+
+	// Buffered is the size of the records waiting to be written.
+	Buffered int64
+
+	// Failures counts failed writes and fsyncs since the log was opened.
+	Failures int64
+}
+
+type walBatch struct {
+	buf  bytes.Buffer
+	done chan struct{}
+	err  error
+}
+
+// OpenWAL opens (or creates) the log at path, calls apply for each intact
+// record and positions the log for appending. A torn record at the end,
+// left by a crash mid-write, is discarded; a corrupt one anywhere else
+// fails the open with its offset.
+func OpenWAL(path string, syncWindow time.Duration, noSync bool, apply func(Record) error) (*WAL, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	good, err := replay(f, apply)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(good); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	w := &WAL{
+		file:       f,
+		path:       path,
+		size:       good,
+		end:        good,
+		syncWindow: syncWindow,
+		noSync:     noSync,
+		kick:       make(chan struct{}, 1),
+		stopped:    make(chan struct{}),
+	}
+	w.logSize.Store(good)
+	go w.flushLoop()
+	return w, nil
+}
+
+// replay applies every complete record and returns the offset just past
+// the last one. A final line without its newline is a torn write and is
+// left out; a complete line that is not a record means the log is
+// corrupt, and replay fails rather than drop what follows it.
+func replay(f io.Reader, apply func(Record) error) (int64, error) {
+	r := bufio.NewReader(f)
+	var offset int64
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return 0, fmt.Errorf("corrupt record at offset %d: %w", offset, err)
+		}
+		if err := apply(rec); err != nil {
+			return 0, err
+		}
+		offset += int64(len(line))
+	}
+}
+
+// Enqueue adds records to the next batch and returns a function that
+// blocks until that batch is durable. Records are written in the order
+// Enqueue is called, so callers that enqueue under their own lock get a
+// log that matches the order in which they applied the changes.
+func (w *WAL) Enqueue(recs ...Record) (wait func() error) {
+	_, wait = w.enqueue(recs)
+	return wait
+}
+
+// Barrier returns the offset just past everything enqueued so far and a
+// function that blocks until all of it is durable.
+func (w *WAL) Barrier() (offset int64, wait func() error) {
+	return w.enqueue(nil)
+}
+
+func (w *WAL) enqueue(recs []Record) (int64, func() error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return w.end, func() error { return ErrClosed }
+	}
+
+	b := w.current
+	if b == nil {
+		b = &walBatch{done: make(chan struct{})}
+		w.current = b
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+
+	before := b.buf.Len()
+	enc := json.NewEncoder(&b.buf)
+	for _, rec := range recs {
+		enc.Encode(rec)
+	}
+	w.end += int64(b.buf.Len() - before)
+
+	return w.end, func() error {
+		<-b.done
+		return b.err
+	}
+}
+
+// Close flushes pending records and closes the file. Records that still
+// can't be written are lost, and reported as ErrUnavailable.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.kick)
+	w.mu.Unlock()
+
+	<-w.stopped
+	w.fileMu.Lock()
+	defer w.fileMu.Unlock()
+	err := w.file.Close()
+	if n := len(w.pending); n > 0 {
+		err = fmt.Errorf("%w: %d bytes of records lost", ErrUnavailable, n)
+	}
+	return err
+}
+
+// SetHealthObserver registers fn to be called from the flusher whenever
+// writes start failing and when they succeed again.
+func (w *WAL) SetHealthObserver(fn func(WALHealth)) {
+	w.mu.Lock()
+	w.onHealth = fn
+	w.mu.Unlock()
+}
+
+// Health returns the state of the log's writes.
+func (w *WAL) Health() WALHealth {
+	w.mu.Lock()
+	h := w.health
+	w.mu.Unlock()
+	h.Buffered = w.buffered.Load()
+	h.Failures = w.failures.Load()
+	return h
+}
+
+// Buffered returns the size of the records waiting for a write that
+// failed to be retried.
+func (w *WAL) Buffered() int64 {
+	return w.buffered.Load()
+}
+
+func (w *WAL) flushLoop() {
+	defer close(w.stopped)
+
+	var retry <-chan time.Time
+	for {
+		ok := true
+		select {
+		case _, ok = <-w.kick:
+			if ok && w.syncWindow > 0 {
+				time.Sleep(w.syncWindow)
+			}
+		case <-retry:
+		}
+
+		w.mu.Lock()
+		b := w.current
+		w.current = nil
+		w.mu.Unlock()
+
+		retry = nil
+		if b != nil || len(w.pending) > 0 || w.unsynced {
+			err := w.flush(b)
+			if b != nil {
+				b.err = err
+				close(b.done)
+			}
+			if err != nil {
+				retry = time.After(walRetryInterval)
+			}
+		}
+
+		if !ok {
+			return
+		}
+	}
+}
+
+// flush writes the records of earlier failed writes followed by b, if
+// any, keeping whatever did not make it for the next attempt.
+func (w *WAL) flush(b *walBatch) error {
+	p := w.pending
+	if b != nil {
+		p = append(p, b.buf.Bytes()...)
+	}
+
+	n, err := w.write(p)
+	w.pending = p[n:]
+	if len(w.pending) == 0 {
+		w.pending = nil
+	}
+	w.buffered.Store(int64(len(w.pending)))
+
+	w.mu.Lock()
+	failing := w.health.Err != nil
+	switch {
+	case err != nil && !failing:
+		w.health = WALHealth{Err: err, Since: time.Now()}
+	case err != nil:
+		w.health.Err = err
+	case failing:
+		w.health = WALHealth{}
+	}
+	changed := failing != (err != nil)
+	fn := w.onHealth
+	w.mu.Unlock()
+
+	if err != nil {
+		w.failures.Add(1)
+	}
+	if changed && fn != nil {
+		fn(w.Health())
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return nil
+}
+
+// write appends p and syncs the file, returning how much of p is in the
+// file; it is all or nothing. An fsync that fails is retried by the next
+// write.
+func (w *WAL) write(p []byte) (int, error) {
+	w.fileMu.Lock()
+	defer w.fileMu.Unlock()
+
+	if len(p) > 0 {
+		if _, err := w.file.Write(p); err != nil {
+			// Cut off a partial write so that later batches don't end up
+			// behind a torn record, which replay would take for corruption.
+			w.file.Truncate(w.size)
+			w.file.Seek(w.size, io.SeekStart)
+			return 0, err
+		}
+		w.size += int64(len(p))
+		w.written.Add(int64(len(p)))
+		w.logSize.Store(w.size)
+		w.unsynced = true
+	}
+
+	if w.noSync {
+		w.unsynced = false
+		return len(p), nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return len(p), err
+	}
+	w.unsynced = false
+	return len(p), nil
+}
+
+// Size returns the size of the log file.
+func (w *WAL) Size() int64 {
+	return w.logSize.Load()
+}
+
+// Written returns the number of bytes appended since the log was opened.
+func (w *WAL) Written() int64 {
+	return w.written.Load()
+}
+
+// Rewrite replaces the log with snapshot, which must reproduce the state
+// logged in the first offset bytes, followed by everything logged after
+// offset, and returns the size of the snapshot part. Records up to offset
+// must be durable. The snapshot is written without holding up appends;
+// they only wait while the tail is copied and the files are swapped.
+// wrap, if not nil, wraps the file the snapshot is written through, e.g.
+// to throttle it.
+func (w *WAL) Rewrite(snapshot []Record, offset int64, wrap func(io.Writer) io.Writer) (int64, error) {
+	tmp := w.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	fail := func(err error) (int64, error) {
+		f.Close()
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	var out io.Writer = f
+	if wrap != nil {
+		out = wrap(f)
+	}
+	bw := bufio.NewWriterSize(out, compactChunk)
+	enc := json.NewEncoder(bw)
+	for _, rec := range snapshot {
+		if err := enc.Encode(rec); err != nil {
+			return fail(err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fail(err)
+	}
+	snapSize, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fail(err)
+	}
+
+	w.fileMu.Lock()
+	defer w.fileMu.Unlock()
+
+	w.mu.Lock()
+	closed := w.closed
+	w.mu.Unlock()
+	if closed {
+		return fail(ErrClosed)
+	}
+	if offset > w.size {
+		return fail(errors.New("rewrite offset beyond the end of the log"))
+	}
+
+	tail, err := io.Copy(f, io.NewSectionReader(w.file, offset, w.size-offset))
+	if err != nil {
+		return fail(err)
+	}
+	if !w.noSync {
+		if err := f.Sync(); err != nil {
+			return fail(err)
+		}
+	}
+	if err := os.Rename(tmp, w.path); err != nil {
+		return fail(err)
+	}
+	if dir, err := os.Open(filepath.Dir(w.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	oldSize := w.size
+	w.file.Close()
+	w.file = f
+	w.size = snapSize + tail
+	w.logSize.Store(w.size)
+
+	w.mu.Lock()
+	w.end += w.size - oldSize
+	w.mu.Unlock()
+	return snapSize, nil
+}
@@ -0,0 +1,78 @@
+package storage_test
+
+import (
+	"assignment2/internal/storage"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// writeLog fills a store in dir with keys a, b and c and returns the
+// contents of its log.
+func writeLog(t *testing.T, dir string) []byte {
+	t.Helper()
+	s, err := storage.Open(dir, storage.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "b", "c"} {
+		if _, err := s.Set(context.Background(), k, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "wal.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestWALDiscardsTornTail(t *testing.T) {
+	dir := t.TempDir()
+	data := writeLog(t, dir)
+	torn := append(data, `{"op":"set","key":"d"`...)
+	if err := os.WriteFile(filepath.Join(dir, "wal.log"), torn, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := storage.Open(dir, storage.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if n := s.Size(); n != 3 {
+		t.Fatalf("reopened store holds %d keys, want 3", n)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wal.log")); !bytes.Equal(got, data) {
+		t.Fatalf("torn tail was not cut off:\n%s", got)
+	}
+}
+
+func TestWALRejectsCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	data := writeLog(t, dir)
+	first := bytes.IndexByte(data, '\n') + 1
+	corrupt := append(append(append([]byte{}, data[:first]...), "not a record\n"...), data[first:]...)
+	if err := os.WriteFile(filepath.Join(dir, "wal.log"), corrupt, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := storage.Open(dir, storage.Options{})
+	if err == nil {
+		s.Close()
+		t.Fatal("opened a log with a corrupt record in the middle")
+	}
+	if want := "offset " + strconv.Itoa(first); !strings.Contains(err.Error(), want) {
+		t.Fatalf("error %q does not give %s", err, want)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wal.log")); !bytes.Equal(got, corrupt) {
+		t.Fatal("the log was changed by the failed open")
+	}
+}
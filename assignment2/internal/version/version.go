@@ -0,0 +1,78 @@
+// Package version identifies the running build. Release builds set the
+// variables with the linker, e.g.
+//
+//	go build -ldflags "-X assignment2/internal/version.Version=v1.4.0 \
+//	    -X assignment2/internal/version.Commit=$(git rev-parse HEAD) \
+//	    -X assignment2/internal/version.BuildDate=$(date -u +%FT%TZ)" ./cmd/server
+//
+// Whatever is left empty is taken from the build information the Go
+// toolchain embeds (module version, VCS revision and commit time).
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+var (
+	Version   string
+	Commit    string
+	BuildDate string
+)
+
+// Info describes the running build.
+type Info struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+
+	// BuildDate is the commit time unless it was set at link time.
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+
+	// Modified is set for a build from a tree with uncommitted changes.
+	Modified bool `json:"modified,omitempty"`
+}
+
+var (
+	once sync.Once
+	info Info
+)
+
+// Get returns the build's version information.
+func Get() Info {
+	once.Do(func() { info = read() })
+	return info
+}
+
+// String is the build's version, "(devel)" if nothing identifies it.
+func String() string {
+	return Get().Version
+}
+
+func read() Info {
+	in := Info{Version: Version, Commit: Commit, BuildDate: BuildDate, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if in.Version == "" && bi.Main.Version != "" {
+			in.Version = bi.Main.Version
+		}
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if in.Commit == "" {
+					in.Commit = setting.Value
+				}
+			case "vcs.time":
+				if in.BuildDate == "" {
+					in.BuildDate = setting.Value
+				}
+			case "vcs.modified":
+				in.Modified = setting.Value == "true"
+			}
+		}
+	}
+	if in.Version == "" {
+		in.Version = "(devel)"
+	}
+	return in
+}
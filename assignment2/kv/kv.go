@@ -0,0 +1,387 @@
+// Package kv is the key-value store without the HTTP server: the same
+// storage, time to live, transactions and change events that the server
+// is built on, for programs that want them in-process.
+//
+//	db, err := kv.Open(kv.Options{Dir: "data"})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer db.Close()
+//
+//	db.SetTTL(ctx, "session:42", token, 30*time.Minute)
+package kv
+
+import (
+	"assignment2/internal/events"
+	"assignment2/internal/storage"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is a change to the store; Seq is the revision of the change.
+type Event = events.Event
+
+// Entry is a key's value with its revision and expiry.
+type Entry = storage.Entry
+
+// Txn is a transaction, see DB.Txn.
+type Txn = storage.Txn
+
+// FailurePolicy is what writes get while the write-ahead log can't be
+// written, see Options.OnLogFailure.
+type FailurePolicy = storage.FailurePolicy
+
+const (
+	FailWrites   = storage.FailWrites
+	BufferWrites = storage.BufferWrites
+)
+
+// LogHealth is the state of the write-ahead log's writes.
+type LogHealth = storage.WALHealth
+
+// ErrUnavailable means a write could not be made durable (or, under
+// FailWrites, was refused because it couldn't be).
+var ErrUnavailable = storage.ErrUnavailable
+
+// ErrNoTTL means Touch was asked to renew a time to live a key doesn't
+// have.
+var ErrNoTTL = storage.ErrNoTTL
+
+// Event types.
+const (
+	EventSet    = storage.OpSet
+	EventDelete = storage.OpDelete
+	EventTags   = storage.OpTags
+	EventExpire = storage.OpExpire
+	EventReset  = events.TypeReset
+)
+
+// ErrTooOld means a watch asked for events that are no longer kept.
+var ErrTooOld = events.ErrTooOld
+
+var ErrClosed = errors.New("kv: database closed")
+
+// sweepBatch caps how many expired keys one write-ahead log batch
+// deletes.
+const sweepBatch = 1000
+
+type Options struct {
+	// Dir holds the write-ahead log; empty keeps everything in memory.
+	Dir string
+
+	// SyncWindow is how long commits wait to share an fsync; NoSync skips
+	// fsync altogether.
+	SyncWindow time.Duration
+	NoSync     bool
+
+	// Dedup stores identical values once.
+	Dedup bool
+
+	// TierBudget, if positive, is how many bytes of keys and values are
+	// kept in memory; the values accessed least recently beyond it are
+	// kept on disk in Dir and read back when needed. They are moved
+	// there every TierInterval (default 1s); negative leaves that to
+	// callers, through Store().EvictCold.
+	TierBudget   int64
+	TierInterval time.Duration
+
+	// CompactBytesPerSec, if positive, limits how fast a compaction
+	// (Store().Compact) writes its snapshot, so that it does not take the
+	// disk from commits.
+	CompactBytesPerSec int64
+
+	// OnLogFailure is what writes get while the log can't be written:
+	// FailWrites (the default) refuses them with ErrUnavailable,
+	// BufferWrites acknowledges them from memory until MaxBuffered bytes
+	// are waiting. Either way they are written once the log recovers,
+	// and OnLogHealth, if set, is told when it fails and recovers.
+	OnLogFailure FailurePolicy
+	MaxBuffered  int64
+	OnLogHealth  func(LogHealth)
+
+	// ContentionDepth, if positive, tracks lock waits and conditional
+	// write conflicts per key prefix of that many ':' or '/' separated
+	// segments; see Store().Contention.
+	ContentionDepth int
+
+	// WatchHistory is how many recent events are kept for watchers that
+	// resume with WatchOptions.Since (default 10000), and WatchBuffer how
+	// many are queued per watcher before WatchOptions.Overflow applies
+	// (default 256).
+	WatchHistory int
+	WatchBuffer  int
+
+	// TTLSweepInterval is how often expired keys are deleted (default
+	// 1s); they are hidden from reads as soon as they expire. Negative
+	// disables the sweep, for callers that run DeleteExpired themselves.
+	TTLSweepInterval time.Duration
+
+	// Observer, if set, sees every event after it is published, under
+	// the store lock. It must not block or call back into the database.
+	Observer func(Event)
+}
+
+// DB is an open store.
+type DB struct {
+	store    *storage.MemoryStore
+	events   *events.Broker
+	observer func(Event)
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// Open opens the store in opts.Dir, replaying its write-ahead log.
+func Open(opts Options) (*DB, error) {
+	if opts.WatchHistory <= 0 {
+		opts.WatchHistory = 10000
+	}
+	if opts.WatchBuffer <= 0 {
+		opts.WatchBuffer = 256
+	}
+	if opts.TTLSweepInterval == 0 {
+		opts.TTLSweepInterval = time.Second
+	}
+	if opts.TierInterval == 0 {
+		opts.TierInterval = time.Second
+	}
+
+	var replayed []storage.Record
+	store, err := storage.Open(opts.Dir, storage.Options{
+		SyncWindow: opts.SyncWindow,
+		NoSync:     opts.NoSync,
+		Dedup:      opts.Dedup,
+		TierBudget: opts.TierBudget,
+
+		CompactBytesPerSec: opts.CompactBytesPerSec,
+
+		OnLogFailure: opts.OnLogFailure,
+		MaxBuffered:  opts.MaxBuffered,
+		OnLogHealth:  opts.OnLogHealth,
+
+		ContentionDepth: opts.ContentionDepth,
+
+		OnReplay: func(rec storage.Record) {
+			if rec.Op == storage.OpReset {
+				replayed = replayed[:0]
+			}
+			if len(replayed) >= 2*opts.WatchHistory {
+				replayed = append(replayed[:0], replayed[len(replayed)-opts.WatchHistory:]...)
+			}
+			replayed = append(replayed, rec)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The latest replayed changes become the watch history again, so
+	// that watchers can resume with since= across a restart.
+	seq := store.Revision()
+	if len(replayed) > 0 {
+		seq = replayed[0].Rev - 1
+	}
+	db := &DB{
+		store:    store,
+		events:   events.NewBroker(seq, opts.WatchHistory, opts.WatchBuffer),
+		observer: opts.Observer,
+		stop:     make(chan struct{}),
+	}
+	for _, rec := range replayed {
+		db.events.Publish(recordEvent(rec))
+	}
+	store.SetObserver(db.publish)
+
+	if opts.TTLSweepInterval > 0 {
+		db.wg.Add(1)
+		go db.sweep(opts.TTLSweepInterval)
+	}
+	if opts.TierBudget > 0 && opts.TierInterval > 0 {
+		db.wg.Add(1)
+		go db.evictCold(opts.TierInterval)
+	}
+	return db, nil
+}
+
+// Close ends all watches, stops the expiry sweep and closes the
+// write-ahead log.
+func (db *DB) Close() error {
+	err := ErrClosed
+	db.closeOnce.Do(func() {
+		close(db.stop)
+		db.wg.Wait()
+		db.events.Close()
+		err = db.store.Close()
+	})
+	return err
+}
+
+// Store returns the underlying store, for the HTTP server.
+func (db *DB) Store() *storage.MemoryStore {
+	return db.store
+}
+
+// Events returns the broker that watches subscribe to, for the HTTP
+// server.
+func (db *DB) Events() *events.Broker {
+	return db.events
+}
+
+// recordEvent turns a logged change into an event.
+func recordEvent(rec storage.Record) Event {
+	e := Event{
+		Seq:   rec.Rev,
+		Type:  rec.Op,
+		Key:   rec.Key,
+		Value: rec.Value,
+		Tags:  rec.Tags,
+
+		Expires: rec.Expires,
+		TTL:     rec.TTL,
+		Enc:     rec.Enc,
+	}
+	if rec.TS != 0 {
+		e.Time = time.Unix(0, rec.TS)
+	}
+	return e
+}
+
+func (db *DB) publish(rec storage.Record) {
+	e := db.events.Publish(recordEvent(rec))
+	if db.observer != nil {
+		db.observer(e)
+	}
+}
+
+func (db *DB) sweep(interval time.Duration) {
+	defer db.wg.Done()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			db.DeleteExpired(context.Background())
+		case <-db.stop:
+			return
+		}
+	}
+}
+
+func (db *DB) evictCold(interval time.Duration) {
+	defer db.wg.Done()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			db.store.EvictCold(context.Background())
+		case <-db.stop:
+			return
+		}
+	}
+}
+
+// DeleteExpired deletes every key whose time to live has run out and
+// returns how many it deleted. Each delete is an event like any other.
+func (db *DB) DeleteExpired(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := db.store.DeleteExpired(ctx, sweepBatch)
+		total += n
+		if err != nil || n < sweepBatch {
+			return total, err
+		}
+	}
+}
+
+// LogHealth reports the state of the write-ahead log's writes; ok is
+// false without one.
+func (db *DB) LogHealth() (health LogHealth, ok bool) {
+	return db.store.LogHealth()
+}
+
+// Revision returns the revision of the latest change.
+func (db *DB) Revision() uint64 {
+	return db.store.Revision()
+}
+
+func (db *DB) Get(ctx context.Context, key string) (string, bool, error) {
+	return db.store.Get(ctx, key)
+}
+
+// GetEntry returns key's value with its revision and expiry.
+func (db *DB) GetEntry(ctx context.Context, key string) (Entry, bool, error) {
+	return db.store.GetEntry(ctx, key)
+}
+
+// List returns the keys starting with prefix and the revision they
+// reflect.
+func (db *DB) List(ctx context.Context, prefix string) (map[string]string, uint64, error) {
+	entries, rev, err := db.store.GetSince(ctx, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	for k := range entries {
+		if !strings.HasPrefix(k, prefix) {
+			delete(entries, k)
+		}
+	}
+	return entries, rev, nil
+}
+
+// Set stores value and returns the revision of the change. An existing
+// time to live is dropped.
+func (db *DB) Set(ctx context.Context, key, value string) (uint64, error) {
+	return db.store.Set(ctx, key, value)
+}
+
+// SetTTL stores value for key until ttl has elapsed.
+func (db *DB) SetTTL(ctx context.Context, key, value string, ttl time.Duration) (uint64, error) {
+	return db.store.SetManyTTL(ctx, map[string]string{key: value}, ttl)
+}
+
+// Expire gives key a time to live of ttl from now, or none if ttl is not
+// positive, without rewriting its value. It reports false if key does
+// not exist.
+func (db *DB) Expire(ctx context.Context, key string, ttl time.Duration) (Entry, bool, error) {
+	return db.store.Expire(ctx, key, ttl)
+}
+
+// Touch renews key's time to live, to ttl from now or, if ttl is 0, to
+// the one it was last given (ErrNoTTL if it has none). It
+// reports false if key does not exist.
+func (db *DB) Touch(ctx context.Context, key string, ttl time.Duration) (Entry, bool, error) {
+	return db.store.Touch(ctx, key, ttl)
+}
+
+// SetMany stores all entries at once, expiring after ttl if it is
+// positive.
+func (db *DB) SetMany(ctx context.Context, entries map[string]string, ttl time.Duration) (uint64, error) {
+	return db.store.SetManyTTL(ctx, entries, ttl)
+}
+
+// Delete removes key and returns the revision of the delete, or 0 if it
+// did not exist.
+func (db *DB) Delete(ctx context.Context, key string) (uint64, error) {
+	return db.store.Delete(ctx, key)
+}
+
+// Update atomically replaces key's value with what fn returns; keep=false
+// deletes it. See storage.MemoryStore.Update.
+func (db *DB) Update(ctx context.Context, key string, fn func(old string, exists bool) (value string, keep bool, err error)) (uint64, error) {
+	return db.store.Update(ctx, key, fn)
+}
+
+// Txn runs fn as a transaction over any number of keys: fn reads and
+// writes through tx, and its writes are applied together once it returns
+// nil. fn holds the store lock, so it should be quick and must not use
+// db.
+func (db *DB) Txn(ctx context.Context, fn func(tx *Txn) error) (uint64, error) {
+	return db.store.Txn(ctx, fn)
+}
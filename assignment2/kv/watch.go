@@ -0,0 +1,78 @@
+package kv
+
+import (
+	"assignment2/internal/events"
+	"context"
+	"strings"
+)
+
+// Overflow is what a watcher that can't keep up loses, see WatchOptions.
+type Overflow = events.Overflow
+
+const (
+	OverflowDisconnect = events.OverflowDisconnect
+	OverflowDropOldest = events.OverflowDropOldest
+	OverflowCoalesce   = events.OverflowCoalesce
+)
+
+type WatchOptions struct {
+	// Prefix limits the events to keys starting with it. Reset events,
+	// after which the whole store should be read again, always pass.
+	Prefix string
+
+	// Since replays the events after that revision first, if they are
+	// still kept (ErrTooOld otherwise). 0 starts with the next change.
+	Since uint64
+
+	// Overflow decides what happens once Options.WatchBuffer events are
+	// queued for the watcher: the default, OverflowDisconnect, closes the
+	// channel, so that nothing goes missing unnoticed.
+	Overflow Overflow
+}
+
+// Watch returns a channel of changes as they are made. It is closed when
+// ctx is done, the database is closed or, with OverflowDisconnect, the
+// receiver falls behind; a watcher can then resume from the Seq of the
+// last event it got.
+func (db *DB) Watch(ctx context.Context, opts WatchOptions) (<-chan Event, error) {
+	filter := func(e Event) bool {
+		return e.Type == EventReset || strings.HasPrefix(e.Key, opts.Prefix)
+	}
+	sub, backlog, err := db.events.Subscribe(opts.Since, filter, opts.Overflow)
+	if err == events.ErrClosed {
+		return nil, ErrClosed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		defer sub.Close()
+
+		for _, e := range backlog {
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+		for {
+			select {
+			case e, ok := <-sub.C:
+				if !ok {
+					return
+				}
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}